@@ -1,9 +1,14 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"log"
 	"os"
 	"os/signal"
+
+	// economies can be set to any time zone, which the host may not have installed
+	_ "time/tzdata"
 	
 	"github.com/joho/godotenv"
 	"github.com/ohknettel/taubot-v3/internal/bot"
@@ -51,6 +56,24 @@ func main() {
 
 	bot.SetLogger(bot_logger)
 
+	err = bot.SetupBackend(database_uri, db_driver, db_logger)
+
+	if err != nil {
+		main_logger.Fatalf("An error occured while setting up the backend: %v", err)
+		return
+	}
+
+	if signing_key := os.Getenv("ledger_signing_key"); signing_key != "" {
+		seed, err := base64.StdEncoding.DecodeString(signing_key)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			main_logger.Fatalf("Ledger signing key 'ledger_signing_key' must be a base64-encoded %d byte seed.", ed25519.SeedSize)
+			return
+		}
+		bot.Backend.SetSigningKey(ed25519.NewKeyFromSeed(seed))
+	} else {
+		main_logger.Printf("Ledger signing key 'ledger_signing_key' not found, ledger checkpoints cannot be exported.")
+	}
+
 	err = bot.RegisterHandlers()
 	if err != nil {
 		main_logger.Fatalf("An error occured while registering handlers: %v", err)
@@ -61,16 +84,19 @@ func main() {
 	if err != nil {
 		main_logger.Fatalf("An error occured while opening the session: %v", err)
 	}
-	
-	err = bot.SetupBackend(database_uri, db_driver, db_logger)
 
+	err = bot.SyncCommands()
 	if err != nil {
-		main_logger.Fatalf("An error occured while setting up the backend: %v", err)
+		main_logger.Fatalf("An error occured while registering commands: %v", err)
 		return
 	}
 
+	jobs := make(chan struct{})
+	bot.StartScheduler(jobs)
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, os.Kill)
 	<-stop
 	main_logger.Printf("Shutting down...")
+	close(jobs)
 }
\ No newline at end of file
@@ -0,0 +1,472 @@
+package bot
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var AccountCommand = handlers.Command{
+	Name: "account",
+	Description: "Manage shared accounts",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "members",
+			Description: "Manage who can use an account",
+			Subcommands: []*handlers.Command{
+				{
+					Name: "add",
+					Description: "Add a member to an account, or change their role",
+					Callback: addAccountMember,
+					Options: []*handlers.Option{
+						{Name: "account", Description: "The account", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+						{Name: "user", Description: "The user to add", Type: &discordgo.User{}, Required: true},
+						{Name: "role", Description: "What the user may do, manager if omitted", Type: 0, Choices: accountRoleChoices},
+					},
+				},
+				{
+					Name: "remove",
+					Description: "Remove a member from an account",
+					Callback: removeAccountMember,
+					Options: []*handlers.Option{
+						{Name: "account", Description: "The account", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+						{Name: "user", Description: "The user to remove", Type: &discordgo.User{}, Required: true},
+					},
+				},
+				{
+					Name: "list",
+					Description: "List the members of an account",
+					Callback: listAccountMembers,
+					Options: []*handlers.Option{
+						{Name: "account", Description: "The account", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+					},
+				},
+			},
+		},
+		{
+			Name: "limits",
+			Description: "Limit what members can transfer out of an account",
+			Subcommands: []*handlers.Command{
+				{
+					Name: "set",
+					Description: "Set how much a user may transfer from an account",
+					Callback: setSpendingLimit,
+					Options: []*handlers.Option{
+						{Name: "account", Description: "The account", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+						{Name: "user", Description: "The user to limit", Type: &discordgo.User{}, Required: true},
+						{Name: "per-transfer", Description: "The most they may transfer at once, unlimited if omitted", Type: ""},
+						{Name: "per-period", Description: "The most they may transfer per period, unlimited if omitted", Type: ""},
+						{Name: "period", Description: "The period of the limit, daily if omitted", Type: 0, Choices: periodChoices[1:]},
+					},
+				},
+				{
+					Name: "allow",
+					Description: "Allow a user to pay a recipient from an account; once any are allowed, no others can be paid",
+					Callback: allowRecipient,
+					Options: []*handlers.Option{
+						{Name: "account", Description: "The account", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+						{Name: "user", Description: "The limited user", Type: &discordgo.User{}, Required: true},
+						{Name: "recipient", Description: "The account they may pay", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+					},
+				},
+				{
+					Name: "disallow",
+					Description: "Stop allowing a user to pay a recipient from an account",
+					Callback: disallowRecipient,
+					Options: []*handlers.Option{
+						{Name: "account", Description: "The account", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+						{Name: "user", Description: "The limited user", Type: &discordgo.User{}, Required: true},
+						{Name: "recipient", Description: "The account they may no longer pay", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+					},
+				},
+				{
+					Name: "allow-all",
+					Description: "Let a limited user pay any recipient again",
+					Callback: clearAllowedRecipients,
+					Options: []*handlers.Option{
+						{Name: "account", Description: "The account", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+						{Name: "user", Description: "The limited user", Type: &discordgo.User{}, Required: true},
+					},
+				},
+				{
+					Name: "remove",
+					Description: "Lift all limits of a user on an account",
+					Callback: removeSpendingLimit,
+					Options: []*handlers.Option{
+						{Name: "account", Description: "The account", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+						{Name: "user", Description: "The limited user", Type: &discordgo.User{}, Required: true},
+					},
+				},
+			},
+		},
+		{
+			Name: "transfer",
+			Description: "Hand the ownership of an account over to another user",
+			Callback: transferAccountOwnership,
+			Options: []*handlers.Option{
+				{Name: "account", Description: "The account", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+				{Name: "user", Description: "The new owner", Type: &discordgo.User{}, Required: true},
+				{Name: "keep-previous", Description: "Whether the previous owner stays on as a manager, true if omitted", Type: true},
+			},
+		},
+	},
+}
+
+func addAccountMember(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	user_id := ctx.Option("user").Value.(string)
+	role := database.AR_Manager
+	if opt := ctx.Option("role"); opt != nil {
+		role = uint8(opt.IntValue())
+	}
+
+	if _, err := ctx.Backend.SetAccountMember(member, account, user_id, role); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Member added").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("<@%s> is now a %s of **%s**.", user_id, strings.ToLower(database.AccountRoleName(role)), account.AccountName))
+	handlers.Respond(s, event, embed)
+}
+
+func removeAccountMember(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	user_id := ctx.Option("user").Value.(string)
+	if err := ctx.Backend.RemoveAccountMember(member, account, user_id); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Member removed").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("<@%s> is no longer a member of **%s**.", user_id, account.AccountName))
+	handlers.Respond(s, event, embed)
+}
+
+func listAccountMembers(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	members, err := ctx.Backend.GetAccountMembers(member, account)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	limits, err := ctx.Backend.GetSpendingLimits(member, account)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	limited := make(map[string]database.SpendingLimit, len(limits))
+	for _, limit := range limits {
+		limited[limit.UserID] = limit
+	}
+
+	// owners of accounts opened before memberships existed have no membership of their own
+	var lines []string
+	is_owner := func(account_member database.AccountMember) bool { return account_member.UserID == account.OwnerID }
+	if account.OwnerID != "" && !slices.ContainsFunc(members, is_owner) {
+		lines = append(lines, fmt.Sprintf("<@%s> (%s)", account.OwnerID, database.AccountRoleName(database.AR_Owner)))
+	}
+	for _, account_member := range members {
+		line := fmt.Sprintf("<@%s> (%s)", account_member.UserID, database.AccountRoleName(account_member.Role))
+		if limit, ok := limited[account_member.UserID]; ok {
+			line += limitSummary(economy, limit)
+			delete(limited, account_member.UserID)
+		}
+		lines = append(lines, line)
+	}
+
+	// limits also apply to users who were granted permissions on the account directly
+	for _, limit := range limits {
+		if _, ok := limited[limit.UserID]; ok {
+			lines = append(lines, fmt.Sprintf("<@%s> (no role)", limit.UserID)+limitSummary(economy, limit))
+		}
+	}
+
+	embed := utils.NewEmbed().SetTitle(fmt.Sprintf("Members of %s", account.AccountName)).SetColor(handlers.Colors.Normal)
+	if len(lines) == 0 {
+		embed.SetDescription("This account has no members.")
+	} else {
+		embed.SetDescription(strings.Join(lines, "\n"))
+	}
+
+	handlers.RespondEphemeral(s, event, embed)
+}
+
+func transferAccountOwnership(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	user_id := ctx.Option("user").Value.(string)
+	keep_previous := true
+	if opt := ctx.Option("keep-previous"); opt != nil {
+		keep_previous = opt.BoolValue()
+	}
+
+	if err := ctx.Backend.TransferAccountOwnership(member, &account, user_id, keep_previous); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Ownership transferred").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("<@%s> now owns **%s**.", user_id, account.AccountName))
+	handlers.Respond(s, event, embed)
+}
+
+// limitSummary describes the spending limits of a user, to follow their entry in the member list
+func limitSummary(economy database.Economy, limit database.SpendingLimit) string {
+	var parts []string
+	if limit.PerTransfer.IsPositive() {
+		parts = append(parts, fmt.Sprintf("%s per transfer", economy.FormatMoney(limit.PerTransfer)))
+	}
+	if limit.PerPeriod.IsPositive() {
+		parts = append(parts, fmt.Sprintf("%s %s", economy.FormatMoney(limit.PerPeriod), database.PeriodName(limit.Period)))
+	}
+	if limit.RestrictRecipients && len(limit.Recipients) == 0 {
+		parts = append(parts, "to no one")
+	} else if limit.RestrictRecipients {
+		var recipients []string
+		for _, recipient := range limit.Recipients {
+			recipients = append(recipients, recipient.Account.AccountName)
+		}
+		parts = append(parts, "only to "+strings.Join(recipients, ", "))
+	}
+
+	if len(parts) == 0 {
+		return ""
+	}
+	return ": " + strings.Join(parts, ", ")
+}
+
+func setSpendingLimit(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	limit := database.SpendingLimit{UserID: ctx.Option("user").Value.(string), Period: database.PD_Daily}
+	if ctx.Option("per-transfer") != nil {
+		if limit.PerTransfer, err = moneyOption(ctx, economy, "per-transfer"); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+	}
+
+	if ctx.Option("per-period") != nil {
+		if limit.PerPeriod, err = moneyOption(ctx, economy, "per-period"); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+	}
+
+	if opt := ctx.Option("period"); opt != nil {
+		limit.Period = uint8(opt.IntValue())
+	}
+
+	if err := ctx.Backend.SetSpendingLimit(member, account, &limit); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	summary := limitSummary(economy, limit)
+	if summary == "" {
+		summary = ": no amount limits"
+	}
+
+	embed := utils.NewEmbed().SetTitle("Spending limit set").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("<@%s> on **%s**%s.", limit.UserID, account.AccountName, summary))
+	handlers.Respond(s, event, embed)
+}
+
+func allowRecipient(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	setAllowedRecipient(ctx, s, event, true)
+}
+
+func disallowRecipient(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	setAllowedRecipient(ctx, s, event, false)
+}
+
+func setAllowedRecipient(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, allowed bool) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	recipient, err := accountOption(ctx, economy, "recipient")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	user_id := ctx.Option("user").Value.(string)
+	if err := ctx.Backend.SetAllowedRecipient(member, account, user_id, recipient, allowed); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	description := fmt.Sprintf("<@%s> may now pay **%s** from **%s**.", user_id, recipient.AccountName, account.AccountName)
+	if !allowed {
+		description = fmt.Sprintf("<@%s> may no longer pay **%s** from **%s**.", user_id, recipient.AccountName, account.AccountName)
+	}
+
+	embed := utils.NewEmbed().SetTitle("Recipients updated").SetColor(handlers.Colors.Normal).SetDescription(description)
+	handlers.Respond(s, event, embed)
+}
+
+func clearAllowedRecipients(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	user_id := ctx.Option("user").Value.(string)
+	if err := ctx.Backend.ClearAllowedRecipients(member, account, user_id); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Recipients updated").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("<@%s> may pay any recipient from **%s** again.", user_id, account.AccountName))
+	handlers.Respond(s, event, embed)
+}
+
+func removeSpendingLimit(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	user_id := ctx.Option("user").Value.(string)
+	if err := ctx.Backend.RemoveSpendingLimit(member, account, user_id); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Spending limit removed").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("<@%s> is no longer limited on **%s**.", user_id, account.AccountName))
+	handlers.Respond(s, event, embed)
+}
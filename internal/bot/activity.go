@@ -0,0 +1,314 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var ActivityCommand = handlers.Command{
+	Name: "activity",
+	Description: "Manage the rewards for chatting and spending time in voice",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "set",
+			Description: "Reward activity in the activity channels of this economy",
+			Callback: setActivityReward,
+			Options: []*handlers.Option{
+				{Name: "from", Description: "The account to pay rewards from", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+				{Name: "message", Description: "The reward per message, nothing if omitted", Type: ""},
+				{Name: "voice", Description: "The reward per minute in voice, nothing if omitted", Type: ""},
+				{Name: "cooldown", Description: "The seconds between rewarded messages of a user, 60 if omitted", Type: 0},
+				{Name: "cap", Description: "The most a user can earn a day, unlimited if omitted", Type: ""},
+			},
+		},
+		{
+			Name: "channel",
+			Description: "Choose whether activity in a channel is rewarded",
+			Callback: setActivityChannel,
+			Options: []*handlers.Option{
+				{Name: "channel", Description: "The text or voice channel", Type: &discordgo.Channel{}, Required: true},
+				{Name: "enabled", Description: "Whether activity in the channel is rewarded", Type: true, Required: true},
+			},
+		},
+		{
+			Name: "show",
+			Description: "Show the activity rewards of this economy",
+			Callback: showActivityReward,
+		},
+		{
+			Name: "disable",
+			Description: "Stop rewarding activity, forfeiting rewards that have not been paid out yet",
+			Callback: disableActivityReward,
+		},
+	},
+}
+
+const defaultActivityCooldown = 60 * time.Second
+
+func activityEmbed(title string, reward database.ActivityReward, channels []string) *utils.Embed {
+	economy := reward.FromAccount.Economy
+
+	limit := "Unlimited"
+	if reward.DailyCap.IsPositive() {
+		limit = economy.FormatMoney(reward.DailyCap)
+	}
+
+	embed := utils.NewEmbed().SetTitle(title).SetColor(handlers.Colors.Normal).
+		AddField("From", reward.FromAccount.AccountName).
+		AddField("Per message", economy.FormatMoney(reward.PerMessage)).
+		AddField("Per minute in voice", economy.FormatMoney(reward.PerVoiceMinute)).
+		AddField("Cooldown", reward.Cooldown.String()).
+		AddField("Daily cap", limit).
+		InlineAllFields()
+
+	if channels != nil {
+		mentions := make([]string, 0, len(channels))
+		for _, channel := range channels {
+			mentions = append(mentions, fmt.Sprintf("<#%s>", channel))
+		}
+
+		if len(mentions) == 0 {
+			embed.AddField("Channels", "None yet, add some with /activity channel.")
+		} else {
+			embed.AddField("Channels", strings.Join(mentions, " "))
+		}
+	}
+
+	return embed
+}
+
+func setActivityReward(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	reward := database.ActivityReward{Cooldown: defaultActivityCooldown}
+	if reward.FromAccount, err = accountOption(ctx, economy, "from"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+	reward.FromAccount.Economy = economy
+
+	for name, target := range map[string]*datatypes.Money{"message": &reward.PerMessage, "voice": &reward.PerVoiceMinute, "cap": &reward.DailyCap} {
+		if ctx.Option(name) == nil {
+			continue
+		}
+
+		if *target, err = moneyOption(ctx, economy, name); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+	}
+
+	if opt := ctx.Option("cooldown"); opt != nil {
+		reward.Cooldown = time.Duration(opt.IntValue()) * time.Second
+	}
+
+	if err := ctx.Backend.SetActivityReward(member, economy, &reward); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	channels, err := ctx.Backend.GetActivityChannels(economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, activityEmbed("Activity rewards updated", reward, channels))
+}
+
+func setActivityChannel(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	channel_id := ctx.Option("channel").Value.(string)
+	enabled := ctx.Option("enabled").BoolValue()
+	if err := ctx.Backend.SetActivityChannel(member, economy, channel_id, enabled); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	message := fmt.Sprintf("Activity in <#%s> is now rewarded.", channel_id)
+	if !enabled {
+		message = fmt.Sprintf("Activity in <#%s> is no longer rewarded.", channel_id)
+	}
+
+	embed := utils.NewEmbed().SetTitle("Activity channel updated").SetColor(handlers.Colors.Normal).SetDescription(message)
+	handlers.Respond(s, event, embed)
+}
+
+func showActivityReward(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	reward, err := ctx.Backend.GetActivityReward(economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	} else if reward == nil {
+		handlers.RespondError(ctx, s, event, database.BackendError{Message: "This economy does not reward activity."})
+		return
+	}
+
+	channels, err := ctx.Backend.GetActivityChannels(economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.RespondEphemeral(s, event, activityEmbed("Activity rewards", *reward, channels))
+}
+
+func disableActivityReward(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.RemoveActivityReward(member, economy); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Activity rewards disabled").SetColor(handlers.Colors.Normal).
+		SetDescription("Activity is no longer rewarded in this economy.")
+	handlers.Respond(s, event, embed)
+}
+
+// voiceSession is the time a user has been in a voice channel without being credited for it yet
+type voiceSession struct {
+	ChannelID string
+	Since time.Time
+}
+
+// voiceSessions holds the users in voice channels by guild and user ID, so the minutes they spend there can be rewarded
+var voiceSessions = struct {
+	sync.Mutex
+	sessions map[string]voiceSession
+}{sessions: map[string]voiceSession{}}
+
+// eligibleVoiceState reports whether time spent in a voice state can earn rewards, which it cannot while deafened
+func eligibleVoiceState(state *discordgo.VoiceState) bool {
+	return state != nil && state.ChannelID != "" && !state.Deaf && !state.SelfDeaf
+}
+
+// creditVoiceMinutes rewards a user for the whole minutes of a voice session until now, and returns when the uncredited rest began
+func (b *Bot) creditVoiceMinutes(guild_id string, user_id string, session voiceSession, now time.Time) time.Time {
+	minutes := int(now.Sub(session.Since) / time.Minute)
+	if minutes <= 0 {
+		return session.Since
+	}
+
+	if err := b.Backend.RecordVoiceActivity(guild_id, session.ChannelID, user_id, minutes, now); err != nil && b.Logger != nil {
+		b.Logger.Printf("An error occured while rewarding %s for time in voice: %v", user_id, err)
+	}
+	return session.Since.Add(time.Duration(minutes) * time.Minute)
+}
+
+func (b *Bot) onMessageCreate(s *discordgo.Session, event *discordgo.MessageCreate) {
+	if event.GuildID == "" || event.Author == nil || event.Author.Bot || event.WebhookID != "" {
+		return
+	}
+
+	if err := b.Backend.RecordMessageActivity(event.GuildID, event.ChannelID, event.Author.ID, time.Now()); err != nil && b.Logger != nil {
+		b.Logger.Printf("An error occured while rewarding %s for a message: %v", event.Author.ID, err)
+	}
+}
+
+func (b *Bot) onVoiceStateUpdate(s *discordgo.Session, event *discordgo.VoiceStateUpdate) {
+	if member := event.Member; member != nil && member.User != nil && member.User.Bot {
+		return
+	}
+
+	now := time.Now()
+	key := event.GuildID + ":" + event.UserID
+
+	voiceSessions.Lock()
+	defer voiceSessions.Unlock()
+
+	session, tracked := voiceSessions.sessions[key]
+	if tracked {
+		b.creditVoiceMinutes(event.GuildID, event.UserID, session, now)
+		delete(voiceSessions.sessions, key)
+	}
+
+	if eligibleVoiceState(event.VoiceState) {
+		voiceSessions.sessions[key] = voiceSession{ChannelID: event.ChannelID, Since: now}
+	}
+}
+
+// creditVoiceActivity rewards everyone in a voice channel for their time so far, picking up anyone who joined before the bot
+// started from the voice states it has cached
+func (b *Bot) creditVoiceActivity(now time.Time) {
+	voiceSessions.Lock()
+	defer voiceSessions.Unlock()
+
+	b.Session.State.RLock()
+	for _, guild := range b.Session.State.Guilds {
+		for _, state := range guild.VoiceStates {
+			key := guild.ID + ":" + state.UserID
+			if _, ok := voiceSessions.sessions[key]; !ok && eligibleVoiceState(state) {
+				voiceSessions.sessions[key] = voiceSession{ChannelID: state.ChannelID, Since: now}
+			}
+		}
+	}
+	b.Session.State.RUnlock()
+
+	for key, session := range voiceSessions.sessions {
+		guild_id, user_id, _ := strings.Cut(key, ":")
+		session.Since = b.creditVoiceMinutes(guild_id, user_id, session, now)
+		voiceSessions.sessions[key] = session
+	}
+}
+
+// notifyActivityPayout tells whoever set up the activity rewards of an economy by DM that they could not be paid out
+func (b *Bot) notifyActivityPayout(payout database.ActivityPayout) error {
+	if payout.Error == nil {
+		return nil
+	}
+
+	embed := activityEmbed("Activity rewards not paid", payout.Reward, nil).SetColor(handlers.Colors.Error).SetDescription(payout.Error.Error())
+	channel, err := b.Session.UserChannelCreate(payout.Reward.ActorID)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.Session.ChannelMessageSendEmbed(channel.ID, embed.Truncate().MessageEmbed)
+	return err
+}
@@ -0,0 +1,325 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+const defaultProposalHours = 24
+
+var ApprovalsCommand = handlers.Command{
+	Name: "approvals",
+	Description: "Manage the spending controls of government and corporate accounts",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "set",
+			Description: "Require transfers above an amount to be approved by several people",
+			Callback: setApprovalPolicy,
+			Options: []*handlers.Option{
+				{Name: "account", Description: "The account", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+				{Name: "threshold", Description: "Transfers above this amount need approval", Type: "", Required: true},
+				{Name: "approvals", Description: "How many people have to approve, including whoever proposes the transfer", Type: 0, Required: true},
+				{Name: "hours", Description: fmt.Sprintf("How long proposals wait for approvals, %d if omitted", defaultProposalHours), Type: 0},
+				{Name: "channel", Description: "Where to post proposals, the channel they are made in if omitted", Type: &discordgo.Channel{}},
+			},
+		},
+		{
+			Name: "remove",
+			Description: "Let transfers from an account go through without approvals",
+			Callback: removeApprovalPolicy,
+			Options: []*handlers.Option{
+				{Name: "account", Description: "The account", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+			},
+		},
+		{
+			Name: "pending",
+			Description: "List the transfers waiting for approval on an account",
+			Callback: listPendingProposals,
+			Options: []*handlers.Option{
+				{Name: "account", Description: "The account", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+			},
+		},
+	},
+}
+
+// ProposalComponent handles the approve and reject buttons of transfer proposals, whose custom IDs are "proposal:<action>:<id>"
+var ProposalComponent = handlers.Component{
+	Name: "proposal",
+	Callback: handleProposalButton,
+}
+
+func proposalEmbed(proposal database.TransferProposal) *utils.Embed {
+	economy := proposal.FromAccount.Economy
+	color := handlers.Colors.Normal
+	if proposal.Status == database.PS_Pending {
+		color = handlers.Colors.Warning
+	} else if proposal.Status != database.PS_Executed {
+		color = handlers.Colors.Error
+	}
+
+	var approvers []string
+	for _, approval := range proposal.Approvals {
+		approvers = append(approvers, fmt.Sprintf("<@%s>", approval.UserID))
+	}
+
+	embed := utils.NewEmbed().SetTitle(fmt.Sprintf("Transfer proposal #%d", proposal.ID)).SetColor(color).
+		AddField("From", proposal.FromAccount.AccountName).
+		AddField("To", proposal.ToAccount.AccountName).
+		AddField("Amount", economy.FormatMoney(proposal.Amount)).
+		AddField("Type", database.TransferTypeName(proposal.TransferType)).
+		AddField("Status", database.ProposalStatusName(proposal.Status)).
+		AddField("Approvals", fmt.Sprintf("%d of %d", len(proposal.Approvals), proposal.Required)).
+		InlineAllFields().
+		AddField("Approved by", strings.Join(approvers, ", "))
+
+	switch proposal.Status {
+	case database.PS_Pending:
+		embed.SetDescription(fmt.Sprintf("Proposed by <@%s>. %s is held until the proposal is settled, which it has to be by <t:%d:f>.", proposal.ProposerID, economy.FormatMoney(proposal.Held), proposal.ExpiresAt.Unix()))
+	case database.PS_Executed:
+		embed.SetDescription(fmt.Sprintf("Proposed by <@%s> and made as transfer #%d.", proposal.ProposerID, *proposal.TransferID))
+	case database.PS_Failed:
+		embed.SetDescription(fmt.Sprintf("Proposed by <@%s>, but the transfer could not be made: %s", proposal.ProposerID, proposal.Error))
+	default:
+		embed.SetDescription(fmt.Sprintf("Proposed by <@%s>. The held funds have been released.", proposal.ProposerID))
+	}
+
+	return embed
+}
+
+// proposalComponents returns the buttons of a pending proposal, or none once it has been settled
+func proposalComponents(proposal database.TransferProposal) []discordgo.MessageComponent {
+	if proposal.Status != database.PS_Pending {
+		return []discordgo.MessageComponent{}
+	}
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.Button{Label: "Approve", Style: discordgo.SuccessButton, CustomID: fmt.Sprintf("proposal:approve:%d", proposal.ID)},
+			discordgo.Button{Label: "Reject", Style: discordgo.DangerButton, CustomID: fmt.Sprintf("proposal:reject:%d", proposal.ID)},
+		}},
+	}
+}
+
+// proposeTransfer turns a transfer that needs approval into a proposal, which is posted with its buttons in the channel
+// of the account's policy, or otherwise in reply to the command
+func proposeTransfer(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, member database.SessionedMember, from database.Account, to database.Account, amount datatypes.Money, transfer_type uint8) {
+	proposal, err := ctx.Backend.ProposeTransfer(member, &from, &to, amount, transfer_type)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := proposalEmbed(*proposal)
+	if proposal.ChannelID != "" && proposal.ChannelID != event.ChannelID {
+		message, err := s.ChannelMessageSendComplex(proposal.ChannelID, &discordgo.MessageSend{
+			Embeds: []*discordgo.MessageEmbed{embed.Truncate().MessageEmbed},
+			Components: proposalComponents(*proposal),
+		})
+		if err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+
+		if err := ctx.Backend.SetProposalMessage(proposal, message.ChannelID, message.ID); err != nil && ctx.Logger != nil {
+			ctx.Logger.Printf("An error occured while saving the message of proposal #%d: %v", proposal.ID, err)
+		}
+
+		notice := utils.NewEmbed().SetTitle("Transfer proposed").SetColor(handlers.Colors.Warning).
+			SetDescription(fmt.Sprintf("This transfer needs %d approvals and has been posted in <#%s>.", proposal.Required, proposal.ChannelID))
+		handlers.RespondEphemeral(s, event, notice)
+		return
+	}
+
+	if err := handlers.RespondComponents(s, event, embed, proposalComponents(*proposal)); err != nil {
+		return
+	}
+
+	if message, err := s.InteractionResponse(event.Interaction); err == nil {
+		err = ctx.Backend.SetProposalMessage(proposal, message.ChannelID, message.ID)
+		if err != nil && ctx.Logger != nil {
+			ctx.Logger.Printf("An error occured while saving the message of proposal #%d: %v", proposal.ID, err)
+		}
+	}
+}
+
+func handleProposalButton(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	parts := strings.Split(event.MessageComponentData().CustomID, ":")
+	if len(parts) != 3 {
+		return
+	}
+
+	id, err := strconv.ParseUint(parts[2], 10, 64)
+	if err != nil {
+		return
+	}
+
+	var proposal *database.TransferProposal
+	switch parts[1] {
+	case "approve":
+		proposal, err = ctx.Backend.ApproveProposal(member, uint(id))
+	case "reject":
+		proposal, err = ctx.Backend.RejectProposal(member, uint(id))
+	default:
+		return
+	}
+
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.UpdateMessage(s, event, proposalEmbed(*proposal), proposalComponents(*proposal))
+}
+
+func setApprovalPolicy(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	threshold, err := moneyOption(ctx, economy, "threshold")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	hours := int64(defaultProposalHours)
+	if opt := ctx.Option("hours"); opt != nil {
+		hours = opt.IntValue()
+	}
+
+	policy := database.ApprovalPolicy{
+		Threshold: threshold,
+		Required: uint(max(ctx.Option("approvals").IntValue(), 0)),
+		Expiry: time.Duration(hours) * time.Hour,
+	}
+	if opt := ctx.Option("channel"); opt != nil {
+		policy.ChannelID = opt.Value.(string)
+	}
+
+	if err := ctx.Backend.SetApprovalPolicy(member, account, &policy); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Approvals required").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("Transfers from **%s** above %s now need %d approvals within %d hours.", account.AccountName, economy.FormatMoney(threshold), policy.Required, hours))
+	handlers.Respond(s, event, embed)
+}
+
+func removeApprovalPolicy(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.RemoveApprovalPolicy(member, account); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Approvals removed").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("Transfers from **%s** no longer need approval.", account.AccountName))
+	handlers.Respond(s, event, embed)
+}
+
+func listPendingProposals(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	proposals, err := ctx.Backend.GetPendingProposals(member, account)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var lines []string
+	for _, proposal := range proposals {
+		line := fmt.Sprintf("**#%d** %s to %s, %d of %d approvals, expires <t:%d:R>", proposal.ID, economy.FormatMoney(proposal.Amount), proposal.ToAccount.AccountName, len(proposal.Approvals), proposal.Required, proposal.ExpiresAt.Unix())
+		if proposal.MessageID != "" {
+			line += fmt.Sprintf(" ([vote](https://discord.com/channels/%s/%s/%s))", event.GuildID, proposal.ChannelID, proposal.MessageID)
+		}
+		lines = append(lines, line)
+	}
+
+	embed := utils.NewEmbed().SetTitle(fmt.Sprintf("Pending proposals of %s", account.AccountName)).SetColor(handlers.Colors.Normal)
+	if len(lines) == 0 {
+		embed.SetDescription("No transfers are waiting for approval.")
+	} else {
+		embed.SetDescription(strings.Join(lines, "\n"))
+	}
+
+	handlers.RespondEphemeral(s, event, embed)
+}
+
+// updateExpiredProposal replaces the buttons of an expired proposal's message with its final state
+func (b *Bot) updateExpiredProposal(proposal database.TransferProposal) error {
+	if proposal.MessageID == "" {
+		return nil
+	}
+
+	embeds := []*discordgo.MessageEmbed{proposalEmbed(proposal).Truncate().MessageEmbed}
+	components := proposalComponents(proposal)
+	_, err := b.Session.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID: proposal.MessageID,
+		Channel: proposal.ChannelID,
+		Embeds: &embeds,
+		Components: &components,
+	})
+	return err
+}
@@ -0,0 +1,90 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+const defaultAuditEntries = 20
+
+var AuditCommand = handlers.Command{
+	Name: "audit",
+	Description: "Show the most recent changes made in this economy",
+	Callback: showAuditLog,
+	Options: []*handlers.Option{
+		{Name: "user", Description: "Only show changes made by this user", Type: &discordgo.User{}},
+		{Name: "count", Description: fmt.Sprintf("How many entries to show, %d if omitted", defaultAuditEntries), Type: 0},
+	},
+}
+
+func changeName(change uint8) string {
+	switch change {
+	case database.CUD_Create:
+		return "created"
+	case database.CUD_Update:
+		return "updated"
+	default:
+		return "deleted"
+	}
+}
+
+func showAuditLog(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var actor_id string
+	if opt := ctx.Option("user"); opt != nil {
+		actor_id = opt.Value.(string)
+	}
+
+	count := defaultAuditEntries
+	if opt := ctx.Option("count"); opt != nil {
+		count = int(opt.IntValue())
+	}
+
+	entries, err := ctx.Backend.GetAuditLog(member, economy, actor_id, count)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var lines []string
+	for _, entry := range entries {
+		actor := "The bot"
+		if entry.ActorID != "" {
+			actor = fmt.Sprintf("<@%s>", entry.ActorID)
+		}
+
+		line := fmt.Sprintf("<t:%d:g> %s %s %s", entry.CreatedAt.Unix(), actor, changeName(entry.Change), entry.Action)
+		if entry.Details != "" {
+			line += ": " + entry.Details
+		}
+		if entry.OnBehalfOfID != nil {
+			line += fmt.Sprintf(" (acting as `%s`)", entry.OnBehalfOfID)
+		}
+		lines = append(lines, line)
+	}
+
+	embed := utils.NewEmbed().SetTitle("Audit log").SetColor(handlers.Colors.Normal)
+	if len(lines) == 0 {
+		embed.SetDescription("Nothing has been recorded yet.")
+	} else {
+		embed.SetDescription(strings.Join(lines, "\n"))
+	}
+
+	handlers.RespondEphemeral(s, event, embed)
+}
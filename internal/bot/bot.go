@@ -19,6 +19,10 @@ func NewBot(token string) (*Bot, error) {
 		return nil, err
 	}
 
+	// GUILD_MEMBERS is a privileged intent, which has to be enabled for the bot in the Discord developer portal.
+	// Paying the members of a role needs it to keep the members of each guild and their roles in the state cache.
+	session.Identify.Intents = discordgo.IntentsAllWithoutPrivileged | discordgo.IntentsGuildMembers
+
 	bot := Bot{Session: session}
 	return &bot, nil
 }
@@ -30,6 +34,22 @@ func (b *Bot) SetupBackend(uri string, driver database.DriverFunc, logger *log.L
 	}
 
 	backend := database.NewBackend(db)
+	if err := backend.MigrateLedger(); err != nil {
+		return err
+	}
+
+	if err := backend.MigrateTaxes(); err != nil {
+		return err
+	}
+
+	if err := backend.MigrateSpendingLimits(); err != nil {
+		return err
+	}
+
+	if err := backend.MigrateRecurringTransfers(); err != nil {
+		return err
+	}
+
 	b.Backend = *backend
 	return nil
 }
@@ -40,16 +60,40 @@ func (b *Bot) SetLogger(logger *log.Logger) {
 
 
 func (b *Bot) RegisterHandlers() error {
-	dict, err := handlers.MigrateCommands(b.Session, Commands)
-	if err != nil {
-		return err
+	dict := make(map[string]handlers.Command, len(Commands))
+	for _, cmd := range Commands {
+		dict[cmd.Name] = cmd
+	}
+
+	components := make(map[string]handlers.Component, len(Components))
+	for _, component := range Components {
+		components[component.Name] = component
 	}
 
-	b.Session.AddHandler(handlers.SlashCommandHandlerWrapper(dict))
+	b.Session.AddHandler(handlers.SlashCommandHandlerWrapper(dict, &b.Backend, b.Logger))
+	b.Session.AddHandler(handlers.ComponentHandlerWrapper(components, &b.Backend, b.Logger))
 	b.Session.AddHandler(handlers.ReadyEventWrapper(b.Logger))
+	b.Session.AddHandler(b.onMessageCreate)
+	b.Session.AddHandler(b.onVoiceStateUpdate)
+	b.Session.AddHandler(b.onGuildCreate)
 	return nil
 }
 
+// onGuildCreate asks the gateway for every member of a guild the bot joins or reconnects to, which fills the state cache
+// that role incomes and payrolls look up the holders of a role in. Members joining, leaving and changing roles afterwards
+// are kept up to date by their own events.
+func (b *Bot) onGuildCreate(s *discordgo.Session, event *discordgo.GuildCreate) {
+	if err := s.RequestGuildMembers(event.ID, "", 0, "", false); err != nil {
+		b.Logger.Printf("An error occured while requesting the members of guild %s: %v", event.ID, err)
+	}
+}
+
 func (b *Bot) Run() error {
 	return b.Session.Open()
+}
+
+// SyncCommands registers the bot's application commands with Discord; the session has to be open beforehand
+func (b *Bot) SyncCommands() error {
+	_, err := handlers.MigrateCommands(b.Session, Commands)
+	return err
 }
\ No newline at end of file
@@ -4,4 +4,45 @@ import (
 	"github.com/ohknettel/taubot-v3/internal/handlers"
 )
 
-var Commands []handlers.Command
\ No newline at end of file
+var Commands []handlers.Command = []handlers.Command{
+	LedgerCommand,
+	WealthTaxCommand,
+	TaxCommand,
+	VATCommand,
+	ReportCommand,
+	RecurringCommand,
+	LoginCommand,
+	LogoutCommand,
+	PayCommand,
+	BalanceCommand,
+	AuditCommand,
+	AccountCommand,
+	ApprovalsCommand,
+	RequestCommand,
+	InvoiceCommand,
+	RequestsCommand,
+	PayrollCommand,
+	RoleIncomeCommand,
+	ActivityCommand,
+	DailyCommand,
+	FaucetCommand,
+	TimeZoneCommand,
+	FineCommand,
+	SanctionsCommand,
+	FreezeCommand,
+	UnfreezeCommand,
+	EconomyCommand,
+	LoanCommand,
+	SavingsCommand,
+	DemurrageCommand,
+	DormancyCommand,
+	EscrowCommand,
+}
+
+var Components []handlers.Component = []handlers.Component{
+	ProposalComponent,
+	PaymentRequestComponent,
+	BatchComponent,
+	LoanComponent,
+	EscrowComponent,
+}
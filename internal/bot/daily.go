@@ -0,0 +1,265 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var DailyCommand = handlers.Command{
+	Name: "daily",
+	Description: "Claim your daily reward",
+	Callback: claimDaily,
+}
+
+var FaucetCommand = handlers.Command{
+	Name: "faucet",
+	Description: "Manage the daily reward of this economy",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "set",
+			Description: "Let members claim a daily reward from a faucet account",
+			Callback: setFaucet,
+			Options: []*handlers.Option{
+				{Name: "from", Description: "The faucet account to pay from", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+				{Name: "amount", Description: "The reward on the first day of a streak", Type: "", Required: true},
+				{Name: "bonus", Description: "The extra reward for every further day of a streak, nothing if omitted", Type: ""},
+				{Name: "max-streak", Description: "The day of a streak after which the reward stops growing, never if omitted", Type: 0},
+				{Name: "low-balance", Description: "Alert when the faucet's balance falls below this amount", Type: ""},
+				{Name: "alert-channel", Description: "Where to alert, a DM to you if omitted", Type: &discordgo.Channel{}},
+			},
+		},
+		{
+			Name: "show",
+			Description: "Show the daily reward of this economy",
+			Callback: showFaucet,
+		},
+		{
+			Name: "disable",
+			Description: "Stop the daily reward, resetting everyone's streak",
+			Callback: disableFaucet,
+		},
+	},
+}
+
+var TimeZoneCommand = handlers.Command{
+	Name: "timezone",
+	Description: "Set the time zone of this economy, which daily rewards reset in",
+	Callback: setTimeZone,
+	Options: []*handlers.Option{
+		{Name: "zone", Description: "The name of the time zone, such as Europe/London", Type: "", Required: true},
+	},
+}
+
+func faucetEmbed(title string, reward database.DailyReward) *utils.Embed {
+	economy := reward.FromAccount.Economy
+
+	max_streak := "Never"
+	if reward.MaxStreak > 0 {
+		max_streak = fmt.Sprintf("%d days", reward.MaxStreak)
+	}
+
+	embed := utils.NewEmbed().SetTitle(title).SetColor(handlers.Colors.Normal).
+		AddField("Faucet", reward.FromAccount.AccountName).
+		AddField("Reward", economy.FormatMoney(reward.Amount)).
+		AddField("Streak bonus", economy.FormatMoney(reward.StreakBonus)).
+		AddField("Stops growing after", max_streak).
+		AddField("Low balance alert", economy.FormatMoney(reward.LowBalance)).
+		AddField("Time zone", economy.Location().String())
+
+	return embed.InlineAllFields()
+}
+
+// alertFaucet tells the admins of an economy that its faucet is running low, once until it is refilled
+func alertFaucet(ctx *handlers.Context, s *discordgo.Session, reward database.DailyReward) {
+	if alert, err := ctx.Backend.TakeFaucetAlert(reward, time.Now()); err != nil || !alert {
+		if err != nil && ctx.Logger != nil {
+			ctx.Logger.Printf("An error occured while alerting of a low faucet: %v", err)
+		}
+		return
+	}
+
+	channel_id := reward.AlertChannelID
+	if channel_id == "" {
+		channel, err := s.UserChannelCreate(reward.ActorID)
+		if err != nil {
+			return
+		}
+		channel_id = channel.ID
+	}
+
+	balance := "It is empty."
+	if account, err := ctx.Backend.GetAccountByID(reward.FromAccountID.String()); err == nil && account.Balance.IsPositive() {
+		balance = fmt.Sprintf("It only has %s left.", reward.FromAccount.Economy.FormatMoney(account.Balance))
+	}
+
+	embed := utils.NewEmbed().SetTitle("Faucet running low").SetColor(handlers.Colors.Warning).
+		SetDescription(fmt.Sprintf("The daily reward faucet **%s** needs to be refilled. %s", reward.FromAccount.AccountName, balance))
+	if _, err := s.ChannelMessageSendEmbed(channel_id, embed.Truncate().MessageEmbed); err != nil && ctx.Logger != nil {
+		ctx.Logger.Printf("An error occured while alerting of a low faucet: %v", err)
+	}
+}
+
+func claimDaily(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	result, err := ctx.Backend.ClaimDaily(member, economy, time.Now())
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		if errors.Is(err, database.FaucetEmptyError) {
+			if reward, err := ctx.Backend.GetDailyReward(economy); err == nil && reward != nil {
+				alertFaucet(ctx, s, *reward)
+			}
+		}
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Daily reward claimed").SetColor(handlers.Colors.Normal).
+		AddField("Reward", economy.FormatMoney(result.Amount)).
+		AddField("Streak", fmt.Sprintf("%d days", result.Claim.Streak)).
+		AddField("Next claim", fmt.Sprintf("<t:%d:R>", result.NextClaim.Unix())).
+		InlineAllFields()
+	handlers.Respond(s, event, embed)
+
+	if reward, err := ctx.Backend.GetDailyReward(economy); err == nil && reward != nil && result.Faucet < reward.LowBalance {
+		alertFaucet(ctx, s, *reward)
+	}
+}
+
+func setFaucet(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var reward database.DailyReward
+	if reward.FromAccount, err = accountOption(ctx, economy, "from"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	} else if reward.Amount, err = moneyOption(ctx, economy, "amount"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+	reward.FromAccount.Economy = economy
+
+	if ctx.Option("bonus") != nil {
+		if reward.StreakBonus, err = moneyOption(ctx, economy, "bonus"); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+	}
+
+	if ctx.Option("low-balance") != nil {
+		if reward.LowBalance, err = moneyOption(ctx, economy, "low-balance"); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+	}
+
+	if opt := ctx.Option("max-streak"); opt != nil {
+		if opt.IntValue() < 1 {
+			handlers.RespondError(ctx, s, event, database.BackendError{Message: "A streak has to grow for at least one day."})
+			return
+		}
+		reward.MaxStreak = uint(opt.IntValue())
+	}
+
+	if opt := ctx.Option("alert-channel"); opt != nil {
+		reward.AlertChannelID = opt.Value.(string)
+	}
+
+	if err := ctx.Backend.SetDailyReward(member, economy, &reward); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, faucetEmbed("Daily reward updated", reward))
+}
+
+func showFaucet(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	reward, err := ctx.Backend.GetDailyReward(economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	} else if reward == nil {
+		handlers.RespondError(ctx, s, event, database.BackendError{Message: "This economy does not have a daily reward."})
+		return
+	}
+
+	handlers.RespondEphemeral(s, event, faucetEmbed("Daily reward", *reward))
+}
+
+func disableFaucet(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.RemoveDailyReward(member, economy); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Daily reward disabled").SetColor(handlers.Colors.Normal).
+		SetDescription("Members can no longer claim a daily reward in this economy.")
+	handlers.Respond(s, event, embed)
+}
+
+func setTimeZone(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.SetTimeZone(member, &economy, ctx.Option("zone").StringValue()); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	now := time.Now().In(economy.Location())
+	embed := utils.NewEmbed().SetTitle("Time zone updated").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("This economy now runs on %s, where it is %s.", economy.TimeZone, now.Format("15:04 on Monday")))
+	handlers.Respond(s, event, embed)
+}
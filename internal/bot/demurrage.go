@@ -0,0 +1,160 @@
+package bot
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var DemurrageCommand = handlers.Command{
+	Name: "demurrage",
+	Description: "Charge a periodic fee on idle balances in this economy",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "set",
+			Description: "Set up or change the demurrage of this economy",
+			Callback: setDemurrage,
+			Options: []*handlers.Option{
+				{Name: "account", Description: "The government account receiving the charges", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+				{Name: "rate", Description: "The percentage of the idle balance charged each period, such as 0.5%", Type: "", Required: true},
+				{Name: "period", Description: "How often demurrage is charged, monthly if omitted", Type: 0, Choices: periodChoices[1:]},
+			},
+		},
+		{
+			Name: "remove",
+			Description: "Stop charging demurrage in this economy",
+			Callback: removeDemurrage,
+		},
+		{
+			Name: "preview",
+			Description: "Show what demurrage would be charged for the current period so far",
+			Callback: previewDemurrage,
+		},
+	},
+}
+
+func demurrageEmbed(economy database.Economy, title string, charges []database.DemurrageCharge) *utils.Embed {
+	slices.SortFunc(charges, func(a, b database.DemurrageCharge) int {
+		return cmp.Compare(b.Amount, a.Amount)
+	})
+
+	var total datatypes.Money
+	var lines []string
+	for i, charge := range charges {
+		total, _ = total.Add(charge.Amount)
+		if i < maxListedAssessments {
+			lines = append(lines, fmt.Sprintf("%s: %s on %s idle", charge.Account.AccountName, economy.FormatMoney(charge.Amount), economy.FormatMoney(charge.Idle)))
+		}
+	}
+
+	if len(charges) > maxListedAssessments {
+		lines = append(lines, fmt.Sprintf("...and %d more", len(charges) - maxListedAssessments))
+	}
+
+	embed := utils.NewEmbed().SetTitle(title).SetColor(handlers.Colors.Normal)
+	if len(lines) == 0 {
+		return embed.SetDescription("No account owes any demurrage.")
+	}
+
+	return embed.SetDescription(strings.Join(lines, "\n")).
+		AddField("Accounts", fmt.Sprint(len(charges))).
+		AddField("Total", economy.FormatMoney(total)).
+		InlineAllFields()
+}
+
+func setDemurrage(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	policy := database.DemurragePolicy{Period: database.PD_Monthly}
+	if policy.ToAccount, err = accountOption(ctx, economy, "account"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if policy.Rate, err = rateOption(ctx, "rate"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if opt := ctx.Option("period"); opt != nil {
+		policy.Period = uint8(opt.IntValue())
+	}
+
+	if err := ctx.Backend.SetDemurragePolicy(member, economy, &policy); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Demurrage updated").SetColor(handlers.Colors.Normal).
+		SetDescription("The lowest balance each account holds through a period is charged once the period is over.").
+		AddField("Rate", database.FormatBasisPoints(policy.Rate)).
+		AddField("Charged", database.PeriodName(policy.Period)).
+		AddField("Paid into", policy.ToAccount.AccountName).
+		InlineAllFields()
+	handlers.Respond(s, event, embed)
+}
+
+func removeDemurrage(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.RemoveDemurragePolicy(member, economy); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Demurrage removed").SetColor(handlers.Colors.Normal).
+		SetDescription("Idle balances are no longer charged in this economy.")
+	handlers.Respond(s, event, embed)
+}
+
+func previewDemurrage(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	policy, err := ctx.Backend.GetDemurragePolicy(economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	} else if policy == nil {
+		handlers.RespondError(ctx, s, event, database.BackendError{Message: "This economy does not charge demurrage."})
+		return
+	}
+
+	charges, err := ctx.Backend.PreviewDemurrage(economy, *policy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, demurrageEmbed(economy, "Demurrage preview", charges).SetFooter("Nothing has been charged."))
+}
@@ -0,0 +1,259 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var escheatmentAutocomplete handlers.EventFunc = func(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	escheatments, err := ctx.Backend.GetEscheatments(economy, member.User.ID)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	query := strings.ToLower(focusedValue(ctx))
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, escheatment := range escheatments {
+		if escheatment.Status != database.ES_Escheated {
+			continue
+		}
+
+		name := fmt.Sprintf("#%d: %s from %s", escheatment.ID, economy.FormatMoney(escheatment.Amount), escheatment.Account.AccountName)
+		if len(name) > 100 {
+			name = name[:97] + "..."
+		}
+
+		if strings.Contains(strings.ToLower(name), query) && len(choices) < 25 {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: fmt.Sprint(escheatment.ID)})
+		}
+	}
+	autocomplete(s, event, choices)
+}
+
+var DormancyCommand = handlers.Command{
+	Name: "dormancy",
+	Description: "Manage the escheatment of dormant accounts",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "policy",
+			Description: "Set how long accounts may stay dormant before their balance is escheated",
+			Callback: setEscheatmentPolicy,
+			Options: []*handlers.Option{
+				{Name: "account", Description: "The government account receiving dormant balances", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+				{Name: "days", Description: "How many days without activity make an account dormant", Type: 0, Required: true},
+				{Name: "warning", Description: "How many days beforehand owners are warned, 7 if omitted", Type: 0},
+				{Name: "claim", Description: "How many days owners have to claim their balance back, 30 if omitted", Type: 0},
+			},
+		},
+		{
+			Name: "remove",
+			Description: "Stop escheating dormant accounts in this economy",
+			Callback: removeEscheatmentPolicy,
+		},
+		{
+			Name: "list",
+			Description: "List the warnings and escheated balances of your accounts",
+			Callback: listEscheatments,
+		},
+		{
+			Name: "claim",
+			Description: "Claim an escheated balance back into its account",
+			Callback: claimEscheatment,
+			Options: []*handlers.Option{
+				{Name: "balance", Description: "The escheated balance", Type: "", Required: true, Autocomplete: &escheatmentAutocomplete},
+			},
+		},
+	},
+}
+
+// escheatmentLine describes a warning or an escheated balance in a single line
+func escheatmentLine(escheatment database.Escheatment) string {
+	economy := escheatment.Account.Economy
+
+	switch escheatment.Status {
+	case database.ES_Warned:
+		return fmt.Sprintf("**#%d** %s will be escheated <t:%d:R> unless it is used", escheatment.ID, escheatment.Account.AccountName, escheatment.DueAt.Unix())
+	case database.ES_Escheated:
+		return fmt.Sprintf("**#%d** %s escheated from %s, claimable until <t:%d:f>", escheatment.ID, economy.FormatMoney(escheatment.Amount), escheatment.Account.AccountName, escheatment.ClaimBy.Unix())
+	default:
+		return fmt.Sprintf("**#%d** %s, %s", escheatment.ID, escheatment.Account.AccountName, strings.ToLower(database.EscheatmentStatusName(escheatment.Status)))
+	}
+}
+
+func setEscheatmentPolicy(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	policy := database.EscheatmentPolicy{WarningDays: 7, ClaimDays: 30}
+	if policy.ToAccount, err = accountOption(ctx, economy, "account"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	days := []struct {
+		name string
+		value *uint
+	}{{"days", &policy.DormantDays}, {"warning", &policy.WarningDays}, {"claim", &policy.ClaimDays}}
+	for _, option := range days {
+		if opt := ctx.Option(option.name); opt != nil {
+			if opt.IntValue() < 0 {
+				handlers.RespondError(ctx, s, event, database.BackendError{Message: "The number of days cannot be negative."})
+				return
+			}
+			*option.value = uint(opt.IntValue())
+		}
+	}
+
+	if err := ctx.Backend.SetEscheatmentPolicy(member, economy, &policy); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Dormancy policy updated").SetColor(handlers.Colors.Normal).
+		AddField("Dormant after", fmt.Sprintf("%d days", policy.DormantDays)).
+		AddField("Warning", fmt.Sprintf("%d days before", policy.WarningDays)).
+		AddField("Claim window", fmt.Sprintf("%d days", policy.ClaimDays)).
+		AddField("Escheated to", policy.ToAccount.AccountName).
+		InlineAllFields()
+	handlers.Respond(s, event, embed)
+}
+
+func removeEscheatmentPolicy(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.RemoveEscheatmentPolicy(member, economy); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Dormancy policy removed").SetColor(handlers.Colors.Normal).
+		SetDescription("Dormant accounts are no longer escheated. Balances that already were can still be claimed back.")
+	handlers.Respond(s, event, embed)
+}
+
+func listEscheatments(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	escheatments, err := ctx.Backend.GetEscheatments(economy, member.User.ID)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var lines []string
+	for _, escheatment := range escheatments {
+		lines = append(lines, escheatmentLine(escheatment))
+	}
+
+	embed := utils.NewEmbed().SetTitle("Dormant accounts").SetColor(handlers.Colors.Normal)
+	if len(lines) == 0 {
+		embed.SetDescription("None of your accounts are dormant.")
+	} else {
+		embed.SetDescription(strings.Join(lines, "\n"))
+	}
+
+	handlers.RespondEphemeral(s, event, embed)
+}
+
+func claimEscheatment(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	id, err := strconv.ParseUint(strings.TrimPrefix(ctx.Option("balance").StringValue(), "#"), 10, 64)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, database.BackendError{Message: "Please choose an escheated balance from the list."})
+		return
+	}
+
+	escheatment, err := ctx.Backend.GetEscheatmentByID(uint(id))
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.ClaimEscheatment(member, &escheatment); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy := escheatment.Account.Economy
+	embed := utils.NewEmbed().SetTitle("Balance claimed back").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("%s has been returned to **%s**.", economy.FormatMoney(escheatment.Amount), escheatment.Account.AccountName))
+	handlers.Respond(s, event, embed)
+}
+
+// notifyEscheatment tells the owner of a dormant account by DM that it is about to be escheated, or has been
+func (b *Bot) notifyEscheatment(escheatment database.Escheatment) error {
+	full, err := b.Backend.GetEscheatmentByID(escheatment.ID)
+	if err != nil {
+		return err
+	}
+
+	embed := utils.NewEmbed().SetDescription(escheatmentLine(full) + ".")
+	if full.Status == database.ES_Warned {
+		embed.SetTitle("Your account is dormant").SetColor(handlers.Colors.Warning).
+			SetFooter("Making or receiving a transfer keeps the account active.")
+	} else {
+		embed.SetTitle("Dormant balance escheated").SetColor(handlers.Colors.Error).
+			SetFooter("Use /dormancy claim to get it back.")
+	}
+
+	channel, err := b.Session.UserChannelCreate(full.OwnerID)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.Session.ChannelMessageSendEmbed(channel.ID, embed.Truncate().MessageEmbed)
+	return err
+}
@@ -0,0 +1,482 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var escrowAutocomplete handlers.EventFunc = func(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	escrowContractAutocomplete(ctx, s, event, false)
+}
+
+var disputedEscrowAutocomplete handlers.EventFunc = func(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	escrowContractAutocomplete(ctx, s, event, true)
+}
+
+var EscrowCommand = handlers.Command{
+	Name: "escrow",
+	Description: "Trade safely by holding the payment in escrow until both sides confirm",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "create",
+			Description: "Hold a payment for a seller until you both confirm the trade",
+			Callback: createEscrow,
+			Options: []*handlers.Option{
+				{Name: "seller", Description: "Who you are buying from", Type: &discordgo.User{}, Required: true},
+				{Name: "amount", Description: "The amount to hold", Type: "", Required: true},
+				{Name: "terms", Description: "What the seller has to deliver", Type: "", Required: true},
+				{Name: "days", Description: "How many days until the contract expires and is refunded, 7 if omitted", Type: 0},
+				{Name: "from", Description: "The account to pay from instead", Type: "", Autocomplete: &accountAutocomplete},
+			},
+		},
+		{
+			Name: "list",
+			Description: "List your open escrow contracts",
+			Callback: listEscrow,
+		},
+		{
+			Name: "show",
+			Description: "Show an escrow contract",
+			Callback: showEscrow,
+			Options: []*handlers.Option{
+				{Name: "contract", Description: "The contract", Type: "", Required: true, Autocomplete: &escrowAutocomplete},
+			},
+		},
+		{
+			Name: "confirm",
+			Description: "Confirm that the trade is done, paying the seller once both sides have",
+			Callback: confirmEscrowCommand,
+			Options: []*handlers.Option{
+				{Name: "contract", Description: "The contract", Type: "", Required: true, Autocomplete: &escrowAutocomplete},
+			},
+		},
+		{
+			Name: "cancel",
+			Description: "Cancel a contract and refund the buyer",
+			Callback: cancelEscrowCommand,
+			Options: []*handlers.Option{
+				{Name: "contract", Description: "The contract", Type: "", Required: true, Autocomplete: &escrowAutocomplete},
+			},
+		},
+		{
+			Name: "dispute",
+			Description: "Ask an arbitrator to settle a contract",
+			Callback: disputeEscrow,
+			Options: []*handlers.Option{
+				{Name: "contract", Description: "The contract", Type: "", Required: true, Autocomplete: &escrowAutocomplete},
+				{Name: "reason", Description: "What went wrong", Type: "", Required: true},
+			},
+		},
+		{
+			Name: "resolve",
+			Description: "Settle a disputed contract as an arbitrator",
+			Callback: resolveEscrow,
+			Options: []*handlers.Option{
+				{Name: "contract", Description: "The disputed contract", Type: "", Required: true, Autocomplete: &disputedEscrowAutocomplete},
+				{Name: "release", Description: "Whether to pay the seller, or otherwise refund the buyer", Type: true, Required: true},
+				{Name: "note", Description: "A note for the buyer and seller", Type: ""},
+			},
+		},
+	},
+}
+
+// EscrowComponent handles the confirm and cancel buttons of escrow contracts, whose custom IDs are "escrow:<action>:<id>"
+var EscrowComponent = handlers.Component{
+	Name: "escrow",
+	Callback: handleEscrowButton,
+}
+
+func escrowContractAutocomplete(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, disputed bool) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	var contracts []database.EscrowContract
+	if disputed {
+		contracts, err = ctx.Backend.GetDisputedEscrowContracts(economy)
+	} else {
+		contracts, err = ctx.Backend.GetEscrowContracts(member, economy)
+	}
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	query := strings.ToLower(focusedValue(ctx))
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, contract := range contracts {
+		name := fmt.Sprintf("#%d: %s for %s", contract.ID, economy.FormatMoney(contract.Amount), contract.Terms)
+		if len(name) > 100 {
+			name = name[:97] + "..."
+		}
+
+		if strings.Contains(strings.ToLower(name), query) && len(choices) < 25 {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: fmt.Sprint(contract.ID)})
+		}
+	}
+	autocomplete(s, event, choices)
+}
+
+// escrowOption looks up the escrow contract chosen in an option
+func escrowOption(ctx *handlers.Context, name string) (database.EscrowContract, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(ctx.Option(name).StringValue(), "#"), 10, 64)
+	if err != nil {
+		return database.EscrowContract{}, database.BackendError{Message: "Please choose a contract from the list."}
+	}
+	return ctx.Backend.GetEscrowContractByID(uint(id))
+}
+
+func escrowEmbed(contract database.EscrowContract) *utils.Embed {
+	economy := contract.BuyerAccount.Economy
+
+	color := handlers.Colors.Normal
+	if contract.Status == database.EC_Disputed {
+		color = handlers.Colors.Error
+	} else if contract.Status == database.EC_Open {
+		color = handlers.Colors.Warning
+	}
+
+	confirmed := func(yes bool) string {
+		if yes {
+			return "Confirmed"
+		}
+		return "Waiting"
+	}
+
+	embed := utils.NewEmbed().SetTitle(fmt.Sprintf("Escrow contract #%d", contract.ID)).SetColor(color).
+		SetDescription(contract.Terms).
+		AddField("Buyer", fmt.Sprintf("<@%s>", contract.BuyerID)).
+		AddField("Seller", fmt.Sprintf("<@%s>", contract.SellerID)).
+		AddField("Price", economy.FormatMoney(contract.Amount)).
+		AddField("Status", database.EscrowStatusName(contract.Status))
+
+	// exclusive VAT and savings penalties are held on top of the price
+	if contract.Held != contract.Amount {
+		embed.AddField("Held", economy.FormatMoney(contract.Held))
+	}
+
+	if contract.Status == database.EC_Open {
+		embed.AddField("Buyer confirmation", confirmed(contract.BuyerConfirmed)).
+			AddField("Seller confirmation", confirmed(contract.SellerConfirmed)).
+			AddField("Expires", fmt.Sprintf("<t:%d:R>", contract.ExpiresAt.Unix()))
+	}
+	if contract.Dispute != "" {
+		embed.AddField("Dispute", contract.Dispute)
+	}
+	if contract.Resolution != "" {
+		embed.AddField("Resolution", contract.Resolution)
+	}
+	if contract.TransferID != nil {
+		embed.SetFooter(fmt.Sprintf("Transfer #%d", *contract.TransferID))
+	}
+
+	return embed.InlineAllFields()
+}
+
+// escrowComponents returns the buttons of an open contract, or none once it is no longer open
+func escrowComponents(contract database.EscrowContract) []discordgo.MessageComponent {
+	if contract.Status != database.EC_Open {
+		return []discordgo.MessageComponent{}
+	}
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.Button{Label: "Confirm", Style: discordgo.SuccessButton, CustomID: fmt.Sprintf("escrow:confirm:%d", contract.ID)},
+			discordgo.Button{Label: "Cancel", Style: discordgo.DangerButton, CustomID: fmt.Sprintf("escrow:cancel:%d", contract.ID)},
+		}},
+	}
+}
+
+// notifyEscrow sends a contract to a party of it by DM, if they accept DMs
+func notifyEscrow(s *discordgo.Session, user_id string, contract database.EscrowContract) error {
+	channel, err := s.UserChannelCreate(user_id)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.ChannelMessageSendComplex(channel.ID, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{escrowEmbed(contract).Truncate().MessageEmbed},
+		Components: escrowComponents(contract),
+	})
+	return err
+}
+
+// notifyEscrowCounterparty tells the other party of a contract that the member has changed it
+func notifyEscrowCounterparty(s *discordgo.Session, member database.SessionedMember, contract database.EscrowContract) {
+	if member.User.ID == contract.BuyerID {
+		notifyEscrow(s, contract.SellerID, contract)
+	} else {
+		notifyEscrow(s, contract.BuyerID, contract)
+	}
+}
+
+func createEscrow(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var from database.Account
+	if ctx.Option("from") != nil {
+		from, err = accountOption(ctx, economy, "from")
+	} else {
+		from, _, err = ctx.Backend.GetActingAccount(member, economy)
+	}
+
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	amount, err := moneyOption(ctx, economy, "amount")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	days := int64(7)
+	if opt := ctx.Option("days"); opt != nil {
+		days = opt.IntValue()
+	}
+	if days < 1 {
+		handlers.RespondError(ctx, s, event, database.BackendError{Message: "A contract has to last at least a day."})
+		return
+	}
+
+	contract := database.EscrowContract{
+		GuildID: event.GuildID,
+		SellerID: ctx.Option("seller").Value.(string),
+		BuyerAccount: from,
+		Amount: amount,
+		Terms: ctx.Option("terms").StringValue(),
+	}
+
+	if err := ctx.Backend.CreateEscrowContract(member, economy, &contract, uint(days)); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := notifyEscrow(s, contract.SellerID, contract); err != nil {
+		handlers.RespondComponents(s, event, escrowEmbed(contract), escrowComponents(contract))
+		return
+	}
+
+	handlers.Respond(s, event, escrowEmbed(contract).SetFooter("The seller has been sent the contract by DM."))
+}
+
+func listEscrow(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	contracts, err := ctx.Backend.GetEscrowContracts(member, economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var lines []string
+	for _, contract := range contracts {
+		lines = append(lines, fmt.Sprintf("**#%d** %s from <@%s> to <@%s> for %s, %s", contract.ID, economy.FormatMoney(contract.Amount),
+			contract.BuyerID, contract.SellerID, contract.Terms, strings.ToLower(database.EscrowStatusName(contract.Status))))
+	}
+
+	embed := utils.NewEmbed().SetTitle("Escrow contracts").SetColor(handlers.Colors.Normal)
+	if len(lines) == 0 {
+		embed.SetDescription("You have no open escrow contracts.")
+	} else {
+		embed.SetDescription(strings.Join(lines, "\n"))
+	}
+
+	handlers.RespondEphemeral(s, event, embed.Truncate())
+}
+
+func showEscrow(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	contract, err := escrowOption(ctx, "contract")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	// arbitrators may look at any contract of the economy
+	if member.User.ID != contract.BuyerID && member.User.ID != contract.SellerID {
+		economy := contract.BuyerAccount.Economy
+		if perm, err := ctx.Backend.HasPermission(member, database.P_ManageFunds, nil, &economy); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		} else if !perm {
+			handlers.RespondError(ctx, s, event, database.BackendError{Message: "You can only view contracts you are a party to."})
+			return
+		}
+	}
+
+	handlers.RespondEphemeral(s, event, escrowEmbed(contract))
+}
+
+func confirmEscrowCommand(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	answerEscrowCommand(ctx, s, event, func(member database.SessionedMember, contract *database.EscrowContract) error {
+		_, err := ctx.Backend.ConfirmEscrowContract(member, contract)
+		return err
+	})
+}
+
+func cancelEscrowCommand(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	answerEscrowCommand(ctx, s, event, ctx.Backend.CancelEscrowContract)
+}
+
+// answerEscrowCommand runs one of the escrow subcommands a party uses to answer a contract, and shows its final state
+func answerEscrowCommand(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, answer func(member database.SessionedMember, contract *database.EscrowContract) error) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	contract, err := escrowOption(ctx, "contract")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := answer(member, &contract); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	notifyEscrowCounterparty(s, member, contract)
+	handlers.RespondEphemeral(s, event, escrowEmbed(contract))
+}
+
+func handleEscrowButton(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	parts := strings.Split(event.MessageComponentData().CustomID, ":")
+	if len(parts) != 3 {
+		return
+	}
+
+	id, err := strconv.ParseUint(parts[2], 10, 64)
+	if err != nil {
+		return
+	}
+
+	contract, err := ctx.Backend.GetEscrowContractByID(uint(id))
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	member, err := interactionMember(ctx, s, event, contract.GuildID)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	switch parts[1] {
+	case "confirm":
+		_, err = ctx.Backend.ConfirmEscrowContract(member, &contract)
+	case "cancel":
+		err = ctx.Backend.CancelEscrowContract(member, &contract)
+	default:
+		return
+	}
+
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	notifyEscrowCounterparty(s, member, contract)
+	handlers.UpdateMessage(s, event, escrowEmbed(contract), escrowComponents(contract))
+}
+
+func disputeEscrow(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	contract, err := escrowOption(ctx, "contract")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.DisputeEscrowContract(member, &contract, ctx.Option("reason").StringValue()); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	notifyEscrowCounterparty(s, member, contract)
+	handlers.Respond(s, event, escrowEmbed(contract).SetFooter("An arbitrator will settle this contract with /escrow resolve."))
+}
+
+func resolveEscrow(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	contract, err := escrowOption(ctx, "contract")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	note := ""
+	if opt := ctx.Option("note"); opt != nil {
+		note = opt.StringValue()
+	}
+
+	if err := ctx.Backend.ResolveEscrowContract(member, &contract, ctx.Option("release").BoolValue(), note); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	notifyEscrow(s, contract.BuyerID, contract)
+	notifyEscrow(s, contract.SellerID, contract)
+	handlers.Respond(s, event, escrowEmbed(contract))
+}
+
+// notifyEscrowExpired tells the buyer and seller of a contract that it expired and was refunded
+func (b *Bot) notifyEscrowExpired(contract database.EscrowContract) error {
+	if err := notifyEscrow(b.Session, contract.BuyerID, contract); err != nil {
+		return err
+	}
+	return notifyEscrow(b.Session, contract.SellerID, contract)
+}
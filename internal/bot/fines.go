@@ -0,0 +1,369 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var shortfallChoices = []*discordgo.ApplicationCommandOptionChoice{
+	{Name: "Refuse the fine", Value: database.FS_Refuse},
+	{Name: "Overdraw the account", Value: database.FS_Overdraft},
+	{Name: "Record a debt", Value: database.FS_Debt},
+}
+
+var FineCommand = handlers.Command{
+	Name: "fine",
+	Description: "Fine a user, paid from their account into the fines account of this economy",
+	Callback: issueFine,
+	Options: []*handlers.Option{
+		{Name: "user", Description: "The user to fine", Type: &discordgo.User{}, Required: true},
+		{Name: "amount", Description: "The amount of the fine", Type: "", Required: true},
+		{Name: "reason", Description: "Why the user is fined", Type: "", Required: true},
+	},
+}
+
+var ownSanctionAutocomplete handlers.EventFunc = func(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	sanctionAutocomplete(ctx, s, event, false)
+}
+
+var appealedSanctionAutocomplete handlers.EventFunc = func(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	sanctionAutocomplete(ctx, s, event, true)
+}
+
+var SanctionsCommand = handlers.Command{
+	Name: "sanctions",
+	Description: "View, appeal and review fines",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "history",
+			Description: "Show the sanctions of a user",
+			Callback: sanctionHistory,
+			Options: []*handlers.Option{
+				{Name: "user", Description: "Whose sanctions to show, yours if omitted", Type: &discordgo.User{}},
+			},
+		},
+		{
+			Name: "appeal",
+			Description: "Ask the admins to overturn one of your fines",
+			Callback: appealSanction,
+			Options: []*handlers.Option{
+				{Name: "sanction", Description: "The fine to appeal", Type: "", Required: true, Autocomplete: &ownSanctionAutocomplete},
+				{Name: "reason", Description: "Why the fine should be overturned", Type: "", Required: true},
+			},
+		},
+		{
+			Name: "review",
+			Description: "Overturn a fine, refunding it, or uphold its appeal",
+			Callback: reviewSanction,
+			Options: []*handlers.Option{
+				{Name: "sanction", Description: "The fine to review", Type: "", Required: true, Autocomplete: &appealedSanctionAutocomplete},
+				{Name: "overturn", Description: "Whether to overturn the fine and refund it", Type: true, Required: true},
+				{Name: "note", Description: "A note for the fined user", Type: ""},
+			},
+		},
+		{
+			Name: "settle",
+			Description: "Pay off as much of the debt of one of your fines as you can",
+			Callback: settleSanction,
+			Options: []*handlers.Option{
+				{Name: "sanction", Description: "The fine to settle", Type: "", Required: true, Autocomplete: &ownSanctionAutocomplete},
+			},
+		},
+		{
+			Name: "policy",
+			Description: "Choose the government account fines are paid into",
+			Callback: setFinePolicy,
+			Options: []*handlers.Option{
+				{Name: "account", Description: "The government account receiving fines", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+				{Name: "shortfall", Description: "What to do when someone cannot afford a fine, refuse it if omitted", Type: 0, Choices: shortfallChoices},
+			},
+		},
+	},
+}
+
+func sanctionAutocomplete(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, appealed bool) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	var sanctions []database.Sanction
+	if appealed {
+		sanctions, err = ctx.Backend.GetAppealedSanctions(economy)
+	} else {
+		sanctions, err = ctx.Backend.GetSanctions(member, economy, member.User.ID)
+	}
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	query := strings.ToLower(focusedValue(ctx))
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, sanction := range sanctions {
+		name := fmt.Sprintf("#%d: %s for %s", sanction.ID, economy.FormatMoney(sanction.Amount), sanction.Reason)
+		if len(name) > 100 {
+			name = name[:97] + "..."
+		}
+
+		if strings.Contains(strings.ToLower(name), query) && len(choices) < 25 {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: fmt.Sprint(sanction.ID)})
+		}
+	}
+	autocomplete(s, event, choices)
+}
+
+// sanctionOption looks up the sanction chosen in an option
+func sanctionOption(ctx *handlers.Context, name string) (database.Sanction, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(ctx.Option(name).StringValue(), "#"), 10, 64)
+	if err != nil {
+		return database.Sanction{}, database.BackendError{Message: "Please choose a sanction from the list."}
+	}
+	return ctx.Backend.GetSanctionByID(uint(id))
+}
+
+func sanctionEmbed(sanction database.Sanction) *utils.Embed {
+	economy := sanction.FromAccount.Economy
+
+	color := handlers.Colors.Error
+	if sanction.Status == database.SS_Overturned {
+		color = handlers.Colors.Normal
+	} else if sanction.Status == database.SS_Appealed {
+		color = handlers.Colors.Warning
+	}
+
+	embed := utils.NewEmbed().SetTitle(fmt.Sprintf("Fine #%d", sanction.ID)).SetColor(color).
+		SetDescription(fmt.Sprintf("<@%s> was fined by <@%s> on <t:%d:D>.", sanction.UserID, sanction.IssuerID, sanction.CreatedAt.Unix())).
+		AddField("Reason", sanction.Reason).
+		AddField("Amount", economy.FormatMoney(sanction.Amount)).
+		AddField("Paid into", sanction.ToAccount.AccountName).
+		AddField("Status", database.SanctionStatusName(sanction.Status))
+
+	if sanction.Debt.IsPositive() {
+		embed.AddField("Still owed", economy.FormatMoney(sanction.Debt))
+	}
+	if sanction.Appeal != "" {
+		embed.AddField("Appeal", sanction.Appeal)
+	}
+	if sanction.ReviewedAt != nil {
+		review := fmt.Sprintf("By <@%s>", sanction.ReviewerID)
+		if sanction.ReviewNote != "" {
+			review += ": " + sanction.ReviewNote
+		}
+		embed.AddField("Review", review)
+	}
+
+	return embed
+}
+
+// notifySanction tells the sanctioned user about a change to one of their sanctions by DM, if they accept DMs
+func notifySanction(s *discordgo.Session, sanction database.Sanction) {
+	channel, err := s.UserChannelCreate(sanction.UserID)
+	if err != nil {
+		return
+	}
+	s.ChannelMessageSendEmbed(channel.ID, sanctionEmbed(sanction).Truncate().MessageEmbed)
+}
+
+func issueFine(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	amount, err := moneyOption(ctx, economy, "amount")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	user_id := ctx.Option("user").Value.(string)
+	sanction, err := ctx.Backend.IssueFine(member, economy, user_id, amount, ctx.Option("reason").StringValue())
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, sanctionEmbed(*sanction))
+	notifySanction(s, *sanction)
+}
+
+func sanctionHistory(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	user_id := member.User.ID
+	if opt := ctx.Option("user"); opt != nil {
+		user_id = opt.Value.(string)
+	}
+
+	sanctions, err := ctx.Backend.GetSanctions(member, economy, user_id)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Sanctions").SetColor(handlers.Colors.Normal)
+	if len(sanctions) == 0 {
+		embed.SetDescription(fmt.Sprintf("<@%s> has never been fined.", user_id))
+	} else {
+		embed.SetDescription(fmt.Sprintf("The most recent sanctions of <@%s>.", user_id))
+	}
+
+	for _, sanction := range sanctions {
+		value := fmt.Sprintf("%s, %s, <t:%d:D>\n%s", economy.FormatMoney(sanction.Amount), strings.ToLower(database.SanctionStatusName(sanction.Status)), sanction.CreatedAt.Unix(), sanction.Reason)
+		if sanction.Debt.IsPositive() {
+			value += fmt.Sprintf("\n%s still owed", economy.FormatMoney(sanction.Debt))
+		}
+		embed.AddField(fmt.Sprintf("#%d", sanction.ID), value)
+	}
+
+	handlers.RespondEphemeral(s, event, embed)
+}
+
+func appealSanction(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	sanction, err := sanctionOption(ctx, "sanction")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.AppealSanction(member, &sanction, ctx.Option("reason").StringValue()); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.RespondEphemeral(s, event, sanctionEmbed(sanction).SetTitle(fmt.Sprintf("Fine #%d appealed", sanction.ID)))
+}
+
+func reviewSanction(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	sanction, err := sanctionOption(ctx, "sanction")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	note := ""
+	if opt := ctx.Option("note"); opt != nil {
+		note = opt.StringValue()
+	}
+
+	if err := ctx.Backend.ReviewSanction(member, economy, &sanction, ctx.Option("overturn").BoolValue(), note); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, sanctionEmbed(sanction))
+	notifySanction(s, sanction)
+}
+
+func settleSanction(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	sanction, err := sanctionOption(ctx, "sanction")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	paid, err := ctx.Backend.SettleSanction(member, &sanction)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy := sanction.FromAccount.Economy
+	message := fmt.Sprintf("You paid %s and have settled this fine.", economy.FormatMoney(paid))
+	if sanction.Debt.IsPositive() {
+		message = fmt.Sprintf("You paid %s and still owe %s.", economy.FormatMoney(paid), economy.FormatMoney(sanction.Debt))
+	}
+
+	embed := sanctionEmbed(sanction).SetDescription(message)
+	handlers.RespondEphemeral(s, event, embed)
+}
+
+func setFinePolicy(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var policy database.FinePolicy
+	if policy.Account, err = accountOption(ctx, economy, "account"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if opt := ctx.Option("shortfall"); opt != nil {
+		policy.Shortfall = uint8(opt.IntValue())
+	}
+
+	if err := ctx.Backend.SetFinePolicy(member, economy, &policy); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Fine policy updated").SetColor(handlers.Colors.Normal).
+		AddField("Fines account", policy.Account.AccountName).
+		AddField("When someone cannot afford a fine", database.ShortfallName(policy.Shortfall)).
+		InlineAllFields()
+	handlers.Respond(s, event, embed)
+}
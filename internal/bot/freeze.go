@@ -0,0 +1,156 @@
+package bot
+
+import (
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var FreezeCommand = handlers.Command{
+	Name: "freeze",
+	Description: "Stop an account from sending transfers",
+	Callback: freezeAccount,
+	Options: []*handlers.Option{
+		{Name: "account", Description: "The account to freeze", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+		{Name: "incoming", Description: "Whether to stop it from receiving transfers as well", Type: true},
+		{Name: "reason", Description: "Why the account is frozen, for the audit log", Type: ""},
+	},
+}
+
+var UnfreezeCommand = handlers.Command{
+	Name: "unfreeze",
+	Description: "Lift the freeze of an account",
+	Callback: unfreezeAccount,
+	Options: []*handlers.Option{
+		{Name: "account", Description: "The account to unfreeze", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+		{Name: "reason", Description: "Why the freeze is lifted, for the audit log", Type: ""},
+	},
+}
+
+var EconomyCommand = handlers.Command{
+	Name: "economy",
+	Description: "Manage this economy",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "lock",
+			Description: "Pause all transfers, recurring payments and scheduled jobs in this economy",
+			Callback: lockEconomy,
+			Options: []*handlers.Option{
+				{Name: "reason", Description: "Why the economy is locked, for the audit log", Type: ""},
+			},
+		},
+		{
+			Name: "unlock",
+			Description: "Resume transfers and scheduled jobs in this economy",
+			Callback: unlockEconomy,
+			Options: []*handlers.Option{
+				{Name: "reason", Description: "Why the economy is unlocked, for the audit log", Type: ""},
+			},
+		},
+		{
+			Name: "reversals",
+			Description: "Set who can reverse transfers in this economy",
+			Callback: setReversalPolicy,
+			Options: []*handlers.Option{
+				{Name: "policy", Description: "Who can reverse a transfer", Type: 0, Required: true, Choices: reversalPolicyChoices},
+			},
+		},
+	},
+}
+
+// reasonOption returns the reason given in a command, if any
+func reasonOption(ctx *handlers.Context) string {
+	if opt := ctx.Option("reason"); opt != nil {
+		return opt.StringValue()
+	}
+	return ""
+}
+
+func setAccountFreeze(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, outgoing bool, incoming bool) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.FreezeAccount(member, &account, outgoing, incoming, reasonOption(ctx)); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	message := fmt.Sprintf("**%s** can no longer send transfers.", account.AccountName)
+	if incoming {
+		message = fmt.Sprintf("**%s** can no longer send or receive transfers.", account.AccountName)
+	} else if !outgoing {
+		message = fmt.Sprintf("**%s** can send and receive transfers again.", account.AccountName)
+	}
+
+	title := "Account frozen"
+	if !outgoing {
+		title = "Account unfrozen"
+	}
+
+	embed := utils.NewEmbed().SetTitle(title).SetColor(handlers.Colors.Normal).SetDescription(message)
+	handlers.Respond(s, event, embed)
+}
+
+func freezeAccount(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	incoming := false
+	if opt := ctx.Option("incoming"); opt != nil {
+		incoming = opt.BoolValue()
+	}
+	setAccountFreeze(ctx, s, event, true, incoming)
+}
+
+func unfreezeAccount(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	setAccountFreeze(ctx, s, event, false, false)
+}
+
+func setEconomyLock(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, locked bool) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.LockEconomy(member, &economy, locked, reasonOption(ctx)); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Economy unlocked").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("Transfers and scheduled payments in %s have resumed.", economy.Name))
+	if locked {
+		embed.SetTitle("Economy locked").SetColor(handlers.Colors.Warning).
+			SetDescription(fmt.Sprintf("All transfers, recurring payments and scheduled jobs in %s are paused until it is unlocked.", economy.Name))
+	}
+	handlers.Respond(s, event, embed)
+}
+
+func lockEconomy(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	setEconomyLock(ctx, s, event, true)
+}
+
+func unlockEconomy(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	setEconomyLock(ctx, s, event, false)
+}
@@ -0,0 +1,196 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+const maxListedBreaks = 10
+
+// ledgerPermissions hides /ledger from members who cannot manage the server until an admin allows them,
+// while the backend still checks P_ManageEconomies on every subcommand
+var ledgerPermissions int64 = discordgo.PermissionManageGuild
+
+var LedgerCommand = handlers.Command{
+	Name: "ledger",
+	Description: "Inspect the integrity of this economy's ledger",
+	DefaultPermissions: &ledgerPermissions,
+	Subcommands: []*handlers.Command{
+		{
+			Name: "verify",
+			Description: "Verify the ledger's hash chain and account balances",
+			Callback: verifyLedger,
+		},
+		{
+			Name: "checkpoint",
+			Description: "Export a signed checkpoint of the ledger's current state",
+			Callback: exportCheckpoint,
+		},
+		{
+			Name: "check",
+			Description: "Check an exported checkpoint against the ledger",
+			Callback: checkCheckpoint,
+			Options: []*handlers.Option{
+				{Name: "checkpoint", Description: "The exported checkpoint file", Type: discordgo.MessageAttachment{}, Required: true},
+			},
+		},
+	},
+}
+
+func verifyLedger(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	report, err := ctx.Backend.VerifyChain(member, economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	discrepancies, err := ctx.Backend.VerifyLedger(member, economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Ledger verification").SetColor(handlers.Colors.Normal)
+	embed.AddField("Entries checked", fmt.Sprint(report.Checked))
+	if report.Head != "" {
+		embed.AddField("Chain head", fmt.Sprintf("`%s`", report.Head))
+	}
+
+	if len(report.Breaks) == 0 && len(discrepancies) == 0 {
+		embed.SetDescription("The ledger is intact.")
+		handlers.Respond(s, event, embed)
+		return
+	}
+
+	embed.SetColor(handlers.Colors.Error).SetDescription("The ledger has been tampered with or is inconsistent.")
+
+	if len(report.Breaks) > 0 {
+		var lines []string
+		for i, brk := range report.Breaks {
+			if i == maxListedBreaks {
+				lines = append(lines, fmt.Sprintf("...and %d more", len(report.Breaks) - maxListedBreaks))
+				break
+			}
+			lines = append(lines, fmt.Sprintf("#%d (entry %d): %s", brk.Sequence, brk.JournalID, brk.Reason))
+		}
+		embed.AddField("Chain breaks", strings.Join(lines, "\n"))
+	}
+
+	if len(discrepancies) > 0 {
+		var lines []string
+		for i, d := range discrepancies {
+			if i == maxListedBreaks {
+				lines = append(lines, fmt.Sprintf("...and %d more", len(discrepancies) - maxListedBreaks))
+				break
+			}
+			lines = append(lines, fmt.Sprintf("%s: %s, expected %s", d.Account.AccountName, economy.FormatMoney(d.Balance), economy.FormatMoney(d.Expected)))
+		}
+		embed.AddField("Balance discrepancies", strings.Join(lines, "\n"))
+	}
+
+	handlers.Respond(s, event, embed)
+}
+
+func exportCheckpoint(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	checkpoint, err := ctx.Backend.CreateCheckpoint(member, economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	data, err := json.MarshalIndent(checkpoint, "", "\t")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Ledger checkpoint").SetColor(handlers.Colors.Normal).
+		SetDescription("Keep this file; it can later be checked with `/ledger check` to prove the ledger up to this entry was not rewritten.").
+		AddField("Entry", fmt.Sprint(checkpoint.Sequence)).
+		AddField("Hash", fmt.Sprintf("`%s`", checkpoint.Hash))
+
+	handlers.Respond(s, event, embed, &discordgo.File{
+		Name: fmt.Sprintf("checkpoint-%s-%d.json", economy.Name, checkpoint.Sequence),
+		ContentType: "application/json",
+		Reader: bytes.NewReader(data),
+	})
+}
+
+func checkCheckpoint(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	attachment := event.ApplicationCommandData().Resolved.Attachments[ctx.Option("checkpoint").Value.(string)]
+
+	client := http.Client{Timeout: 10 * time.Second}
+	response, err := client.Get(attachment.URL)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+	defer response.Body.Close()
+
+	var checkpoint database.LedgerCheckpoint
+	if err := json.NewDecoder(io.LimitReader(response.Body, 64 * 1024)).Decode(&checkpoint); err != nil {
+		handlers.RespondError(ctx, s, event, database.BackendError{Message: "The attachment is not a valid checkpoint."})
+		return
+	}
+
+	valid, err := ctx.Backend.VerifyCheckpoint(member, economy, checkpoint)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Ledger checkpoint").AddField("Entry", fmt.Sprint(checkpoint.Sequence))
+	if valid {
+		embed.SetColor(handlers.Colors.Normal).SetDescription("The ledger still matches this checkpoint.")
+	} else {
+		embed.SetColor(handlers.Colors.Error).SetDescription("The ledger no longer matches this checkpoint; entries up to it have been modified or removed.")
+	}
+
+	handlers.Respond(s, event, embed)
+}
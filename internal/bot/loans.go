@@ -0,0 +1,452 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var loanAutocomplete handlers.EventFunc = func(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	loans, err := ctx.Backend.GetLoans(member, economy)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	query := strings.ToLower(focusedValue(ctx))
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, loan := range loans {
+		name := fmt.Sprintf("#%d: %s from %s, %s", loan.ID, economy.FormatMoney(loan.Principal), loan.LenderAccount.AccountName, strings.ToLower(database.LoanStatusName(loan.Status)))
+		if len(name) > 100 {
+			name = name[:97] + "..."
+		}
+
+		if strings.Contains(strings.ToLower(name), query) && len(choices) < 25 {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: fmt.Sprint(loan.ID)})
+		}
+	}
+	autocomplete(s, event, choices)
+}
+
+var LoanCommand = handlers.Command{
+	Name: "loan",
+	Description: "Lend and borrow money, repaid in installments",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "offer",
+			Description: "Offer someone a loan from your account, or the account you are logged in as",
+			Callback: offerLoan,
+			Options: []*handlers.Option{
+				{Name: "user", Description: "Who to lend to", Type: &discordgo.User{}, Required: true},
+				{Name: "principal", Description: "The amount to lend", Type: "", Required: true},
+				{Name: "rate", Description: "The interest on the outstanding principal per installment, such as 1.5%", Type: "", Required: true},
+				{Name: "installments", Description: "How many installments to repay the loan in", Type: 0, Required: true},
+				{Name: "interval", Description: "How often an installment is due", Type: 0, Required: true, Choices: periodChoices[1:]},
+				{Name: "collections", Description: "Whether you may collect from the borrower's other accounts if they default", Type: true},
+				{Name: "from", Description: "The account to lend from instead", Type: "", Autocomplete: &accountAutocomplete},
+			},
+		},
+		{
+			Name: "list",
+			Description: "List the loans you have lent or borrowed",
+			Callback: listLoans,
+		},
+		{
+			Name: "show",
+			Description: "Show the state of a loan",
+			Callback: showLoan,
+			Options: []*handlers.Option{
+				{Name: "loan", Description: "The loan", Type: "", Required: true, Autocomplete: &loanAutocomplete},
+			},
+		},
+		{
+			Name: "accept",
+			Description: "Accept a loan offered to you",
+			Callback: acceptLoanCommand,
+			Options: []*handlers.Option{
+				{Name: "loan", Description: "The loan to accept", Type: "", Required: true, Autocomplete: &loanAutocomplete},
+			},
+		},
+		{
+			Name: "decline",
+			Description: "Decline a loan offered to you",
+			Callback: declineLoanCommand,
+			Options: []*handlers.Option{
+				{Name: "loan", Description: "The loan to decline", Type: "", Required: true, Autocomplete: &loanAutocomplete},
+			},
+		},
+		{
+			Name: "cancel",
+			Description: "Withdraw a loan you have offered",
+			Callback: cancelLoanCommand,
+			Options: []*handlers.Option{
+				{Name: "loan", Description: "The loan to withdraw", Type: "", Required: true, Autocomplete: &loanAutocomplete},
+			},
+		},
+		{
+			Name: "collect",
+			Description: "Collect on a defaulted loan from another account of the borrower",
+			Callback: collectLoan,
+			Options: []*handlers.Option{
+				{Name: "loan", Description: "The defaulted loan", Type: "", Required: true, Autocomplete: &loanAutocomplete},
+				{Name: "account", Description: "The account of the borrower to collect from", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+			},
+		},
+	},
+}
+
+// LoanComponent handles the accept and decline buttons of loan offers, whose custom IDs are "loan:<action>:<id>"
+var LoanComponent = handlers.Component{
+	Name: "loan",
+	Callback: handleLoanButton,
+}
+
+// loanOption looks up the loan chosen in an option
+func loanOption(ctx *handlers.Context, name string) (database.Loan, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(ctx.Option(name).StringValue(), "#"), 10, 64)
+	if err != nil {
+		return database.Loan{}, database.BackendError{Message: "Please choose a loan from the list."}
+	}
+	return ctx.Backend.GetLoanByID(uint(id))
+}
+
+func loanEmbed(loan database.Loan) *utils.Embed {
+	economy := loan.LenderAccount.Economy
+
+	color := handlers.Colors.Normal
+	if loan.Status == database.LS_Offered {
+		color = handlers.Colors.Warning
+	} else if loan.Status == database.LS_Defaulted || loan.Status == database.LS_Declined || loan.Status == database.LS_Cancelled {
+		color = handlers.Colors.Error
+	}
+
+	embed := utils.NewEmbed().SetTitle(fmt.Sprintf("Loan #%d", loan.ID)).SetColor(color).
+		SetDescription(fmt.Sprintf("<@%s> lends <@%s> money from **%s**.", loan.LenderID, loan.BorrowerID, loan.LenderAccount.AccountName)).
+		AddField("Principal", economy.FormatMoney(loan.Principal)).
+		AddField("Interest", fmt.Sprintf("%s %s", database.FormatBasisPoints(loan.Rate), database.PeriodName(loan.PaymentInterval))).
+		AddField("Installments", fmt.Sprintf("%d of %s", loan.Installments, economy.FormatMoney(loan.Installment))).
+		AddField("Collections", map[bool]string{true: "Allowed", false: "Not allowed"}[loan.Collections]).
+		AddField("Status", database.LoanStatusName(loan.Status))
+
+	if loan.Status == database.LS_Active || loan.Status == database.LS_Defaulted {
+		embed.AddField("Outstanding principal", economy.FormatMoney(loan.OutstandingPrincipal)).
+			AddField("Outstanding interest", economy.FormatMoney(loan.OutstandingInterest))
+	}
+	if loan.Missed > 0 {
+		embed.AddField("Missed installments", fmt.Sprint(loan.Missed))
+	}
+
+	return embed.InlineAllFields()
+}
+
+// loanComponents returns the buttons of a loan offer, or none once it has been answered
+func loanComponents(loan database.Loan) []discordgo.MessageComponent {
+	if loan.Status != database.LS_Offered {
+		return []discordgo.MessageComponent{}
+	}
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.Button{Label: "Accept", Style: discordgo.SuccessButton, CustomID: fmt.Sprintf("loan:accept:%d", loan.ID)},
+			discordgo.Button{Label: "Decline", Style: discordgo.DangerButton, CustomID: fmt.Sprintf("loan:decline:%d", loan.ID)},
+		}},
+	}
+}
+
+func offerLoan(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var from database.Account
+	if ctx.Option("from") != nil {
+		from, err = accountOption(ctx, economy, "from")
+	} else {
+		from, _, err = ctx.Backend.GetActingAccount(member, economy)
+	}
+
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	principal, err := moneyOption(ctx, economy, "principal")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	rate, err := rateOption(ctx, "rate")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	installments := ctx.Option("installments").IntValue()
+	if installments < 1 {
+		handlers.RespondError(ctx, s, event, database.BackendError{Message: "A loan needs at least one installment."})
+		return
+	}
+
+	from.Economy = economy
+	loan := database.Loan{
+		GuildID: event.GuildID,
+		BorrowerID: ctx.Option("user").Value.(string),
+		LenderAccount: from,
+		Principal: principal,
+		Rate: rate,
+		Installments: uint(installments),
+		PaymentInterval: uint8(ctx.Option("interval").IntValue()),
+	}
+
+	if opt := ctx.Option("collections"); opt != nil {
+		loan.Collections = opt.BoolValue()
+	}
+
+	if err := ctx.Backend.OfferLoan(member, economy, &loan); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := loanEmbed(loan)
+	channel, err := s.UserChannelCreate(loan.BorrowerID)
+	if err == nil {
+		_, err = s.ChannelMessageSendComplex(channel.ID, &discordgo.MessageSend{
+			Embeds: []*discordgo.MessageEmbed{embed.Truncate().MessageEmbed},
+			Components: loanComponents(loan),
+		})
+	}
+
+	if err != nil {
+		handlers.RespondComponents(s, event, embed, loanComponents(loan))
+		return
+	}
+
+	notice := utils.NewEmbed().SetTitle("Loan offered").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("<@%s> has been offered a loan of %s by DM.", loan.BorrowerID, economy.FormatMoney(loan.Principal)))
+	handlers.Respond(s, event, notice)
+}
+
+func listLoans(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	loans, err := ctx.Backend.GetLoans(member, economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var lines []string
+	for _, loan := range loans {
+		line := fmt.Sprintf("**#%d** %s from <@%s> to <@%s>, %s", loan.ID, economy.FormatMoney(loan.Principal), loan.LenderID, loan.BorrowerID, strings.ToLower(database.LoanStatusName(loan.Status)))
+		if owed, err := loan.Outstanding(); err == nil && owed.IsPositive() {
+			line += fmt.Sprintf(", %s owed", economy.FormatMoney(owed))
+		}
+		lines = append(lines, line)
+	}
+
+	embed := utils.NewEmbed().SetTitle("Loans").SetColor(handlers.Colors.Normal)
+	if len(lines) == 0 {
+		embed.SetDescription("You have no open loans.")
+	} else {
+		embed.SetDescription(strings.Join(lines, "\n"))
+	}
+
+	handlers.RespondEphemeral(s, event, embed)
+}
+
+func showLoan(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	loan, err := loanOption(ctx, "loan")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	} else if loan.LenderID != member.User.ID && loan.BorrowerID != member.User.ID {
+		handlers.RespondError(ctx, s, event, database.BackendError{Message: "You can only view loans you have lent or borrowed."})
+		return
+	}
+
+	handlers.RespondEphemeral(s, event, loanEmbed(loan))
+}
+
+// answerLoanCommand runs one of the loan subcommands that answer an offer, and shows its final state
+func answerLoanCommand(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, answer func(member database.SessionedMember, loan *database.Loan) error) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	loan, err := loanOption(ctx, "loan")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := answer(member, &loan); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.RespondEphemeral(s, event, loanEmbed(loan))
+}
+
+func acceptLoan(ctx *handlers.Context) func(member database.SessionedMember, loan *database.Loan) error {
+	return func(member database.SessionedMember, loan *database.Loan) error {
+		_, err := ctx.Backend.AcceptLoan(member, loan)
+		return err
+	}
+}
+
+func acceptLoanCommand(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	answerLoanCommand(ctx, s, event, acceptLoan(ctx))
+}
+
+func declineLoanCommand(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	answerLoanCommand(ctx, s, event, ctx.Backend.DeclineLoan)
+}
+
+func cancelLoanCommand(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	answerLoanCommand(ctx, s, event, ctx.Backend.CancelLoan)
+}
+
+func handleLoanButton(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	parts := strings.Split(event.MessageComponentData().CustomID, ":")
+	if len(parts) != 3 {
+		return
+	}
+
+	id, err := strconv.ParseUint(parts[2], 10, 64)
+	if err != nil {
+		return
+	}
+
+	loan, err := ctx.Backend.GetLoanByID(uint(id))
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	member, err := interactionMember(ctx, s, event, loan.GuildID)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	switch parts[1] {
+	case "accept":
+		err = acceptLoan(ctx)(member, &loan)
+	case "decline":
+		err = ctx.Backend.DeclineLoan(member, &loan)
+	default:
+		return
+	}
+
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.UpdateMessage(s, event, loanEmbed(loan), loanComponents(loan))
+}
+
+func collectLoan(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	loan, err := loanOption(ctx, "loan")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	from, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	collected, err := ctx.Backend.CollectLoan(member, &loan, &from)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := loanEmbed(loan).SetDescription(fmt.Sprintf("Collected %s from **%s**.", economy.FormatMoney(collected), from.AccountName))
+	handlers.Respond(s, event, embed)
+}
+
+// notifyLoanDefault tells the lender of a loan by DM that it has just defaulted
+func (b *Bot) notifyLoanDefault(loan database.Loan) error {
+	if loan.Status != database.LS_Defaulted {
+		return nil
+	}
+
+	full, err := b.Backend.GetLoanByID(loan.ID)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("<@%s> has stopped repaying this loan.", full.BorrowerID)
+	if full.Collections {
+		message += " You can collect the rest from their other accounts with /loan collect."
+	}
+
+	channel, err := b.Session.UserChannelCreate(full.LenderID)
+	if err != nil {
+		return err
+	}
+
+	embed := loanEmbed(full).SetTitle(fmt.Sprintf("Loan #%d defaulted", full.ID)).SetDescription(message)
+	_, err = b.Session.ChannelMessageSendEmbed(channel.ID, embed.Truncate().MessageEmbed)
+	return err
+}
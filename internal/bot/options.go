@@ -0,0 +1,104 @@
+package bot
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+)
+
+var accountTypeChoices = []*discordgo.ApplicationCommandOptionChoice{
+	{Name: "User", Value: database.AT_User},
+	{Name: "Government", Value: database.AT_Government},
+	{Name: "Corporation", Value: database.AT_Corporation},
+	{Name: "Charity", Value: database.AT_Charity},
+}
+
+var transferTypeChoices = []*discordgo.ApplicationCommandOptionChoice{
+	{Name: "Personal", Value: database.TT_Personal},
+	{Name: "Income", Value: database.TT_Income},
+	{Name: "Purchase", Value: database.TT_Purchase},
+}
+
+var accountRoleChoices = []*discordgo.ApplicationCommandOptionChoice{
+	{Name: "Manager", Value: database.AR_Manager},
+	{Name: "Viewer", Value: database.AR_Viewer},
+}
+
+var accountAutocomplete handlers.EventFunc = func(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	accounts, err := ctx.Backend.SearchAccounts(economy, focusedValue(ctx))
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(accounts))
+	for _, account := range accounts {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: account.AccountName, Value: account.ID.String()})
+	}
+	autocomplete(s, event, choices)
+}
+
+func focusedValue(ctx *handlers.Context) string {
+	for _, opt := range ctx.GetOptions() {
+		if opt.Focused {
+			if value, ok := opt.Value.(string); ok {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+func autocomplete(s *discordgo.Session, event *discordgo.InteractionCreate, choices []*discordgo.ApplicationCommandOptionChoice) {
+	s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{Choices: choices},
+	})
+}
+
+// accountOption resolves an account option, which holds the ID of an account in the given economy
+func accountOption(ctx *handlers.Context, economy database.Economy, name string) (database.Account, error) {
+	account, err := ctx.Backend.GetAccountByID(ctx.Option(name).StringValue())
+	if errors.Is(err, database.RecordNotFoundError) || (err == nil && account.EconomyID != economy.ID.String()) {
+		return database.Account{}, database.BackendError{Message: "That account does not exist in this economy."}
+	}
+	return account, err
+}
+
+// moneyOption parses an amount option in the economy's currency
+func moneyOption(ctx *handlers.Context, economy database.Economy, name string) (datatypes.Money, error) {
+	amount, err := economy.ParseMoney(ctx.Option(name).StringValue())
+	if err != nil {
+		return 0, database.BackendError{Message: "Invalid amount: " + err.Error() + "."}
+	}
+	return amount, nil
+}
+
+// dateOption parses a date option in the form YYYY-MM-DD, as midnight UTC
+func dateOption(ctx *handlers.Context, name string) (time.Time, error) {
+	date, err := time.Parse(time.DateOnly, ctx.Option(name).StringValue())
+	if err != nil {
+		return time.Time{}, database.BackendError{Message: "Invalid date, please use the format YYYY-MM-DD."}
+	}
+	return date, nil
+}
+
+// rateOption parses a percentage with up to two decimals, such as "2.5%", into basis points
+func rateOption(ctx *handlers.Context, name string) (uint, error) {
+	rate, err := datatypes.ParseMoney(strings.TrimSuffix(strings.TrimSpace(ctx.Option(name).StringValue()), "%"), 2)
+	if err != nil || rate.IsNegative() {
+		return 0, database.BackendError{Message: "Invalid rate, please give a percentage such as 2.5%."}
+	}
+	return uint(rate), nil
+}
@@ -0,0 +1,144 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var PayCommand = handlers.Command{
+	Name: "pay",
+	Description: "Pay another account from your account, or the account you are logged in as",
+	Callback: pay,
+	Options: []*handlers.Option{
+		{Name: "to", Description: "The account to pay", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+		{Name: "amount", Description: "The amount to pay", Type: "", Required: true},
+		{Name: "type", Description: "The kind of transfer, personal if omitted", Type: 0, Choices: transferTypeChoices},
+	},
+}
+
+var BalanceCommand = handlers.Command{
+	Name: "balance",
+	Description: "Show the balance of your account, or the account you are logged in as",
+	Callback: balance,
+	Options: []*handlers.Option{
+		{Name: "account", Description: "Show the balance of this account instead", Type: "", Autocomplete: &accountAutocomplete},
+	},
+}
+
+// actingFooter notes the account a command ran from when the member is logged in as it
+func actingFooter(embed *utils.Embed, session *database.AccountSession) *utils.Embed {
+	if session != nil {
+		embed.SetFooter(fmt.Sprintf("Acting as %s", session.Account.AccountName))
+	}
+	return embed
+}
+
+func pay(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	from, session, err := ctx.Backend.GetActingAccount(member, economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	to, err := accountOption(ctx, economy, "to")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	amount, err := moneyOption(ctx, economy, "amount")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	transfer_type := database.TT_Personal
+	if opt := ctx.Option("type"); opt != nil {
+		transfer_type = uint8(opt.IntValue())
+	}
+
+	transfer, err := ctx.Backend.CreateTransfer(member, &from, &to, amount, transfer_type)
+	if errors.Is(err, database.ApprovalRequiredError) {
+		proposeTransfer(ctx, s, event, member, from, to, amount, transfer_type)
+		return
+	} else if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Payment sent").SetColor(handlers.Colors.Normal).
+		AddField("From", from.AccountName).
+		AddField("To", to.AccountName).
+		AddField("Amount", economy.FormatMoney(amount)).
+		AddField("Transfer", fmt.Sprintf("#%d", transfer.TrxID)).
+		InlineAllFields()
+	handlers.Respond(s, event, actingFooter(embed, session))
+}
+
+func balance(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var account database.Account
+	var session *database.AccountSession
+	if ctx.Option("account") != nil {
+		account, err = accountOption(ctx, economy, "account")
+	} else {
+		account, session, err = ctx.Backend.GetActingAccount(member, economy)
+	}
+
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if account, err = ctx.Backend.ViewAccount(member, account); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle(account.AccountName).SetColor(handlers.Colors.Normal).
+		AddField("Balance", economy.FormatMoney(account.Balance))
+
+	// funds held for pending proposals and escrow contracts still belong to the account, but cannot be spent
+	if held, err := account.TotalBalance.Sub(account.Balance); err == nil && held.IsPositive() {
+		embed.AddField("Held", economy.FormatMoney(held))
+	}
+
+	if account.Frozen && account.FrozenIncoming {
+		embed.AddField("Frozen", "Sending and receiving")
+	} else if account.Frozen {
+		embed.AddField("Frozen", "Sending")
+	} else if account.FrozenIncoming {
+		embed.AddField("Frozen", "Receiving")
+	}
+
+	embed.AddField("Type", database.AccountTypeName(account.AccountType)).InlineAllFields()
+	handlers.RespondEphemeral(s, event, actingFooter(embed, session))
+}
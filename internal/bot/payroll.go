@@ -0,0 +1,462 @@
+package bot
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+// batchConfirmWindow is how long the preview of a batch can be confirmed
+const batchConfirmWindow = 10 * time.Minute
+
+// maxPayrollFileSize is the largest CSV file of recipients that is downloaded
+const maxPayrollFileSize = 64 * 1024
+
+// attachmentClient downloads the files attached to commands, giving up on slow downloads long before the interaction expires
+var attachmentClient = &http.Client{Timeout: 10 * time.Second}
+
+var transferTypeOption = &handlers.Option{Name: "type", Description: "The kind of transfer, income if omitted", Type: 0, Choices: transferTypeChoices}
+
+var PayrollCommand = handlers.Command{
+	Name: "payroll",
+	Description: "Pay many accounts at once from your account, or the account you are logged in as",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "file",
+			Description: "Pay the recipients of a CSV file with a recipient and an amount on each line",
+			Callback: payrollFromFile,
+			Options: []*handlers.Option{
+				{Name: "file", Description: "The CSV file, where recipients are mentions, user IDs or account names", Type: &discordgo.MessageAttachment{}, Required: true},
+				transferTypeOption,
+			},
+		},
+		{
+			Name: "role",
+			Description: "Pay everyone with a role the same amount",
+			Callback: payrollFromRole,
+			Options: []*handlers.Option{
+				{Name: "role", Description: "The role to pay", Type: &discordgo.Role{}, Required: true},
+				{Name: "amount", Description: "The amount to pay each member", Type: "", Required: true},
+				transferTypeOption,
+			},
+		},
+		{
+			Name: "list",
+			Description: "Pay a list of recipients and amounts",
+			Callback: payrollFromList,
+			Options: []*handlers.Option{
+				{Name: "payments", Description: "The payments separated by semicolons, e.g. \"@Alice, 100; Shop, 50\"", Type: "", Required: true},
+				transferTypeOption,
+			},
+		},
+	},
+}
+
+// BatchComponent handles the confirm and cancel buttons of batch previews, whose custom IDs are "batch:<action>:<id>"
+var BatchComponent = handlers.Component{
+	Name: "batch",
+	Callback: handleBatchButton,
+}
+
+// pendingBatch is a previewed batch transfer waiting for whoever made it to confirm it
+type pendingBatch struct {
+	UserID string
+	From database.Account
+	Payments []database.BatchPayment
+	TransferType uint8
+	ExpiresAt time.Time
+}
+
+// pendingBatches holds previewed batches by the ID of the interaction that made them. They are kept in memory only, so
+// a restart expires every preview early; the preview tells its user so, and confirming it afterwards asks them to run the command again.
+var pendingBatches = struct {
+	sync.Mutex
+	batches map[string]pendingBatch
+}{batches: map[string]pendingBatch{}}
+
+func storeBatch(id string, batch pendingBatch) {
+	pendingBatches.Lock()
+	defer pendingBatches.Unlock()
+
+	now := time.Now()
+	for key, pending := range pendingBatches.batches {
+		if now.After(pending.ExpiresAt) {
+			delete(pendingBatches.batches, key)
+		}
+	}
+	pendingBatches.batches[id] = batch
+}
+
+func takeBatch(id string) (pendingBatch, bool) {
+	pendingBatches.Lock()
+	defer pendingBatches.Unlock()
+
+	batch, ok := pendingBatches.batches[id]
+	delete(pendingBatches.batches, id)
+	return batch, ok && time.Now().Before(batch.ExpiresAt)
+}
+
+var mentionPattern = regexp.MustCompile(`^(?:<@!?(\d+)>|(\d{15,20}))$`)
+
+// resolveRecipient finds the account a recipient refers to, which is the personal account of a mentioned user or user ID,
+// or otherwise the account with that ID or name
+func resolveRecipient(ctx *handlers.Context, economy database.Economy, recipient string) (database.Account, error) {
+	recipient = strings.TrimSpace(recipient)
+	if match := mentionPattern.FindStringSubmatch(recipient); match != nil {
+		user_id := match[1] + match[2]
+		account, err := ctx.Backend.GetUserAccount(economy, user_id)
+		var backend_err database.BackendError
+		if errors.As(err, &backend_err) {
+			return database.Account{}, database.BackendError{Message: fmt.Sprintf("<@%s> does not have an account in this economy.", user_id)}
+		}
+		return account, err
+	}
+
+	if account, err := ctx.Backend.GetAccountByID(recipient); err == nil && account.EconomyID == economy.ID.String() {
+		return account, nil
+	}
+
+	accounts, err := ctx.Backend.SearchAccounts(economy, recipient)
+	if err != nil {
+		return database.Account{}, err
+	}
+
+	index := slices.IndexFunc(accounts, func(account database.Account) bool { return strings.EqualFold(account.AccountName, recipient) })
+	if index < 0 {
+		return database.Account{}, database.BackendError{Message: fmt.Sprintf("No account named '%s' exists in this economy.", recipient)}
+	}
+	return accounts[index], nil
+}
+
+// parsePayments reads payments as CSV records of a recipient and an amount, skipping a header line if there is one
+func parsePayments(ctx *handlers.Context, economy database.Economy, input io.Reader) ([]database.BatchPayment, error) {
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, database.BackendError{Message: "The payments could not be read as CSV: " + err.Error() + "."}
+	}
+
+	var payments []database.BatchPayment
+	for i, record := range records {
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		} else if len(record) != 2 {
+			return nil, database.BackendError{Message: fmt.Sprintf("Line %d needs a recipient and an amount.", i+1)}
+		}
+
+		amount, err := economy.ParseMoney(strings.TrimSpace(record[1]))
+		if err != nil {
+			if i == 0 {
+				continue
+			}
+			return nil, database.BackendError{Message: fmt.Sprintf("Invalid amount on line %d: %s.", i+1, err.Error())}
+		}
+
+		account, err := resolveRecipient(ctx, economy, record[0])
+		if err != nil {
+			return nil, err
+		}
+
+		payments = append(payments, database.BatchPayment{To: account, Amount: amount})
+	}
+
+	return payments, nil
+}
+
+func batchTransferType(ctx *handlers.Context) uint8 {
+	if opt := ctx.Option("type"); opt != nil {
+		return uint8(opt.IntValue())
+	}
+	return database.TT_Income
+}
+
+func batchEmbed(title string, economy database.Economy, from database.Account, results []database.BatchResult) *utils.Embed {
+	var lines []string
+	for _, result := range results {
+		line := fmt.Sprintf("**%s**: %s", result.To.AccountName, economy.FormatMoney(result.Amount))
+		if result.Credit != result.Amount || result.Debit != result.Amount {
+			line += fmt.Sprintf(" (receives %s, costs %s)", economy.FormatMoney(result.Credit), economy.FormatMoney(result.Debit))
+		}
+		if result.Transfer != nil {
+			line += fmt.Sprintf(" #%d", result.Transfer.TrxID)
+		}
+		lines = append(lines, line)
+	}
+
+	embed := utils.NewEmbed().SetTitle(title).SetColor(handlers.Colors.Normal).
+		SetDescription(strings.Join(lines, "\n")).
+		AddField("From", from.AccountName).
+		AddField("Payments", fmt.Sprint(len(results)))
+
+	if total, err := database.BatchTotal(results); err == nil {
+		embed.AddField("Amount", economy.FormatMoney(total.Amount)).
+			AddField("Cost with taxes", economy.FormatMoney(total.Debit)).
+			AddField("Received after taxes", economy.FormatMoney(total.Credit))
+	}
+
+	return embed.InlineAllFields()
+}
+
+// previewBatch shows what a batch of payments would cost with taxes, with buttons to confirm or cancel it.
+// Previews run every payment and roll them back, so the interaction has to be deferred before.
+func previewBatch(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, economy database.Economy, payments []database.BatchPayment, notes ...string) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.EditError(ctx, s, event, err)
+		return
+	}
+
+	from, session, err := ctx.Backend.GetActingAccount(member, economy)
+	if err != nil {
+		handlers.EditError(ctx, s, event, err)
+		return
+	}
+
+	transfer_type := batchTransferType(ctx)
+	results, err := ctx.Backend.PreviewBatchTransfer(member, &from, payments, transfer_type)
+	if err != nil {
+		handlers.EditError(ctx, s, event, err)
+		return
+	}
+
+	storeBatch(event.ID, pendingBatch{
+		UserID: member.User.ID,
+		From: from,
+		Payments: payments,
+		TransferType: transfer_type,
+		ExpiresAt: time.Now().Add(batchConfirmWindow),
+	})
+
+	embed := batchEmbed("Payroll preview", economy, from, results).SetColor(handlers.Colors.Warning)
+	for _, note := range notes {
+		embed.AddField("Note", note)
+	}
+
+	// previews only live in memory, so their users are told that a restart expires them as well
+	footer := fmt.Sprintf("This preview expires in %d minutes, or when the bot restarts.", int(batchConfirmWindow.Minutes()))
+	if session != nil {
+		footer = fmt.Sprintf("Acting as %s. %s", session.Account.AccountName, footer)
+	}
+	embed.SetFooter(footer)
+
+	handlers.EditResponse(s, event, embed, []discordgo.MessageComponent{
+		discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.Button{Label: "Confirm", Style: discordgo.SuccessButton, CustomID: "batch:confirm:" + event.ID},
+			discordgo.Button{Label: "Cancel", Style: discordgo.SecondaryButton, CustomID: "batch:cancel:" + event.ID},
+		}},
+	})
+}
+
+func payrollFromFile(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	if err := handlers.Defer(s, event); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.EditError(ctx, s, event, err)
+		return
+	}
+
+	attachment, ok := event.ApplicationCommandData().Resolved.Attachments[ctx.Option("file").StringValue()]
+	if !ok {
+		handlers.EditError(ctx, s, event, database.BackendError{Message: "The file could not be found."})
+		return
+	} else if attachment.Size > maxPayrollFileSize {
+		handlers.EditError(ctx, s, event, database.BackendError{Message: fmt.Sprintf("The file can be at most %d KiB.", maxPayrollFileSize/1024)})
+		return
+	}
+
+	response, err := attachmentClient.Get(attachment.URL)
+	if err != nil {
+		handlers.EditError(ctx, s, event, database.BackendError{Message: "The file could not be downloaded, please try again."})
+		return
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		handlers.EditError(ctx, s, event, database.BackendError{Message: "The file could not be downloaded, please try again."})
+		return
+	}
+
+	payments, err := parsePayments(ctx, economy, io.LimitReader(response.Body, maxPayrollFileSize))
+	if err != nil {
+		handlers.EditError(ctx, s, event, err)
+		return
+	}
+
+	previewBatch(ctx, s, event, economy, payments)
+}
+
+func payrollFromList(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	if err := handlers.Defer(s, event); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.EditError(ctx, s, event, err)
+		return
+	}
+
+	input := strings.ReplaceAll(ctx.Option("payments").StringValue(), ";", "\n")
+	payments, err := parsePayments(ctx, economy, strings.NewReader(input))
+	if err != nil {
+		handlers.EditError(ctx, s, event, err)
+		return
+	}
+
+	previewBatch(ctx, s, event, economy, payments)
+}
+
+// roleMembers returns the IDs of the members of a guild with a role, leaving out bots. Members are read from the state cache,
+// which onGuildCreate fills, so paying a role does not list the whole guild over the API every time.
+func roleMembers(s *discordgo.Session, guild_id string, role_id string) ([]string, error) {
+	guild, err := s.State.Guild(guild_id)
+	if err != nil {
+		return nil, database.BackendError{Message: "The members of this server have not been loaded yet, please try again later."}
+	}
+
+	s.State.RLock()
+	defer s.State.RUnlock()
+
+	var user_ids []string
+	for _, member := range guild.Members {
+		// the @everyone role shares its ID with the guild and is never listed among a member's roles
+		if member.User != nil && !member.User.Bot && (role_id == guild_id || slices.Contains(member.Roles, role_id)) {
+			user_ids = append(user_ids, member.User.ID)
+		}
+	}
+	return user_ids, nil
+}
+
+func payrollFromRole(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	if err := handlers.Defer(s, event); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.EditError(ctx, s, event, err)
+		return
+	}
+
+	amount, err := moneyOption(ctx, economy, "amount")
+	if err != nil {
+		handlers.EditError(ctx, s, event, err)
+		return
+	}
+
+	role_id := ctx.Option("role").Value.(string)
+	user_ids, err := roleMembers(s, event.GuildID, role_id)
+	if err != nil {
+		handlers.EditError(ctx, s, event, err)
+		return
+	}
+
+	var payments []database.BatchPayment
+	skipped := 0
+	for _, user_id := range user_ids {
+		account, err := ctx.Backend.GetUserAccount(economy, user_id)
+		var backend_err database.BackendError
+		if errors.As(err, &backend_err) {
+			skipped++
+			continue
+		} else if err != nil {
+			handlers.EditError(ctx, s, event, err)
+			return
+		}
+
+		payments = append(payments, database.BatchPayment{To: account, Amount: amount})
+	}
+
+	var notes []string
+	if skipped > 0 {
+		notes = append(notes, fmt.Sprintf("%d members of <@&%s> do not have an account and will not be paid.", skipped, role_id))
+	}
+
+	previewBatch(ctx, s, event, economy, payments, notes...)
+}
+
+func handleBatchButton(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	parts := strings.Split(event.MessageComponentData().CustomID, ":")
+	if len(parts) != 3 {
+		return
+	}
+
+	pendingBatches.Lock()
+	batch, ok := pendingBatches.batches[parts[2]]
+	pendingBatches.Unlock()
+
+	if ok && batch.UserID != member.User.ID {
+		handlers.RespondError(ctx, s, event, database.BackendError{Message: "Only whoever made this batch can confirm or cancel it."})
+		return
+	}
+
+	batch, ok = takeBatch(parts[2])
+	if !ok {
+		embed := utils.NewEmbed().SetTitle("Payroll expired").SetColor(handlers.Colors.Error).
+			SetDescription("This preview has expired or the bot has restarted since, please run the command again.")
+		handlers.UpdateMessage(s, event, embed, []discordgo.MessageComponent{})
+		return
+	}
+
+	if parts[1] != "confirm" {
+		embed := utils.NewEmbed().SetTitle("Payroll cancelled").SetColor(handlers.Colors.Error).
+			SetDescription("No payments were made.")
+		handlers.UpdateMessage(s, event, embed, []discordgo.MessageComponent{})
+		return
+	}
+
+	// making every payment can take longer than Discord waits for an answer
+	if err := handlers.DeferUpdate(s, event); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	// the accounts may have changed since the preview, for example been closed
+	from, err := ctx.Backend.GetAccountByID(batch.From.ID.String())
+	if err != nil {
+		handlers.EditError(ctx, s, event, err)
+		return
+	}
+
+	for i, payment := range batch.Payments {
+		if batch.Payments[i].To, err = ctx.Backend.GetAccountByID(payment.To.ID.String()); err != nil {
+			handlers.EditError(ctx, s, event, err)
+			return
+		}
+	}
+
+	results, err := ctx.Backend.CreateBatchTransfer(member, &from, batch.Payments, batch.TransferType)
+	if err != nil {
+		handlers.EditError(ctx, s, event, err)
+		return
+	}
+
+	handlers.EditResponse(s, event, batchEmbed("Payroll sent", from.Economy, from, results), []discordgo.MessageComponent{})
+}
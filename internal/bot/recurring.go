@@ -0,0 +1,422 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var recurringAutocomplete handlers.EventFunc = func(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	orders, err := ctx.Backend.GetRecurringTransfers(member, nil)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	query := strings.ToLower(focusedValue(ctx))
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, order := range orders {
+		name := recurringName(order)
+		if strings.Contains(strings.ToLower(name), query) && len(choices) < 25 {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: order.EntryID})
+		}
+	}
+	autocomplete(s, event, choices)
+}
+
+var RecurringCommand = handlers.Command{
+	Name: "recurring",
+	Description: "Manage standing orders that pay an account regularly",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "create",
+			Description: "Create a standing order",
+			Callback: createRecurringTransfer,
+			Options: []*handlers.Option{
+				{Name: "from", Description: "The account to pay from", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+				{Name: "to", Description: "The account to pay", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+				{Name: "amount", Description: "The amount of each payment", Type: "", Required: true},
+				{Name: "interval", Description: "How often to pay", Type: 0, Required: true, Choices: periodChoices[1:]},
+				{Name: "payments", Description: "How many payments to make, unlimited if omitted", Type: 0},
+				{Name: "start", Description: "The date of the first payment as YYYY-MM-DD, today if omitted", Type: ""},
+				{Name: "end", Description: "The date of the last possible payment as YYYY-MM-DD", Type: ""},
+				{Name: "type", Description: "The kind of transfer, personal if omitted", Type: 0, Choices: transferTypeChoices},
+			},
+		},
+		{
+			Name: "list",
+			Description: "List standing orders",
+			Callback: listRecurringTransfers,
+			Options: []*handlers.Option{
+				{Name: "account", Description: "List the orders paying from this account instead of your own", Type: "", Autocomplete: &accountAutocomplete},
+			},
+		},
+		{
+			Name: "pause",
+			Description: "Pause a standing order, skipping its payments until it is resumed",
+			Callback: pauseRecurringTransfer,
+			Options: []*handlers.Option{
+				{Name: "order", Description: "The standing order", Type: "", Required: true, Autocomplete: &recurringAutocomplete},
+			},
+		},
+		{
+			Name: "resume",
+			Description: "Resume a paused standing order",
+			Callback: resumeRecurringTransfer,
+			Options: []*handlers.Option{
+				{Name: "order", Description: "The standing order", Type: "", Required: true, Autocomplete: &recurringAutocomplete},
+			},
+		},
+		{
+			Name: "cancel",
+			Description: "Cancel a standing order",
+			Callback: cancelRecurringTransfer,
+			Options: []*handlers.Option{
+				{Name: "order", Description: "The standing order", Type: "", Required: true, Autocomplete: &recurringAutocomplete},
+			},
+		},
+		{
+			Name: "edit",
+			Description: "Edit a standing order",
+			Callback: editRecurringTransfer,
+			Options: []*handlers.Option{
+				{Name: "order", Description: "The standing order", Type: "", Required: true, Autocomplete: &recurringAutocomplete},
+				{Name: "amount", Description: "The new amount of each payment", Type: ""},
+				{Name: "interval", Description: "How often to pay from now on", Type: 0, Choices: periodChoices[1:]},
+				{Name: "payments", Description: "How many payments are left, or 0 to make it unlimited", Type: 0},
+				{Name: "end", Description: "The new date of the last possible payment as YYYY-MM-DD, or 'none' to remove it", Type: ""},
+			},
+		},
+	},
+}
+
+func recurringName(order database.RecurringTransfer) string {
+	economy := order.FromAccount.Economy
+	return fmt.Sprintf("%s %s from %s to %s", economy.FormatMoney(order.Amount), database.PeriodName(uint8(order.PaymentInterval)), order.FromAccount.AccountName, order.ToAccount.AccountName)
+}
+
+// endOfDay returns the last second of the day a date option names, so that payments due on that day are still made
+func endOfDay(date time.Time) *time.Time {
+	end := date.AddDate(0, 0, 1).Add(-time.Second)
+	return &end
+}
+
+func recurringEmbed(title string, order database.RecurringTransfer) *utils.Embed {
+	economy := order.FromAccount.Economy
+
+	payments := "Unlimited"
+	if order.PaymentsLeft != nil {
+		payments = fmt.Sprint(*order.PaymentsLeft)
+	}
+
+	embed := utils.NewEmbed().SetTitle(title).SetColor(handlers.Colors.Normal).
+		AddField("From", order.FromAccount.AccountName).
+		AddField("To", order.ToAccount.AccountName).
+		AddField("Amount", economy.FormatMoney(order.Amount)).
+		AddField("Interval", database.PeriodName(uint8(order.PaymentInterval))).
+		AddField("Payments left", payments).
+		AddField("Status", database.RecurringStatusName(order.Status))
+
+	if order.LoanID != nil {
+		embed.AddField("Loan", fmt.Sprintf("#%d", *order.LoanID))
+	}
+
+	if order.Status == database.RS_Active {
+		embed.AddField("Next payment", fmt.Sprintf("<t:%d:f>", order.NextPayment.Unix()))
+	}
+
+	if order.EndsAt != nil {
+		embed.AddField("Ends", fmt.Sprintf("<t:%d:d>", order.EndsAt.Unix()))
+	}
+
+	return embed.InlineAllFields()
+}
+
+// recurringOption resolves the standing order option, which holds the ID of an order in the given economy
+func recurringOption(ctx *handlers.Context, economy database.Economy) (database.RecurringTransfer, error) {
+	order, err := ctx.Backend.GetRecurringTransferByID(ctx.Option("order").StringValue())
+	if err == nil && order.FromAccount.EconomyID != economy.ID.String() {
+		return database.RecurringTransfer{}, database.BackendError{Message: "That standing order does not exist in this economy."}
+	}
+	return order, err
+}
+
+func createRecurringTransfer(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	order := database.RecurringTransfer{PaymentInterval: uint(ctx.Option("interval").IntValue())}
+	if order.FromAccount, err = accountOption(ctx, economy, "from"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	} else if order.ToAccount, err = accountOption(ctx, economy, "to"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	} else if order.Amount, err = moneyOption(ctx, economy, "amount"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+	order.FromAccount.Economy = economy
+
+	if opt := ctx.Option("payments"); opt != nil {
+		if opt.IntValue() <= 0 {
+			handlers.RespondError(ctx, s, event, database.BackendError{Message: "A standing order has to make at least one payment."})
+			return
+		}
+		payments := uint(opt.IntValue())
+		order.PaymentsLeft = &payments
+	}
+
+	if ctx.Option("start") != nil {
+		if order.NextPayment, err = dateOption(ctx, "start"); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+	}
+
+	if ctx.Option("end") != nil {
+		end, err := dateOption(ctx, "end")
+		if err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+		order.EndsAt = endOfDay(end)
+	}
+
+	if opt := ctx.Option("type"); opt != nil {
+		order.TransferType = uint8(opt.IntValue())
+	}
+
+	if err := ctx.Backend.CreateRecurringTransfer(member, &order); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, recurringEmbed("Standing order created", order))
+}
+
+func listRecurringTransfers(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var account *database.Account
+	if ctx.Option("account") != nil {
+		selected, err := accountOption(ctx, economy, "account")
+		if err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+		account = &selected
+	}
+
+	orders, err := ctx.Backend.GetRecurringTransfers(member, account)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var lines []string
+	for _, order := range orders {
+		if order.FromAccount.EconomyID != economy.ID.String() {
+			continue
+		}
+
+		line := recurringName(order)
+		if order.Status == database.RS_Active {
+			line += fmt.Sprintf(", next <t:%d:R>", order.NextPayment.Unix())
+		} else {
+			line += ", paused"
+		}
+		lines = append(lines, line)
+	}
+
+	embed := utils.NewEmbed().SetTitle("Standing orders").SetColor(handlers.Colors.Normal)
+	if len(lines) == 0 {
+		embed.SetDescription("There are no standing orders.")
+	} else {
+		embed.SetDescription(strings.Join(lines, "\n"))
+	}
+
+	handlers.Respond(s, event, embed)
+}
+
+func setRecurringStatus(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, status uint8, title string) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	order, err := recurringOption(ctx, economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.SetRecurringTransferStatus(member, &order, status); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, recurringEmbed(title, order))
+}
+
+func pauseRecurringTransfer(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	setRecurringStatus(ctx, s, event, database.RS_Paused, "Standing order paused")
+}
+
+func resumeRecurringTransfer(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	setRecurringStatus(ctx, s, event, database.RS_Active, "Standing order resumed")
+}
+
+func cancelRecurringTransfer(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	setRecurringStatus(ctx, s, event, database.RS_Cancelled, "Standing order cancelled")
+}
+
+func editRecurringTransfer(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	order, err := recurringOption(ctx, economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if ctx.Option("amount") != nil {
+		if order.Amount, err = moneyOption(ctx, economy, "amount"); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+	}
+
+	// a new interval counts from the last payment, or from now if nothing has been paid yet
+	if opt := ctx.Option("interval"); opt != nil && uint(opt.IntValue()) != order.PaymentInterval {
+		order.PaymentInterval = uint(opt.IntValue())
+		anchor := order.LastPaid
+		if anchor.IsZero() {
+			anchor = time.Now()
+		}
+		order.NextPayment = database.NextPeriod(anchor, uint8(order.PaymentInterval))
+	}
+
+	if opt := ctx.Option("payments"); opt != nil {
+		if opt.IntValue() < 0 {
+			handlers.RespondError(ctx, s, event, database.BackendError{Message: "The number of payments cannot be negative."})
+			return
+		} else if opt.IntValue() == 0 {
+			order.PaymentsLeft = nil
+		} else {
+			payments := uint(opt.IntValue())
+			order.PaymentsLeft = &payments
+		}
+	}
+
+	if opt := ctx.Option("end"); opt != nil {
+		if strings.EqualFold(opt.StringValue(), "none") {
+			order.EndsAt = nil
+		} else if end, err := dateOption(ctx, "end"); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		} else {
+			order.EndsAt = endOfDay(end)
+		}
+	}
+
+	if err := ctx.Backend.UpdateRecurringTransfer(member, &order); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, recurringEmbed("Standing order updated", order))
+}
+
+// notifyRecurringPayment sends the payer of a standing order a DM about a payment that was made or failed
+func (b *Bot) notifyRecurringPayment(payment database.RecurringPayment) error {
+	order := payment.Order
+	economy := order.FromAccount.Economy
+
+	amount := order.Amount
+	if payment.Transfer != nil {
+		amount = payment.Transfer.Amount
+	}
+
+	embed := utils.NewEmbed().
+		AddField("From", order.FromAccount.AccountName).
+		AddField("To", order.ToAccount.AccountName).
+		AddField("Amount", economy.FormatMoney(amount)).
+		InlineAllFields()
+
+	if payment.Error != nil {
+		embed.SetTitle("Standing order payment failed").SetColor(handlers.Colors.Error).SetDescription(payment.Error.Error())
+	} else {
+		embed.SetTitle("Standing order paid").SetColor(handlers.Colors.Normal).
+			SetFooter(fmt.Sprintf("Transfer #%d", payment.Transfer.TrxID))
+	}
+
+	if payment.Loan != nil {
+		embed.AddField("Loan", fmt.Sprintf("#%d, %s", payment.Loan.ID, strings.ToLower(database.LoanStatusName(payment.Loan.Status))))
+	}
+
+	if payment.Loan != nil {
+		embed.AddField("Loan", fmt.Sprintf("#%d, %s", payment.Loan.ID, strings.ToLower(database.LoanStatusName(payment.Loan.Status))))
+	}
+
+	if order.Status == database.RS_Active {
+		embed.AddField("Next payment", fmt.Sprintf("<t:%d:f>", order.NextPayment.Unix()))
+	} else {
+		embed.AddField("Status", database.RecurringStatusName(order.Status))
+	}
+
+	channel, err := b.Session.UserChannelCreate(order.ActorID)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.Session.ChannelMessageSendEmbed(channel.ID, embed.Truncate().MessageEmbed)
+	return err
+}
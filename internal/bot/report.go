@@ -0,0 +1,211 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+const (
+	defaultReportPeriods = 6
+	maxListedRevenueLines = 15
+)
+
+const (
+	RG_Bracket uint8 = iota
+	RG_TaxType
+	RG_Account
+)
+
+var ReportCommand = handlers.Command{
+	Name: "report",
+	Description: "Show financial reports of this economy",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "revenue",
+			Description: "Show the tax revenue collected in this economy",
+			Callback: showRevenueReport,
+			Options: []*handlers.Option{
+				{Name: "period", Description: "The length of each period of the report", Type: 0, Required: true, Choices: periodChoices[1:]},
+				{Name: "periods", Description: fmt.Sprintf("How many periods to cover, %d if omitted", defaultReportPeriods), Type: 0},
+				{Name: "group", Description: "How to break down the revenue, by bracket if omitted", Type: 0, Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "Bracket", Value: RG_Bracket},
+					{Name: "Tax type", Value: RG_TaxType},
+					{Name: "Receiving account", Value: RG_Account},
+				}},
+				{Name: "csv", Description: "Whether to attach the report as a CSV file", Type: false},
+			},
+		},
+	},
+}
+
+// periodLabel formats the start of a period for a report, e.g. "2024-05-06" or "May 2024"
+func periodLabel(start time.Time, period uint8) string {
+	if period == database.PD_Monthly {
+		return start.Format("Jan 2006")
+	}
+	return start.Format("2006-01-02")
+}
+
+// periodChange describes how the last amount compares to the one before it, e.g. "+12.5% vs previous"
+func periodChange(amounts []datatypes.Money) string {
+	if len(amounts) < 2 {
+		return ""
+	}
+
+	current, previous := amounts[len(amounts) - 1], amounts[len(amounts) - 2]
+	if previous.IsZero() {
+		if current.IsZero() {
+			return "no change"
+		}
+		return "new"
+	}
+
+	// the base is taken as positive so that a refund-heavy previous period does not flip the sign of the change
+	change := float64(current - previous) / float64(max(previous, -previous)) * 100
+	return fmt.Sprintf("%+.1f%% vs previous", change)
+}
+
+func revenueLineName(line database.RevenueLine, group uint8) string {
+	switch group {
+	case RG_TaxType:
+		return line.TaxName + " tax"
+	case RG_Account:
+		return line.ToAccountName
+	}
+
+	if line.TaxName == "" {
+		return fmt.Sprintf("Removed bracket -> %s", line.ToAccountName)
+	}
+	return fmt.Sprintf("%s (%s) -> %s", line.TaxName, database.TaxTypeName(line.TaxType), line.ToAccountName)
+}
+
+// revenueCSV writes every bracket line of the report with one column per period, followed by the totals
+func revenueCSV(report database.RevenueReport) ([]byte, error) {
+	amount := func(money datatypes.Money) string {
+		return strings.ReplaceAll(money.Format(report.Economy.Decimals), ",", "")
+	}
+
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+
+	header := []string{"tax_entry_id", "tax_name", "tax_type", "to_account_id", "to_account_name"}
+	for _, start := range report.Starts {
+		header = append(header, start.Format("2006-01-02"))
+	}
+	writer.Write(header)
+
+	for _, line := range report.Lines {
+		row := []string{line.TaxEntryID, line.TaxName, database.TaxTypeName(line.TaxType), line.ToAccountID.String(), line.ToAccountName}
+		for _, money := range line.Amounts {
+			row = append(row, amount(money))
+		}
+		writer.Write(row)
+	}
+
+	totals := []string{"", "Total", "", "", ""}
+	for _, money := range report.Totals() {
+		totals = append(totals, amount(money))
+	}
+	writer.Write(totals)
+
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
+
+func showRevenueReport(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	period, periods := uint8(ctx.Option("period").IntValue()), defaultReportPeriods
+	if opt := ctx.Option("periods"); opt != nil {
+		periods = int(opt.IntValue())
+	}
+
+	report, err := ctx.Backend.GetRevenueReport(member, economy, period, periods, time.Now())
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	group := RG_Bracket
+	if opt := ctx.Option("group"); opt != nil {
+		group = uint8(opt.IntValue())
+	}
+
+	lines := report.Lines
+	switch group {
+	case RG_TaxType:
+		lines = report.ByTaxType()
+	case RG_Account:
+		lines = report.ByAccount()
+	}
+
+	current := len(report.Starts) - 1
+	var rows []string
+	for i, line := range lines {
+		if i == maxListedRevenueLines {
+			rows = append(rows, fmt.Sprintf("...and %d more", len(lines) - maxListedRevenueLines))
+			break
+		}
+
+		row := fmt.Sprintf("%s: %s", revenueLineName(line, group), economy.FormatMoney(line.Amounts[current]))
+		if change := periodChange(line.Amounts); change != "" {
+			row += fmt.Sprintf(" (%s)", change)
+		}
+		rows = append(rows, row)
+	}
+
+	totals := report.Totals()
+	embed := utils.NewEmbed().SetTitle(fmt.Sprintf("Tax revenue since %s", periodLabel(report.Starts[current], period))).SetColor(handlers.Colors.Normal)
+	if len(rows) == 0 {
+		embed.SetDescription("No tax has been collected in this period.")
+	} else {
+		embed.SetDescription(strings.Join(rows, "\n"))
+	}
+
+	embed.AddField("Total", economy.FormatMoney(totals[current]))
+	if change := periodChange(totals); change != "" {
+		embed.AddField("Change", change)
+	}
+
+	var history []string
+	for i := range report.Starts {
+		history = append(history, fmt.Sprintf("%-12s %s", periodLabel(report.Starts[i], period), economy.FormatMoney(totals[i])))
+	}
+	embed.AddField(fmt.Sprintf("Last %d periods (%s)", periods, database.PeriodName(period)), "```\n" + strings.Join(history, "\n") + "\n```")
+
+	if opt := ctx.Option("csv"); opt == nil || !opt.BoolValue() {
+		handlers.Respond(s, event, embed)
+		return
+	}
+
+	data, err := revenueCSV(report)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, embed, &discordgo.File{
+		Name: fmt.Sprintf("revenue-%s-%s.csv", economy.Name, report.Starts[0].Format("20060102")),
+		ContentType: "text/csv",
+		Reader: bytes.NewReader(data),
+	})
+}
@@ -0,0 +1,513 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var RequestCommand = handlers.Command{
+	Name: "request",
+	Description: "Ask someone to pay you, or the account you are logged in as",
+	Callback: requestPayment,
+	Options: []*handlers.Option{
+		{Name: "user", Description: "Who should pay", Type: &discordgo.User{}, Required: true},
+		{Name: "amount", Description: "The amount to request", Type: "", Required: true},
+		{Name: "memo", Description: "What the payment is for", Type: "", Required: true},
+		{Name: "type", Description: "The kind of transfer, personal if omitted", Type: 0, Choices: transferTypeChoices},
+		{Name: "due", Description: "The date to pay by as YYYY-MM-DD", Type: ""},
+	},
+}
+
+var InvoiceCommand = handlers.Command{
+	Name: "invoice",
+	Description: "Bill someone for a purchase, paid with VAT like any other purchase",
+	Callback: sendInvoice,
+	Options: []*handlers.Option{
+		{Name: "user", Description: "Who should pay", Type: &discordgo.User{}, Required: true},
+		{Name: "items", Description: "The items separated by semicolons, e.g. \"2x Apples @ 1.50; Delivery @ 5\"", Type: "", Required: true},
+		{Name: "memo", Description: "A note for the payer", Type: ""},
+		{Name: "due", Description: "The date to pay by as YYYY-MM-DD", Type: ""},
+	},
+}
+
+var incomingRequestAutocomplete handlers.EventFunc = func(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	requestAutocomplete(ctx, s, event, false)
+}
+
+var outgoingRequestAutocomplete handlers.EventFunc = func(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	requestAutocomplete(ctx, s, event, true)
+}
+
+var RequestsCommand = handlers.Command{
+	Name: "requests",
+	Description: "Manage payment requests and invoices",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "incoming",
+			Description: "List the requests you have to pay",
+			Callback: listIncomingRequests,
+		},
+		{
+			Name: "outgoing",
+			Description: "List the requests you have sent that are not paid yet",
+			Callback: listOutgoingRequests,
+		},
+		{
+			Name: "pay",
+			Description: "Pay a request from your account, or the account you are logged in as",
+			Callback: payRequestCommand,
+			Options: []*handlers.Option{
+				{Name: "request", Description: "The request to pay", Type: "", Required: true, Autocomplete: &incomingRequestAutocomplete},
+			},
+		},
+		{
+			Name: "decline",
+			Description: "Decline a request",
+			Callback: declineRequestCommand,
+			Options: []*handlers.Option{
+				{Name: "request", Description: "The request to decline", Type: "", Required: true, Autocomplete: &incomingRequestAutocomplete},
+			},
+		},
+		{
+			Name: "cancel",
+			Description: "Cancel a request you have sent",
+			Callback: cancelRequest,
+			Options: []*handlers.Option{
+				{Name: "request", Description: "The request to cancel", Type: "", Required: true, Autocomplete: &outgoingRequestAutocomplete},
+			},
+		},
+	},
+}
+
+// PaymentRequestComponent handles the pay and decline buttons of payment requests, whose custom IDs are "request:<action>:<id>"
+var PaymentRequestComponent = handlers.Component{
+	Name: "request",
+	Callback: handleRequestButton,
+}
+
+func requestAutocomplete(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, outgoing bool) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	requests, err := ctx.Backend.GetPaymentRequests(member, economy, outgoing)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	query := strings.ToLower(focusedValue(ctx))
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, request := range requests {
+		name := fmt.Sprintf("#%d: %s for %s", request.ID, economy.FormatMoney(request.Amount), request.Memo)
+		if len(name) > 100 {
+			name = name[:97] + "..."
+		}
+
+		if strings.Contains(strings.ToLower(name), query) && len(choices) < 25 {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: fmt.Sprint(request.ID)})
+		}
+	}
+	autocomplete(s, event, choices)
+}
+
+// requestOption looks up the payment request chosen in an option
+func requestOption(ctx *handlers.Context, name string) (database.PaymentRequest, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(ctx.Option(name).StringValue(), "#"), 10, 64)
+	if err != nil {
+		return database.PaymentRequest{}, database.BackendError{Message: "Please choose a request from the list."}
+	}
+	return ctx.Backend.GetPaymentRequestByID(uint(id))
+}
+
+// parseInvoiceItems parses line items in the form "2x Apples @ 1.50; Delivery @ 5", where the quantity is optional
+func parseInvoiceItems(economy database.Economy, input string) ([]database.InvoiceItem, error) {
+	var items []database.InvoiceItem
+	for _, entry := range strings.Split(input, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		description, price, ok := strings.Cut(entry, "@")
+		if !ok {
+			return nil, database.BackendError{Message: fmt.Sprintf("The item '%s' needs a price, e.g. \"2x Apples @ 1.50\".", entry)}
+		}
+
+		item := database.InvoiceItem{Description: strings.TrimSpace(description), Quantity: 1}
+		if quantity, rest, ok := strings.Cut(item.Description, "x "); ok {
+			if n, err := strconv.ParseUint(strings.TrimSpace(quantity), 10, 32); err == nil {
+				item.Quantity, item.Description = uint(n), strings.TrimSpace(rest)
+			}
+		}
+
+		amount, err := economy.ParseMoney(price)
+		if err != nil {
+			return nil, database.BackendError{Message: fmt.Sprintf("Invalid price for '%s': %s.", item.Description, err.Error())}
+		}
+
+		item.UnitPrice = amount
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func requestEmbed(request database.PaymentRequest) *utils.Embed {
+	economy := request.ToAccount.Economy
+	title := fmt.Sprintf("Payment request #%d", request.ID)
+	if request.Invoice {
+		title = fmt.Sprintf("Invoice #%d", request.ID)
+	}
+
+	color := handlers.Colors.Warning
+	if request.Status == database.RQ_Paid {
+		color = handlers.Colors.Normal
+	} else if request.Status != database.RQ_Pending {
+		color = handlers.Colors.Error
+	}
+
+	embed := utils.NewEmbed().SetTitle(title).SetColor(color).
+		SetDescription(fmt.Sprintf("<@%s> asks <@%s> to pay **%s**.", request.RequesterID, request.PayerID, request.ToAccount.AccountName))
+
+	if request.Memo != "" {
+		embed.AddField("Memo", request.Memo)
+	}
+
+	if len(request.Items) > 0 {
+		var lines []string
+		for _, item := range request.Items {
+			total, _ := item.UnitPrice.Mul(int64(item.Quantity))
+			lines = append(lines, fmt.Sprintf("%dx %s @ %s = %s", item.Quantity, item.Description, economy.FormatMoney(item.UnitPrice), economy.FormatMoney(total)))
+		}
+		embed.AddField("Items", strings.Join(lines, "\n"))
+	}
+
+	embed.AddField("Amount", economy.FormatMoney(request.Amount)).
+		AddField("Type", database.TransferTypeName(request.TransferType)).
+		AddField("Status", database.RequestStatusName(request.Status))
+
+	if request.DueAt != nil {
+		embed.AddField("Due", fmt.Sprintf("<t:%d:D>", request.DueAt.Unix()))
+	}
+	if request.TransferID != nil {
+		embed.AddField("Transfer", fmt.Sprintf("#%d", *request.TransferID))
+	}
+
+	return embed
+}
+
+// requestComponents returns the buttons of a pending request, or none once it has been settled
+func requestComponents(request database.PaymentRequest) []discordgo.MessageComponent {
+	if request.Status != database.RQ_Pending {
+		return []discordgo.MessageComponent{}
+	}
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.Button{Label: "Pay", Style: discordgo.SuccessButton, CustomID: fmt.Sprintf("request:pay:%d", request.ID)},
+			discordgo.Button{Label: "Decline", Style: discordgo.DangerButton, CustomID: fmt.Sprintf("request:decline:%d", request.ID)},
+		}},
+	}
+}
+
+// sendPaymentRequest delivers a request to its payer by DM, or in reply to the command if they do not accept DMs
+func sendPaymentRequest(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, request database.PaymentRequest) {
+	embed := requestEmbed(request)
+
+	channel, err := s.UserChannelCreate(request.PayerID)
+	if err == nil {
+		_, err = s.ChannelMessageSendComplex(channel.ID, &discordgo.MessageSend{
+			Embeds: []*discordgo.MessageEmbed{embed.Truncate().MessageEmbed},
+			Components: requestComponents(request),
+		})
+	}
+
+	if err != nil {
+		handlers.RespondComponents(s, event, embed, requestComponents(request))
+		return
+	}
+
+	notice := utils.NewEmbed().SetTitle("Request sent").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("<@%s> has been asked by DM to pay %s.", request.PayerID, request.ToAccount.Economy.FormatMoney(request.Amount)))
+	handlers.Respond(s, event, notice)
+}
+
+func createPaymentRequest(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, build func(economy database.Economy, request *database.PaymentRequest) error) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	to, _, err := ctx.Backend.GetActingAccount(member, economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	to.Economy = economy
+	request := database.PaymentRequest{
+		GuildID: event.GuildID,
+		PayerID: ctx.Option("user").Value.(string),
+		ToAccount: to,
+	}
+
+	if opt := ctx.Option("memo"); opt != nil {
+		request.Memo = opt.StringValue()
+	}
+
+	if ctx.Option("due") != nil {
+		due, err := dateOption(ctx, "due")
+		if err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+
+		request.DueAt = endOfDay(due)
+	}
+
+	if err := build(economy, &request); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.CreatePaymentRequest(member, &request); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	sendPaymentRequest(ctx, s, event, request)
+}
+
+func requestPayment(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	createPaymentRequest(ctx, s, event, func(economy database.Economy, request *database.PaymentRequest) error {
+		amount, err := moneyOption(ctx, economy, "amount")
+		if err != nil {
+			return err
+		}
+
+		request.Amount, request.TransferType = amount, database.TT_Personal
+		if opt := ctx.Option("type"); opt != nil {
+			request.TransferType = uint8(opt.IntValue())
+		}
+		return nil
+	})
+}
+
+func sendInvoice(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	createPaymentRequest(ctx, s, event, func(economy database.Economy, request *database.PaymentRequest) error {
+		items, err := parseInvoiceItems(economy, ctx.Option("items").StringValue())
+		if err != nil {
+			return err
+		} else if len(items) == 0 {
+			return database.BackendError{Message: "An invoice needs at least one item."}
+		}
+
+		request.Items = items
+		return nil
+	})
+}
+
+// interactionMember returns the member behind an interaction, looking them up in the given guild when it comes from a DM,
+// since permission checks need their roles
+func interactionMember(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, guild_id string) (database.SessionedMember, error) {
+	if event.Member != nil {
+		return ctx.Member(s, event)
+	}
+
+	member, err := s.State.Member(guild_id, event.User.ID)
+	if err != nil {
+		if member, err = s.GuildMember(guild_id, event.User.ID); err != nil {
+			return database.SessionedMember{}, database.BackendError{Message: "You are no longer a member of the server this request was made in."}
+		}
+	}
+
+	resolved := *member
+	resolved.GuildID = guild_id
+	return database.SessionedMember{Member: resolved, Session: s}, nil
+}
+
+// payRequest pays a request from the payer's acting account in the economy of the request
+func payRequest(ctx *handlers.Context, member database.SessionedMember, request *database.PaymentRequest) (*database.Transfer, error) {
+	from, _, err := ctx.Backend.GetActingAccount(member, request.ToAccount.Economy)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer, err := ctx.Backend.PayPaymentRequest(member, request, &from)
+	if errors.Is(err, database.ApprovalRequiredError) {
+		return nil, database.BackendError{Message: "This payment needs approval from the members of your account; use /pay to propose it instead."}
+	}
+	return transfer, err
+}
+
+func handleRequestButton(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	parts := strings.Split(event.MessageComponentData().CustomID, ":")
+	if len(parts) != 3 {
+		return
+	}
+
+	id, err := strconv.ParseUint(parts[2], 10, 64)
+	if err != nil {
+		return
+	}
+
+	request, err := ctx.Backend.GetPaymentRequestByID(uint(id))
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	member, err := interactionMember(ctx, s, event, request.GuildID)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	switch parts[1] {
+	case "pay":
+		_, err = payRequest(ctx, member, &request)
+	case "decline":
+		err = ctx.Backend.DeclinePaymentRequest(member, &request)
+	default:
+		return
+	}
+
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.UpdateMessage(s, event, requestEmbed(request), requestComponents(request))
+}
+
+func listRequests(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, outgoing bool) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	requests, err := ctx.Backend.GetPaymentRequests(member, economy, outgoing)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var lines []string
+	for _, request := range requests {
+		line := fmt.Sprintf("**#%d** %s to %s", request.ID, economy.FormatMoney(request.Amount), request.ToAccount.AccountName)
+		if outgoing {
+			line += fmt.Sprintf(" from <@%s>", request.PayerID)
+		}
+		if request.Memo != "" {
+			line += ": " + request.Memo
+		}
+		if request.DueAt != nil {
+			line += fmt.Sprintf(", due <t:%d:R>", request.DueAt.Unix())
+		}
+		lines = append(lines, line)
+	}
+
+	title := "Requests to pay"
+	if outgoing {
+		title = "Requests sent"
+	}
+
+	embed := utils.NewEmbed().SetTitle(title).SetColor(handlers.Colors.Normal)
+	if len(lines) == 0 {
+		embed.SetDescription("There are no pending requests.")
+	} else {
+		embed.SetDescription(strings.Join(lines, "\n"))
+	}
+
+	handlers.RespondEphemeral(s, event, embed)
+}
+
+func listIncomingRequests(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	listRequests(ctx, s, event, false)
+}
+
+func listOutgoingRequests(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	listRequests(ctx, s, event, true)
+}
+
+// settleRequestCommand runs one of the request subcommands that settle the chosen request, and shows its final state
+func settleRequestCommand(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate, settle func(member database.SessionedMember, request *database.PaymentRequest) error) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	request, err := requestOption(ctx, "request")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := settle(member, &request); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.RespondEphemeral(s, event, requestEmbed(request))
+}
+
+func payRequestCommand(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	settleRequestCommand(ctx, s, event, func(member database.SessionedMember, request *database.PaymentRequest) error {
+		_, err := payRequest(ctx, member, request)
+		return err
+	})
+}
+
+func declineRequestCommand(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	settleRequestCommand(ctx, s, event, ctx.Backend.DeclinePaymentRequest)
+}
+
+func cancelRequest(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	settleRequestCommand(ctx, s, event, ctx.Backend.CancelPaymentRequest)
+}
+
+// remindPaymentRequest sends the payer of an overdue request a reminder with the buttons to settle it
+func (b *Bot) remindPaymentRequest(request database.PaymentRequest) error {
+	channel, err := b.Session.UserChannelCreate(request.PayerID)
+	if err != nil {
+		return err
+	}
+
+	embed := requestEmbed(request)
+	embed.SetTitle("Overdue: " + embed.Title).SetFooter(fmt.Sprintf("You will be reminded every %d hours until this request is settled.", int(database.ReminderInterval.Hours())))
+	_, err = b.Session.ChannelMessageSendComplex(channel.ID, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{embed.Truncate().MessageEmbed},
+		Components: requestComponents(request),
+	})
+	return err
+}
@@ -0,0 +1,40 @@
+package bot
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var reversalPolicyChoices = []*discordgo.ApplicationCommandOptionChoice{
+	{Name: "Admins only", Value: database.RP_Admin},
+	{Name: "Recipients or admins", Value: database.RP_RecipientConsent},
+}
+
+func setReversalPolicy(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	policy := uint8(ctx.Option("policy").IntValue())
+	if err := ctx.Backend.SetReversalPolicy(member, &economy, policy); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Reversal policy updated").SetColor(handlers.Colors.Normal).
+		SetDescription("Only admins who can manage funds can reverse transfers in this economy.")
+	if policy == database.RP_RecipientConsent {
+		embed.SetDescription("The recipient of a transfer can now reverse it, which counts as their consent, as can admins who can manage funds.")
+	}
+	handlers.Respond(s, event, embed)
+}
@@ -0,0 +1,232 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var roleIncomeAutocomplete handlers.EventFunc = func(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	incomes, err := ctx.Backend.GetRoleIncomes(economy)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	query := strings.ToLower(focusedValue(ctx))
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, income := range incomes {
+		name := fmt.Sprintf("#%d: %s %s to @%s", income.ID, economy.FormatMoney(income.Amount), database.PeriodName(income.PaymentInterval), roleName(s, income.GuildID, income.RoleID))
+		if strings.Contains(strings.ToLower(name), query) && len(choices) < 25 {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: fmt.Sprint(income.ID)})
+		}
+	}
+	autocomplete(s, event, choices)
+}
+
+var RoleIncomeCommand = handlers.Command{
+	Name: "roleincome",
+	Description: "Manage salaries and basic incomes paid to everyone with a role",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "add",
+			Description: "Pay everyone with a role an amount regularly",
+			Callback: addRoleIncome,
+			Options: []*handlers.Option{
+				{Name: "role", Description: "The role to pay", Type: &discordgo.Role{}, Required: true},
+				{Name: "amount", Description: "The amount to pay each member", Type: "", Required: true},
+				{Name: "interval", Description: "How often to pay", Type: 0, Required: true, Choices: periodChoices[1:]},
+				{Name: "from", Description: "The account to pay from", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+				{Name: "taxed", Description: "Whether income tax applies, true if omitted", Type: true},
+				{Name: "start", Description: "The date of the first payment as YYYY-MM-DD, today if omitted", Type: ""},
+			},
+		},
+		{
+			Name: "list",
+			Description: "List the role incomes of this economy",
+			Callback: listRoleIncomes,
+		},
+		{
+			Name: "remove",
+			Description: "Stop paying a role income",
+			Callback: removeRoleIncome,
+			Options: []*handlers.Option{
+				{Name: "income", Description: "The role income", Type: "", Required: true, Autocomplete: &roleIncomeAutocomplete},
+			},
+		},
+	},
+}
+
+// roleName returns the name of a role from the state cache, or its ID if it is not cached
+func roleName(s *discordgo.Session, guild_id string, role_id string) string {
+	if role, err := s.State.Role(guild_id, role_id); err == nil {
+		return role.Name
+	}
+	return role_id
+}
+
+func roleIncomeEmbed(title string, income database.RoleIncome) *utils.Embed {
+	economy := income.FromAccount.Economy
+
+	taxed := "Yes"
+	if !income.Taxed {
+		taxed = "No"
+	}
+
+	embed := utils.NewEmbed().SetTitle(title).SetColor(handlers.Colors.Normal).
+		AddField("Role", fmt.Sprintf("<@&%s>", income.RoleID)).
+		AddField("From", income.FromAccount.AccountName).
+		AddField("Amount", economy.FormatMoney(income.Amount)).
+		AddField("Interval", database.PeriodName(income.PaymentInterval)).
+		AddField("Income tax", taxed).
+		AddField("Next payment", fmt.Sprintf("<t:%d:f>", income.NextPayment.Unix()))
+
+	return embed.InlineAllFields()
+}
+
+func addRoleIncome(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	income := database.RoleIncome{
+		GuildID: event.GuildID,
+		RoleID: ctx.Option("role").Value.(string),
+		PaymentInterval: uint8(ctx.Option("interval").IntValue()),
+		Taxed: true,
+	}
+
+	if income.FromAccount, err = accountOption(ctx, economy, "from"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	} else if income.Amount, err = moneyOption(ctx, economy, "amount"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+	income.FromAccount.Economy = economy
+
+	if opt := ctx.Option("taxed"); opt != nil {
+		income.Taxed = opt.BoolValue()
+	}
+
+	if ctx.Option("start") != nil {
+		if income.NextPayment, err = dateOption(ctx, "start"); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+	}
+
+	if err := ctx.Backend.CreateRoleIncome(member, economy, &income); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, roleIncomeEmbed("Role income created", income))
+}
+
+func listRoleIncomes(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	incomes, err := ctx.Backend.GetRoleIncomes(economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var lines []string
+	for _, income := range incomes {
+		line := fmt.Sprintf("**#%d** %s %s to <@&%s> from %s, next <t:%d:R>", income.ID, economy.FormatMoney(income.Amount), database.PeriodName(income.PaymentInterval), income.RoleID, income.FromAccount.AccountName, income.NextPayment.Unix())
+		if !income.Taxed {
+			line += ", untaxed"
+		}
+		lines = append(lines, line)
+	}
+
+	embed := utils.NewEmbed().SetTitle("Role incomes").SetColor(handlers.Colors.Normal)
+	if len(lines) == 0 {
+		embed.SetDescription("There are no role incomes.")
+	} else {
+		embed.SetDescription(strings.Join(lines, "\n"))
+	}
+
+	handlers.Respond(s, event, embed)
+}
+
+func removeRoleIncome(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	id, err := strconv.ParseUint(strings.TrimPrefix(ctx.Option("income").StringValue(), "#"), 10, 64)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, database.BackendError{Message: "Please choose a role income from the list."})
+		return
+	}
+
+	income, err := ctx.Backend.GetRoleIncomeByID(uint(id))
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.RemoveRoleIncome(member, economy, income); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, roleIncomeEmbed("Role income removed", income))
+}
+
+// payRoleIncome pays a due role income to whoever holds its role right now, and tells its creator by DM if nobody could be paid
+func (b *Bot) payRoleIncome(income database.RoleIncome, now time.Time) error {
+	user_ids, err := roleMembers(b.Session, income.GuildID, income.RoleID)
+	if err != nil {
+		return err
+	}
+
+	payment, err := b.Backend.PayRoleIncome(&income, user_ids, now)
+	if err != nil || payment == nil || payment.Error == nil {
+		return err
+	}
+
+	embed := roleIncomeEmbed("Role income failed", income).SetColor(handlers.Colors.Error).SetDescription(payment.Error.Error())
+	channel, err := b.Session.UserChannelCreate(income.ActorID)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.Session.ChannelMessageSendEmbed(channel.ID, embed.Truncate().MessageEmbed)
+	return err
+}
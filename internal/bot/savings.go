@@ -0,0 +1,266 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var savingsBasisChoices = []*discordgo.ApplicationCommandOptionChoice{
+	{Name: "Average balance", Value: database.SB_Average},
+	{Name: "Minimum balance", Value: database.SB_Minimum},
+}
+
+var SavingsCommand = handlers.Command{
+	Name: "savings",
+	Description: "Earn interest on the balance of an account",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "open",
+			Description: "Turn an account into a savings account that earns interest",
+			Callback: openSavings,
+			Options: []*handlers.Option{
+				{Name: "account", Description: "The account, the one you are logged in as or your own if omitted", Type: "", Autocomplete: &accountAutocomplete},
+				{Name: "lockup", Description: "How many days to lock the funds up for, withdrawing early costs a penalty", Type: 0},
+			},
+		},
+		{
+			Name: "close",
+			Description: "Stop a savings account from earning interest, forfeiting the interest of the current period",
+			Callback: closeSavings,
+			Options: []*handlers.Option{
+				{Name: "account", Description: "The savings account", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+			},
+		},
+		{
+			Name: "show",
+			Description: "Show the interest a savings account has earned",
+			Callback: showSavings,
+			Options: []*handlers.Option{
+				{Name: "account", Description: "The savings account, the one you are logged in as or your own if omitted", Type: "", Autocomplete: &accountAutocomplete},
+			},
+		},
+		{
+			Name: "policy",
+			Description: "Set the interest savings accounts earn in this economy",
+			Callback: setSavingsPolicy,
+			Options: []*handlers.Option{
+				{Name: "account", Description: "The government account paying the interest", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+				{Name: "rate", Description: "The interest per year, such as 2.5%", Type: "", Required: true},
+				{Name: "payout", Description: "How often interest is paid, monthly if omitted", Type: 0, Choices: periodChoices[1:]},
+				{Name: "basis", Description: "Which balance interest is earned on, the daily average if omitted", Type: 0, Choices: savingsBasisChoices},
+				{Name: "penalty", Description: "The share of a withdrawal during a lock-up paid as a penalty, such as 5%", Type: ""},
+			},
+		},
+	},
+}
+
+// savingsAccountOption returns the account chosen in an option, or the account the member is logged in as if it was omitted
+func savingsAccountOption(ctx *handlers.Context, member database.SessionedMember, economy database.Economy) (database.Account, error) {
+	if ctx.Option("account") != nil {
+		return accountOption(ctx, economy, "account")
+	}
+
+	account, _, err := ctx.Backend.GetActingAccount(member, economy)
+	return account, err
+}
+
+func savingsEmbed(title string, savings database.SavingsAccount, policy database.SavingsPolicy) *utils.Embed {
+	economy := savings.Account.Economy
+
+	embed := utils.NewEmbed().SetTitle(title).SetColor(handlers.Colors.Normal).
+		AddField("Account", savings.Account.AccountName).
+		AddField("Interest", fmt.Sprintf("%s a year on the %s", database.FormatBasisPoints(policy.Rate), strings.ToLower(database.SavingsBasisName(policy.Basis)))).
+		AddField("Paid", database.PeriodName(policy.PayoutPeriod)).
+		AddField("Earned", economy.FormatMoney(savings.Earned))
+
+	if interest, err := savings.Interest(policy); err == nil {
+		embed.AddField("Accrued this period", economy.FormatMoney(interest))
+	}
+
+	if savings.Locked(time.Now()) {
+		embed.AddField("Locked up until", fmt.Sprintf("<t:%d:d>", savings.LockedUntil.Unix())).
+			AddField("Early withdrawal penalty", database.FormatBasisPoints(policy.Penalty))
+	}
+
+	return embed.InlineAllFields()
+}
+
+func openSavings(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := savingsAccountOption(ctx, member, economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var lockup uint
+	if opt := ctx.Option("lockup"); opt != nil {
+		if opt.IntValue() < 0 {
+			handlers.RespondError(ctx, s, event, database.BackendError{Message: "The lock-up cannot be negative."})
+			return
+		}
+		lockup = uint(opt.IntValue())
+	}
+
+	savings, err := ctx.Backend.OpenSavingsAccount(member, economy, &account, lockup)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	policy, err := ctx.Backend.GetSavingsPolicy(economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	} else if policy == nil {
+		handlers.RespondError(ctx, s, event, database.BackendError{Message: "This economy no longer offers savings accounts."})
+		return
+	}
+
+	savings.Account.Economy = economy
+	handlers.Respond(s, event, savingsEmbed("Savings account opened", *savings, *policy))
+}
+
+func closeSavings(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.CloseSavingsAccount(member, &account); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Savings account closed").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("**%s** no longer earns interest.", account.AccountName))
+	handlers.Respond(s, event, embed)
+}
+
+func showSavings(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := savingsAccountOption(ctx, member, economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	// viewing the savings of an account reveals its balance
+	if account, err = ctx.Backend.ViewAccount(member, account); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	savings, err := ctx.Backend.GetSavingsAccount(account)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	} else if savings == nil {
+		handlers.RespondError(ctx, s, event, database.BackendError{Message: "This account is not a savings account."})
+		return
+	}
+
+	policy, err := ctx.Backend.GetSavingsPolicy(economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	} else if policy == nil {
+		handlers.RespondError(ctx, s, event, database.BackendError{Message: "This economy no longer offers savings accounts."})
+		return
+	}
+
+	handlers.RespondEphemeral(s, event, savingsEmbed("Savings account", *savings, *policy))
+}
+
+func setSavingsPolicy(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	policy := database.SavingsPolicy{PayoutPeriod: database.PD_Monthly}
+	if policy.FromAccount, err = accountOption(ctx, economy, "account"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if policy.Rate, err = rateOption(ctx, "rate"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if opt := ctx.Option("payout"); opt != nil {
+		policy.PayoutPeriod = uint8(opt.IntValue())
+	}
+	if opt := ctx.Option("basis"); opt != nil {
+		policy.Basis = uint8(opt.IntValue())
+	}
+	if ctx.Option("penalty") != nil {
+		if policy.Penalty, err = rateOption(ctx, "penalty"); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+	}
+
+	if err := ctx.Backend.SetSavingsPolicy(member, economy, &policy); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Savings policy updated").SetColor(handlers.Colors.Normal).
+		AddField("Central bank", policy.FromAccount.AccountName).
+		AddField("Interest", fmt.Sprintf("%s a year", database.FormatBasisPoints(policy.Rate))).
+		AddField("Earned on", database.SavingsBasisName(policy.Basis)).
+		AddField("Paid", database.PeriodName(policy.PayoutPeriod)).
+		AddField("Early withdrawal penalty", database.FormatBasisPoints(policy.Penalty)).
+		InlineAllFields()
+	handlers.Respond(s, event, embed)
+}
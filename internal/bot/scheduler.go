@@ -0,0 +1,172 @@
+package bot
+
+import (
+	"time"
+
+	"github.com/ohknettel/taubot-v3/internal/database"
+)
+
+// Job is a task the bot runs periodically for as long as it is connected
+type Job struct {
+	Name string
+	Interval time.Duration
+	Run func(b *Bot, now time.Time) error
+}
+
+var Jobs []Job = []Job{
+	{
+		Name: "wealth tax",
+		Interval: 5 * time.Minute,
+		Run: func(b *Bot, now time.Time) error {
+			return b.Backend.RunScheduledWealthTaxes(now)
+		},
+	},
+	{
+		Name: "savings interest",
+		Interval: 15 * time.Minute,
+		Run: func(b *Bot, now time.Time) error {
+			return b.Backend.RunSavingsInterest(now)
+		},
+	},
+	{
+		Name: "demurrage",
+		Interval: 15 * time.Minute,
+		Run: func(b *Bot, now time.Time) error {
+			return b.Backend.RunDemurrage(now)
+		},
+	},
+	{
+		Name: "escheatment",
+		Interval: time.Hour,
+		Run: func(b *Bot, now time.Time) error {
+			notices, err := b.Backend.RunEscheatment(now)
+			for _, notice := range notices {
+				if err := b.notifyEscheatment(notice); err != nil && b.Logger != nil {
+					b.Logger.Printf("An error occured while notifying %s of a dormant account: %v", notice.OwnerID, err)
+				}
+			}
+			return err
+		},
+	},
+	{
+		Name: "recurring transfers",
+		Interval: time.Minute,
+		Run: func(b *Bot, now time.Time) error {
+			payments, err := b.Backend.RunRecurringTransfers(now)
+			for _, payment := range payments {
+				if err := b.notifyRecurringPayment(payment); err != nil && b.Logger != nil {
+					b.Logger.Printf("An error occured while notifying %s of a standing order payment: %v", payment.Order.ActorID, err)
+				}
+
+				// the lender hears of a default once, when the installment that tipped it over fails
+				if loan := payment.Loan; loan != nil && loan.Status == database.LS_Defaulted && payment.Error != nil && (loan.Missed == database.LoanDefaultAfter || payment.Order.Status == database.RS_Cancelled) {
+					if err := b.notifyLoanDefault(*loan); err != nil && b.Logger != nil {
+						b.Logger.Printf("An error occured while notifying %s of a loan default: %v", loan.LenderID, err)
+					}
+				}
+			}
+			return err
+		},
+	},
+	{
+		Name: "account sessions",
+		Interval: time.Minute,
+		Run: func(b *Bot, now time.Time) error {
+			sessions, err := b.Backend.ExpireAccountSessions(now)
+			for _, session := range sessions {
+				if err := b.notifySessionExpired(session); err != nil && b.Logger != nil {
+					b.Logger.Printf("An error occured while notifying %s of an expired session: %v", session.UserID, err)
+				}
+			}
+			return err
+		},
+	},
+	{
+		Name: "escrow contracts",
+		Interval: time.Minute,
+		Run: func(b *Bot, now time.Time) error {
+			contracts, err := b.Backend.ExpireEscrowContracts(now)
+			for _, contract := range contracts {
+				if err := b.notifyEscrowExpired(contract); err != nil && b.Logger != nil {
+					b.Logger.Printf("An error occured while notifying %s of an expired escrow contract: %v", contract.BuyerID, err)
+				}
+			}
+			return err
+		},
+	},
+	{
+		Name: "transfer proposals",
+		Interval: time.Minute,
+		Run: func(b *Bot, now time.Time) error {
+			proposals, err := b.Backend.ExpireProposals(now)
+			for _, proposal := range proposals {
+				if err := b.updateExpiredProposal(proposal); err != nil && b.Logger != nil {
+					b.Logger.Printf("An error occured while updating the message of proposal #%d: %v", proposal.ID, err)
+				}
+			}
+			return err
+		},
+	},
+	{
+		Name: "payment reminders",
+		Interval: 15 * time.Minute,
+		Run: func(b *Bot, now time.Time) error {
+			requests, err := b.Backend.RemindOverdueRequests(now)
+			for _, request := range requests {
+				if err := b.remindPaymentRequest(request); err != nil && b.Logger != nil {
+					b.Logger.Printf("An error occured while reminding %s of request #%d: %v", request.PayerID, request.ID, err)
+				}
+			}
+			return err
+		},
+	},
+	{
+		Name: "role incomes",
+		Interval: time.Minute,
+		Run: func(b *Bot, now time.Time) error {
+			incomes, err := b.Backend.DueRoleIncomes(now)
+			for _, income := range incomes {
+				if err := b.payRoleIncome(income, now); err != nil && b.Logger != nil {
+					b.Logger.Printf("An error occured while paying role income #%d: %v", income.ID, err)
+				}
+			}
+			return err
+		},
+	},
+	{
+		Name: "activity rewards",
+		Interval: time.Hour,
+		Run: func(b *Bot, now time.Time) error {
+			b.creditVoiceActivity(now)
+			payouts, err := b.Backend.PayActivityRewards(now)
+			for _, payout := range payouts {
+				if err := b.notifyActivityPayout(payout); err != nil && b.Logger != nil {
+					b.Logger.Printf("An error occured while notifying %s of unpaid activity rewards: %v", payout.Reward.ActorID, err)
+				}
+			}
+			return err
+		},
+	},
+}
+
+// StartScheduler runs every job once right away and then on its interval, until stop is closed
+func (b *Bot) StartScheduler(stop <-chan struct{}) {
+	for _, job := range Jobs {
+		go func(job Job) {
+			ticker := time.NewTicker(job.Interval)
+			defer ticker.Stop()
+
+			for {
+				if err := job.Run(b, time.Now()); err != nil && b.Logger != nil {
+					b.Logger.Printf("An error occured while running the %s job: %v", job.Name, err)
+				}
+
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				}
+			}
+		}(job)
+	}
+}
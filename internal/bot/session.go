@@ -0,0 +1,101 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+const defaultSessionMinutes = 60
+
+var LoginCommand = handlers.Command{
+	Name: "login",
+	Description: "Act as another account for a limited time",
+	Callback: login,
+	Options: []*handlers.Option{
+		{Name: "account", Description: "The account to act as", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+		{Name: "minutes", Description: fmt.Sprintf("How long to act as the account, %d if omitted", defaultSessionMinutes), Type: 0},
+	},
+}
+
+var LogoutCommand = handlers.Command{
+	Name: "logout",
+	Description: "Stop acting as another account",
+	Callback: logout,
+}
+
+func login(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	minutes := int64(defaultSessionMinutes)
+	if opt := ctx.Option("minutes"); opt != nil {
+		minutes = opt.IntValue()
+	}
+
+	session, err := ctx.Backend.StartAccountSession(member, account, time.Duration(minutes) * time.Minute)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Logged in").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("You are now acting as **%s**. Payments and other commands run from this account until <t:%d:t>, or until you use /logout.", account.AccountName, session.ExpiresAt.Unix()))
+	handlers.RespondEphemeral(s, event, embed)
+}
+
+func logout(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	session, err := ctx.Backend.EndAccountSession(member, economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Logged out").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("You are no longer acting as **%s**.", session.Account.AccountName))
+	handlers.RespondEphemeral(s, event, embed)
+}
+
+// notifySessionExpired lets a user know that they are no longer acting as an account
+func (b *Bot) notifySessionExpired(session database.AccountSession) error {
+	channel, err := b.Session.UserChannelCreate(session.UserID)
+	if err != nil {
+		return err
+	}
+
+	embed := utils.NewEmbed().SetTitle("Session expired").SetColor(handlers.Colors.Warning).
+		SetDescription(fmt.Sprintf("You are no longer acting as **%s**.", session.Account.AccountName))
+	_, err = b.Session.ChannelMessageSendEmbed(channel.ID, embed.MessageEmbed)
+	return err
+}
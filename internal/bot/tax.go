@@ -0,0 +1,381 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+var taxTypeChoices = []*discordgo.ApplicationCommandOptionChoice{
+	{Name: "Wealth", Value: database.TX_Wealth},
+	{Name: "Income", Value: database.TX_Income},
+	{Name: "VAT", Value: database.TX_VAT},
+	{Name: "Transaction", Value: database.TX_Transaction},
+}
+
+var taxAutocomplete handlers.EventFunc = func(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	brackets, err := ctx.Backend.GetTaxBrackets(economy)
+	if err != nil {
+		autocomplete(s, event, nil)
+		return
+	}
+
+	query := strings.ToLower(focusedValue(ctx))
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, bracket := range brackets {
+		name := fmt.Sprintf("%s (%s, %s)", bracket.TaxName, database.TaxTypeName(bracket.TaxType), database.AccountTypeName(bracket.AffectedType))
+		if strings.Contains(strings.ToLower(name), query) && len(choices) < 25 {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: bracket.EntryID})
+		}
+	}
+	autocomplete(s, event, choices)
+}
+
+var TaxCommand = handlers.Command{
+	Name: "tax",
+	Description: "Manage the tax brackets of this economy",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "add",
+			Description: "Add a tax bracket",
+			Callback: addTaxBracket,
+			Options: []*handlers.Option{
+				{Name: "name", Description: "The name of the bracket", Type: "", Required: true},
+				{Name: "type", Description: "The kind of tax", Type: 0, Required: true, Choices: taxTypeChoices},
+				{Name: "affected", Description: "The type of account the bracket applies to", Type: 0, Required: true, Choices: accountTypeChoices},
+				{Name: "start", Description: "The amount the bracket starts at", Type: "", Required: true},
+				{Name: "rate", Description: "The rate of the bracket, such as 12.5%", Type: "", Required: true},
+				{Name: "account", Description: "The account the tax is collected into", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+				{Name: "end", Description: "The amount the bracket ends at, unbounded if omitted", Type: ""},
+			},
+		},
+		{
+			Name: "edit",
+			Description: "Edit a tax bracket",
+			Callback: editTaxBracket,
+			Options: []*handlers.Option{
+				{Name: "bracket", Description: "The bracket to edit", Type: "", Required: true, Autocomplete: &taxAutocomplete},
+				{Name: "name", Description: "The new name of the bracket", Type: ""},
+				{Name: "start", Description: "The new amount the bracket starts at", Type: ""},
+				{Name: "end", Description: "The new amount the bracket ends at, or 'none' to make it unbounded", Type: ""},
+				{Name: "rate", Description: "The new rate of the bracket, such as 12.5%", Type: ""},
+				{Name: "account", Description: "The new account the tax is collected into", Type: "", Autocomplete: &accountAutocomplete},
+			},
+		},
+		{
+			Name: "remove",
+			Description: "Remove a tax bracket",
+			Callback: removeTaxBracket,
+			Options: []*handlers.Option{
+				{Name: "bracket", Description: "The bracket to remove", Type: "", Required: true, Autocomplete: &taxAutocomplete},
+			},
+		},
+		{
+			Name: "list",
+			Description: "List the tax brackets of this economy",
+			Callback: listTaxBrackets,
+			Options: []*handlers.Option{
+				{Name: "type", Description: "Only list brackets of this kind of tax", Type: 0, Choices: taxTypeChoices},
+			},
+		},
+		{
+			Name: "simulate",
+			Description: "Calculate the tax owed on an amount",
+			Callback: simulateTax,
+			Options: []*handlers.Option{
+				{Name: "type", Description: "The kind of tax", Type: 0, Required: true, Choices: taxTypeChoices},
+				{Name: "affected", Description: "The type of account paying the tax", Type: 0, Required: true, Choices: accountTypeChoices},
+				{Name: "amount", Description: "The amount to calculate the tax on", Type: "", Required: true},
+			},
+		},
+		{
+			Name: "income-period",
+			Description: "Set the period over which income is accumulated for income tax",
+			Callback: setIncomeTaxPeriod,
+			Options: []*handlers.Option{
+				{Name: "period", Description: "The length of an income tax period", Type: 0, Required: true, Choices: periodChoices[1:]},
+			},
+		},
+	},
+}
+
+// bracketRange formats the range a bracket covers, e.g. "$100.00 - $500.00" or "$500.00+"
+func bracketRange(economy database.Economy, bracket database.Tax) string {
+	if bracket.BracketEnd.IsZero() {
+		return economy.FormatMoney(bracket.BracketStart) + "+"
+	}
+	return economy.FormatMoney(bracket.BracketStart) + " - " + economy.FormatMoney(bracket.BracketEnd)
+}
+
+func bracketEmbed(economy database.Economy, title string, bracket database.Tax) *utils.Embed {
+	return utils.NewEmbed().SetTitle(title).SetColor(handlers.Colors.Normal).
+		AddField("Name", bracket.TaxName).
+		AddField("Tax", database.TaxTypeName(bracket.TaxType)).
+		AddField("Affects", database.AccountTypeName(bracket.AffectedType)).
+		AddField("Range", bracketRange(economy, bracket)).
+		AddField("Rate", database.FormatBasisPoints(bracket.Rate)).
+		AddField("Collected into", bracket.ToAccount.AccountName).
+		InlineAllFields()
+}
+
+func addTaxBracket(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	bracket := database.Tax{
+		TaxName: ctx.Option("name").StringValue(),
+		TaxType: uint8(ctx.Option("type").IntValue()),
+		AffectedType: uint8(ctx.Option("affected").IntValue()),
+	}
+
+	if bracket.Rate, err = rateOption(ctx, "rate"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if bracket.BracketStart, err = moneyOption(ctx, economy, "start"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if ctx.Option("end") != nil {
+		if bracket.BracketEnd, err = moneyOption(ctx, economy, "end"); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+	}
+
+	if bracket.ToAccount, err = accountOption(ctx, economy, "account"); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+	bracket.ToAccountID = bracket.ToAccount.ID
+
+	if err := ctx.Backend.CreateTaxBracket(member, economy, &bracket); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, bracketEmbed(economy, "Tax bracket added", bracket))
+}
+
+func editTaxBracket(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	bracket, err := ctx.Backend.GetTaxBracketByID(economy, ctx.Option("bracket").StringValue())
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if opt := ctx.Option("name"); opt != nil {
+		bracket.TaxName = opt.StringValue()
+	}
+
+	if ctx.Option("rate") != nil {
+		if bracket.Rate, err = rateOption(ctx, "rate"); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+	}
+
+	if ctx.Option("start") != nil {
+		if bracket.BracketStart, err = moneyOption(ctx, economy, "start"); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+	}
+
+	if opt := ctx.Option("end"); opt != nil {
+		if strings.EqualFold(opt.StringValue(), "none") {
+			bracket.BracketEnd = 0
+		} else if bracket.BracketEnd, err = moneyOption(ctx, economy, "end"); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+	}
+
+	if ctx.Option("account") != nil {
+		if bracket.ToAccount, err = accountOption(ctx, economy, "account"); err != nil {
+			handlers.RespondError(ctx, s, event, err)
+			return
+		}
+		bracket.ToAccountID = bracket.ToAccount.ID
+	}
+
+	if err := ctx.Backend.UpdateTaxBracket(member, economy, &bracket); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, bracketEmbed(economy, "Tax bracket updated", bracket))
+}
+
+func removeTaxBracket(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	bracket, err := ctx.Backend.GetTaxBracketByID(economy, ctx.Option("bracket").StringValue())
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	if err := ctx.Backend.DeleteTaxBracket(member, economy, bracket); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, bracketEmbed(economy, "Tax bracket removed", bracket))
+}
+
+func listTaxBrackets(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	brackets, err := ctx.Backend.GetTaxBrackets(economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	filter := ctx.Option("type")
+
+	// one table per tax and account type, since only brackets within the same group are progressive with each other
+	embed := utils.NewEmbed().SetTitle("Tax brackets").SetColor(handlers.Colors.Normal)
+	var group string
+	var rows []string
+	flush := func() {
+		if len(rows) > 0 {
+			embed.AddField(group, "```\n" + strings.Join(rows, "\n") + "\n```")
+		}
+		rows = nil
+	}
+
+	for _, bracket := range brackets {
+		if filter != nil && uint8(filter.IntValue()) != bracket.TaxType {
+			continue
+		}
+
+		name := fmt.Sprintf("%s tax on %s accounts", database.TaxTypeName(bracket.TaxType), database.AccountTypeName(bracket.AffectedType))
+		if name != group {
+			flush()
+			group = name
+		}
+
+		rows = append(rows, fmt.Sprintf("%-16.16s %-28s %7s  -> %s", bracket.TaxName, bracketRange(economy, bracket), database.FormatBasisPoints(bracket.Rate), bracket.ToAccount.AccountName))
+	}
+	flush()
+
+	if len(embed.Fields) == 0 {
+		embed.SetDescription("There are no tax brackets.")
+	}
+
+	handlers.Respond(s, event, embed)
+}
+
+func simulateTax(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	amount, err := moneyOption(ctx, economy, "amount")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	tax_type, affected := uint8(ctx.Option("type").IntValue()), uint8(ctx.Option("affected").IntValue())
+	legs, err := ctx.Backend.SimulateTax(economy, tax_type, affected, amount)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var total datatypes.Money
+	var lines []string
+	for _, leg := range legs {
+		total, _ = total.Add(leg.Amount)
+		lines = append(lines, fmt.Sprintf("%s: %s of %s = %s", leg.Tax.TaxName, database.FormatBasisPoints(leg.Tax.Rate), economy.FormatMoney(leg.Basis), economy.FormatMoney(leg.Amount)))
+	}
+
+	embed := utils.NewEmbed().SetTitle(fmt.Sprintf("%s tax on %s for %s accounts", database.TaxTypeName(tax_type), economy.FormatMoney(amount), database.AccountTypeName(affected))).
+		SetColor(handlers.Colors.Normal).
+		AddField("Total", economy.FormatMoney(total))
+
+	if len(lines) == 0 {
+		embed.SetDescription("No tax is owed.")
+	} else {
+		embed.SetDescription(strings.Join(lines, "\n"))
+	}
+
+	handlers.Respond(s, event, embed)
+}
+
+func setIncomeTaxPeriod(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	period := uint8(ctx.Option("period").IntValue())
+	if err := ctx.Backend.SetIncomeTaxPeriod(member, &economy, period); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Income tax").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("Income is now accumulated and taxed %s.", database.PeriodName(period)))
+	handlers.Respond(s, event, embed)
+}
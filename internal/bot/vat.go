@@ -0,0 +1,199 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+const defaultReportDays = 30
+
+var VATCommand = handlers.Command{
+	Name: "vat",
+	Description: "Manage VAT on purchases in this economy",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "pricing",
+			Description: "Set whether purchase prices include VAT or have it added on top",
+			Callback: setVATPricing,
+			Options: []*handlers.Option{
+				{Name: "inclusive", Description: "Whether purchase prices already include VAT", Type: false, Required: true},
+			},
+		},
+		{
+			Name: "exempt",
+			Description: "Exempt sales by a type of account from VAT, or lift the exemption",
+			Callback: setVATExemption,
+			Options: []*handlers.Option{
+				{Name: "type", Description: "The type of selling account", Type: 0, Required: true, Choices: accountTypeChoices},
+				{Name: "exempt", Description: "Whether sales by this type of account are exempt", Type: false, Required: true},
+			},
+		},
+		{
+			Name: "status",
+			Description: "Show how VAT is applied in this economy",
+			Callback: showVATStatus,
+		},
+		{
+			Name: "report",
+			Description: "Show the VAT an account collected on its sales",
+			Callback: showVATReport,
+			Options: []*handlers.Option{
+				{Name: "account", Description: "The selling account", Type: "", Required: true, Autocomplete: &accountAutocomplete},
+				{Name: "days", Description: fmt.Sprintf("How many days back to report on, %d if omitted", defaultReportDays), Type: 0},
+			},
+		},
+	},
+}
+
+func vatPricingName(inclusive bool) string {
+	if inclusive {
+		return "VAT-inclusive"
+	}
+	return "VAT-exclusive"
+}
+
+func setVATPricing(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	inclusive := ctx.Option("inclusive").BoolValue()
+	if err := ctx.Backend.SetVATInclusive(member, &economy, inclusive); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("VAT").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("Purchase prices are now %s.", vatPricingName(inclusive)))
+	handlers.Respond(s, event, embed)
+}
+
+func setVATExemption(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	account_type, exempt := uint8(ctx.Option("type").IntValue()), ctx.Option("exempt").BoolValue()
+	if err := ctx.Backend.SetVATExemption(member, economy, account_type, exempt); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	description := fmt.Sprintf("Sales by %s accounts are now subject to VAT.", database.AccountTypeName(account_type))
+	if exempt {
+		description = fmt.Sprintf("Sales by %s accounts are now exempt from VAT.", database.AccountTypeName(account_type))
+	}
+
+	embed := utils.NewEmbed().SetTitle("VAT").SetColor(handlers.Colors.Normal).SetDescription(description)
+	handlers.Respond(s, event, embed)
+}
+
+func showVATStatus(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	exemptions, err := ctx.Backend.GetVATExemptions(economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	exempt := "None"
+	if len(exemptions) > 0 {
+		names := make([]string, 0, len(exemptions))
+		for _, exemption := range exemptions {
+			names = append(names, database.AccountTypeName(exemption.AccountType))
+		}
+		exempt = strings.Join(names, ", ")
+	}
+
+	embed := utils.NewEmbed().SetTitle("VAT").SetColor(handlers.Colors.Normal).
+		AddField("Pricing", vatPricingName(economy.VATInclusive)).
+		AddField("Exempt sellers", exempt).
+		InlineAllFields()
+	handlers.Respond(s, event, embed)
+}
+
+func showVATReport(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	seller, err := accountOption(ctx, economy, "account")
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	days := int64(defaultReportDays)
+	if opt := ctx.Option("days"); opt != nil {
+		if days = opt.IntValue(); days <= 0 {
+			handlers.RespondError(ctx, s, event, database.BackendError{Message: "A report has to cover at least one day."})
+			return
+		}
+	}
+
+	to := time.Now()
+	report, err := ctx.Backend.GetVATReport(member, seller, to.AddDate(0, 0, -int(days)), to)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	var lines []string
+	for _, line := range report.Lines {
+		name := line.TaxName
+		if name == "" {
+			name = "Removed bracket"
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s): %s on %s over %d sales", name, database.FormatBasisPoints(line.Rate), economy.FormatMoney(line.VAT), economy.FormatMoney(line.Net), line.Sales))
+	}
+
+	embed := utils.NewEmbed().SetTitle(fmt.Sprintf("VAT collected by %s", seller.AccountName)).SetColor(handlers.Colors.Normal).
+		AddField("Sales", fmt.Sprint(report.Sales)).
+		AddField("Net sales", economy.FormatMoney(report.Net)).
+		AddField("VAT", economy.FormatMoney(report.VAT)).
+		InlineAllFields().
+		SetFooter(fmt.Sprintf("Last %d days", days))
+
+	if len(lines) == 0 {
+		embed.SetDescription("No VAT was collected in this period.")
+	} else {
+		embed.SetDescription(strings.Join(lines, "\n"))
+	}
+
+	handlers.Respond(s, event, embed)
+}
@@ -0,0 +1,139 @@
+package bot
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/internal/handlers"
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+const maxListedAssessments = 15
+
+var periodChoices = []*discordgo.ApplicationCommandOptionChoice{
+	{Name: "Never", Value: database.PD_None},
+	{Name: "Daily", Value: database.PD_Daily},
+	{Name: "Weekly", Value: database.PD_Weekly},
+	{Name: "Monthly", Value: database.PD_Monthly},
+}
+
+var WealthTaxCommand = handlers.Command{
+	Name: "wealthtax",
+	Description: "Collect wealth tax in this economy",
+	Subcommands: []*handlers.Command{
+		{
+			Name: "preview",
+			Description: "Show what a wealth tax run would collect right now",
+			Callback: previewWealthTax,
+		},
+		{
+			Name: "run",
+			Description: "Collect wealth tax for the current period",
+			Callback: runWealthTax,
+		},
+		{
+			Name: "schedule",
+			Description: "Set how often wealth tax is collected automatically",
+			Callback: scheduleWealthTax,
+			Options: []*handlers.Option{
+				{Name: "period", Description: "How often to collect wealth tax", Type: 0, Required: true, Choices: periodChoices},
+			},
+		},
+	},
+}
+
+func assessmentsEmbed(economy database.Economy, title string, assessments []database.WealthTaxAssessment) *utils.Embed {
+	slices.SortFunc(assessments, func(a, b database.WealthTaxAssessment) int {
+		return cmp.Compare(b.Amount, a.Amount)
+	})
+
+	var total datatypes.Money
+	var lines []string
+	for i, assessment := range assessments {
+		total, _ = total.Add(assessment.Amount)
+		if i < maxListedAssessments {
+			lines = append(lines, fmt.Sprintf("%s: %s on %s", assessment.Account.AccountName, economy.FormatMoney(assessment.Amount), economy.FormatMoney(assessment.Basis)))
+		}
+	}
+
+	if len(assessments) > maxListedAssessments {
+		lines = append(lines, fmt.Sprintf("...and %d more", len(assessments) - maxListedAssessments))
+	}
+
+	embed := utils.NewEmbed().SetTitle(title).SetColor(handlers.Colors.Normal)
+	if len(lines) == 0 {
+		return embed.SetDescription("No account owes any wealth tax.")
+	}
+
+	return embed.SetDescription(strings.Join(lines, "\n")).
+		AddField("Accounts", fmt.Sprint(len(assessments))).
+		AddField("Total", economy.FormatMoney(total)).
+		InlineAllFields()
+}
+
+func previewWealthTax(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	assessments, err := ctx.Backend.PreviewWealthTax(economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, assessmentsEmbed(economy, "Wealth tax preview", assessments).SetFooter("Nothing has been collected."))
+}
+
+func runWealthTax(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	run, err := ctx.Backend.RunWealthTax(member, economy)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	handlers.Respond(s, event, assessmentsEmbed(economy, "Wealth tax collected", run.Assessments))
+}
+
+func scheduleWealthTax(ctx *handlers.Context, s *discordgo.Session, event *discordgo.InteractionCreate) {
+	member, err := ctx.Member(s, event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	economy, err := ctx.Economy(event)
+	if err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	period := uint8(ctx.Option("period").IntValue())
+	if err := ctx.Backend.SetWealthTaxPeriod(member, &economy, period); err != nil {
+		handlers.RespondError(ctx, s, event, err)
+		return
+	}
+
+	embed := utils.NewEmbed().SetTitle("Wealth tax").SetColor(handlers.Colors.Normal).
+		SetDescription(fmt.Sprintf("Wealth tax will now be collected %s.", database.PeriodName(period)))
+	handlers.Respond(s, event, embed)
+}
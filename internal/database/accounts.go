@@ -0,0 +1,68 @@
+package database
+
+import (
+	"errors"
+	"strings"
+)
+
+const maxSearchResults = 25
+
+func (self *Backend) GetAccountByID(id string) (Account, error) {
+	var account Account
+	result := self.db.Where("id = ?", id).Preload("Economy").First(&account)
+	if err := result.Error; err != nil {
+		return Account{}, err
+	}
+	return account, nil
+}
+
+// SearchAccounts returns the open accounts of an economy whose name contains the query, for autocompletion
+func (self *Backend) SearchAccounts(economy Economy, query string) ([]Account, error) {
+	var accounts []Account
+	pattern := "%" + strings.NewReplacer("%", "", "_", "").Replace(strings.ToLower(query)) + "%"
+	result := self.db.Where("economy_id = ? AND deleted = ? AND account_type <> ? AND account_type <> ?", economy.ID.String(), false, AT_Reserve, AT_Escrow).
+		Where("LOWER(account_name) LIKE ?", pattern).
+		Order("account_name").Limit(maxSearchResults).Find(&accounts)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// GetUserAccount returns the personal account a user owns in an economy.
+func (self *Backend) GetUserAccount(economy Economy, user_id string) (Account, error) {
+	var account Account
+	result := self.db.Where("economy_id = ? AND owner_id = ? AND account_type = ? AND deleted = ?", economy.ID.String(), user_id, AT_User, false).Preload("Economy").First(&account)
+	if err := result.Error; errors.Is(err, RecordNotFoundError) {
+		return Account{}, BackendError{Message: "You do not have an account in this economy."}
+	} else if err != nil {
+		return Account{}, err
+	}
+	return account, nil
+}
+
+// GetActingAccount returns the account the member acts from in an economy, which is the account they are
+// logged in as, or otherwise their personal account.
+func (self *Backend) GetActingAccount(member SessionedMember, economy Economy) (Account, *AccountSession, error) {
+	account_session, err := self.GetAccountSession(member, economy)
+	if err != nil {
+		return Account{}, nil, err
+	} else if account_session != nil {
+		return account_session.Account, account_session, nil
+	}
+
+	account, err := self.GetUserAccount(economy, member.User.ID)
+	return account, nil, err
+}
+
+// ViewAccount returns the current state of an account, which its owner and members with P_ViewBalance on it may see.
+func (self *Backend) ViewAccount(member SessionedMember, account Account) (Account, error) {
+	if account.OwnerID != member.User.ID {
+		if perm, err := self.HasPermission(member, P_ViewBalance, &account, nil); err != nil {
+			return Account{}, err
+		} else if !perm {
+			return Account{}, BackendError{Message: "You do not have the permission to view the balance of this account."}
+		}
+	}
+	return self.GetAccountByID(account.ID.String())
+}
@@ -0,0 +1,356 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+// MaxActivityCooldown is the longest cooldown between rewarded messages of a user.
+const MaxActivityCooldown = 24 * time.Hour
+
+// ActivityPayout is the outcome of paying out the activity rewards of an economy.
+// Error holds the reason nobody was paid, such as a shortfall of the source account; the rewards stay pending until the next payout.
+type ActivityPayout struct {
+	Reward 		ActivityReward
+	Paid 		int
+	Total 		datatypes.Money
+	Error 		error
+}
+
+// GetActivityReward returns the activity rewards of an economy, or nil if it has none.
+func (self *Backend) GetActivityReward(economy Economy) (*ActivityReward, error) {
+	var reward ActivityReward
+	err := self.db.Where("economy_id = ?", economy.ID.String()).Preload("FromAccount.Economy").First(&reward).Error
+	if errors.Is(err, RecordNotFoundError) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &reward, nil
+}
+
+// GetActivityChannels returns the IDs of the channels where activity earns rewards in an economy.
+func (self *Backend) GetActivityChannels(economy Economy) ([]string, error) {
+	var channels []string
+	err := self.db.Model(&ActivityChannel{}).Where("economy_id = ?", economy.ID.String()).Order("id").Pluck("channel_id", &channels).Error
+	return channels, err
+}
+
+// SetActivityReward sets up or changes the activity rewards of an economy, which are paid out of the reward's FromAccount.
+// The member has to be able to manage the economy as well as transfer funds from the account.
+func (self *Backend) SetActivityReward(member SessionedMember, economy Economy, reward *ActivityReward) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage the activity rewards of this economy."}
+	} else if perm, err := self.HasPermission(member, P_TransferFunds, &reward.FromAccount, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to transfer funds from this account."}
+	}
+
+	if reward.PerMessage.IsNegative() || reward.PerVoiceMinute.IsNegative() || reward.DailyCap.IsNegative() {
+		return BackendError{Message: "Activity rewards cannot be negative."}
+	} else if !reward.PerMessage.IsPositive() && !reward.PerVoiceMinute.IsPositive() {
+		return BackendError{Message: "Either messages or time in voice have to be rewarded."}
+	} else if reward.Cooldown < 0 || reward.Cooldown > MaxActivityCooldown {
+		return BackendError{Message: fmt.Sprintf("The cooldown between rewarded messages can be at most %d hours.", int(MaxActivityCooldown.Hours()))}
+	} else if reward.FromAccount.EconomyID != economy.ID.String() {
+		return BackendError{Message: "The account to pay from has to be in this economy."}
+	} else if reward.FromAccount.Deleted {
+		return BackendError{Message: "You cannot pay rewards from a closed account."}
+	}
+
+	reward.EconomyID = economy.ID.String()
+	reward.ActorID = member.User.ID
+	reward.FromAccountID = reward.FromAccount.ID
+
+	session := self.db.Begin()
+
+	if err := session.Omit("FromAccount").Save(reward).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "activity reward",
+		Target: economy.ID.String(),
+		Details: fmt.Sprintf("rewards %s per message and %s per minute in voice, up to %s a day, from %s", economy.FormatMoney(reward.PerMessage), economy.FormatMoney(reward.PerVoiceMinute), economy.FormatMoney(reward.DailyCap), reward.FromAccount.AccountName),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// RemoveActivityReward stops rewarding activity in an economy. Rewards that were earned but not paid out yet are forfeited.
+func (self *Backend) RemoveActivityReward(member SessionedMember, economy Economy) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage the activity rewards of this economy."}
+	}
+
+	session := self.db.Begin()
+
+	result := session.Where("economy_id = ?", economy.ID.String()).Delete(&ActivityReward{})
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return BackendError{Message: "This economy does not reward activity."}
+	}
+
+	if err := session.Where("economy_id = ?", economy.ID.String()).Delete(&ActivityCredit{}).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Delete,
+		Action: "activity reward",
+		Target: economy.ID.String(),
+		Details: "stopped rewarding activity",
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// SetActivityChannel adds a channel to or removes it from the channels where activity earns rewards.
+func (self *Backend) SetActivityChannel(member SessionedMember, economy Economy, channel_id string, enabled bool) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage the activity rewards of this economy."}
+	}
+
+	session := self.db.Begin()
+
+	var err error
+	details := fmt.Sprintf("rewards activity in <#%s>", channel_id)
+	if enabled {
+		err = session.Where(ActivityChannel{EconomyID: economy.ID.String(), ChannelID: channel_id}).FirstOrCreate(&ActivityChannel{}).Error
+	} else {
+		details = fmt.Sprintf("no longer rewards activity in <#%s>", channel_id)
+		err = session.Where("economy_id = ? AND channel_id = ?", economy.ID.String(), channel_id).Delete(&ActivityChannel{}).Error
+	}
+
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err = self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "activity reward",
+		Target: channel_id,
+		Details: details,
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// activityRewardIn returns the activity rewards of the economy a guild is registered to if the channel earns them, or nil otherwise.
+func (self *Backend) activityRewardIn(guild_id string, channel_id string) (*ActivityReward, error) {
+	var reward ActivityReward
+	err := self.db.Joins("JOIN guilds ON guilds.economy_id = activity_rewards.economy_id").
+		Joins("JOIN activity_channels ON activity_channels.economy_id = activity_rewards.economy_id").
+		Where("guilds.guild_id = ? AND activity_channels.channel_id = ?", guild_id, channel_id).
+		Preload("FromAccount.Economy").First(&reward).Error
+	if errors.Is(err, RecordNotFoundError) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &reward, nil
+}
+
+// creditActivity adds an amount to what a user earned today in the economy's time zone, up to the daily cap of the reward.
+// Messages are only credited once the cooldown since the user's last rewarded message is over. Users without an account in the economy earn nothing.
+func (self *Backend) creditActivity(reward ActivityReward, user_id string, amount datatypes.Money, message bool, now time.Time) error {
+	now = now.UTC()
+	if !amount.IsPositive() {
+		return nil
+	}
+
+	var accounts int64
+	err := self.db.Model(&Account{}).Where("economy_id = ? AND owner_id = ? AND account_type = ? AND deleted = ?", reward.EconomyID, user_id, AT_User, false).Count(&accounts).Error
+	if err != nil || accounts == 0 {
+		return err
+	}
+
+	credit := ActivityCredit{EconomyID: reward.EconomyID, UserID: user_id, Day: PeriodStart(now, PD_Daily, reward.FromAccount.Economy.Location()).UTC()}
+	if err := self.db.Where(credit).FirstOrCreate(&credit).Error; err != nil {
+		return err
+	}
+
+	if message && credit.LastMessageAt != nil && now.Sub(*credit.LastMessageAt) < reward.Cooldown {
+		return nil
+	}
+
+	if reward.DailyCap.IsPositive() && credit.Earned >= reward.DailyCap && !message {
+		return nil
+	}
+
+	// the cap and the cooldown are applied by the update itself, so a burst of credits cannot earn past the cap
+	// or more than once per cooldown, and none of them is lost to another that got in first
+	earned := gorm.Expr("?", amount)
+	if reward.DailyCap.IsPositive() {
+		earned = gorm.Expr("CASE WHEN earned >= ? THEN 0 WHEN earned + ? > ? THEN ? - earned ELSE ? END", reward.DailyCap, amount, reward.DailyCap, reward.DailyCap, amount)
+	}
+
+	stmt := self.db.Model(&ActivityCredit{}).Where("id = ?", credit.ID)
+	updates := map[string]any{
+		"earned": gorm.Expr("earned + ?", earned),
+		"pending": gorm.Expr("pending + ?", earned),
+	}
+	if message {
+		stmt = stmt.Where("last_message_at IS NULL OR last_message_at <= ?", now.Add(-reward.Cooldown))
+		updates["last_message_at"] = now
+	}
+
+	return stmt.Updates(updates).Error
+}
+
+// RecordMessageActivity rewards a user for a message sent in a channel of a guild, if the channel earns rewards.
+func (self *Backend) RecordMessageActivity(guild_id string, channel_id string, user_id string, now time.Time) error {
+	reward, err := self.activityRewardIn(guild_id, channel_id)
+	if err != nil || reward == nil {
+		return err
+	}
+	return self.creditActivity(*reward, user_id, reward.PerMessage, true, now)
+}
+
+// RecordVoiceActivity rewards a user for minutes spent in a voice channel of a guild, if the channel earns rewards.
+func (self *Backend) RecordVoiceActivity(guild_id string, channel_id string, user_id string, minutes int, now time.Time) error {
+	reward, err := self.activityRewardIn(guild_id, channel_id)
+	if err != nil || reward == nil || minutes <= 0 {
+		return err
+	}
+
+	amount, err := reward.PerVoiceMinute.Mul(int64(minutes))
+	if err != nil {
+		return err
+	}
+	return self.creditActivity(*reward, user_id, amount, false, now)
+}
+
+// payActivityReward pays every user the rewards they have pending in an economy, with one transfer each.
+func (self *Backend) payActivityReward(reward ActivityReward, now time.Time) (ActivityPayout, error) {
+	payout := ActivityPayout{Reward: reward}
+	session := self.db.Begin()
+
+	var credits []ActivityCredit
+	if err := session.Where("economy_id = ? AND pending > 0", reward.EconomyID).Order("user_id").Find(&credits).Error; err != nil {
+		session.Rollback()
+		return payout, err
+	}
+
+	pending := map[string]datatypes.Money{}
+	var users []string
+	for _, credit := range credits {
+		if _, ok := pending[credit.UserID]; !ok {
+			users = append(users, credit.UserID)
+		}
+		pending[credit.UserID] += credit.Pending
+	}
+
+	var from Account
+	if err := session.Where("id = ?", reward.FromAccountID).First(&from).Error; err != nil {
+		session.Rollback()
+		return payout, err
+	}
+
+	for _, user_id := range users {
+		var to Account
+		err := session.Where("economy_id = ? AND owner_id = ? AND account_type = ? AND deleted = ?", reward.EconomyID, user_id, AT_User, false).First(&to).Error
+		if errors.Is(err, RecordNotFoundError) || (err == nil && to.ID.Equals(from.ID)) {
+			// users who closed their account since earning the reward, or own the source account, forfeit it
+			continue
+		} else if err != nil {
+			session.Rollback()
+			return payout, err
+		}
+
+		if _, err := self.transferTx(session, reward.ActorID, nil, &from, &to, pending[user_id], TT_Income); err != nil {
+			session.Rollback()
+
+			var backend_err BackendError
+			if !errors.As(err, &backend_err) {
+				return payout, err
+			}
+
+			payout.Paid, payout.Total, payout.Error = 0, 0, backend_err
+			if errors.Is(err, InsufficientFundsError) {
+				var total datatypes.Money
+				for _, amount := range pending {
+					total += amount
+				}
+				payout.Error = BackendError{Message: fmt.Sprintf("%s needs %s to pay out the activity rewards, but only has %s; they stay pending until it can.", from.AccountName, reward.FromAccount.Economy.FormatMoney(total), reward.FromAccount.Economy.FormatMoney(from.Balance))}
+			}
+			return payout, nil
+		}
+
+		payout.Paid++
+		payout.Total += pending[user_id]
+	}
+
+	// rewards earned while paying out stay pending for the next payout
+	for _, credit := range credits {
+		if err := session.Model(&credit).Update("pending", gorm.Expr("pending - ?", credit.Pending)).Error; err != nil {
+			session.Rollback()
+			return payout, err
+		}
+	}
+
+	// only today's credits are needed to enforce the cooldown and cap
+	if err := session.Where("economy_id = ? AND day < ? AND pending = 0", reward.EconomyID, PeriodStart(now, PD_Daily, reward.FromAccount.Economy.Location()).UTC()).Delete(&ActivityCredit{}).Error; err != nil {
+		session.Rollback()
+		return payout, err
+	}
+
+	return payout, session.Commit().Error
+}
+
+// PayActivityRewards pays out the pending activity rewards of every unlocked economy, batched into a single transfer per user,
+// and returns the outcome for each economy that had anything to pay.
+func (self *Backend) PayActivityRewards(now time.Time) ([]ActivityPayout, error) {
+	var rewards []ActivityReward
+	if err := self.db.Where("economy_id NOT IN (?)", self.lockedEconomies()).Preload("FromAccount.Economy").Find(&rewards).Error; err != nil {
+		return nil, err
+	}
+
+	var payouts []ActivityPayout
+	for _, reward := range rewards {
+		payout, err := self.payActivityReward(reward, now)
+		if err != nil {
+			return payouts, err
+		} else if payout.Paid > 0 || payout.Error != nil {
+			payouts = append(payouts, payout)
+		}
+	}
+
+	return payouts, nil
+}
@@ -0,0 +1,437 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+// MaxProposalExpiry is the longest a transfer proposal can wait for approvals.
+const MaxProposalExpiry = 7 * 24 * time.Hour
+
+var ApprovalRequiredError = BackendError{Message: "Transfers of this size from this account have to be approved by its members first."}
+
+// GetApprovalPolicy returns the approval policy of an account, or nil if it has none.
+func (self *Backend) GetApprovalPolicy(account Account) (*ApprovalPolicy, error) {
+	var policy ApprovalPolicy
+	err := self.db.Where("account_id = ?", account.ID).First(&policy).Error
+	if errors.Is(err, RecordNotFoundError) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// approvalPolicyTx returns the approval policy of an account if a transfer of the given amount from it needs approval.
+func (self *Backend) approvalPolicyTx(session *gorm.DB, account Account, amount datatypes.Money) (*ApprovalPolicy, error) {
+	var policy ApprovalPolicy
+	err := session.Where("account_id = ? AND threshold < ?", account.ID, amount).First(&policy).Error
+	if errors.Is(err, RecordNotFoundError) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// SetApprovalPolicy requires transfers above the policy's threshold from a government or corporate account
+// to be approved by the given number of people, which only admins with P_ManageFunds on the account can set up.
+func (self *Backend) SetApprovalPolicy(member SessionedMember, account Account, policy *ApprovalPolicy) error {
+	if perm, err := self.HasPermission(member, P_ManageFunds, &account, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage the spending controls of this account."}
+	} else if account.AccountType != AT_Government && account.AccountType != AT_Corporation {
+		return BackendError{Message: "Only government and corporate accounts can require approvals."}
+	} else if policy.Threshold.IsNegative() {
+		return BackendError{Message: "The approval threshold cannot be negative."}
+	} else if policy.Required < 2 {
+		return BackendError{Message: "A transfer has to need at least two approvals, including the one of whoever proposes it."}
+	} else if policy.Expiry < time.Hour || policy.Expiry > MaxProposalExpiry {
+		return BackendError{Message: fmt.Sprintf("Proposals have to expire between an hour and %d days after they are made.", int(MaxProposalExpiry.Hours()/24))}
+	}
+
+	policy.AccountID = account.ID
+	session := self.db.Begin()
+
+	if err := session.Omit("Account").Save(policy).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	economy, err := self.economyTx(session, account.EconomyID)
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err = self.auditTx(session, AuditEntry{
+		EconomyID: account.EconomyID,
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "approval policy",
+		Target: account.ID.String(),
+		Details: fmt.Sprintf("transfers from %s above %s need %d approvals", account.AccountName, economy.FormatMoney(policy.Threshold), policy.Required),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// RemoveApprovalPolicy lets transfers from an account go through without approvals again.
+// Proposals that are already pending still need their approvals.
+func (self *Backend) RemoveApprovalPolicy(member SessionedMember, account Account) error {
+	if perm, err := self.HasPermission(member, P_ManageFunds, &account, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage the spending controls of this account."}
+	}
+
+	session := self.db.Begin()
+
+	result := session.Where("account_id = ?", account.ID).Delete(&ApprovalPolicy{})
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return BackendError{Message: "This account does not require approvals."}
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: account.EconomyID,
+		ActorID: member.User.ID,
+		Change: CUD_Delete,
+		Action: "approval policy",
+		Target: account.ID.String(),
+		Details: fmt.Sprintf("transfers from %s no longer need approval", account.AccountName),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+func (self *Backend) GetProposalByID(id uint) (TransferProposal, error) {
+	var proposal TransferProposal
+	result := self.db.Where("id = ?", id).Preload("FromAccount.Economy").Preload("ToAccount").Preload("Approvals").First(&proposal)
+	if err := result.Error; err != nil {
+		return TransferProposal{}, err
+	}
+	return proposal, nil
+}
+
+// GetPendingProposals returns the proposals waiting for approval on an account, oldest first.
+func (self *Backend) GetPendingProposals(member SessionedMember, account Account) ([]TransferProposal, error) {
+	if perm, err := self.HasPermission(member, P_TransferFunds, &account, nil); err != nil {
+		return nil, err
+	} else if !perm {
+		return nil, BackendError{Message: "You do not have the permission to view the proposals of this account."}
+	}
+
+	var proposals []TransferProposal
+	result := self.db.Where("from_account_id = ? AND status = ?", account.ID, PS_Pending).
+		Preload("FromAccount.Economy").Preload("ToAccount").Preload("Approvals").Order("id").Find(&proposals)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	return proposals, nil
+}
+
+// ProposeTransfer creates a proposal for a transfer that needs approval and holds the funds it would debit,
+// including any VAT added on top, until it is executed or dropped. Proposing a transfer counts as the first approval.
+func (self *Backend) ProposeTransfer(member SessionedMember, from *Account, to *Account, amount datatypes.Money, transfer_type uint8) (*TransferProposal, error) {
+	if perm, err := self.HasPermission(member, P_TransferFunds, from, nil); err != nil {
+		return nil, err
+	} else if !perm {
+		return nil, BackendError{Message: "You do not have the permission to transfer funds from this account."}
+	} else if !amount.IsPositive() {
+		return nil, BackendError{Message: "The transfer amount must be positive."}
+	} else if from.ID.Equals(to.ID) {
+		return nil, BackendError{Message: "You cannot transfer funds to the same account."}
+	} else if from.EconomyID != to.EconomyID {
+		return nil, BackendError{Message: "You cannot transfer funds between different economies."}
+	} else if from.Deleted || to.Deleted {
+		return nil, BackendError{Message: "You cannot transfer funds to or from a closed account."}
+	}
+
+	policy, err := self.approvalPolicyTx(self.db, *from, amount)
+	if err != nil {
+		return nil, err
+	} else if policy == nil {
+		return nil, BackendError{Message: "This transfer does not need approval."}
+	}
+
+	session := self.db.Begin()
+
+	economy, err := self.economyTx(session, from.EconomyID)
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	} else if err := self.checkTransferableTx(session, economy, from, to); err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	// taxes are settled when the proposal executes, so the estimate must not leave anything behind, such as taxable income
+	session.SavePoint("estimate")
+	debit, _, _, err := self.transferTaxesTx(session, economy, from, to, amount, transfer_type)
+	session.RollbackTo("estimate")
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	held, err := debit.Neg()
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	} else if err := self.checkSpendingLimitTx(session, member.User.ID, from, to, held); err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	on_behalf_of, err := self.actingAs(session, member, *from)
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	proposal := TransferProposal{
+		EconomyID: from.EconomyID,
+		ProposerID: member.User.ID,
+		OnBehalfOfID: on_behalf_of,
+		FromAccountID: from.ID,
+		ToAccountID: to.ID,
+		Amount: amount,
+		TransferType: transfer_type,
+		Held: held,
+		Required: policy.Required,
+		Status: PS_Pending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(policy.Expiry),
+		ChannelID: policy.ChannelID,
+		Approvals: []ProposalApproval{{UserID: member.User.ID, CreatedAt: now}},
+	}
+
+	if err := session.Omit("FromAccount", "ToAccount").Create(&proposal).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	if err := self.holdFundsTx(session, member.User.ID, *from, held, fmt.Sprintf("Transfer proposal #%d", proposal.ID)); err != nil {
+		session.Rollback()
+		if errors.Is(err, InsufficientFundsError) {
+			return nil, BackendError{Message: "The account does not have enough funds to hold for this transfer."}
+		}
+		return nil, err
+	}
+
+	proposal.FromAccount, proposal.ToAccount = *from, *to
+	proposal.FromAccount.Economy = economy
+	return &proposal, session.Commit().Error
+}
+
+// setProposalStatusTx moves a pending proposal to another status, failing if it has been settled in the meantime.
+func (self *Backend) setProposalStatusTx(session *gorm.DB, proposal *TransferProposal, updates map[string]any) error {
+	result := session.Model(&TransferProposal{}).Where("id = ? AND status = ?", proposal.ID, PS_Pending).Updates(updates)
+	if err := result.Error; err != nil {
+		return err
+	} else if result.RowsAffected == 0 {
+		return BackendError{Message: "This proposal has already been settled."}
+	}
+	return nil
+}
+
+// executeProposalTx releases the funds held for a proposal and makes its transfer. If the transfer cannot be made,
+// for example because the taxes on it changed, the proposal fails instead and its funds stay released.
+func (self *Backend) executeProposalTx(session *gorm.DB, proposal *TransferProposal) error {
+	memo := fmt.Sprintf("Transfer proposal #%d", proposal.ID)
+	if err := self.releaseFundsTx(session, proposal.ProposerID, proposal.FromAccount, proposal.Held, memo); err != nil {
+		return err
+	}
+
+	session.SavePoint("execute")
+	transfer, err := self.transferTx(session, proposal.ProposerID, proposal.OnBehalfOfID, &proposal.FromAccount, &proposal.ToAccount, proposal.Amount, proposal.TransferType)
+
+	// a locked economy only pauses the proposal, which can be approved again once it is unlocked
+	var backend_err BackendError
+	if errors.Is(err, EconomyLockedError) {
+		return err
+	} else if errors.As(err, &backend_err) {
+		session.RollbackTo("execute")
+		proposal.Status, proposal.Error = PS_Failed, backend_err.Message
+		return self.setProposalStatusTx(session, proposal, map[string]any{"status": PS_Failed, "error": backend_err.Message})
+	} else if err != nil {
+		return err
+	}
+
+	proposal.Status, proposal.TransferID = PS_Executed, &transfer.TrxID
+	return self.setProposalStatusTx(session, proposal, map[string]any{"status": PS_Executed, "transfer_id": transfer.TrxID})
+}
+
+// ApproveProposal adds the member's approval to a pending proposal and executes it once enough people have approved.
+// Anyone who may transfer funds from the account can approve, but only once.
+func (self *Backend) ApproveProposal(member SessionedMember, id uint) (*TransferProposal, error) {
+	proposal, err := self.GetProposalByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if perm, err := self.HasPermission(member, P_TransferFunds, &proposal.FromAccount, nil); err != nil {
+		return nil, err
+	} else if !perm {
+		return nil, BackendError{Message: "You do not have the permission to approve transfers from this account."}
+	}
+
+	session := self.db.Begin()
+
+	// touching the proposal locks its row until the transaction ends, so approvals made at the same time are counted
+	// one after another and the one that reaches the quorum sees every approval before it
+	result := session.Model(&TransferProposal{}).Where("id = ? AND status = ? AND expires_at > ?", proposal.ID, PS_Pending, time.Now().UTC()).
+		Update("status", PS_Pending)
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return nil, err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return nil, BackendError{Message: "This proposal is no longer pending."}
+	}
+
+	var approved int64
+	if err := session.Model(&ProposalApproval{}).Where("proposal_id = ? AND user_id = ?", proposal.ID, member.User.ID).Count(&approved).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	} else if approved > 0 {
+		session.Rollback()
+		return nil, BackendError{Message: "You have already approved this proposal."}
+	}
+
+	approval := ProposalApproval{ProposalID: proposal.ID, UserID: member.User.ID}
+	if err := session.Create(&approval).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	proposal.Approvals = nil
+	if err := session.Where("proposal_id = ?", proposal.ID).Order("id").Find(&proposal.Approvals).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	err = self.auditTx(session, AuditEntry{
+		EconomyID: proposal.EconomyID,
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "transfer proposal",
+		Target: fmt.Sprint(proposal.ID),
+		Details: fmt.Sprintf("approved %s from %s to %s", proposal.FromAccount.Economy.FormatMoney(proposal.Amount), proposal.FromAccount.AccountName, proposal.ToAccount.AccountName),
+	})
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	if uint(len(proposal.Approvals)) >= proposal.Required {
+		if err := self.executeProposalTx(session, &proposal); err != nil {
+			session.Rollback()
+			return nil, err
+		}
+	}
+
+	return &proposal, session.Commit().Error
+}
+
+// RejectProposal drops a pending proposal and releases its funds. Any one person who may approve it can reject it.
+func (self *Backend) RejectProposal(member SessionedMember, id uint) (*TransferProposal, error) {
+	proposal, err := self.GetProposalByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if perm, err := self.HasPermission(member, P_TransferFunds, &proposal.FromAccount, nil); err != nil {
+		return nil, err
+	} else if !perm {
+		return nil, BackendError{Message: "You do not have the permission to reject transfers from this account."}
+	} else if proposal.Status != PS_Pending {
+		return nil, BackendError{Message: "This proposal is no longer pending."}
+	}
+
+	session := self.db.Begin()
+
+	if err := self.setProposalStatusTx(session, &proposal, map[string]any{"status": PS_Rejected}); err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	if err := self.releaseFundsTx(session, member.User.ID, proposal.FromAccount, proposal.Held, fmt.Sprintf("Transfer proposal #%d", proposal.ID)); err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	err = self.auditTx(session, AuditEntry{
+		EconomyID: proposal.EconomyID,
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "transfer proposal",
+		Target: fmt.Sprint(proposal.ID),
+		Details: fmt.Sprintf("rejected %s from %s to %s", proposal.FromAccount.Economy.FormatMoney(proposal.Amount), proposal.FromAccount.AccountName, proposal.ToAccount.AccountName),
+	})
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	proposal.Status = PS_Rejected
+	return &proposal, session.Commit().Error
+}
+
+// SetProposalMessage remembers the message a proposal was posted in, so that it can be updated once the proposal expires.
+func (self *Backend) SetProposalMessage(proposal *TransferProposal, channel_id string, message_id string) error {
+	proposal.ChannelID, proposal.MessageID = channel_id, message_id
+	return self.db.Model(&TransferProposal{}).Where("id = ?", proposal.ID).Updates(map[string]any{"channel_id": channel_id, "message_id": message_id}).Error
+}
+
+// ExpireProposals drops every pending proposal whose deadline has passed and releases its funds.
+func (self *Backend) ExpireProposals(now time.Time) ([]TransferProposal, error) {
+	var proposals []TransferProposal
+	result := self.db.Where("status = ? AND expires_at <= ?", PS_Pending, now.UTC()).
+		Preload("FromAccount.Economy").Preload("ToAccount").Preload("Approvals").Order("id").Find(&proposals)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	var expired []TransferProposal
+	for _, proposal := range proposals {
+		session := self.db.Begin()
+
+		if err := self.setProposalStatusTx(session, &proposal, map[string]any{"status": PS_Expired}); err != nil {
+			session.Rollback()
+			continue
+		}
+
+		if err := self.releaseFundsTx(session, "", proposal.FromAccount, proposal.Held, fmt.Sprintf("Transfer proposal #%d", proposal.ID)); err != nil {
+			session.Rollback()
+			return expired, err
+		}
+
+		if err := session.Commit().Error; err != nil {
+			return expired, err
+		}
+
+		proposal.Status = PS_Expired
+		expired = append(expired, proposal)
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+const maxAuditEntries = 100
+
+// auditTx records an entry in the audit log of an economy as part of the given transaction.
+func (self *Backend) auditTx(session *gorm.DB, entry AuditEntry) error {
+	return session.Create(&entry).Error
+}
+
+// auditActingTx records an action in the audit log if the member took it while logged in as the account,
+// and returns the account's ID in that case.
+func (self *Backend) auditActingTx(session *gorm.DB, member SessionedMember, account Account, change uint8, action string, target string, details string) (*datatypes.UUID, error) {
+	on_behalf_of, err := self.actingAs(session, member, account)
+	if err != nil || on_behalf_of == nil {
+		return nil, err
+	}
+
+	return on_behalf_of, self.auditTx(session, AuditEntry{
+		EconomyID: account.EconomyID,
+		ActorID: member.User.ID,
+		OnBehalfOfID: on_behalf_of,
+		Change: change,
+		Action: action,
+		Target: target,
+		Details: details,
+	})
+}
+
+// GetAuditLog returns the most recent entries of an economy's audit log, optionally only those of a single actor.
+func (self *Backend) GetAuditLog(member SessionedMember, economy Economy, actor_id string, limit int) ([]AuditEntry, error) {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return nil, err
+	} else if !perm {
+		return nil, BackendError{Message: "You do not have the permission to view the audit log of this economy."}
+	}
+
+	stmt := self.db.Where("economy_id = ?", economy.ID.String())
+	if actor_id != "" {
+		stmt = stmt.Where("actor_id = ?", actor_id)
+	}
+
+	var entries []AuditEntry
+	result := stmt.Order("id DESC").Limit(min(max(limit, 1), maxAuditEntries)).Find(&entries)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
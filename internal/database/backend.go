@@ -1,7 +1,13 @@
 package database
 
 import (
+	"crypto/ed25519"
+	"fmt"
+	"slices"
+	"time"
+
 	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
 	"gorm.io/gorm"
 )
 
@@ -9,6 +15,7 @@ var RecordNotFoundError error = gorm.ErrRecordNotFound
 
 type Backend struct {
 	db *gorm.DB
+	signer ed25519.PrivateKey
 }
 
 type SessionedMember struct {
@@ -31,6 +38,11 @@ func NewBackend(database *gorm.DB) *Backend {
 	}
 }
 
+// OwnerPermissions are the account permissions that spend or reveal the funds of an account. Economy-wide and global
+// grants of them only reach the accounts a member owns, while other accounts need an entry of their own.
+var OwnerPermissions = []uint8{P_ViewBalance, P_CloseAccount, P_TransferFunds, P_CreateRecurringTransfer, P_LoginAsAccount}
+
+// EvaluatePrecedence ranks how specific a permission entry is, from global entries up to entries on a single account.
 func EvaluatePrecedence(permission UserPermission) uint8 {
 	if permission.AccountID == nil && permission.EconomyID == nil {
 		return 1
@@ -55,18 +67,37 @@ func (self *Backend) GetPermissions(member discordgo.Member) ([]UserPermission,
 	return permissions, nil
 }
 
+// HasPermission reports whether the member holds a permission, either granted to them or one of their roles,
+// or delegated to them on the account they are logged in as.
 func (self *Backend) HasPermission(member SessionedMember, permission uint8, account *Account, economy *Economy) (bool, error) {
+	if perm, err := self.grantedPermission(member, permission, account, economy); err != nil || perm {
+		return perm, err
+	}
+	return self.delegatedPermission(member, permission, account)
+}
+
+func (self *Backend) grantedPermission(member SessionedMember, permission uint8, account *Account, economy *Economy) (bool, error) {
 	var permissions []UserPermission
 	var err error
 
 	stmt := self.db.Where("permission_id = ?", permission).Where("user_id IN ?", append(member.Roles, member.User.ID))
 
+	// broader grants apply to everything beneath them, except for OwnerPermissions on accounts the member does not own,
+	// so a guild-wide grant to transfer funds does not open up government accounts or everyone else's
 	if account != nil {
-		stmt = stmt.Where("account_id = ?", account.ID)
+		if account.OwnerID == member.User.ID || !slices.Contains(OwnerPermissions, permission) {
+			stmt = stmt.Where("account_id = ? OR (account_id IS NULL AND (economy_id = ? OR economy_id IS NULL))", account.ID, account.EconomyID)
+		} else {
+			stmt = stmt.Where("account_id = ?", account.ID)
+		}
+	} else {
+		stmt = stmt.Where("account_id IS NULL")
 	}
 
 	if economy != nil {
-		stmt = stmt.Where("economy_id = ?", account.ID)
+		stmt = stmt.Where("economy_id = ? OR economy_id IS NULL", economy.ID)
+	} else if account == nil {
+		stmt = stmt.Where("economy_id IS NULL")
 	}
 
 	err = stmt.Find(&permissions).Error
@@ -76,9 +107,10 @@ func (self *Backend) HasPermission(member SessionedMember, permission uint8, acc
 		return false, nil
 	}
 
+	// the most specific entry wins, so an account entry can narrow or override an economy-wide or global grant
 	best := permissions[0]
 	for _, perm := range permissions {
-		if EvaluatePrecedence(best) > EvaluatePrecedence(perm) {
+		if EvaluatePrecedence(perm) > EvaluatePrecedence(best) {
 			best = perm
 			continue
 		} else if EvaluatePrecedence(best) == EvaluatePrecedence(perm) {
@@ -233,3 +265,52 @@ func (self *Backend) DeleteEconomy(member SessionedMember, economy *Economy) err
 
 	return session.Commit().Error
 }
+
+func (self *Backend) SetCurrencyDecimals(member SessionedMember, economy *Economy, decimals uint8) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage this economy."}
+	}
+
+	if decimals > datatypes.MaxMoneyDecimals {
+		return BackendError{Message: fmt.Sprintf("A currency can have at most %d decimal places.", datatypes.MaxMoneyDecimals)}
+	}
+
+	// amounts are stored in minor units, so changing the precision afterwards would silently rescale every balance
+	var accounts int64
+	if err := self.db.Model(&Account{}).Where("economy_id = ?", economy.ID.String()).Count(&accounts).Error; err != nil {
+		return err
+	} else if accounts > 0 {
+		return BackendError{Message: "The decimal places of a currency cannot be changed once accounts have been opened."}
+	}
+
+	// the update goes through a blank model, as gorm would otherwise set the field on economy even if the update fails
+	if err := self.db.Model(&Economy{}).Where("id = ?", economy.ID).Update("decimals", decimals).Error; err != nil {
+		return err
+	}
+
+	economy.Decimals = decimals
+	return nil
+}
+
+// SetTimeZone sets the time zone of an economy by its IANA name, such as "Europe/London".
+func (self *Backend) SetTimeZone(member SessionedMember, economy *Economy, name string) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage this economy."}
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil || name == "" || name == "Local" {
+		return BackendError{Message: "Unknown time zone, please use a name such as \"Europe/London\" or \"America/New_York\"."}
+	}
+
+	if err := self.db.Model(&Economy{}).Where("id = ?", economy.ID).Update("time_zone", location.String()).Error; err != nil {
+		return err
+	}
+
+	economy.TimeZone = location.String()
+	return nil
+}
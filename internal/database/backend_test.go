@@ -0,0 +1,150 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestBackend opens an empty in-memory database with every model migrated.
+func newTestBackend(t *testing.T) *Backend {
+	t.Helper()
+
+	db, err := gorm.Open(Drivers.Sqlite("file::memory:"), &gorm.Config{SkipDefaultTransaction: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every connection to an in-memory database opens a database of its own
+	sql_db, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql_db.SetMaxOpenConns(1)
+	t.Cleanup(func() { sql_db.Close() })
+
+	if err := db.AutoMigrate(Models...); err != nil {
+		t.Fatal(err)
+	}
+	return NewBackend(db)
+}
+
+func testMember(user_id string, roles ...string) SessionedMember {
+	return SessionedMember{Member: discordgo.Member{User: &discordgo.User{ID: user_id}, GuildID: "guild", Roles: roles}}
+}
+
+func newTestEconomy(t *testing.T, backend *Backend, name string) Economy {
+	t.Helper()
+
+	economy := Economy{ID: datatypes.NewUUIDv4(), Name: name, CurrencyName: name, CurrencyUnit: "$", Decimals: 2}
+	if err := backend.db.Create(&economy).Error; err != nil {
+		t.Fatal(err)
+	}
+	return economy
+}
+
+func newTestAccount(t *testing.T, backend *Backend, economy Economy, name string, owner_id string, account_type uint8) Account {
+	t.Helper()
+
+	account := Account{ID: datatypes.NewUUIDv4(), AccountName: name, AccountType: account_type, OwnerID: owner_id, EconomyID: economy.ID.String()}
+	if err := backend.db.Omit("Economy").Create(&account).Error; err != nil {
+		t.Fatal(err)
+	}
+	account.Economy = economy
+	return account
+}
+
+// grantPermission stores a permission entry for a user or role, on an account, an economy, or globally if both are nil.
+func grantPermission(t *testing.T, backend *Backend, user_id string, permission uint8, account *Account, economy *Economy, value bool) {
+	t.Helper()
+
+	entry := UserPermission{EntryID: datatypes.NewUUIDv4().String(), UserID: user_id, PermissionID: permission, Value: value}
+	if account != nil {
+		entry.AccountID = &account.ID
+	}
+	if economy != nil {
+		entry.EconomyID = &economy.ID
+	}
+
+	if err := backend.db.Omit("Account", "Economy").Create(&entry).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	type grant struct {
+		user_id 	string
+		permission 	uint8
+		scope 		string
+		value 		bool
+	}
+
+	tests := []struct {
+		name 		string
+		grants 		[]grant
+		permission 	uint8
+		scope 		string
+		want 		bool
+	}{
+		{"no entries", nil, P_ManageFunds, "economy", false},
+		{"global grant reaches an economy", []grant{{"bob", P_ManageEconomies, "global", true}}, P_ManageEconomies, "economy", true},
+		{"global grant reaches an account", []grant{{"bob", P_ManageFunds, "global", true}}, P_ManageFunds, "treasury", true},
+		{"economy grant reaches its accounts", []grant{{"bob", P_ManageFunds, "economy", true}}, P_ManageFunds, "treasury", true},
+		{"economy grant does not reach another economy", []grant{{"bob", P_ManageFunds, "other", true}}, P_ManageFunds, "economy", false},
+		{"economy grant does not reach another economy's accounts", []grant{{"bob", P_ManageFunds, "other", true}}, P_ManageFunds, "treasury", false},
+		{"economy grant does not reach global checks", []grant{{"bob", P_ManageEconomies, "economy", true}}, P_ManageEconomies, "global", false},
+		{"account grant does not reach its economy", []grant{{"bob", P_ManageFunds, "treasury", true}}, P_ManageFunds, "economy", false},
+		{"account grant does not reach other accounts", []grant{{"bob", P_TransferFunds, "treasury", true}}, P_TransferFunds, "own", false},
+		{"economy grant of an owner permission reaches an owned account", []grant{{"bob", P_TransferFunds, "economy", true}}, P_TransferFunds, "own", true},
+		{"economy grant of an owner permission does not reach other accounts", []grant{{"bob", P_TransferFunds, "economy", true}}, P_TransferFunds, "treasury", false},
+		{"global grant of an owner permission does not reach other accounts", []grant{{"bob", P_ViewBalance, "global", true}}, P_ViewBalance, "treasury", false},
+		{"account grant of an owner permission", []grant{{"bob", P_TransferFunds, "treasury", true}}, P_TransferFunds, "treasury", true},
+		{"economy denial overrides a global grant", []grant{{"bob", P_ManageFunds, "global", true}, {"bob", P_ManageFunds, "economy", false}}, P_ManageFunds, "economy", false},
+		{"economy grant overrides a global denial", []grant{{"bob", P_ManageFunds, "global", false}, {"bob", P_ManageFunds, "economy", true}}, P_ManageFunds, "economy", true},
+		{"account denial overrides an economy grant", []grant{{"bob", P_ManageFunds, "economy", true}, {"bob", P_ManageFunds, "treasury", false}}, P_ManageFunds, "treasury", false},
+		{"account grant overrides an economy denial", []grant{{"bob", P_TransferFunds, "economy", false}, {"bob", P_TransferFunds, "own", true}}, P_TransferFunds, "own", true},
+		{"account denial overrides a global grant", []grant{{"bob", P_TransferFunds, "global", true}, {"bob", P_TransferFunds, "own", false}}, P_TransferFunds, "own", false},
+		{"role grant", []grant{{"staff", P_ManageFunds, "economy", true}}, P_ManageFunds, "economy", true},
+		{"user entry wins over a role entry of the same scope", []grant{{"staff", P_ManageFunds, "economy", true}, {"bob", P_ManageFunds, "economy", false}}, P_ManageFunds, "economy", false},
+		{"role entry on an account wins over a user entry on the economy", []grant{{"bob", P_ManageFunds, "economy", true}, {"staff", P_ManageFunds, "treasury", false}}, P_ManageFunds, "treasury", false},
+		{"entries of other users are ignored", []grant{{"carol", P_ManageFunds, "economy", true}}, P_ManageFunds, "economy", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := newTestBackend(t)
+			economy := newTestEconomy(t, backend, "economy")
+			other := newTestEconomy(t, backend, "other")
+			treasury := newTestAccount(t, backend, economy, "treasury", "", AT_Government)
+			own := newTestAccount(t, backend, economy, "own", "bob", AT_User)
+
+			scope := func(name string) (*Account, *Economy) {
+				switch name {
+				case "economy":
+					return nil, &economy
+				case "other":
+					return nil, &other
+				case "treasury":
+					return &treasury, nil
+				case "own":
+					return &own, nil
+				}
+				return nil, nil
+			}
+
+			for _, grant := range test.grants {
+				on_account, on_economy := scope(grant.scope)
+				grantPermission(t, backend, grant.user_id, grant.permission, on_account, on_economy, grant.value)
+			}
+
+			on_account, on_economy := scope(test.scope)
+			got, err := backend.HasPermission(testMember("bob", "staff"), test.permission, on_account, on_economy)
+			if err != nil || got != test.want {
+				t.Errorf("HasPermission(%d, %s) = %t, %v; want %t", test.permission, test.scope, got, err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+// MaxBatchPayments is the most payments a single batch transfer can make.
+const MaxBatchPayments = 100
+
+// BatchPayment is a single payment of a batch transfer.
+type BatchPayment struct {
+	To 			Account
+	Amount 		datatypes.Money
+}
+
+// BatchResult is the outcome of a single payment of a batch transfer, with what it cost the payer and what the recipient received after taxes.
+type BatchResult struct {
+	To 			Account
+	Amount 		datatypes.Money
+	Debit 		datatypes.Money
+	Credit 		datatypes.Money
+	Transfer 	*Transfer
+}
+
+// BatchTotal sums the amounts, debits and credits of the results of a batch transfer.
+func BatchTotal(results []BatchResult) (BatchResult, error) {
+	var total BatchResult
+	for _, result := range results {
+		var err error
+		if total.Amount, err = total.Amount.Add(result.Amount); err != nil {
+			return BatchResult{}, err
+		} else if total.Debit, err = total.Debit.Add(result.Debit); err != nil {
+			return BatchResult{}, err
+		} else if total.Credit, err = total.Credit.Add(result.Credit); err != nil {
+			return BatchResult{}, err
+		}
+	}
+	return total, nil
+}
+
+func (self *Backend) balanceTx(session *gorm.DB, account_id datatypes.UUID) (datatypes.Money, error) {
+	var account Account
+	if err := session.Select("balance").Where("id = ?", account_id).First(&account).Error; err != nil {
+		return 0, err
+	}
+	return account.Balance, nil
+}
+
+// batchTransferTx makes every payment of a batch from an account inside an existing database transaction, which the caller
+// rolls back if any of them fails. The caller checks the member's permission.
+func (self *Backend) batchTransferTx(session *gorm.DB, member SessionedMember, from *Account, payments []BatchPayment, transfer_type uint8) ([]BatchResult, error) {
+	if len(payments) == 0 {
+		return nil, BackendError{Message: "A batch needs at least one payment."}
+	} else if len(payments) > MaxBatchPayments {
+		return nil, BackendError{Message: fmt.Sprintf("A batch can make at most %d payments.", MaxBatchPayments)}
+	}
+
+	var total datatypes.Money
+	for _, payment := range payments {
+		var err error
+		if total, err = total.Add(payment.Amount); err != nil {
+			return nil, err
+		}
+	}
+
+	// paying many small amounts at once must not get around the approval policy of the account
+	if policy, err := self.approvalPolicyTx(session, *from, total); err != nil {
+		return nil, err
+	} else if policy != nil {
+		return nil, BackendError{Message: "This batch exceeds the approval threshold of the account, so its payments have to be proposed one by one."}
+	}
+
+	results := make([]BatchResult, 0, len(payments))
+	for _, payment := range payments {
+		before_from, err := self.balanceTx(session, from.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		before_to, err := self.balanceTx(session, payment.To.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		transfer, err := self.createTransferTx(session, member, from, &payment.To, payment.Amount, transfer_type)
+		var backend_err BackendError
+		if errors.As(err, &backend_err) {
+			return nil, BackendError{Message: fmt.Sprintf("The payment to %s failed: %s", payment.To.AccountName, backend_err.Message)}
+		} else if err != nil {
+			return nil, err
+		}
+
+		after_from, err := self.balanceTx(session, from.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		after_to, err := self.balanceTx(session, payment.To.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, BatchResult{
+			To: payment.To,
+			Amount: payment.Amount,
+			Debit: before_from - after_from,
+			Credit: after_to - before_to,
+			Transfer: transfer,
+		})
+	}
+
+	return results, nil
+}
+
+// PreviewBatchTransfer works out what each payment of a batch would cost and pay out after taxes, without making any of them.
+func (self *Backend) PreviewBatchTransfer(member SessionedMember, from *Account, payments []BatchPayment, transfer_type uint8) ([]BatchResult, error) {
+	if perm, err := self.HasPermission(member, P_TransferFunds, from, nil); err != nil {
+		return nil, err
+	} else if !perm {
+		return nil, BackendError{Message: "You do not have the permission to transfer funds from this account."}
+	}
+
+	// the payments are made to account for taxes that depend on earlier ones, such as progressive income tax, and then undone
+	session := self.db.Begin()
+	results, err := self.batchTransferTx(session, member, from, payments, transfer_type)
+	session.Rollback()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		results[i].Transfer = nil
+	}
+	return results, nil
+}
+
+// CreateBatchTransfer pays every account of a batch from an account in a single transaction, so either all payments are made or none.
+func (self *Backend) CreateBatchTransfer(member SessionedMember, from *Account, payments []BatchPayment, transfer_type uint8) ([]BatchResult, error) {
+	if perm, err := self.HasPermission(member, P_TransferFunds, from, nil); err != nil {
+		return nil, err
+	} else if !perm {
+		return nil, BackendError{Message: "You do not have the permission to transfer funds from this account."}
+	}
+
+	session := self.db.Begin()
+
+	results, err := self.batchTransferTx(session, member, from, payments, transfer_type)
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	return results, session.Commit().Error
+}
@@ -0,0 +1,353 @@
+package database
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const chainBatchSize = 500
+
+// ChainBreak describes a point at which an economy's journal chain no longer matches its recorded hashes.
+type ChainBreak struct {
+	JournalID 	uint
+	Sequence 	uint64
+	Reason 		string
+}
+
+// ChainReport is the result of verifying an economy's journal chain.
+type ChainReport struct {
+	Checked 	uint64
+	Head 		string
+	Breaks 		[]ChainBreak
+}
+
+type canonicalPosting struct {
+	AccountID 	string 	`json:"account_id"`
+	Amount 		int64 	`json:"amount"`
+	TaxEntryID 	*string `json:"tax_entry_id,omitempty"`
+}
+
+type canonicalTransfer struct {
+	TrxID 			uint 	`json:"trx_id"`
+	ActorID 		string 	`json:"actor_id"`
+	FromAccountID 	string 	`json:"from_account_id"`
+	ToAccountID 	string 	`json:"to_account_id"`
+	Amount 			int64 	`json:"amount"`
+	TransferType 	uint8 	`json:"transfer_type"`
+	CreatedAt 		string 	`json:"created_at"`
+	ReversalOfID 	*uint 	`json:"reversal_of_id,omitempty"`
+}
+
+// Fields added to the canonical forms after their introduction are omitted when empty,
+// so that the hashes of entries recorded before them stay the same.
+type canonicalJournal struct {
+	EconomyID 		string 				`json:"economy_id"`
+	Sequence 		uint64 				`json:"sequence"`
+	PrevHash 		string 				`json:"prev_hash"`
+	JournalType 	uint8 				`json:"journal_type"`
+	ActorID 		string 				`json:"actor_id"`
+	Memo 			string 				`json:"memo"`
+	CreatedAt 		string 				`json:"created_at"`
+	Postings 		[]canonicalPosting 	`json:"postings"`
+	Transfers 		[]canonicalTransfer `json:"transfers"`
+	OnBehalfOf 		string 				`json:"on_behalf_of,omitempty"`
+}
+
+// chainTime normalises a timestamp to what every supported database can store without loss,
+// so that a journal hashes the same before and after a round trip.
+func chainTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+func formatChainTime(t time.Time) string {
+	return chainTime(t).Format(time.RFC3339Nano)
+}
+
+// journalHash computes the hash of a journal's canonical contents, which include its postings and transfers.
+func journalHash(journal Journal) string {
+	canonical := canonicalJournal{
+		EconomyID: journal.EconomyID,
+		PrevHash: journal.PrevHash,
+		JournalType: journal.JournalType,
+		ActorID: journal.ActorID,
+		Memo: journal.Memo,
+		CreatedAt: formatChainTime(journal.CreatedAt),
+		Postings: make([]canonicalPosting, 0, len(journal.Postings)),
+		Transfers: make([]canonicalTransfer, 0, len(journal.Transfers)),
+	}
+
+	if journal.Sequence != nil {
+		canonical.Sequence = *journal.Sequence
+	}
+
+	if journal.OnBehalfOfID != nil {
+		canonical.OnBehalfOf = journal.OnBehalfOfID.String()
+	}
+
+	for _, posting := range journal.Postings {
+		canonical.Postings = append(canonical.Postings, canonicalPosting{posting.AccountID.String(), int64(posting.Amount), posting.TaxEntryID})
+	}
+
+	sort.Slice(canonical.Postings, func(i, j int) bool {
+		a, b := canonical.Postings[i], canonical.Postings[j]
+		if a.AccountID != b.AccountID {
+			return a.AccountID < b.AccountID
+		} else if a.Amount != b.Amount {
+			return a.Amount < b.Amount
+		}
+		return a.TaxEntryID != nil && (b.TaxEntryID == nil || *a.TaxEntryID < *b.TaxEntryID)
+	})
+
+	for _, transfer := range journal.Transfers {
+		canonical.Transfers = append(canonical.Transfers, canonicalTransfer{
+			TrxID: transfer.TrxID,
+			ActorID: transfer.ActorID,
+			FromAccountID: transfer.FromAccountID.String(),
+			ToAccountID: transfer.ToAccountID.String(),
+			Amount: int64(transfer.Amount),
+			TransferType: transfer.TransferType,
+			CreatedAt: formatChainTime(transfer.CreatedAt),
+			ReversalOfID: transfer.ReversalOfID,
+		})
+	}
+
+	sort.Slice(canonical.Transfers, func(i, j int) bool {
+		return canonical.Transfers[i].TrxID < canonical.Transfers[j].TrxID
+	})
+
+	// struct fields are always encoded in declaration order, which keeps the encoding canonical
+	data, _ := json.Marshal(canonical)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sealJournalTx appends a recorded journal to the end of its economy's chain.
+// The unique index on the sequence rejects a concurrent transaction that tries to append to the same head.
+func (self *Backend) sealJournalTx(session *gorm.DB, journal *Journal) error {
+	var head []Journal
+	err := session.Where("economy_id = ? AND sequence IS NOT NULL", journal.EconomyID).Order("sequence DESC").Limit(1).Find(&head).Error
+	if err != nil {
+		return err
+	}
+
+	sequence := uint64(1)
+	journal.PrevHash = ""
+	if len(head) > 0 {
+		sequence = *head[0].Sequence + 1
+		journal.PrevHash = head[0].Hash
+	}
+
+	journal.Sequence = &sequence
+	journal.Hash = journalHash(*journal)
+
+	return session.Model(&Journal{}).Where("id = ?", journal.ID).Updates(map[string]any{
+		"sequence": sequence,
+		"prev_hash": journal.PrevHash,
+		"hash": journal.Hash,
+	}).Error
+}
+
+// sealLegacyJournals appends journals recorded before hash chaining was introduced to their economy's chain.
+func (self *Backend) sealLegacyJournals() error {
+	var journals []Journal
+	if err := self.db.Where("sequence IS NULL").Preload("Postings").Preload("Transfers").Order("id").Find(&journals).Error; err != nil {
+		return err
+	}
+
+	for _, journal := range journals {
+		session := self.db.Begin()
+		if err := self.sealJournalTx(session, &journal); err != nil {
+			session.Rollback()
+			return err
+		}
+
+		if err := session.Commit().Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkChain recomputes the journal chain of an economy from its first entry and reports every entry that was modified,
+// removed or inserted, along with the hash of each entry by its sequence. A non-zero upto stops the walk after that entry.
+func (self *Backend) walkChain(economy_id string, upto uint64) (ChainReport, map[uint64]string, error) {
+	var report ChainReport
+	hashes := make(map[uint64]string)
+
+	for offset := 0; ; offset += chainBatchSize {
+		stmt := self.db.Where("economy_id = ?", economy_id)
+		if upto > 0 {
+			stmt = stmt.Where("sequence <= ?", upto)
+		}
+
+		var journals []Journal
+		err := stmt.Preload("Postings").Preload("Transfers").Order("sequence").Order("id").Limit(chainBatchSize).Offset(offset).Find(&journals).Error
+		if err != nil {
+			return ChainReport{}, nil, err
+		}
+
+		for _, journal := range journals {
+			if journal.Sequence == nil {
+				report.Breaks = append(report.Breaks, ChainBreak{JournalID: journal.ID, Reason: "Entry is not part of the chain."})
+				continue
+			}
+
+			sequence := *journal.Sequence
+			if sequence != report.Checked + 1 {
+				report.Breaks = append(report.Breaks, ChainBreak{JournalID: journal.ID, Sequence: sequence, Reason: fmt.Sprintf("Entries %d to %d are missing.", report.Checked + 1, sequence - 1)})
+			} else if journal.PrevHash != report.Head {
+				report.Breaks = append(report.Breaks, ChainBreak{JournalID: journal.ID, Sequence: sequence, Reason: "Entry does not link to the previous entry."})
+			}
+
+			if journalHash(journal) != journal.Hash {
+				report.Breaks = append(report.Breaks, ChainBreak{JournalID: journal.ID, Sequence: sequence, Reason: "Entry contents were modified."})
+			}
+
+			report.Checked = sequence
+			report.Head = journal.Hash
+			hashes[sequence] = journal.Hash
+		}
+
+		if len(journals) < chainBatchSize {
+			break
+		}
+	}
+
+	return report, hashes, nil
+}
+
+// VerifyChain recomputes the journal chain of an economy and reports every entry that was modified, removed or inserted,
+// every transfer that lost its journal, and every stored checkpoint the chain no longer matches.
+func (self *Backend) VerifyChain(member SessionedMember, economy Economy) (ChainReport, error) {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return ChainReport{}, err
+	} else if !perm {
+		return ChainReport{}, BackendError{Message: "You do not have the permission to verify the ledger of this economy."}
+	}
+
+	report, hashes, err := self.walkChain(economy.ID.String(), 0)
+	if err != nil {
+		return ChainReport{}, err
+	}
+
+	var orphans []Transfer
+	err = self.db.Joins("JOIN accounts ON accounts.id = transfers.from_account_id").
+		Where("accounts.economy_id = ?", economy.ID.String()).
+		Where("NOT EXISTS (SELECT 1 FROM journals WHERE journals.id = transfers.journal_id)").
+		Find(&orphans).Error
+	if err != nil {
+		return ChainReport{}, err
+	}
+
+	for _, transfer := range orphans {
+		report.Breaks = append(report.Breaks, ChainBreak{JournalID: transfer.JournalID, Reason: fmt.Sprintf("Transfer #%d has no ledger entry.", transfer.TrxID)})
+	}
+
+	var checkpoints []LedgerCheckpoint
+	if err := self.db.Where("economy_id = ?", economy.ID.String()).Order("sequence").Find(&checkpoints).Error; err != nil {
+		return ChainReport{}, err
+	}
+
+	for _, checkpoint := range checkpoints {
+		if hash, ok := hashes[checkpoint.Sequence]; !ok || hash != checkpoint.Hash {
+			report.Breaks = append(report.Breaks, ChainBreak{Sequence: checkpoint.Sequence, Reason: fmt.Sprintf("Chain does not match the checkpoint taken at %s.", checkpoint.CreatedAt.UTC().Format(time.RFC3339))})
+		}
+	}
+
+	return report, nil
+}
+
+// SetSigningKey sets the key ledger checkpoints are signed with.
+func (self *Backend) SetSigningKey(key ed25519.PrivateKey) {
+	self.signer = key
+}
+
+func checkpointPayload(checkpoint LedgerCheckpoint) []byte {
+	return fmt.Appendf(nil, "taubot-ledger-checkpoint:v1\n%s\n%d\n%s\n%s", checkpoint.EconomyID, checkpoint.Sequence, checkpoint.Hash, formatChainTime(checkpoint.CreatedAt))
+}
+
+// CreateCheckpoint signs and stores the current head of the economy's journal chain.
+func (self *Backend) CreateCheckpoint(member SessionedMember, economy Economy) (LedgerCheckpoint, error) {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return LedgerCheckpoint{}, err
+	} else if !perm {
+		return LedgerCheckpoint{}, BackendError{Message: "You do not have the permission to checkpoint the ledger of this economy."}
+	}
+
+	if self.signer == nil {
+		return LedgerCheckpoint{}, BackendError{Message: "No ledger signing key is configured."}
+	}
+
+	var head Journal
+	if err := self.db.Where("economy_id = ? AND sequence IS NOT NULL", economy.ID.String()).Order("sequence DESC").First(&head).Error; err != nil {
+		if err == RecordNotFoundError {
+			return LedgerCheckpoint{}, BackendError{Message: "This economy has no ledger entries yet."}
+		}
+		return LedgerCheckpoint{}, err
+	}
+
+	checkpoint := LedgerCheckpoint{
+		EconomyID: economy.ID.String(),
+		Sequence: *head.Sequence,
+		Hash: head.Hash,
+		CreatedAt: chainTime(time.Now()),
+		PublicKey: base64.StdEncoding.EncodeToString(self.signer.Public().(ed25519.PublicKey)),
+	}
+	checkpoint.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(self.signer, checkpointPayload(checkpoint)))
+
+	if err := self.db.Create(&checkpoint).Error; err != nil {
+		return LedgerCheckpoint{}, err
+	}
+
+	return checkpoint, nil
+}
+
+// VerifyCheckpoint checks the signature of an exported checkpoint of the economy's ledger, and whether the journal chain
+// is still intact from its first entry up to the entry the checkpoint attests to.
+func (self *Backend) VerifyCheckpoint(member SessionedMember, economy Economy, checkpoint LedgerCheckpoint) (bool, error) {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return false, err
+	} else if !perm {
+		return false, BackendError{Message: "You do not have the permission to verify the ledger of this economy."}
+	}
+
+	if checkpoint.EconomyID != economy.ID.String() {
+		return false, BackendError{Message: "The checkpoint was taken of another economy's ledger."}
+	}
+
+	key, err := base64.StdEncoding.DecodeString(checkpoint.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return false, BackendError{Message: "The checkpoint has an invalid public key."}
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(checkpoint.Signature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), checkpointPayload(checkpoint), signature) {
+		return false, BackendError{Message: "The checkpoint signature is invalid."}
+	}
+
+	if self.signer != nil && !self.signer.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(key)) {
+		return false, BackendError{Message: "The checkpoint was not signed by this bot."}
+	}
+
+	if checkpoint.Sequence == 0 {
+		return false, nil
+	}
+
+	// every earlier entry is hashed into the ones after it, so the whole chain up to the checkpoint has to be walked again
+	// for an edit or deletion before the attested entry to show up
+	report, _, err := self.walkChain(checkpoint.EconomyID, checkpoint.Sequence)
+	if err != nil {
+		return false, err
+	}
+
+	return len(report.Breaks) == 0 && report.Checked == checkpoint.Sequence && report.Head == checkpoint.Hash, nil
+}
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+)
+
+// newTestChain sets up a ledger with a signing key and a chain of four entries: a mint followed by three transfers.
+func newTestChain(t *testing.T) (*Backend, Economy, Account, Account) {
+	t.Helper()
+
+	backend, economy, alice, bob := newTestLedger(t)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.SetSigningKey(key)
+
+	if err := backend.MintFunds(testMember("admin"), &alice, 10000); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		alice = reloadAccount(t, backend, alice)
+		if _, err := backend.CreateTransfer(testMember("alice"), &alice, &bob, 100, TT_Personal); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return backend, economy, reloadAccount(t, backend, alice), reloadAccount(t, backend, bob)
+}
+
+// tamperings are the ways the tests modify the ledger behind the backend's back, each on the entry with the given sequence.
+var tamperings = map[string]func(t *testing.T, backend *Backend, economy Economy, sequence uint64){
+	"edited posting": func(t *testing.T, backend *Backend, economy Economy, sequence uint64) {
+		journal := journalAt(t, backend, economy, sequence)
+		if err := backend.db.Model(&Posting{}).Where("journal_id = ? AND amount > 0", journal.ID).Update("amount", 1).Error; err != nil {
+			t.Fatal(err)
+		}
+	},
+	"edited transfer": func(t *testing.T, backend *Backend, economy Economy, sequence uint64) {
+		journal := journalAt(t, backend, economy, sequence)
+		if err := backend.db.Model(&Transfer{}).Where("journal_id = ?", journal.ID).Update("transfer_type", TT_Purchase).Error; err != nil {
+			t.Fatal(err)
+		}
+	},
+	"deleted entry": func(t *testing.T, backend *Backend, economy Economy, sequence uint64) {
+		journal := journalAt(t, backend, economy, sequence)
+		if err := backend.db.Where("journal_id = ?", journal.ID).Delete(&Posting{}).Error; err != nil {
+			t.Fatal(err)
+		} else if err := backend.db.Where("journal_id = ?", journal.ID).Delete(&Transfer{}).Error; err != nil {
+			t.Fatal(err)
+		} else if err := backend.db.Where("id = ?", journal.ID).Delete(&Journal{}).Error; err != nil {
+			t.Fatal(err)
+		}
+	},
+}
+
+func journalAt(t *testing.T, backend *Backend, economy Economy, sequence uint64) Journal {
+	t.Helper()
+
+	var journal Journal
+	if err := backend.db.Where("economy_id = ? AND sequence = ?", economy.ID.String(), sequence).First(&journal).Error; err != nil {
+		t.Fatal(err)
+	}
+	return journal
+}
+
+func TestVerifyChain(t *testing.T) {
+	backend, economy, _, _ := newTestChain(t)
+
+	if _, err := backend.VerifyChain(testMember("alice"), economy); err == nil {
+		t.Error("VerifyChain allowed a member without P_ManageEconomies")
+	}
+
+	report, err := backend.VerifyChain(testMember("admin"), economy)
+	if err != nil || len(report.Breaks) != 0 || report.Checked != 4 || report.Head != journalAt(t, backend, economy, 4).Hash {
+		t.Fatalf("VerifyChain of an intact chain = %+v, %v; want four entries without breaks", report, err)
+	}
+
+	for name, tamper := range tamperings {
+		t.Run(name, func(t *testing.T) {
+			backend, economy, _, _ := newTestChain(t)
+			tamper(t, backend, economy, 2)
+
+			report, err := backend.VerifyChain(testMember("admin"), economy)
+			if err != nil || len(report.Breaks) == 0 {
+				t.Errorf("VerifyChain after the second entry was tampered with = %+v, %v; want breaks", report, err)
+			}
+		})
+	}
+}
+
+func TestVerifyCheckpoint(t *testing.T) {
+	for name, tamper := range tamperings {
+		t.Run(name, func(t *testing.T) {
+			backend, economy, _, _ := newTestChain(t)
+
+			checkpoint, err := backend.CreateCheckpoint(testMember("admin"), economy)
+			if err != nil {
+				t.Fatal(err)
+			} else if checkpoint.Sequence != 4 {
+				t.Fatalf("checkpoint sequence = %d; want the head at 4", checkpoint.Sequence)
+			}
+
+			// an entry before the one the checkpoint attests to has to break it as well
+			tamper(t, backend, economy, 2)
+
+			if ok, err := backend.VerifyCheckpoint(testMember("admin"), economy, checkpoint); err != nil || ok {
+				t.Errorf("VerifyCheckpoint after the second entry was tampered with = %t, %v; want false", ok, err)
+			}
+		})
+	}
+
+	t.Run("intact", func(t *testing.T) {
+		backend, economy, alice, bob := newTestChain(t)
+
+		checkpoint, err := backend.CreateCheckpoint(testMember("admin"), economy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// entries appended after the checkpoint do not affect it
+		if _, err := backend.CreateTransfer(testMember("alice"), &alice, &bob, 100, TT_Personal); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := backend.VerifyCheckpoint(testMember("alice"), economy, checkpoint); err == nil {
+			t.Error("VerifyCheckpoint allowed a member without P_ManageEconomies")
+		}
+		if ok, err := backend.VerifyCheckpoint(testMember("admin"), economy, checkpoint); err != nil || !ok {
+			t.Errorf("VerifyCheckpoint of an intact chain = %t, %v; want true", ok, err)
+		}
+	})
+
+	t.Run("forged", func(t *testing.T) {
+		backend, economy, _, _ := newTestChain(t)
+
+		checkpoint, err := backend.CreateCheckpoint(testMember("admin"), economy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkpoint.Hash = journalAt(t, backend, economy, 3).Hash
+		if ok, err := backend.VerifyCheckpoint(testMember("admin"), economy, checkpoint); err == nil || ok {
+			t.Errorf("VerifyCheckpoint of a checkpoint with a changed hash = %t, %v; want a signature error", ok, err)
+		}
+	})
+
+	t.Run("another economy", func(t *testing.T) {
+		backend, economy, _, _ := newTestChain(t)
+		other := newTestEconomy(t, backend, "other")
+
+		checkpoint, err := backend.CreateCheckpoint(testMember("admin"), economy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ok, err := backend.VerifyCheckpoint(testMember("admin"), other, checkpoint); err == nil || ok {
+			t.Errorf("VerifyCheckpoint against another economy = %t, %v; want an error", ok, err)
+		}
+	})
+}
@@ -0,0 +1,242 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+// FaucetEmptyError is returned when the faucet account of an economy cannot afford a daily claim.
+var FaucetEmptyError = BackendError{Message: "The faucet has run dry, please try again once it has been refilled."}
+
+// DailyResult is the outcome of a daily claim.
+type DailyResult struct {
+	Claim 		DailyClaim
+	Amount 		datatypes.Money
+	Transfer 	*Transfer
+	NextClaim 	time.Time
+
+	// Faucet is the balance the faucet account is left with
+	Faucet 		datatypes.Money
+}
+
+// GetDailyReward returns the daily reward of an economy, or nil if it has none.
+func (self *Backend) GetDailyReward(economy Economy) (*DailyReward, error) {
+	var reward DailyReward
+	err := self.db.Where("economy_id = ?", economy.ID.String()).Preload("FromAccount.Economy").First(&reward).Error
+	if errors.Is(err, RecordNotFoundError) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &reward, nil
+}
+
+// SetDailyReward sets up or changes the daily reward of an economy, which is paid from the reward's FromAccount.
+// The member has to be able to manage the economy as well as transfer funds from the account.
+func (self *Backend) SetDailyReward(member SessionedMember, economy Economy, reward *DailyReward) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage the daily reward of this economy."}
+	} else if perm, err := self.HasPermission(member, P_TransferFunds, &reward.FromAccount, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to transfer funds from this account."}
+	}
+
+	if !reward.Amount.IsPositive() {
+		return BackendError{Message: "The daily reward must be positive."}
+	} else if reward.StreakBonus.IsNegative() || reward.LowBalance.IsNegative() {
+		return BackendError{Message: "The streak bonus and low balance alert cannot be negative."}
+	} else if reward.FromAccount.EconomyID != economy.ID.String() {
+		return BackendError{Message: "The faucet account has to be in this economy."}
+	} else if reward.FromAccount.Deleted {
+		return BackendError{Message: "A closed account cannot be the faucet."}
+	}
+
+	reward.EconomyID = economy.ID.String()
+	reward.ActorID = member.User.ID
+	reward.FromAccountID = reward.FromAccount.ID
+	reward.AlertedAt = nil
+
+	session := self.db.Begin()
+
+	if err := session.Omit("FromAccount").Save(reward).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "daily reward",
+		Target: economy.ID.String(),
+		Details: fmt.Sprintf("pays %s a day plus %s per day of streak from %s", economy.FormatMoney(reward.Amount), economy.FormatMoney(reward.StreakBonus), reward.FromAccount.AccountName),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// RemoveDailyReward stops daily claims in an economy, forgetting everyone's streak.
+func (self *Backend) RemoveDailyReward(member SessionedMember, economy Economy) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage the daily reward of this economy."}
+	}
+
+	session := self.db.Begin()
+
+	result := session.Where("economy_id = ?", economy.ID.String()).Delete(&DailyReward{})
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return BackendError{Message: "This economy does not have a daily reward."}
+	}
+
+	if err := session.Where("economy_id = ?", economy.ID.String()).Delete(&DailyClaim{}).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Delete,
+		Action: "daily reward",
+		Target: economy.ID.String(),
+		Details: "stopped the daily reward",
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// DailyAmount returns what a claim pays on a given day of a streak; the bonus stops growing after MaxStreak days, if set.
+func (reward DailyReward) DailyAmount(streak uint) (datatypes.Money, error) {
+	days := max(streak, 1) - 1
+	if reward.MaxStreak > 0 {
+		days = min(days, reward.MaxStreak-1)
+	}
+
+	bonus, err := reward.StreakBonus.Mul(int64(days))
+	if err != nil {
+		return 0, err
+	}
+	return reward.Amount.Add(bonus)
+}
+
+// ClaimDaily pays the member their daily reward into their personal account. Days start at midnight in the economy's time zone,
+// and claiming on consecutive days grows the streak, while missing a day starts it over.
+func (self *Backend) ClaimDaily(member SessionedMember, economy Economy, now time.Time) (*DailyResult, error) {
+	reward, err := self.GetDailyReward(economy)
+	if err != nil {
+		return nil, err
+	} else if reward == nil {
+		return nil, BackendError{Message: "This economy does not have a daily reward."}
+	}
+
+	to, err := self.GetUserAccount(economy, member.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	today := PeriodStart(now, PD_Daily, economy.Location())
+	tomorrow := NextPeriod(today, PD_Daily)
+
+	session := self.db.Begin()
+
+	claim := DailyClaim{EconomyID: economy.ID.String(), UserID: member.User.ID}
+	if err := session.Where(claim).Limit(1).Find(&claim).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	if !claim.LastClaim.IsZero() && !claim.LastClaim.Before(today) {
+		session.Rollback()
+		return nil, BackendError{Message: fmt.Sprintf("You have already claimed your daily reward, come back <t:%d:R>.", tomorrow.Unix())}
+	}
+
+	previous := claim.LastClaim
+	if !claim.LastClaim.IsZero() && !claim.LastClaim.Before(today.AddDate(0, 0, -1)) {
+		claim.Streak++
+	} else {
+		claim.Streak = 1
+	}
+	claim.LastClaim = now.UTC()
+
+	amount, err := reward.DailyAmount(claim.Streak)
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	var from Account
+	if err := session.Where("id = ?", reward.FromAccountID).First(&from).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	transfer, err := self.transferTx(session, member.User.ID, nil, &from, &to, amount, TT_Income)
+	if errors.Is(err, InsufficientFundsError) {
+		session.Rollback()
+		return nil, FaucetEmptyError
+	} else if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	// the last claim makes this a compare-and-swap, so claiming twice at once only pays once
+	var saved *gorm.DB
+	if previous.IsZero() {
+		saved = session.Create(&claim)
+	} else {
+		saved = session.Model(&DailyClaim{}).Where("economy_id = ? AND user_id = ? AND last_claim = ?", claim.EconomyID, claim.UserID, previous).
+			Updates(map[string]any{"streak": claim.Streak, "last_claim": claim.LastClaim})
+	}
+
+	if err := saved.Error; err != nil {
+		session.Rollback()
+		return nil, err
+	} else if saved.RowsAffected == 0 {
+		session.Rollback()
+		return nil, BackendError{Message: "You have already claimed your daily reward."}
+	}
+
+	faucet, err := self.balanceTx(session, from.ID)
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	// once the faucet has been refilled, it can alert again the next time it runs low
+	if reward.AlertedAt != nil && faucet >= reward.LowBalance {
+		if err := session.Model(&DailyReward{}).Where("economy_id = ?", reward.EconomyID).Update("alerted_at", nil).Error; err != nil {
+			session.Rollback()
+			return nil, err
+		}
+	}
+
+	return &DailyResult{Claim: claim, Amount: amount, Transfer: transfer, NextClaim: tomorrow, Faucet: faucet}, session.Commit().Error
+}
+
+// TakeFaucetAlert reports whether the admins of an economy should be alerted that its faucet is running low, which is
+// only the case the first time it happens until the faucet is refilled.
+func (self *Backend) TakeFaucetAlert(reward DailyReward, now time.Time) (bool, error) {
+	result := self.db.Model(&DailyReward{}).Where("economy_id = ? AND alerted_at IS NULL", reward.EconomyID).Update("alerted_at", now.UTC())
+	return result.RowsAffected > 0, result.Error
+}
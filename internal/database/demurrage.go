@@ -0,0 +1,268 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+// DemurrageCharge is what an account was charged for holding on to its balance through a period.
+type DemurrageCharge struct {
+	Account 	Account
+	Idle 		datatypes.Money
+	Amount 		datatypes.Money
+}
+
+// GetDemurragePolicy returns the demurrage of an economy, or nil if it has none.
+func (self *Backend) GetDemurragePolicy(economy Economy) (*DemurragePolicy, error) {
+	var policy DemurragePolicy
+	err := self.db.Where("economy_id = ?", economy.ID.String()).Preload("ToAccount.Economy").First(&policy).Error
+	if errors.Is(err, RecordNotFoundError) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// SetDemurragePolicy sets up or changes the demurrage of an economy, which is first charged at the end of the current period.
+func (self *Backend) SetDemurragePolicy(member SessionedMember, economy Economy, policy *DemurragePolicy) error {
+	if perm, err := self.HasPermission(member, P_ManageTaxBrackets, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage taxes in this economy."}
+	}
+
+	if policy.ToAccount.EconomyID != economy.ID.String() {
+		return BackendError{Message: "The demurrage account has to be in this economy."}
+	} else if policy.ToAccount.AccountType != AT_Government {
+		return BackendError{Message: "Demurrage can only be paid into a government account."}
+	} else if policy.ToAccount.Deleted {
+		return BackendError{Message: "A closed account cannot receive demurrage."}
+	} else if policy.Rate == 0 || policy.Rate > BasisPoints {
+		return BackendError{Message: "The demurrage rate has to be above 0% and at most 100%."}
+	} else if policy.Period == PD_None || policy.Period > PD_Monthly {
+		return BackendError{Message: "Demurrage has to be charged daily, weekly or monthly."}
+	}
+
+	policy.EconomyID = economy.ID.String()
+	policy.ActorID = member.User.ID
+	policy.ToAccountID = policy.ToAccount.ID
+	policy.LastCharged = PeriodStart(time.Now(), policy.Period, economy.Location()).UTC()
+
+	session := self.db.Begin()
+
+	if err := session.Omit("ToAccount").Save(policy).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "demurrage",
+		Target: economy.ID.String(),
+		Details: fmt.Sprintf("charges %s of idle balances %s into %s", FormatBasisPoints(policy.Rate), PeriodName(policy.Period), policy.ToAccount.AccountName),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// RemoveDemurragePolicy stops charging demurrage in an economy.
+func (self *Backend) RemoveDemurragePolicy(member SessionedMember, economy Economy) error {
+	if perm, err := self.HasPermission(member, P_ManageTaxBrackets, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage taxes in this economy."}
+	}
+
+	session := self.db.Begin()
+
+	result := session.Where("economy_id = ?", economy.ID.String()).Delete(&DemurragePolicy{})
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return BackendError{Message: "This economy does not charge demurrage."}
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Delete,
+		Action: "demurrage",
+		Target: economy.ID.String(),
+		Details: "stopped charging demurrage",
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// idleBalanceTx returns the lowest balance an account held between start and end, by undoing its postings since start
+// from its current balance.
+func (self *Backend) idleBalanceTx(session *gorm.DB, account Account, start time.Time, end time.Time) (datatypes.Money, error) {
+	var postings []struct {
+		Amount 		datatypes.Money
+		CreatedAt 	time.Time
+	}
+
+	err := session.Model(&Posting{}).
+		Select("postings.amount AS amount, journals.created_at AS created_at").
+		Joins("JOIN journals ON journals.id = postings.journal_id").
+		Where("postings.account_id = ? AND journals.created_at >= ?", account.ID, start).
+		Order("journals.id DESC").
+		Scan(&postings).Error
+	if err != nil {
+		return 0, err
+	}
+
+	balance, lowest, ended := account.Balance, account.Balance, false
+	for _, posting := range postings {
+		// the balance at the end of the window is only known once every later posting has been undone
+		if !ended && posting.CreatedAt.Before(end) {
+			lowest, ended = balance, true
+		}
+
+		if balance, err = balance.Sub(posting.Amount); err != nil {
+			return 0, err
+		}
+
+		if !ended {
+			lowest = balance
+		} else if balance < lowest {
+			lowest = balance
+		}
+	}
+
+	if lowest.IsNegative() {
+		return 0, nil
+	}
+	return lowest, nil
+}
+
+// assessDemurrageTx computes the demurrage of every private account in the economy for the period between start and end.
+// Accounts that owe nothing are left out.
+func (self *Backend) assessDemurrageTx(session *gorm.DB, policy DemurragePolicy, start time.Time, end time.Time) ([]DemurrageCharge, error) {
+	var accounts []Account
+	err := session.Where("economy_id = ? AND deleted = ? AND account_type IN ? AND balance > 0 AND id <> ?", policy.EconomyID, false, []int{int(AT_User), int(AT_Corporation), int(AT_Charity)}, policy.ToAccountID).
+		Order("id").Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var charges []DemurrageCharge
+	for _, account := range accounts {
+		idle, err := self.idleBalanceTx(session, account, start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		amount, err := idle.MulRate(int64(policy.Rate), BasisPoints)
+		if err != nil {
+			return nil, err
+		}
+
+		// the balance may have been spent since the period ended; never charge more than the account still holds
+		if amount > account.Balance {
+			amount = account.Balance
+		}
+
+		if amount.IsPositive() {
+			charges = append(charges, DemurrageCharge{Account: account, Idle: idle, Amount: amount})
+		}
+	}
+
+	return charges, nil
+}
+
+// PreviewDemurrage returns what each account would be charged for its idle balance in the current period so far.
+func (self *Backend) PreviewDemurrage(economy Economy, policy DemurragePolicy) ([]DemurrageCharge, error) {
+	now := time.Now()
+	return self.assessDemurrageTx(self.db, policy, PeriodStart(now, policy.Period, economy.Location()).UTC(), now.UTC())
+}
+
+// chargeDemurrageTx charges the demurrage of the period between start and end, unless another process already has.
+func (self *Backend) chargeDemurrageTx(session *gorm.DB, policy DemurragePolicy, start time.Time, end time.Time) ([]DemurrageCharge, error) {
+	// moving the last charge forward only succeeds once, so a period is never charged twice
+	result := session.Model(&DemurragePolicy{}).Where("economy_id = ? AND last_charged = ?", policy.EconomyID, policy.LastCharged).Update("last_charged", end)
+	if err := result.Error; err != nil {
+		return nil, err
+	} else if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	charges, err := self.assessDemurrageTx(session, policy, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	memo := fmt.Sprintf("Demurrage for the period ending %s", end.Format(time.DateOnly))
+	for _, charge := range charges {
+		journal := Journal{
+			EconomyID: policy.EconomyID,
+			JournalType: JT_Demurrage,
+			ActorID: policy.ActorID,
+			Memo: memo,
+			Postings: []Posting{
+				{AccountID: charge.Account.ID, Amount: -charge.Amount},
+				{AccountID: policy.ToAccountID, Amount: charge.Amount},
+			},
+		}
+
+		if err := self.postJournalTx(session, &journal, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return charges, nil
+}
+
+// RunDemurrage charges demurrage in every economy whose demurrage period has ended since it was last charged.
+// Each economy is charged in its own transaction.
+func (self *Backend) RunDemurrage(now time.Time) error {
+	var policies []DemurragePolicy
+	if err := self.db.Where("economy_id NOT IN (?)", self.lockedEconomies()).Find(&policies).Error; err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, policy := range policies {
+		economy, err := self.economyTx(self.db, policy.EconomyID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		end := PeriodStart(now, policy.Period, economy.Location())
+		if !end.After(policy.LastCharged) {
+			continue
+		}
+
+		session := self.db.Begin()
+
+		if _, err := self.chargeDemurrageTx(session, policy, previousPeriod(end, policy.Period).UTC(), end.UTC()); err != nil {
+			session.Rollback()
+			errs = append(errs, fmt.Errorf("economy %s: %w", economy.Name, err))
+			continue
+		}
+
+		if err := session.Commit().Error; err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,363 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// activityJournals are the kinds of journal that count as activity on an account; charges the economy makes on its own,
+// such as taxes, interest and demurrage, do not keep an account from going dormant.
+var activityJournals = []int{int(JT_Transfer), int(JT_Mint), int(JT_Burn), int(JT_Reversal), int(JT_Hold), int(JT_Release)}
+
+// GetEscheatmentPolicy returns the escheatment policy of an economy, or nil if it has none.
+func (self *Backend) GetEscheatmentPolicy(economy Economy) (*EscheatmentPolicy, error) {
+	var policy EscheatmentPolicy
+	err := self.db.Where("economy_id = ?", economy.ID.String()).Preload("ToAccount.Economy").First(&policy).Error
+	if errors.Is(err, RecordNotFoundError) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// SetEscheatmentPolicy sets up or changes how long accounts may stay dormant in an economy before their balance is escheated.
+func (self *Backend) SetEscheatmentPolicy(member SessionedMember, economy Economy, policy *EscheatmentPolicy) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage dormant accounts in this economy."}
+	}
+
+	if policy.ToAccount.EconomyID != economy.ID.String() {
+		return BackendError{Message: "The escheatment account has to be in this economy."}
+	} else if policy.ToAccount.AccountType != AT_Government {
+		return BackendError{Message: "Dormant balances can only be escheated to a government account."}
+	} else if policy.ToAccount.Deleted {
+		return BackendError{Message: "A closed account cannot receive dormant balances."}
+	} else if policy.WarningDays == 0 || policy.DormantDays <= policy.WarningDays {
+		return BackendError{Message: "Owners have to be warned at least a day before their account is escheated, and after it has been dormant for some time."}
+	} else if policy.ClaimDays == 0 {
+		return BackendError{Message: "Owners need at least a day to claim their funds back."}
+	}
+
+	policy.EconomyID = economy.ID.String()
+	policy.ActorID = member.User.ID
+	policy.ToAccountID = policy.ToAccount.ID
+
+	session := self.db.Begin()
+
+	if err := session.Omit("ToAccount").Save(policy).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "escheatment",
+		Target: economy.ID.String(),
+		Details: fmt.Sprintf("escheats accounts dormant for %d days into %s, warning %d days before and allowing claims for %d days",
+			policy.DormantDays, policy.ToAccount.AccountName, policy.WarningDays, policy.ClaimDays),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// RemoveEscheatmentPolicy stops escheating dormant accounts in an economy and clears every pending warning.
+// Balances that were already escheated can still be claimed back.
+func (self *Backend) RemoveEscheatmentPolicy(member SessionedMember, economy Economy) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage dormant accounts in this economy."}
+	}
+
+	session := self.db.Begin()
+
+	result := session.Where("economy_id = ?", economy.ID.String()).Delete(&EscheatmentPolicy{})
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return BackendError{Message: "This economy does not escheat dormant accounts."}
+	}
+
+	if err := session.Model(&Escheatment{}).Where("economy_id = ? AND status = ?", economy.ID.String(), ES_Warned).Update("status", ES_Cleared).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Delete,
+		Action: "escheatment",
+		Target: economy.ID.String(),
+		Details: "stopped escheating dormant accounts",
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+func (self *Backend) GetEscheatmentByID(id uint) (Escheatment, error) {
+	var escheatment Escheatment
+	result := self.db.Where("id = ?", id).Preload("Account.Economy").First(&escheatment)
+	if errors.Is(result.Error, RecordNotFoundError) {
+		return escheatment, BackendError{Message: "That escheatment does not exist."}
+	}
+	return escheatment, result.Error
+}
+
+// GetEscheatments returns the pending warnings and the escheated balances of the accounts a user owns in an economy.
+func (self *Backend) GetEscheatments(economy Economy, owner_id string) ([]Escheatment, error) {
+	var escheatments []Escheatment
+	result := self.db.Where("economy_id = ? AND owner_id = ? AND (status = ? OR status = ?)", economy.ID.String(), owner_id, ES_Warned, ES_Escheated).
+		Preload("Account.Economy").Order("id").Find(&escheatments)
+	return escheatments, result.Error
+}
+
+// lastActivityTx returns when an account last had any activity, or the zero time if it never had.
+func (self *Backend) lastActivityTx(session *gorm.DB, account_id string) (time.Time, error) {
+	var journals []Journal
+	err := session.Model(&Journal{}).Select("journals.created_at").
+		Joins("JOIN postings ON postings.journal_id = journals.id").
+		Where("postings.account_id = ? AND journals.journal_type IN ?", account_id, activityJournals).
+		Order("journals.id DESC").Limit(1).Find(&journals).Error
+	if err != nil || len(journals) == 0 {
+		return time.Time{}, err
+	}
+	return journals[0].CreatedAt, nil
+}
+
+// warnDormantTx opens a warning for every private account in the economy with a balance that has had no activity for long
+// enough, which is escheated once it has been dormant for the full DormantDays, and at least WarningDays from now.
+func (self *Backend) warnDormantTx(session *gorm.DB, policy EscheatmentPolicy, now time.Time) ([]Escheatment, error) {
+	warn_before := now.AddDate(0, 0, -int(policy.DormantDays - policy.WarningDays))
+
+	// claiming an escheated balance back counts as activity too
+	var accounts []Account
+	err := session.Where("economy_id = ? AND deleted = ? AND frozen = ? AND account_type IN ? AND balance > 0 AND id <> ?", policy.EconomyID, false, false, []int{int(AT_User), int(AT_Corporation), int(AT_Charity)}, policy.ToAccountID).
+		Where("id NOT IN (?)", session.Model(&Escheatment{}).Select("account_id").Where("status = ? OR claimed_at >= ?", ES_Warned, warn_before.UTC())).
+		Order("id").Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []Escheatment
+	for _, account := range accounts {
+		last, err := self.lastActivityTx(session, account.ID.String())
+		if err != nil {
+			return nil, err
+		} else if !last.Before(warn_before) {
+			continue
+		}
+
+		due := last.AddDate(0, 0, int(policy.DormantDays))
+		if earliest := now.AddDate(0, 0, int(policy.WarningDays)); due.Before(earliest) {
+			due = earliest
+		}
+
+		warning := Escheatment{
+			EconomyID: policy.EconomyID,
+			AccountID: account.ID,
+			OwnerID: account.OwnerID,
+			Status: ES_Warned,
+			LastActivity: last.UTC(),
+			WarnedAt: now.UTC(),
+			DueAt: due.UTC(),
+		}
+
+		if err := session.Omit("Account").Create(&warning).Error; err != nil {
+			return nil, err
+		}
+
+		warning.Account = account
+		warnings = append(warnings, warning)
+	}
+
+	return warnings, nil
+}
+
+// escheatTx settles a warning that has come due: the balance of the account moves to the escheatment account, unless the
+// account has had activity since the warning or has nothing left, which clears it.
+func (self *Backend) escheatTx(session *gorm.DB, policy EscheatmentPolicy, warning *Escheatment, now time.Time) error {
+	var account Account
+	if err := session.Where("id = ?", warning.AccountID).First(&account).Error; err != nil {
+		return err
+	}
+
+	last, err := self.lastActivityTx(session, account.ID.String())
+	if err != nil {
+		return err
+	}
+
+	updates := map[string]any{"status": ES_Cleared}
+	if last.After(warning.LastActivity) || !account.Balance.IsPositive() || account.Deleted || account.Frozen {
+		warning.Status = ES_Cleared
+	} else {
+		journal := Journal{
+			EconomyID: policy.EconomyID,
+			JournalType: JT_Escheatment,
+			ActorID: policy.ActorID,
+			Memo: fmt.Sprintf("Escheatment of the dormant account %s", account.AccountName),
+			Postings: []Posting{
+				{AccountID: account.ID, Amount: -account.Balance},
+				{AccountID: policy.ToAccountID, Amount: account.Balance},
+			},
+		}
+
+		if err := self.postJournalTx(session, &journal, nil); err != nil {
+			return err
+		}
+
+		escheated, claim_by := now.UTC(), now.AddDate(0, 0, int(policy.ClaimDays)).UTC()
+		warning.Status, warning.Amount, warning.ToAccountID, warning.JournalID = ES_Escheated, account.Balance, &policy.ToAccountID, &journal.ID
+		warning.EscheatedAt, warning.ClaimBy = &escheated, &claim_by
+		updates = map[string]any{
+			"status": ES_Escheated,
+			"amount": warning.Amount,
+			"to_account_id": warning.ToAccountID,
+			"journal_id": warning.JournalID,
+			"escheated_at": warning.EscheatedAt,
+			"claim_by": warning.ClaimBy,
+		}
+	}
+
+	// settling only succeeds once, so two processes cannot both escheat the same account
+	result := session.Model(&Escheatment{}).Where("id = ? AND status = ?", warning.ID, ES_Warned).Updates(updates)
+	if err := result.Error; err != nil {
+		return err
+	} else if result.RowsAffected == 0 {
+		return BackendError{Message: "This escheatment has already been settled."}
+	}
+
+	warning.Account = account
+	return nil
+}
+
+// RunEscheatment warns the owners of accounts that are about to become dormant and escheats the balance of those whose
+// warning has come due, in every economy with an escheatment policy. It returns the warnings and escheatments it made,
+// so that their owners can be told.
+func (self *Backend) RunEscheatment(now time.Time) ([]Escheatment, error) {
+	var policies []EscheatmentPolicy
+	if err := self.db.Where("economy_id NOT IN (?)", self.lockedEconomies()).Find(&policies).Error; err != nil {
+		return nil, err
+	}
+
+	var notices []Escheatment
+	var errs []error
+	for _, policy := range policies {
+		var due []Escheatment
+		if err := self.db.Where("economy_id = ? AND status = ? AND due_at <= ?", policy.EconomyID, ES_Warned, now.UTC()).Find(&due).Error; err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, warning := range due {
+			session := self.db.Begin()
+
+			if err := self.escheatTx(session, policy, &warning, now); err != nil {
+				session.Rollback()
+				errs = append(errs, fmt.Errorf("escheatment %d: %w", warning.ID, err))
+				continue
+			}
+
+			if err := session.Commit().Error; err != nil {
+				errs = append(errs, err)
+			} else if warning.Status == ES_Escheated {
+				notices = append(notices, warning)
+			}
+		}
+
+		session := self.db.Begin()
+
+		warnings, err := self.warnDormantTx(session, policy, now)
+		if err != nil {
+			session.Rollback()
+			errs = append(errs, fmt.Errorf("economy %s: %w", policy.EconomyID, err))
+			continue
+		}
+
+		if err := session.Commit().Error; err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		notices = append(notices, warnings...)
+	}
+
+	return notices, errors.Join(errs...)
+}
+
+// ClaimEscheatment returns an escheated balance from the escheatment account to the account it was taken from, as long as
+// the claim window is open. The escheatment account is allowed to go below zero for it, since the funds were only held.
+func (self *Backend) ClaimEscheatment(member SessionedMember, escheatment *Escheatment) error {
+	if perm, err := self.HasPermission(member, P_TransferFunds, &escheatment.Account, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to claim funds for this account."}
+	}
+
+	if escheatment.Status != ES_Escheated {
+		return BackendError{Message: "Only escheated balances can be claimed back."}
+	} else if escheatment.ToAccountID == nil || escheatment.ClaimBy == nil || time.Now().After(*escheatment.ClaimBy) {
+		return BackendError{Message: "The window to claim this balance back has closed."}
+	} else if escheatment.Account.Deleted {
+		return BackendError{Message: "The escheated account has been closed."}
+	}
+
+	session := self.db.Begin()
+
+	now := time.Now()
+	result := session.Model(&Escheatment{}).Where("id = ? AND status = ?", escheatment.ID, ES_Escheated).Updates(map[string]any{"status": ES_Claimed, "claimed_at": &now})
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return BackendError{Message: "This balance has already been claimed back."}
+	}
+
+	journal := Journal{
+		EconomyID: escheatment.EconomyID,
+		JournalType: JT_Escheatment,
+		ActorID: member.User.ID,
+		Memo: fmt.Sprintf("Claim of the escheated balance of %s", escheatment.Account.AccountName),
+		AllowOverdraft: true,
+		Postings: []Posting{
+			{AccountID: *escheatment.ToAccountID, Amount: -escheatment.Amount},
+			{AccountID: escheatment.AccountID, Amount: escheatment.Amount},
+		},
+	}
+
+	if err := self.postJournalTx(session, &journal, nil); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	details := fmt.Sprintf("claimed back %s escheated from %s", escheatment.Account.Economy.FormatMoney(escheatment.Amount), escheatment.Account.AccountName)
+	if _, err := self.auditActingTx(session, member, escheatment.Account, CUD_Update, "escheatment", fmt.Sprint(escheatment.ID), details); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := session.Commit().Error; err != nil {
+		return err
+	}
+
+	escheatment.Status, escheatment.ClaimedAt = ES_Claimed, &now
+	return nil
+}
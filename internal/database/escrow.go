@@ -0,0 +1,434 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	maxEscrowDays = 90
+	maxEscrowTerms = 1000
+)
+
+func (self *Backend) GetEscrowContractByID(id uint) (EscrowContract, error) {
+	var contract EscrowContract
+	result := self.db.Where("id = ?", id).Preload("BuyerAccount.Economy").Preload("SellerAccount").First(&contract)
+	if errors.Is(result.Error, RecordNotFoundError) {
+		return contract, BackendError{Message: "That escrow contract does not exist."}
+	}
+	return contract, result.Error
+}
+
+// GetEscrowContracts returns the open and disputed escrow contracts a user is the buyer or seller of in an economy.
+func (self *Backend) GetEscrowContracts(member SessionedMember, economy Economy) ([]EscrowContract, error) {
+	var contracts []EscrowContract
+	result := self.db.Where("economy_id = ? AND (buyer_id = ? OR seller_id = ?) AND (status = ? OR status = ?)", economy.ID.String(), member.User.ID, member.User.ID, EC_Open, EC_Disputed).
+		Preload("BuyerAccount.Economy").Preload("SellerAccount").Order("id").Find(&contracts)
+	return contracts, result.Error
+}
+
+// GetDisputedEscrowContracts returns the escrow contracts of an economy that are waiting for an arbitrator.
+func (self *Backend) GetDisputedEscrowContracts(economy Economy) ([]EscrowContract, error) {
+	var contracts []EscrowContract
+	result := self.db.Where("economy_id = ? AND status = ?", economy.ID.String(), EC_Disputed).
+		Preload("BuyerAccount.Economy").Preload("SellerAccount").Order("id").Find(&contracts)
+	return contracts, result.Error
+}
+
+// CreateEscrowContract holds the amount of a contract from the buyer account, to be paid to the personal account of the
+// contract's seller once both confirm. Freezes, spending limits, VAT and savings penalties all apply when the funds are held,
+// which include any VAT paid on top. The contract expires after the given number of days.
+func (self *Backend) CreateEscrowContract(member SessionedMember, economy Economy, contract *EscrowContract, days uint) error {
+	if perm, err := self.HasPermission(member, P_TransferFunds, &contract.BuyerAccount, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to transfer funds from this account."}
+	}
+
+	if !contract.Amount.IsPositive() {
+		return BackendError{Message: "The amount held in escrow must be positive."}
+	} else if contract.Terms == "" || len(contract.Terms) > maxEscrowTerms {
+		return BackendError{Message: fmt.Sprintf("The terms of the contract have to be between 1 and %d characters long.", maxEscrowTerms)}
+	} else if days == 0 || days > maxEscrowDays {
+		return BackendError{Message: fmt.Sprintf("A contract has to expire within 1 to %d days.", maxEscrowDays)}
+	} else if contract.SellerID == member.User.ID {
+		return BackendError{Message: "You cannot make a contract with yourself."}
+	} else if contract.BuyerAccount.EconomyID != economy.ID.String() {
+		return BackendError{Message: "The buyer account has to be in this economy."}
+	}
+
+	seller, err := self.GetUserAccount(economy, contract.SellerID)
+	if err != nil {
+		return BackendError{Message: "The seller does not have an account in this economy."}
+	} else if seller.ID.Equals(contract.BuyerAccount.ID) {
+		return BackendError{Message: "You cannot make a contract with the same account."}
+	}
+
+	// the funds are paid out when both parties confirm, without anyone around to approve it
+	if policy, err := self.approvalPolicyTx(self.db, contract.BuyerAccount, contract.Amount); err != nil {
+		return err
+	} else if policy != nil {
+		return BackendError{Message: "Contracts from this account cannot exceed the amount above which transfers need approval."}
+	}
+
+	now := time.Now().UTC()
+	contract.EconomyID = economy.ID.String()
+	contract.BuyerID = member.User.ID
+	contract.BuyerAccountID = contract.BuyerAccount.ID
+	contract.SellerAccount, contract.SellerAccountID = seller, seller.ID
+	contract.Status = EC_Open
+	contract.CreatedAt = now
+	contract.ExpiresAt = now.AddDate(0, 0, int(days))
+
+	session := self.db.Begin()
+
+	// everything a transfer would check is checked now, since the payout is made from the hold without checking again
+	if err := self.checkTransferableTx(session, economy, &contract.BuyerAccount, &seller); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := self.priceEscrowTx(session, economy, contract, seller); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := self.checkSpendingLimitTx(session, member.User.ID, &contract.BuyerAccount, &seller, contract.Held); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if contract.OnBehalfOfID, err = self.actingAs(session, member, contract.BuyerAccount); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := session.Omit("BuyerAccount", "SellerAccount").Create(contract).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := self.holdFundsTx(session, member.User.ID, contract.BuyerAccount, contract.Held, fmt.Sprintf("Escrow contract #%d", contract.ID)); err != nil {
+		session.Rollback()
+		if errors.Is(err, InsufficientFundsError) {
+			return BackendError{Message: "The account does not have enough funds to hold for this contract."}
+		}
+		return err
+	}
+
+	details := fmt.Sprintf("holds %s in escrow for <@%s>", economy.FormatMoney(contract.Held), contract.SellerID)
+	if _, err := self.auditActingTx(session, member, contract.BuyerAccount, CUD_Create, "escrow contract", fmt.Sprint(contract.ID), details); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := session.Commit().Error; err != nil {
+		return err
+	}
+
+	contract.BuyerAccount.Economy = economy
+	return nil
+}
+
+// priceEscrowTx works out what the buyer of a contract pays and where it goes, the same way a purchase from the buyer
+// account would: the seller receives the amount less any inclusive VAT, while exclusive VAT and the penalty for withdrawing
+// from a locked up savings account are paid on top.
+func (self *Backend) priceEscrowTx(session *gorm.DB, economy Economy, contract *EscrowContract, seller Account) error {
+	debit, credit, taxes, err := self.transferTaxesTx(session, economy, &contract.BuyerAccount, &seller, contract.Amount, TT_Purchase)
+	if err != nil {
+		return err
+	}
+
+	penalty, fee, err := self.savingsPenaltyTx(session, &contract.BuyerAccount, contract.Amount)
+	if err != nil {
+		return err
+	}
+
+	held, err := debit.Neg()
+	if err != nil {
+		return err
+	} else if held, err = held.Add(fee); err != nil {
+		return err
+	}
+
+	contract.Held, contract.Credit, contract.Legs = held, credit, nil
+	for _, leg := range taxes {
+		entry_id := leg.Tax.EntryID
+		contract.Legs = append(contract.Legs, EscrowLeg{AccountID: leg.Tax.ToAccountID, TaxEntryID: &entry_id, TaxType: leg.Tax.TaxType, Basis: leg.Basis, Amount: leg.Amount})
+	}
+
+	if penalty != nil {
+		contract.Legs = append(contract.Legs, EscrowLeg{AccountID: penalty.AccountID, Basis: contract.Amount, Amount: penalty.Amount})
+	}
+	return nil
+}
+
+// payEscrowTx pays the funds just released from the hold of a contract to the seller and the accounts of its legs.
+// Everything was checked and worked out when the funds were held, so the payment only posts the amounts stored on the contract.
+// It is still recorded as a purchase from the buyer, which lets it be reversed like any other transfer.
+func (self *Backend) payEscrowTx(session *gorm.DB, contract *EscrowContract, memo string) (*Transfer, error) {
+	var legs []EscrowLeg
+	if err := session.Where("contract_id = ?", contract.ID).Order("id").Find(&legs).Error; err != nil {
+		return nil, err
+	}
+
+	debit, err := contract.Held.Neg()
+	if err != nil {
+		return nil, err
+	}
+
+	// the buyer's balance gets back exactly what is debited here, so an overdraft from a debt taken on in the meantime cannot stop the payment
+	journal := Journal{
+		EconomyID: contract.EconomyID,
+		JournalType: JT_Transfer,
+		ActorID: contract.BuyerID,
+		OnBehalfOfID: contract.OnBehalfOfID,
+		Memo: memo,
+		AllowOverdraft: true,
+		Postings: []Posting{
+			{AccountID: contract.BuyerAccountID, Amount: debit},
+			{AccountID: contract.SellerAccountID, Amount: contract.Credit},
+		},
+	}
+
+	var taxes []TaxLeg
+	for _, leg := range legs {
+		journal.Postings = append(journal.Postings, Posting{AccountID: leg.AccountID, Amount: leg.Amount, TaxEntryID: leg.TaxEntryID})
+		if leg.TaxEntryID != nil {
+			taxes = append(taxes, TaxLeg{Tax: Tax{EntryID: *leg.TaxEntryID, TaxType: leg.TaxType, ToAccountID: leg.AccountID}, Basis: leg.Basis, Amount: leg.Amount})
+		}
+	}
+
+	transfer := Transfer{
+		ActorID: contract.BuyerID,
+		FromAccountID: contract.BuyerAccountID,
+		ToAccountID: contract.SellerAccountID,
+		Amount: contract.Amount,
+		TransferType: TT_Purchase,
+	}
+
+	if err := self.postJournalTx(session, &journal, &transfer); err != nil {
+		return nil, err
+	}
+
+	if err := self.recordTransferReceiptsTx(session, &journal, Account{ID: contract.BuyerAccountID}, Account{ID: contract.SellerAccountID}, taxes); err != nil {
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+// settleEscrowTx releases the funds held for a contract, paying them to the seller if release is set or otherwise leaving
+// them with the buyer, and moves the contract from the expected status to its final one.
+func (self *Backend) settleEscrowTx(session *gorm.DB, contract *EscrowContract, expected uint8, release bool, updates map[string]any) error {
+	if economy, err := self.economyTx(session, contract.EconomyID); err != nil {
+		return err
+	} else if economy.Locked {
+		return EconomyLockedError
+	}
+
+	memo := fmt.Sprintf("Escrow contract #%d", contract.ID)
+	if err := self.releaseFundsTx(session, contract.BuyerID, contract.BuyerAccount, contract.Held, memo); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	updates["settled_at"] = &now
+	updates["status"] = EC_Refunded
+
+	if release {
+		transfer, err := self.payEscrowTx(session, contract, memo)
+		if err != nil {
+			return err
+		}
+
+		updates["status"] = EC_Released
+		updates["transfer_id"] = &transfer.TrxID
+		contract.TransferID = &transfer.TrxID
+	}
+
+	// settling only succeeds once, so a contract is never paid out or refunded twice
+	result := session.Model(&EscrowContract{}).Where("id = ? AND status = ?", contract.ID, expected).Updates(updates)
+	if err := result.Error; err != nil {
+		return err
+	} else if result.RowsAffected == 0 {
+		return BackendError{Message: "This contract has already been settled."}
+	}
+
+	contract.Status, contract.SettledAt = updates["status"].(uint8), &now
+	return nil
+}
+
+// ConfirmEscrowContract records that the buyer or the seller considers the trade done. Once both have, the held funds are
+// paid to the seller; it returns the transfer if it was made.
+func (self *Backend) ConfirmEscrowContract(member SessionedMember, contract *EscrowContract) (*Transfer, error) {
+	column := "buyer_confirmed"
+	if member.User.ID == contract.SellerID {
+		column = "seller_confirmed"
+	} else if member.User.ID != contract.BuyerID {
+		return nil, BackendError{Message: "Only the buyer and the seller can confirm this contract."}
+	}
+
+	if contract.Status != EC_Open {
+		return nil, BackendError{Message: "This contract is no longer open."}
+	} else if time.Now().After(contract.ExpiresAt) {
+		return nil, BackendError{Message: "This contract has expired."}
+	}
+
+	session := self.db.Begin()
+
+	result := session.Model(&EscrowContract{}).Where("id = ? AND status = ?", contract.ID, EC_Open).Update(column, true)
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return nil, err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return nil, BackendError{Message: "This contract is no longer open."}
+	}
+
+	if err := session.Where("id = ?", contract.ID).Select("buyer_confirmed", "seller_confirmed").First(contract).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	var transfer *Transfer
+	if contract.BuyerConfirmed && contract.SellerConfirmed {
+		if err := self.settleEscrowTx(session, contract, EC_Open, true, map[string]any{}); err != nil {
+			session.Rollback()
+			return nil, err
+		}
+
+		transfer = &Transfer{}
+		if err := session.Where("trx_id = ?", *contract.TransferID).First(transfer).Error; err != nil {
+			session.Rollback()
+			return nil, err
+		}
+	}
+
+	return transfer, session.Commit().Error
+}
+
+// CancelEscrowContract refunds the buyer of an open contract. The seller can cancel at any time, the buyer only as long
+// as the seller has not confirmed the trade.
+func (self *Backend) CancelEscrowContract(member SessionedMember, contract *EscrowContract) error {
+	if member.User.ID != contract.BuyerID && member.User.ID != contract.SellerID {
+		return BackendError{Message: "Only the buyer and the seller can cancel this contract."}
+	} else if contract.Status != EC_Open {
+		return BackendError{Message: "This contract is no longer open."}
+	} else if member.User.ID == contract.BuyerID && contract.SellerConfirmed {
+		return BackendError{Message: "The seller has already confirmed this contract, please open a dispute instead."}
+	}
+
+	session := self.db.Begin()
+
+	if err := self.settleEscrowTx(session, contract, EC_Open, false, map[string]any{"resolver_id": member.User.ID, "resolution": "cancelled"}); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	contract.ResolverID, contract.Resolution = member.User.ID, "cancelled"
+	return session.Commit().Error
+}
+
+// DisputeEscrowContract stops an open contract from being confirmed or expiring, until an arbitrator settles it.
+func (self *Backend) DisputeEscrowContract(member SessionedMember, contract *EscrowContract, reason string) error {
+	if member.User.ID != contract.BuyerID && member.User.ID != contract.SellerID {
+		return BackendError{Message: "Only the buyer and the seller can dispute this contract."}
+	} else if contract.Status != EC_Open {
+		return BackendError{Message: "This contract is no longer open."}
+	} else if reason == "" {
+		return BackendError{Message: "Please give a reason for the dispute."}
+	}
+
+	dispute := fmt.Sprintf("<@%s>: %s", member.User.ID, reason)
+	result := self.db.Model(&EscrowContract{}).Where("id = ? AND status = ?", contract.ID, EC_Open).Updates(map[string]any{"status": EC_Disputed, "dispute": dispute})
+	if err := result.Error; err != nil {
+		return err
+	} else if result.RowsAffected == 0 {
+		return BackendError{Message: "This contract is no longer open."}
+	}
+
+	contract.Status, contract.Dispute = EC_Disputed, dispute
+	return nil
+}
+
+// ResolveEscrowContract settles a disputed contract, releasing the funds to the seller or refunding the buyer.
+// Only arbitrators with P_ManageFunds in the economy may resolve disputes.
+func (self *Backend) ResolveEscrowContract(member SessionedMember, contract *EscrowContract, release bool, note string) error {
+	economy := contract.BuyerAccount.Economy
+	if perm, err := self.HasPermission(member, P_ManageFunds, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to arbitrate contracts in this economy."}
+	}
+
+	if contract.Status != EC_Disputed {
+		return BackendError{Message: "Only disputed contracts can be arbitrated."}
+	} else if member.User.ID == contract.BuyerID || member.User.ID == contract.SellerID {
+		return BackendError{Message: "You cannot arbitrate your own contract."}
+	}
+
+	session := self.db.Begin()
+
+	if err := self.settleEscrowTx(session, contract, EC_Disputed, release, map[string]any{"resolver_id": member.User.ID, "resolution": note}); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	outcome := "refunded"
+	if release {
+		outcome = "released"
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: contract.EconomyID,
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "escrow dispute",
+		Target: fmt.Sprint(contract.ID),
+		Details: fmt.Sprintf("%s %s held for <@%s> by <@%s>", outcome, economy.FormatMoney(contract.Held), contract.SellerID, contract.BuyerID),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	contract.ResolverID, contract.Resolution = member.User.ID, note
+	return session.Commit().Error
+}
+
+// ExpireEscrowContracts refunds the buyers of open contracts that expired before they were confirmed, and returns them.
+// Disputed contracts do not expire, and contracts in locked economies wait until they are unlocked.
+func (self *Backend) ExpireEscrowContracts(now time.Time) ([]EscrowContract, error) {
+	var contracts []EscrowContract
+	err := self.db.Where("status = ? AND expires_at <= ? AND economy_id NOT IN (?)", EC_Open, now.UTC(), self.lockedEconomies()).
+		Preload("BuyerAccount.Economy").Preload("SellerAccount").Find(&contracts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []EscrowContract
+	var errs []error
+	for _, contract := range contracts {
+		session := self.db.Begin()
+
+		if err := self.settleEscrowTx(session, &contract, EC_Open, false, map[string]any{"resolution": "expired"}); err != nil {
+			session.Rollback()
+			errs = append(errs, fmt.Errorf("escrow contract %d: %w", contract.ID, err))
+			continue
+		}
+
+		if err := session.Commit().Error; err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		contract.Resolution = "expired"
+		expired = append(expired, contract)
+	}
+
+	return expired, errors.Join(errs...)
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+)
+
+func TestEscrowHold(t *testing.T) {
+	settlements := []struct {
+		name 	string
+		settle 	func(backend *Backend, contract *EscrowContract) error
+		status 	uint8
+		buyer 	datatypes.Money
+		seller 	datatypes.Money
+	}{
+		{"confirmed by both", func(backend *Backend, contract *EscrowContract) error {
+			if _, err := backend.ConfirmEscrowContract(testMember("alice"), contract); err != nil {
+				return err
+			}
+			_, err := backend.ConfirmEscrowContract(testMember("bob"), contract)
+			return err
+		}, EC_Released, 7000, 3000},
+		{"cancelled by the seller", func(backend *Backend, contract *EscrowContract) error {
+			return backend.CancelEscrowContract(testMember("bob"), contract)
+		}, EC_Refunded, 10000, 0},
+		{"expired", func(backend *Backend, contract *EscrowContract) error {
+			_, err := backend.ExpireEscrowContracts(time.Now().AddDate(0, 0, 8))
+			return err
+		}, EC_Refunded, 10000, 0},
+		{"released by an arbitrator", func(backend *Backend, contract *EscrowContract) error {
+			if err := backend.DisputeEscrowContract(testMember("alice"), contract, "not delivered"); err != nil {
+				return err
+			}
+			return backend.ResolveEscrowContract(testMember("admin"), contract, true, "delivered after all")
+		}, EC_Released, 7000, 3000},
+		{"refunded by an arbitrator", func(backend *Backend, contract *EscrowContract) error {
+			if err := backend.DisputeEscrowContract(testMember("alice"), contract, "not delivered"); err != nil {
+				return err
+			}
+			return backend.ResolveEscrowContract(testMember("admin"), contract, false, "never delivered")
+		}, EC_Refunded, 10000, 0},
+	}
+
+	for _, test := range settlements {
+		t.Run(test.name, func(t *testing.T) {
+			backend, economy, alice, bob := newTestLedger(t)
+			if err := backend.MintFunds(testMember("admin"), &alice, 10000); err != nil {
+				t.Fatal(err)
+			}
+
+			contract := EscrowContract{BuyerAccount: reloadAccount(t, backend, alice), SellerID: "bob", Amount: 3000, Terms: "diamonds"}
+			if err := backend.CreateEscrowContract(testMember("alice"), economy, &contract, 7); err != nil {
+				t.Fatal(err)
+			}
+
+			// held funds can no longer be spent, but still belong to the buyer
+			if alice = reloadAccount(t, backend, alice); alice.Balance != 7000 || alice.TotalBalance != 10000 {
+				t.Fatalf("buyer balance while held = %d/%d; want 7000/10000", alice.Balance, alice.TotalBalance)
+			}
+
+			contract, err := backend.GetEscrowContractByID(contract.ID)
+			if err != nil {
+				t.Fatal(err)
+			} else if err := test.settle(backend, &contract); err != nil {
+				t.Fatal(err)
+			}
+
+			if contract, err = backend.GetEscrowContractByID(contract.ID); err != nil || contract.Status != test.status {
+				t.Errorf("contract status = %d, %v; want %d", contract.Status, err, test.status)
+			}
+
+			alice, bob = reloadAccount(t, backend, alice), reloadAccount(t, backend, bob)
+			if alice.Balance != test.buyer || alice.TotalBalance != test.buyer || bob.Balance != test.seller || bob.TotalBalance != test.seller {
+				t.Errorf("balances = %d/%d and %d/%d; want %d and %d", alice.Balance, alice.TotalBalance, bob.Balance, bob.TotalBalance, test.buyer, test.seller)
+			}
+
+			// a settled contract cannot be paid out or refunded a second time
+			if _, err := backend.ConfirmEscrowContract(testMember("bob"), &contract); err == nil {
+				t.Error("ConfirmEscrowContract of a settled contract succeeded")
+			}
+			if err := backend.CancelEscrowContract(testMember("bob"), &contract); err == nil {
+				t.Error("CancelEscrowContract of a settled contract succeeded")
+			}
+
+			if discrepancies, err := backend.VerifyLedger(testMember("admin"), economy); err != nil || len(discrepancies) != 0 {
+				t.Errorf("VerifyLedger = %+v, %v; want no discrepancies", discrepancies, err)
+			}
+		})
+	}
+}
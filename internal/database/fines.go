@@ -0,0 +1,451 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+const maxSanctions = 25
+
+// GetFinePolicy returns where the fines of an economy are paid into, or nil if it has not designated an account yet.
+func (self *Backend) GetFinePolicy(economy Economy) (*FinePolicy, error) {
+	var policy FinePolicy
+	err := self.db.Where("economy_id = ?", economy.ID.String()).Preload("Account.Economy").First(&policy).Error
+	if errors.Is(err, RecordNotFoundError) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// SetFinePolicy designates the government account fines are paid into and how offenders who cannot afford a fine are treated.
+func (self *Backend) SetFinePolicy(member SessionedMember, economy Economy, policy *FinePolicy) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage the fines of this economy."}
+	}
+
+	if policy.Account.EconomyID != economy.ID.String() {
+		return BackendError{Message: "The fines account has to be in this economy."}
+	} else if policy.Account.AccountType != AT_Government {
+		return BackendError{Message: "Fines can only be paid into a government account."}
+	} else if policy.Account.Deleted {
+		return BackendError{Message: "A closed account cannot receive fines."}
+	} else if policy.Shortfall > FS_Debt {
+		return BackendError{Message: "Unknown shortfall policy."}
+	}
+
+	policy.EconomyID = economy.ID.String()
+	policy.ActorID = member.User.ID
+	policy.AccountID = policy.Account.ID
+
+	session := self.db.Begin()
+
+	if err := session.Omit("Account").Save(policy).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "fine policy",
+		Target: economy.ID.String(),
+		Details: fmt.Sprintf("fines are paid into %s, shortfalls: %s", policy.Account.AccountName, strings.ToLower(ShortfallName(policy.Shortfall))),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+func (self *Backend) GetSanctionByID(id uint) (Sanction, error) {
+	var sanction Sanction
+	result := self.db.Where("id = ?", id).Preload("FromAccount.Economy").Preload("ToAccount").First(&sanction)
+	if err := result.Error; err != nil {
+		return Sanction{}, err
+	}
+	return sanction, nil
+}
+
+// GetSanctions returns the most recent sanctions of a user in an economy. Anyone can see their own, while the sanctions of others
+// are only visible to those who issue fines or manage the economy.
+func (self *Backend) GetSanctions(member SessionedMember, economy Economy, user_id string) ([]Sanction, error) {
+	if user_id != member.User.ID {
+		if perm, err := self.HasPermission(member, P_IssueFines, nil, &economy); err != nil {
+			return nil, err
+		} else if !perm {
+			if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+				return nil, err
+			} else if !perm {
+				return nil, BackendError{Message: "You do not have the permission to view the sanctions of others."}
+			}
+		}
+	}
+
+	var sanctions []Sanction
+	result := self.db.Where("economy_id = ? AND user_id = ?", economy.ID.String(), user_id).
+		Preload("FromAccount.Economy").Preload("ToAccount").Order("id desc").Limit(maxSanctions).Find(&sanctions)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	return sanctions, nil
+}
+
+// GetAppealedSanctions returns the sanctions of an economy waiting for their appeal to be reviewed.
+func (self *Backend) GetAppealedSanctions(economy Economy) ([]Sanction, error) {
+	var sanctions []Sanction
+	result := self.db.Where("economy_id = ? AND status = ?", economy.ID.String(), SS_Appealed).
+		Preload("FromAccount.Economy").Preload("ToAccount").Order("appealed_at").Limit(maxSanctions).Find(&sanctions)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	return sanctions, nil
+}
+
+// fineTransferTx moves an amount from an offender into the fines account without taxes, overdrawing the offender if allowed.
+// Fines are collected even from frozen accounts, but not while the economy is locked.
+func (self *Backend) fineTransferTx(session *gorm.DB, actor_id string, from *Account, to *Account, amount datatypes.Money, overdraft bool) (*Transfer, error) {
+	if from.Deleted || to.Deleted {
+		return nil, BackendError{Message: "You cannot transfer funds to or from a closed account."}
+	}
+
+	if economy, err := self.economyTx(session, from.EconomyID); err != nil {
+		return nil, err
+	} else if economy.Locked {
+		return nil, EconomyLockedError
+	}
+
+	debit, err := amount.Neg()
+	if err != nil {
+		return nil, err
+	}
+
+	journal := Journal{
+		EconomyID: from.EconomyID,
+		JournalType: JT_Transfer,
+		ActorID: actor_id,
+		AllowOverdraft: overdraft,
+		Postings: []Posting{
+			{AccountID: from.ID, Amount: debit},
+			{AccountID: to.ID, Amount: amount},
+		},
+	}
+
+	transfer := Transfer{
+		ActorID: actor_id,
+		FromAccountID: from.ID,
+		ToAccountID: to.ID,
+		Amount: amount,
+		TransferType: TT_Personal,
+	}
+
+	if err := self.postJournalTx(session, &journal, &transfer); err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+// IssueFine fines a user, taking the amount from their personal account into the economy's fines account.
+// If they cannot afford it, the economy's fine policy decides whether the fine is refused, overdraws them or leaves them in debt.
+func (self *Backend) IssueFine(member SessionedMember, economy Economy, user_id string, amount datatypes.Money, reason string) (*Sanction, error) {
+	if perm, err := self.HasPermission(member, P_IssueFines, nil, &economy); err != nil {
+		return nil, err
+	} else if !perm {
+		return nil, BackendError{Message: "You do not have the permission to issue fines in this economy."}
+	}
+
+	if !amount.IsPositive() {
+		return nil, BackendError{Message: "The fine must be positive."}
+	} else if user_id == member.User.ID {
+		return nil, BackendError{Message: "You cannot fine yourself."}
+	} else if strings.TrimSpace(reason) == "" {
+		return nil, BackendError{Message: "A fine needs a reason."}
+	}
+
+	policy, err := self.GetFinePolicy(economy)
+	if err != nil {
+		return nil, err
+	} else if policy == nil {
+		return nil, BackendError{Message: "This economy has not designated an account for fines yet."}
+	}
+
+	from, err := self.GetUserAccount(economy, user_id)
+	if err != nil {
+		return nil, BackendError{Message: "This user does not have an account in this economy."}
+	}
+
+	session := self.db.Begin()
+
+	var to Account
+	if err := session.Where("id = ?", policy.AccountID).First(&to).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	} else if err := session.Where("id = ?", from.ID).First(&from).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	sanction := Sanction{
+		EconomyID: economy.ID.String(),
+		UserID: user_id,
+		IssuerID: member.User.ID,
+		Reason: reason,
+		FromAccountID: from.ID,
+		ToAccountID: to.ID,
+		Amount: amount,
+		Collected: amount,
+	}
+
+	// under a debt policy, only what the offender holds is collected now
+	if policy.Shortfall == FS_Debt && from.Balance < amount {
+		sanction.Collected = max(from.Balance, 0)
+		if sanction.Debt, err = amount.Sub(sanction.Collected); err != nil {
+			session.Rollback()
+			return nil, err
+		}
+	}
+
+	if sanction.Collected.IsPositive() {
+		transfer, err := self.fineTransferTx(session, member.User.ID, &from, &to, sanction.Collected, policy.Shortfall == FS_Overdraft)
+		if errors.Is(err, InsufficientFundsError) {
+			session.Rollback()
+			return nil, BackendError{Message: fmt.Sprintf("<@%s> cannot afford this fine.", user_id)}
+		} else if err != nil {
+			session.Rollback()
+			return nil, err
+		}
+		sanction.TransferID = &transfer.TrxID
+	}
+
+	if err := session.Omit("FromAccount", "ToAccount").Create(&sanction).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	err = self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Create,
+		Action: "fine",
+		Target: fmt.Sprint(sanction.ID),
+		Details: fmt.Sprintf("fined <@%s> %s for %s", user_id, economy.FormatMoney(amount), reason),
+	})
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	from.Economy = economy
+	sanction.FromAccount, sanction.ToAccount = from, to
+	return &sanction, session.Commit().Error
+}
+
+// AppealSanction lets the sanctioned user ask the economy's admins to overturn a fine, once.
+func (self *Backend) AppealSanction(member SessionedMember, sanction *Sanction, reason string) error {
+	if sanction.UserID != member.User.ID {
+		return BackendError{Message: "You can only appeal your own sanctions."}
+	} else if sanction.Status != SS_Active {
+		return BackendError{Message: fmt.Sprintf("This sanction cannot be appealed, it is %s.", strings.ToLower(SanctionStatusName(sanction.Status)))}
+	} else if strings.TrimSpace(reason) == "" {
+		return BackendError{Message: "An appeal needs a reason."}
+	}
+
+	now := time.Now().UTC()
+	session := self.db.Begin()
+
+	result := session.Model(&Sanction{}).Where("id = ? AND status = ?", sanction.ID, SS_Active).
+		Updates(map[string]any{"status": SS_Appealed, "appeal": reason, "appealed_at": now})
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return BackendError{Message: "This sanction has already been appealed or reviewed."}
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: sanction.EconomyID,
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "fine",
+		Target: fmt.Sprint(sanction.ID),
+		Details: fmt.Sprintf("appealed: %s", reason),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := session.Commit().Error; err != nil {
+		return err
+	}
+
+	sanction.Status, sanction.Appeal, sanction.AppealedAt = SS_Appealed, reason, &now
+	return nil
+}
+
+// ReviewSanction decides an appeal. Overturning a sanction refunds what was collected from the fines account and forgives
+// any debt; admins can also overturn sanctions that were never appealed.
+func (self *Backend) ReviewSanction(member SessionedMember, economy Economy, sanction *Sanction, overturn bool, note string) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to review sanctions in this economy."}
+	}
+
+	if sanction.EconomyID != economy.ID.String() {
+		return BackendError{Message: "This sanction does not belong to this economy."}
+	} else if sanction.Status != SS_Active && sanction.Status != SS_Appealed {
+		return BackendError{Message: "This sanction has already been reviewed."}
+	} else if !overturn && sanction.Status != SS_Appealed {
+		return BackendError{Message: "Only appealed sanctions can be upheld."}
+	}
+
+	now := time.Now().UTC()
+	updates := map[string]any{"status": SS_Upheld, "reviewer_id": member.User.ID, "review_note": note, "reviewed_at": now}
+
+	session := self.db.Begin()
+
+	var refund *Transfer
+	if overturn {
+		updates["status"], updates["debt"] = SS_Overturned, datatypes.Money(0)
+
+		if sanction.Collected.IsPositive() {
+			var from, to Account
+			if err := session.Where("id = ?", sanction.ToAccountID).First(&from).Error; err != nil {
+				session.Rollback()
+				return err
+			} else if err := session.Where("id = ?", sanction.FromAccountID).First(&to).Error; err != nil {
+				session.Rollback()
+				return err
+			}
+
+			var err error
+			refund, err = self.postTransferTx(session, member.User.ID, nil, &from, &to, sanction.Collected, TT_Personal, false)
+			if errors.Is(err, InsufficientFundsError) {
+				session.Rollback()
+				return BackendError{Message: fmt.Sprintf("%s no longer holds the %s to refund.", from.AccountName, economy.FormatMoney(sanction.Collected))}
+			} else if err != nil {
+				session.Rollback()
+				return err
+			}
+			updates["refund_id"] = refund.TrxID
+		}
+	}
+
+	result := session.Model(&Sanction{}).Where("id = ? AND status = ?", sanction.ID, sanction.Status).Updates(updates)
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return BackendError{Message: "This sanction has already been reviewed."}
+	}
+
+	details := fmt.Sprintf("upheld the fine of <@%s>", sanction.UserID)
+	if overturn {
+		details = fmt.Sprintf("overturned the fine of <@%s>, refunding %s", sanction.UserID, economy.FormatMoney(sanction.Collected))
+	}
+	if note != "" {
+		details += ": " + note
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "fine",
+		Target: fmt.Sprint(sanction.ID),
+		Details: details,
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := session.Commit().Error; err != nil {
+		return err
+	}
+
+	sanction.Status, sanction.ReviewerID, sanction.ReviewNote, sanction.ReviewedAt = updates["status"].(uint8), member.User.ID, note, &now
+	if overturn {
+		sanction.Debt = 0
+		if refund != nil {
+			sanction.RefundID = &refund.TrxID
+		}
+	}
+	return nil
+}
+
+// SettleSanction pays off as much of the debt of a fine as the sanctioned user can afford from their personal account,
+// and returns the amount paid.
+func (self *Backend) SettleSanction(member SessionedMember, sanction *Sanction) (datatypes.Money, error) {
+	if sanction.UserID != member.User.ID {
+		return 0, BackendError{Message: "You can only settle your own fines."}
+	} else if sanction.Status == SS_Overturned || !sanction.Debt.IsPositive() {
+		return 0, BackendError{Message: "You do not owe anything on this fine."}
+	}
+
+	session := self.db.Begin()
+
+	var from, to Account
+	if err := session.Where("id = ?", sanction.FromAccountID).First(&from).Error; err != nil {
+		session.Rollback()
+		return 0, err
+	} else if err := session.Where("id = ?", sanction.ToAccountID).First(&to).Error; err != nil {
+		session.Rollback()
+		return 0, err
+	}
+
+	amount := min(sanction.Debt, from.Balance)
+	if !amount.IsPositive() {
+		session.Rollback()
+		return 0, InsufficientFundsError
+	}
+
+	if _, err := self.postTransferTx(session, member.User.ID, nil, &from, &to, amount, TT_Personal, false); err != nil {
+		session.Rollback()
+		return 0, err
+	}
+
+	debt, err := sanction.Debt.Sub(amount)
+	if err != nil {
+		session.Rollback()
+		return 0, err
+	}
+
+	collected, err := sanction.Collected.Add(amount)
+	if err != nil {
+		session.Rollback()
+		return 0, err
+	}
+
+	// the debt condition makes this a compare-and-swap, so settling twice at once only pays once
+	result := session.Model(&Sanction{}).Where("id = ? AND debt = ? AND status <> ?", sanction.ID, sanction.Debt, SS_Overturned).
+		Updates(map[string]any{"debt": debt, "collected": collected})
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return 0, err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return 0, BackendError{Message: "This fine was changed in the meantime, please try again."}
+	}
+
+	if err := session.Commit().Error; err != nil {
+		return 0, err
+	}
+
+	sanction.Debt, sanction.Collected = debt, collected
+	return amount, nil
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+var EconomyLockedError = BackendError{Message: "This economy is locked by its admins, no funds can be moved until it is unlocked."}
+var AccountFrozenError = BackendError{Message: "This account is frozen and cannot send transfers."}
+var RecipientFrozenError = BackendError{Message: "The recipient account is frozen and cannot receive transfers."}
+
+// checkTransferableTx fails if the economy is locked or a freeze stops funds from leaving from or reaching to.
+// The freezes are read inside the transaction, since the accounts passed in may have been loaded before one was put in place.
+func (self *Backend) checkTransferableTx(session *gorm.DB, economy Economy, from *Account, to *Account) error {
+	if economy.Locked {
+		return EconomyLockedError
+	}
+
+	var accounts []Account
+	if err := session.Select("id", "frozen", "frozen_incoming").Where("id IN ?", []string{from.ID.String(), to.ID.String()}).Find(&accounts).Error; err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		if account.ID.Equals(from.ID) && account.Frozen {
+			return AccountFrozenError
+		} else if account.ID.Equals(to.ID) && account.FrozenIncoming {
+			return RecipientFrozenError
+		}
+	}
+	return nil
+}
+
+// lockedEconomies selects the IDs of the locked economies, whose scheduled jobs are skipped until they are unlocked.
+func (self *Backend) lockedEconomies() *gorm.DB {
+	return self.db.Model(&Economy{}).Select("id").Where("locked = ?", true)
+}
+
+// FreezeAccount stops an account from sending transfers, and from receiving them as well if incoming is set.
+// Freezing with neither outgoing nor incoming set lifts the freeze. Only admins with P_ManageFunds on the account may freeze it.
+func (self *Backend) FreezeAccount(member SessionedMember, account *Account, outgoing bool, incoming bool, reason string) error {
+	if perm, err := self.HasPermission(member, P_ManageFunds, account, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to freeze this account."}
+	} else if account.AccountType == AT_Reserve || account.AccountType == AT_Escrow {
+		return BackendError{Message: "The reserve and escrow accounts cannot be frozen."}
+	}
+
+	session := self.db.Begin()
+
+	if err := session.Model(&Account{}).Where("id = ?", account.ID).Updates(map[string]any{"frozen": outgoing, "frozen_incoming": incoming}).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	details := "lifted the freeze"
+	if outgoing && incoming {
+		details = "froze all transfers"
+	} else if outgoing {
+		details = "froze outgoing transfers"
+	} else if incoming {
+		details = "froze incoming transfers"
+	}
+	if reason != "" {
+		details += ": " + reason
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: account.EconomyID,
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "freeze",
+		Target: account.ID.String(),
+		Details: fmt.Sprintf("%s of %s", details, account.AccountName),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := session.Commit().Error; err != nil {
+		return err
+	}
+
+	account.Frozen, account.FrozenIncoming = outgoing, incoming
+	return nil
+}
+
+// LockEconomy pauses or resumes every transfer, recurring payment and scheduled job of an economy.
+// Admins can still mint, burn and reverse transfers while it is locked, to clean up after an exploit.
+func (self *Backend) LockEconomy(member SessionedMember, economy *Economy, locked bool, reason string) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to lock this economy."}
+	}
+
+	session := self.db.Begin()
+
+	result := session.Model(&Economy{}).Where("id = ? AND locked = ?", economy.ID, !locked).Update("locked", locked)
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		if locked {
+			return BackendError{Message: "This economy is already locked."}
+		}
+		return BackendError{Message: "This economy is not locked."}
+	}
+
+	details := "unlocked the economy"
+	if locked {
+		details = "locked the economy"
+	}
+	if reason != "" {
+		details += ": " + reason
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "economy lock",
+		Target: economy.ID.String(),
+		Details: details,
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := session.Commit().Error; err != nil {
+		return err
+	}
+
+	economy.Locked = locked
+	return nil
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+// escrowAccountTx returns the economy's escrow account, creating it if needed.
+// Held funds are moved into the escrow account, so they stay on the ledger while their owner cannot spend them.
+func (self *Backend) escrowAccountTx(session *gorm.DB, economy_id string) (Account, error) {
+	var escrow Account
+	err := session.Where(map[string]any{"economy_id": economy_id, "account_type": AT_Escrow}).Attrs(Account{
+		ID: datatypes.NewUUIDv4(),
+		AccountName: "Escrow",
+		AccountType: AT_Escrow,
+	}).FirstOrCreate(&escrow).Error
+	return escrow, err
+}
+
+// holdFundsTx moves funds of an account into escrow. The balance of the account drops while its total balance,
+// which includes held funds, stays the same.
+func (self *Backend) holdFundsTx(session *gorm.DB, actor_id string, account Account, amount datatypes.Money, memo string) error {
+	return self.moveHeldFundsTx(session, actor_id, account, amount, memo, false)
+}
+
+// releaseFundsTx returns held funds from escrow to the account they were held for.
+func (self *Backend) releaseFundsTx(session *gorm.DB, actor_id string, account Account, amount datatypes.Money, memo string) error {
+	return self.moveHeldFundsTx(session, actor_id, account, amount, memo, true)
+}
+
+func (self *Backend) moveHeldFundsTx(session *gorm.DB, actor_id string, account Account, amount datatypes.Money, memo string, release bool) error {
+	if !amount.IsPositive() {
+		return nil
+	}
+
+	escrow, err := self.escrowAccountTx(session, account.EconomyID)
+	if err != nil {
+		return err
+	}
+
+	debit, err := amount.Neg()
+	if err != nil {
+		return err
+	}
+
+	journal := Journal{
+		EconomyID: account.EconomyID,
+		JournalType: JT_Hold,
+		ActorID: actor_id,
+		Memo: memo,
+		Postings: []Posting{
+			{AccountID: account.ID, Amount: debit, Held: true},
+			{AccountID: escrow.ID, Amount: amount},
+		},
+	}
+
+	if release {
+		journal.JournalType = JT_Release
+		journal.Postings[0].Amount, journal.Postings[1].Amount = amount, debit
+	}
+
+	return self.postJournalTx(session, &journal, nil)
+}
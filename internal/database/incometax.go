@@ -0,0 +1,94 @@
+package database
+
+import (
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+func (self *Backend) economyTx(session *gorm.DB, economy_id string) (Economy, error) {
+	var economy Economy
+	err := session.Where("id = ?", economy_id).First(&economy).Error
+	return economy, err
+}
+
+// incomePeriodStart returns the start of the income tax period of the economy that contains t, in the economy's time zone.
+func incomePeriodStart(economy Economy, t time.Time) time.Time {
+	return PeriodStart(t, economy.IncomeTaxPeriod, economy.Location()).UTC()
+}
+
+// incomeTaxTx adds a payment to the recipient's taxable income of the current period and returns the income tax owed on it.
+// Only the part of the brackets between the previous and the new running total is applied, so the marginal rates stay correct.
+func (self *Backend) incomeTaxTx(session *gorm.DB, economy Economy, account *Account, amount datatypes.Money, at time.Time) ([]TaxLeg, error) {
+	income := TaxableIncome{AccountID: account.ID, PeriodStart: incomePeriodStart(economy, at)}
+	if err := session.Where(map[string]any{"account_id": income.AccountID, "period_start": income.PeriodStart}).FirstOrCreate(&income).Error; err != nil {
+		return nil, err
+	}
+
+	total, err := income.Total.Add(amount)
+	if err != nil {
+		return nil, err
+	}
+
+	brackets, err := self.bracketsTx(session, economy.ID.String(), TX_Income, account.AccountType)
+	if err != nil {
+		return nil, err
+	}
+
+	legs, err := progressiveTax(brackets, income.Total, total)
+	if err != nil {
+		return nil, err
+	}
+
+	result := session.Model(&TaxableIncome{}).Where("id = ? AND total = ?", income.ID, income.Total).Update("total", total)
+	if err := result.Error; err != nil {
+		return nil, err
+	} else if result.RowsAffected == 0 {
+		return nil, BackendError{Message: "The account was modified by another transaction, please try again."}
+	}
+
+	return legs, nil
+}
+
+// deductIncomeTx removes a reversed payment from the taxable income of the period it was received in.
+func (self *Backend) deductIncomeTx(session *gorm.DB, economy Economy, account_id datatypes.UUID, amount datatypes.Money, at time.Time) error {
+	var income TaxableIncome
+	err := session.Where("account_id = ? AND period_start = ?", account_id, incomePeriodStart(economy, at)).First(&income).Error
+	if err == RecordNotFoundError {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return session.Model(&TaxableIncome{}).Where("id = ?", income.ID).Update("total", max(income.Total - amount, 0)).Error
+}
+
+// GetTaxableIncome returns the income an account received during the current income tax period of its economy.
+func (self *Backend) GetTaxableIncome(account Account) (TaxableIncome, error) {
+	economy, err := self.economyTx(self.db, account.EconomyID)
+	if err != nil {
+		return TaxableIncome{}, err
+	}
+
+	income := TaxableIncome{AccountID: account.ID, PeriodStart: incomePeriodStart(economy, time.Now())}
+	err = self.db.Where("account_id = ? AND period_start = ?", income.AccountID, income.PeriodStart).Find(&income).Error
+	return income, err
+}
+
+func (self *Backend) SetIncomeTaxPeriod(member SessionedMember, economy *Economy, period uint8) error {
+	if perm, err := self.HasPermission(member, P_ManageTaxBrackets, nil, economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage taxes in this economy."}
+	} else if period == PD_None || period > PD_Monthly {
+		return BackendError{Message: "Income tax periods have to be daily, weekly or monthly."}
+	}
+
+	if err := self.db.Model(&Economy{}).Where("id = ?", economy.ID).Update("income_tax_period", period).Error; err != nil {
+		return err
+	}
+
+	economy.IncomeTaxPeriod = period
+	return nil
+}
@@ -0,0 +1,473 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+// LedgerDiscrepancy describes an account whose maintained balance does not match the sum of its postings.
+type LedgerDiscrepancy struct {
+	Account 	Account
+	Balance 	datatypes.Money
+	Expected 	datatypes.Money
+}
+
+var InsufficientFundsError = BackendError{Message: "The account does not have enough funds for this transaction."}
+
+// reserveAccountTx returns the economy's reserve account, creating it if needed.
+// Minted money is drawn from the reserve and burned money returns to it, so its balance is the negated money supply.
+func (self *Backend) reserveAccountTx(session *gorm.DB, economy_id string) (Account, error) {
+	var reserve Account
+	err := session.Where(map[string]any{"economy_id": economy_id, "account_type": AT_Reserve}).Attrs(Account{
+		ID: datatypes.NewUUIDv4(),
+		AccountName: "Reserve",
+		AccountType: AT_Reserve,
+	}).FirstOrCreate(&reserve).Error
+	return reserve, err
+}
+
+// postJournalTx records a journal, along with the transfer it belongs to if any, applies each of its postings
+// to the balance projection of the affected account and appends it to the economy's journal chain.
+func (self *Backend) postJournalTx(session *gorm.DB, journal *Journal, transfer *Transfer) error {
+	var sum datatypes.Money
+	for _, posting := range journal.Postings {
+		var err error
+		if sum, err = sum.Add(posting.Amount); err != nil {
+			return err
+		}
+	}
+
+	if len(journal.Postings) < 2 || !sum.IsZero() {
+		return fmt.Errorf("journal of type %d is not balanced", journal.JournalType)
+	}
+
+	if journal.CreatedAt.IsZero() {
+		journal.CreatedAt = chainTime(time.Now())
+	}
+
+	if err := session.Create(journal).Error; err != nil {
+		return err
+	}
+
+	if transfer != nil {
+		transfer.JournalID = journal.ID
+		transfer.CreatedAt = journal.CreatedAt
+		if err := session.Omit("FromAccount", "ToAccount", "Journal").Create(transfer).Error; err != nil {
+			return err
+		}
+		journal.Transfers = []Transfer{*transfer}
+	}
+
+	for _, posting := range journal.Postings {
+		if err := self.applyPostingTx(session, posting, journal.AllowOverdraft); err != nil {
+			return err
+		}
+	}
+
+	return self.sealJournalTx(session, journal)
+}
+
+func (self *Backend) applyPostingTx(session *gorm.DB, posting Posting, allow_overdraft bool) error {
+	var account Account
+	if err := session.Where("id = ?", posting.AccountID).First(&account).Error; err != nil {
+		return err
+	}
+
+	balance, err := account.Balance.Add(posting.Amount)
+	if err != nil {
+		return err
+	}
+
+	total := account.TotalBalance
+	if !posting.Held {
+		if total, err = total.Add(posting.Amount); err != nil {
+			return err
+		}
+	}
+
+	if balance.IsNegative() && posting.Amount.IsNegative() && account.AccountType != AT_Reserve && !allow_overdraft {
+		return InsufficientFundsError
+	}
+
+	// the balance condition makes this a compare-and-swap, so concurrent postings cannot overwrite each other
+	result := session.Model(&Account{}).Where("id = ? AND balance = ?", account.ID, account.Balance).Updates(map[string]any{
+		"balance": balance,
+		"total_balance": total,
+	})
+
+	if err := result.Error; err != nil {
+		return err
+	} else if result.RowsAffected == 0 {
+		return BackendError{Message: "The account was modified by another transaction, please try again."}
+	}
+
+	return nil
+}
+
+// transferTx moves funds between two accounts inside an existing database transaction, without any permission checks.
+// on_behalf_of is the account the actor was logged in as, if any.
+func (self *Backend) transferTx(session *gorm.DB, actor_id string, on_behalf_of *datatypes.UUID, from *Account, to *Account, amount datatypes.Money, transfer_type uint8) (*Transfer, error) {
+	return self.postTransferTx(session, actor_id, on_behalf_of, from, to, amount, transfer_type, true)
+}
+
+// postTransferTx is transferTx, optionally without collecting taxes, for payments the economy has exempted from them.
+func (self *Backend) postTransferTx(session *gorm.DB, actor_id string, on_behalf_of *datatypes.UUID, from *Account, to *Account, amount datatypes.Money, transfer_type uint8, taxed bool) (*Transfer, error) {
+	if !amount.IsPositive() {
+		return nil, BackendError{Message: "The transfer amount must be positive."}
+	} else if from.ID.Equals(to.ID) {
+		return nil, BackendError{Message: "You cannot transfer funds to the same account."}
+	} else if from.EconomyID != to.EconomyID {
+		return nil, BackendError{Message: "You cannot transfer funds between different economies."}
+	} else if from.Deleted || to.Deleted {
+		return nil, BackendError{Message: "You cannot transfer funds to or from a closed account."}
+	} else if from.AccountType == AT_Escrow || to.AccountType == AT_Escrow {
+		return nil, BackendError{Message: "Held funds cannot be transferred directly."}
+	}
+
+	economy, err := self.economyTx(session, from.EconomyID)
+	if err != nil {
+		return nil, err
+	} else if err := self.checkTransferableTx(session, economy, from, to); err != nil {
+		return nil, err
+	}
+
+	debit, credit, legs := -amount, amount, []TaxLeg(nil)
+	if taxed {
+		if debit, credit, legs, err = self.transferTaxesTx(session, economy, from, to, amount, transfer_type); err != nil {
+			return nil, err
+		}
+	}
+
+	// withdrawing from a locked up savings account also pays the central bank a penalty
+	penalty, fee, err := self.savingsPenaltyTx(session, from, amount)
+	if err != nil {
+		return nil, err
+	} else if debit, err = debit.Sub(fee); err != nil {
+		return nil, err
+	}
+
+	if debited, err := debit.Neg(); err != nil {
+		return nil, err
+	} else if err := self.checkSpendingLimitTx(session, actor_id, from, to, debited); err != nil {
+		return nil, err
+	}
+
+	journal := Journal{
+		EconomyID: from.EconomyID,
+		JournalType: JT_Transfer,
+		ActorID: actor_id,
+		OnBehalfOfID: on_behalf_of,
+		Postings: append([]Posting{
+			{AccountID: from.ID, Amount: debit},
+			{AccountID: to.ID, Amount: credit},
+		}, taxPostings(legs)...),
+	}
+
+	if penalty != nil {
+		journal.Postings = append(journal.Postings, *penalty)
+	}
+
+	transfer := Transfer{
+		ActorID: actor_id,
+		FromAccountID: from.ID,
+		ToAccountID: to.ID,
+		Amount: amount,
+		TransferType: transfer_type,
+	}
+
+	if err := self.postJournalTx(session, &journal, &transfer); err != nil {
+		return nil, err
+	}
+
+	if err := self.recordTransferReceiptsTx(session, &journal, *from, *to, legs); err != nil {
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+func (self *Backend) CreateTransfer(member SessionedMember, from *Account, to *Account, amount datatypes.Money, transfer_type uint8) (*Transfer, error) {
+	if perm, err := self.HasPermission(member, P_TransferFunds, from, nil); err != nil {
+		return nil, err
+	} else if !perm {
+		return nil, BackendError{Message: "You do not have the permission to transfer funds from this account."}
+	}
+
+	session := self.db.Begin()
+
+	transfer, err := self.createTransferTx(session, member, from, to, amount, transfer_type)
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	return transfer, session.Commit().Error
+}
+
+// createTransferTx makes a transfer on behalf of a member inside an existing database transaction, checking the approval policy
+// of the account and recording it in the audit log if they are logged in as the account. The caller checks the member's permission.
+func (self *Backend) createTransferTx(session *gorm.DB, member SessionedMember, from *Account, to *Account, amount datatypes.Money, transfer_type uint8) (*Transfer, error) {
+	if policy, err := self.approvalPolicyTx(session, *from, amount); err != nil {
+		return nil, err
+	} else if policy != nil {
+		return nil, ApprovalRequiredError
+	}
+
+	on_behalf_of, err := self.actingAs(session, member, *from)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer, err := self.transferTx(session, member.User.ID, on_behalf_of, from, to, amount, transfer_type)
+	if err != nil {
+		return nil, err
+	}
+
+	if on_behalf_of != nil {
+		economy, err := self.economyTx(session, from.EconomyID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = self.auditTx(session, AuditEntry{
+			EconomyID: from.EconomyID,
+			ActorID: member.User.ID,
+			OnBehalfOfID: on_behalf_of,
+			Change: CUD_Create,
+			Action: "transfer",
+			Target: fmt.Sprint(transfer.TrxID),
+			Details: fmt.Sprintf("transferred %s from %s to %s", economy.FormatMoney(amount), from.AccountName, to.AccountName),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return transfer, nil
+}
+
+// mintTx issues new money into an account from the economy's reserve, or destroys it when burn is set.
+func (self *Backend) mintTx(session *gorm.DB, actor_id string, account *Account, amount datatypes.Money, burn bool) (*Journal, error) {
+	if !amount.IsPositive() {
+		return nil, BackendError{Message: "The amount must be positive."}
+	} else if account.Deleted {
+		return nil, BackendError{Message: "You cannot change the balance of a closed account."}
+	}
+
+	reserve, err := self.reserveAccountTx(session, account.EconomyID)
+	if err != nil {
+		return nil, err
+	}
+
+	debit, err := amount.Neg()
+	if err != nil {
+		return nil, err
+	}
+
+	journal := Journal{
+		EconomyID: account.EconomyID,
+		JournalType: JT_Mint,
+		ActorID: actor_id,
+		Postings: []Posting{
+			{AccountID: reserve.ID, Amount: debit},
+			{AccountID: account.ID, Amount: amount},
+		},
+	}
+
+	if burn {
+		journal.JournalType = JT_Burn
+		journal.Postings = []Posting{
+			{AccountID: account.ID, Amount: debit},
+			{AccountID: reserve.ID, Amount: amount},
+		}
+	}
+
+	if err := self.postJournalTx(session, &journal, nil); err != nil {
+		return nil, err
+	}
+
+	return &journal, nil
+}
+
+func (self *Backend) MintFunds(member SessionedMember, account *Account, amount datatypes.Money) error {
+	return self.changeSupply(member, account, amount, false)
+}
+
+func (self *Backend) BurnFunds(member SessionedMember, account *Account, amount datatypes.Money) error {
+	return self.changeSupply(member, account, amount, true)
+}
+
+func (self *Backend) changeSupply(member SessionedMember, account *Account, amount datatypes.Money, burn bool) error {
+	if perm, err := self.HasPermission(member, P_ManageFunds, account, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage funds of this account."}
+	}
+
+	session := self.db.Begin()
+
+	if _, err := self.mintTx(session, member.User.ID, account, amount, burn); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// postingSums returns the sum of postings of every account in the economy that has any.
+func (self *Backend) postingSums(session *gorm.DB, economy_id string) (map[string]datatypes.Money, error) {
+	var rows []struct {
+		AccountID 	string
+		Total 		datatypes.Money
+	}
+
+	err := session.Model(&Posting{}).
+		Select("postings.account_id AS account_id, SUM(postings.amount) AS total").
+		Joins("JOIN journals ON journals.id = postings.journal_id").
+		Where("journals.economy_id = ?", economy_id).
+		Group("postings.account_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]datatypes.Money, len(rows))
+	for _, row := range rows {
+		sums[row.AccountID] = row.Total
+	}
+	return sums, nil
+}
+
+// VerifyLedger reports every account in the economy whose balance differs from the sum of its postings.
+func (self *Backend) VerifyLedger(member SessionedMember, economy Economy) ([]LedgerDiscrepancy, error) {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return nil, err
+	} else if !perm {
+		return nil, BackendError{Message: "You do not have the permission to verify the ledger of this economy."}
+	}
+
+	sums, err := self.postingSums(self.db, economy.ID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []Account
+	if err := self.db.Where("economy_id = ?", economy.ID.String()).Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+
+	var discrepancies []LedgerDiscrepancy
+	for _, account := range accounts {
+		if expected := sums[account.ID.String()]; expected != account.Balance {
+			discrepancies = append(discrepancies, LedgerDiscrepancy{Account: account, Balance: account.Balance, Expected: expected})
+		}
+	}
+
+	return discrepancies, nil
+}
+
+// RebuildBalances recomputes the balance of every account in the economy from its postings.
+func (self *Backend) RebuildBalances(member SessionedMember, economy Economy) error {
+	if perm, err := self.HasPermission(member, P_ManageFunds, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage funds in this economy."}
+	}
+
+	session := self.db.Begin()
+
+	sums, err := self.postingSums(session, economy.ID.String())
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	var accounts []Account
+	if err := session.Where("economy_id = ?", economy.ID.String()).Find(&accounts).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	for _, account := range accounts {
+		expected := sums[account.ID.String()]
+		if expected == account.Balance {
+			continue
+		}
+
+		// postings do not record whether they moved funds into or out of a hold, so the total balance cannot be summed up
+		// from them; it is corrected by the same amount as the balance, which keeps any funds on hold counted in it
+		total, err := account.TotalBalance.Add(expected - account.Balance)
+		if err != nil {
+			session.Rollback()
+			return err
+		}
+
+		err = session.Model(&Account{}).Where("id = ?", account.ID).Updates(map[string]any{
+			"balance": expected,
+			"total_balance": total,
+		}).Error
+		if err != nil {
+			session.Rollback()
+			return err
+		}
+	}
+
+	return session.Commit().Error
+}
+
+// MigrateLedger gives accounts that predate the ledger an opening journal, so that their balances can be derived from postings.
+func (self *Backend) MigrateLedger() error {
+	var accounts []Account
+	err := self.db.Where("balance <> 0 AND account_type <> ?", AT_Reserve).
+		Where("NOT EXISTS (SELECT 1 FROM postings WHERE postings.account_id = accounts.id)").
+		Find(&accounts).Error
+	if err != nil {
+		return err
+	}
+
+	for _, account := range accounts {
+		session := self.db.Begin()
+
+		reserve, err := self.reserveAccountTx(session, account.EconomyID)
+		if err != nil {
+			session.Rollback()
+			return err
+		}
+
+		journal := Journal{
+			EconomyID: account.EconomyID,
+			JournalType: JT_Mint,
+			Memo: "Opening balance",
+			CreatedAt: chainTime(time.Now()),
+			Postings: []Posting{
+				{AccountID: reserve.ID, Amount: -account.Balance},
+				{AccountID: account.ID, Amount: account.Balance},
+			},
+		}
+
+		// the account already holds the balance, so only the reserve's projection has to follow
+		if err := session.Create(&journal).Error; err != nil {
+			session.Rollback()
+			return err
+		}
+
+		if err := self.applyPostingTx(session, journal.Postings[0], false); err != nil {
+			session.Rollback()
+			return err
+		}
+
+		if err := self.sealJournalTx(session, &journal); err != nil {
+			session.Rollback()
+			return err
+		}
+
+		if err := session.Commit().Error; err != nil {
+			return err
+		}
+	}
+
+	return self.sealLegacyJournals()
+}
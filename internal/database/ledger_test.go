@@ -0,0 +1,177 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// newTestLedger sets up an economy with an admin who may manage it, and two users who may spend from their own accounts.
+func newTestLedger(t *testing.T) (*Backend, Economy, Account, Account) {
+	t.Helper()
+
+	backend := newTestBackend(t)
+	economy := newTestEconomy(t, backend, "economy")
+	alice := newTestAccount(t, backend, economy, "alice", "alice", AT_User)
+	bob := newTestAccount(t, backend, economy, "bob", "bob", AT_User)
+
+	for _, permission := range []uint8{P_ManageFunds, P_ManageEconomies} {
+		grantPermission(t, backend, "admin", permission, nil, nil, true)
+	}
+	for _, user_id := range []string{"alice", "bob"} {
+		grantPermission(t, backend, user_id, P_TransferFunds, nil, nil, true)
+	}
+	return backend, economy, alice, bob
+}
+
+// reloadAccount returns the account as currently stored, for checking its balances.
+func reloadAccount(t *testing.T, backend *Backend, account Account) Account {
+	t.Helper()
+
+	reloaded, err := backend.GetAccountByID(account.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reloaded
+}
+
+func TestPostJournalBalanced(t *testing.T) {
+	backend, economy, alice, bob := newTestLedger(t)
+
+	tests := []struct {
+		name 		string
+		postings 	[]Posting
+	}{
+		{"no postings", nil},
+		{"single posting", []Posting{{AccountID: alice.ID, Amount: 0}}},
+		{"unbalanced", []Posting{{AccountID: alice.ID, Amount: -100}, {AccountID: bob.ID, Amount: 99}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			journal := Journal{EconomyID: economy.ID.String(), JournalType: JT_Mint, ActorID: "admin", Postings: test.postings}
+			if err := backend.postJournalTx(backend.db, &journal, nil); err == nil {
+				t.Error("postJournalTx accepted a journal that does not balance")
+			}
+		})
+	}
+}
+
+func TestTransferPostings(t *testing.T) {
+	backend, economy, alice, bob := newTestLedger(t)
+
+	if err := backend.MintFunds(testMember("admin"), &alice, 10000); err != nil {
+		t.Fatal(err)
+	}
+	alice = reloadAccount(t, backend, alice)
+
+	if _, err := backend.CreateTransfer(testMember("alice"), &alice, &bob, 2500, TT_Personal); err != nil {
+		t.Fatal(err)
+	}
+	alice = reloadAccount(t, backend, alice)
+
+	if _, err := backend.CreateTransfer(testMember("alice"), &alice, &bob, 7501, TT_Personal); !errors.Is(err, InsufficientFundsError) {
+		t.Errorf("overdrawing transfer = %v; want %v", err, InsufficientFundsError)
+	}
+
+	alice, bob = reloadAccount(t, backend, alice), reloadAccount(t, backend, bob)
+	if alice.Balance != 7500 || alice.TotalBalance != 7500 || bob.Balance != 2500 || bob.TotalBalance != 2500 {
+		t.Errorf("balances = %d/%d and %d/%d; want 7500/7500 and 2500/2500", alice.Balance, alice.TotalBalance, bob.Balance, bob.TotalBalance)
+	}
+
+	sums, err := backend.postingSums(backend.db, economy.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reserve, err := backend.reserveAccountTx(backend.db, economy.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every journal balances, so the reserve holds the negated money supply
+	if sums[alice.ID.String()] != alice.Balance || sums[bob.ID.String()] != bob.Balance || sums[reserve.ID.String()] != -10000 || reserve.Balance != -10000 {
+		t.Errorf("posting sums = %v, reserve balance %d; want them to match the balances", sums, reserve.Balance)
+	}
+}
+
+func TestApplyPostingCompareAndSwap(t *testing.T) {
+	backend, _, alice, bob := newTestLedger(t)
+
+	if err := backend.MintFunds(testMember("admin"), &alice, 10000); err != nil {
+		t.Fatal(err)
+	}
+
+	// another transaction changes the balance between the posting reading it and writing it back
+	raced := false
+	err := backend.db.Callback().Update().Before("gorm:update").Register("test:race", func(db *gorm.DB) {
+		if raced {
+			return
+		}
+		raced = true
+		db.Session(&gorm.Session{NewDB: true}).Exec("UPDATE accounts SET balance = balance + 1 WHERE id = ?", alice.ID)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posting := Posting{AccountID: alice.ID, Amount: -100}
+	if err := backend.applyPostingTx(backend.db, posting, false); !raced || !errors.As(err, &BackendError{}) {
+		t.Fatalf("applyPostingTx of a balance that changed after it was read = %v; want it to be refused", err)
+	}
+
+	alice = reloadAccount(t, backend, alice)
+	if alice.Balance != 10001 {
+		t.Errorf("balance = %d; want the concurrent change of 10001 to survive", alice.Balance)
+	}
+
+	if err := backend.db.Callback().Update().Remove("test:race"); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.applyPostingTx(backend.db, Posting{AccountID: bob.ID, Amount: 100}, false); err != nil {
+		t.Errorf("applyPostingTx on an unchanged balance = %v", err)
+	}
+}
+
+func TestVerifyLedger(t *testing.T) {
+	backend, economy, alice, bob := newTestLedger(t)
+
+	if err := backend.MintFunds(testMember("admin"), &alice, 10000); err != nil {
+		t.Fatal(err)
+	}
+	alice = reloadAccount(t, backend, alice)
+	if _, err := backend.CreateTransfer(testMember("alice"), &alice, &bob, 2500, TT_Personal); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := backend.VerifyLedger(testMember("alice"), economy); err == nil {
+		t.Error("VerifyLedger allowed a member without P_ManageEconomies")
+	}
+	if discrepancies, err := backend.VerifyLedger(testMember("admin"), economy); err != nil || len(discrepancies) != 0 {
+		t.Fatalf("VerifyLedger of an intact ledger = %+v, %v; want no discrepancies", discrepancies, err)
+	}
+
+	if err := backend.db.Model(&Account{}).Where("id = ?", bob.ID).Updates(map[string]any{"balance": 1, "total_balance": 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	discrepancies, err := backend.VerifyLedger(testMember("admin"), economy)
+	if err != nil || len(discrepancies) != 1 || !discrepancies[0].Account.ID.Equals(bob.ID) || discrepancies[0].Balance != 1 || discrepancies[0].Expected != 2500 {
+		t.Fatalf("VerifyLedger of a tampered balance = %+v, %v; want bob at 1 instead of 2500", discrepancies, err)
+	}
+
+	if err := backend.RebuildBalances(testMember("alice"), economy); err == nil {
+		t.Error("RebuildBalances allowed a member without P_ManageFunds")
+	}
+	if err := backend.RebuildBalances(testMember("admin"), economy); err != nil {
+		t.Fatal(err)
+	}
+
+	if discrepancies, err := backend.VerifyLedger(testMember("admin"), economy); err != nil || len(discrepancies) != 0 {
+		t.Errorf("VerifyLedger after RebuildBalances = %+v, %v; want no discrepancies", discrepancies, err)
+	}
+	if bob = reloadAccount(t, backend, bob); bob.Balance != 2500 || bob.TotalBalance != 2500 {
+		t.Errorf("rebuilt balance = %d/%d; want 2500/2500", bob.Balance, bob.TotalBalance)
+	}
+}
@@ -0,0 +1,306 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+// GetSpendingLimits returns the spending limits of the users of an account, which anyone who may view the account can see.
+func (self *Backend) GetSpendingLimits(member SessionedMember, account Account) ([]SpendingLimit, error) {
+	if _, err := self.ViewAccount(member, account); err != nil {
+		return nil, err
+	}
+
+	var limits []SpendingLimit
+	result := self.db.Where("account_id = ?", account.ID).Preload("Recipients.Account").Order("id").Find(&limits)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// spendingLimitTx returns the spending limit of a user on an account, or nil if they have none.
+func (self *Backend) spendingLimitTx(session *gorm.DB, account_id datatypes.UUID, user_id string) (*SpendingLimit, error) {
+	var limit SpendingLimit
+	err := session.Where("account_id = ? AND user_id = ?", account_id, user_id).Preload("Recipients").First(&limit).Error
+	if errors.Is(err, RecordNotFoundError) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &limit, nil
+}
+
+// checkSpendingLimitTx fails if a transfer the actor makes from an account would exceed their spending limit on it.
+// Limits count everything debited from the account, so debited includes any VAT, transaction tax or penalty paid on top.
+// Every transfer goes through this check, including standing orders and approved proposals, which count towards
+// the limits of whoever set them up. Owners are never limited, even if they were before the account was handed to them.
+func (self *Backend) checkSpendingLimitTx(session *gorm.DB, actor_id string, from *Account, to *Account, debited datatypes.Money) error {
+	if actor_id == from.OwnerID {
+		return nil
+	}
+
+	limit, err := self.spendingLimitTx(session, from.ID, actor_id)
+	if err != nil || limit == nil {
+		return err
+	}
+
+	economy, err := self.economyTx(session, from.EconomyID)
+	if err != nil {
+		return err
+	}
+
+	if limit.PerTransfer.IsPositive() && debited > limit.PerTransfer {
+		return BackendError{Message: fmt.Sprintf("You can transfer at most %s at once from this account, including taxes.", economy.FormatMoney(limit.PerTransfer))}
+	}
+
+	if limit.RestrictRecipients && !slices.ContainsFunc(limit.Recipients, func(recipient AllowedRecipient) bool { return recipient.AccountID.Equals(to.ID) }) {
+		return BackendError{Message: "You are not allowed to pay this recipient from this account."}
+	}
+
+	if !limit.PerPeriod.IsPositive() {
+		return nil
+	}
+
+	start := PeriodStart(time.Now(), limit.Period, economy.Location()).UTC()
+
+	var spent, held datatypes.Money
+	err = session.Model(&Posting{}).Select("COALESCE(SUM(-postings.amount), 0)").
+		Joins("JOIN transfers ON transfers.journal_id = postings.journal_id").
+		Where("transfers.actor_id = ? AND transfers.from_account_id = ? AND transfers.reversal_of_id IS NULL AND transfers.created_at >= ?", actor_id, from.ID, start).
+		Where("postings.account_id = ? AND postings.amount < 0", from.ID).
+		Scan(&spent).Error
+	if err != nil {
+		return err
+	}
+
+	// open escrow contracts count as spent, since they are paid out later without checking the limit again
+	err = session.Model(&EscrowContract{}).Select("COALESCE(SUM(held), 0)").
+		Where("buyer_id = ? AND buyer_account_id = ? AND (status = ? OR status = ?) AND created_at >= ?", actor_id, from.ID, EC_Open, EC_Disputed, start).
+		Scan(&held).Error
+	if err != nil {
+		return err
+	} else if spent, err = spent.Add(held); err != nil {
+		return err
+	}
+
+	total, err := spent.Add(debited)
+	if err != nil {
+		return err
+	} else if total > limit.PerPeriod {
+		left, _ := limit.PerPeriod.Sub(spent)
+		return BackendError{Message: fmt.Sprintf("This transfer exceeds your %s spending limit on this account; you can transfer %s more until it resets.", PeriodName(limit.Period), economy.FormatMoney(max(left, 0)))}
+	}
+
+	return nil
+}
+
+// SetSpendingLimit limits what a user may transfer from an account, keeping any allowed recipients they already have.
+// The owner of an account and anyone with P_ManagePermissions on it can set limits for everyone but the owner.
+func (self *Backend) SetSpendingLimit(member SessionedMember, account Account, limit *SpendingLimit) error {
+	if err := self.canManageMembers(member, account); err != nil {
+		return err
+	} else if limit.UserID == account.OwnerID {
+		return BackendError{Message: "The owner of an account cannot be limited."}
+	} else if limit.PerTransfer.IsNegative() || limit.PerPeriod.IsNegative() {
+		return BackendError{Message: "Spending limits cannot be negative."}
+	} else if limit.PerPeriod.IsPositive() && (limit.Period == PD_None || limit.Period > PD_Monthly) {
+		return BackendError{Message: "A spending limit per period needs a daily, weekly or monthly period."}
+	}
+
+	session := self.db.Begin()
+
+	existing, err := self.spendingLimitTx(session, account.ID, limit.UserID)
+	if err != nil {
+		session.Rollback()
+		return err
+	} else if existing != nil {
+		limit.ID, limit.RestrictRecipients = existing.ID, existing.RestrictRecipients
+	}
+
+	limit.AccountID = account.ID
+	if err := session.Omit("Account", "Recipients").Save(limit).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	economy, err := self.economyTx(session, account.EconomyID)
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err = self.auditTx(session, AuditEntry{
+		EconomyID: account.EconomyID,
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "spending limit",
+		Target: account.ID.String(),
+		Details: fmt.Sprintf("limited <@%s> on %s to %s per transfer and %s %s", limit.UserID, account.AccountName, economy.FormatMoney(limit.PerTransfer), economy.FormatMoney(limit.PerPeriod), PeriodName(limit.Period)),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// RemoveSpendingLimit lifts every limit of a user on an account, including their allowed recipients.
+func (self *Backend) RemoveSpendingLimit(member SessionedMember, account Account, user_id string) error {
+	if err := self.canManageMembers(member, account); err != nil {
+		return err
+	}
+
+	session := self.db.Begin()
+
+	limit, err := self.spendingLimitTx(session, account.ID, user_id)
+	if err != nil {
+		session.Rollback()
+		return err
+	} else if limit == nil {
+		session.Rollback()
+		return BackendError{Message: "This user has no spending limits on the account."}
+	}
+
+	if err := session.Where("limit_id = ?", limit.ID).Delete(&AllowedRecipient{}).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := session.Delete(limit).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err = self.auditTx(session, AuditEntry{
+		EconomyID: account.EconomyID,
+		ActorID: member.User.ID,
+		Change: CUD_Delete,
+		Action: "spending limit",
+		Target: account.ID.String(),
+		Details: fmt.Sprintf("lifted the limits of <@%s> on %s", user_id, account.AccountName),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// SetAllowedRecipient adds an account to or removes it from the accounts a user may pay from an account.
+// Once a user has been allowed any recipient, they can pay no other account, even after the last of them is removed,
+// until the restriction is lifted with ClearAllowedRecipients.
+func (self *Backend) SetAllowedRecipient(member SessionedMember, account Account, user_id string, recipient Account, allowed bool) error {
+	if err := self.canManageMembers(member, account); err != nil {
+		return err
+	} else if user_id == account.OwnerID {
+		return BackendError{Message: "The owner of an account cannot be limited."}
+	} else if recipient.EconomyID != account.EconomyID {
+		return BackendError{Message: "The recipient has to be in the same economy."}
+	}
+
+	session := self.db.Begin()
+
+	limit, err := self.spendingLimitTx(session, account.ID, user_id)
+	if err == nil && limit == nil && allowed {
+		limit = &SpendingLimit{AccountID: account.ID, UserID: user_id}
+		err = session.Omit("Account", "Recipients").Create(limit).Error
+	}
+
+	if err != nil {
+		session.Rollback()
+		return err
+	} else if limit == nil {
+		session.Rollback()
+		return BackendError{Message: "This user has no allowed recipients on the account."}
+	}
+
+	details := fmt.Sprintf("allowed <@%s> to pay %s from %s", user_id, recipient.AccountName, account.AccountName)
+	if allowed {
+		err = session.Where(AllowedRecipient{LimitID: limit.ID, AccountID: recipient.ID}).FirstOrCreate(&AllowedRecipient{}).Error
+		if err == nil && !limit.RestrictRecipients {
+			err = session.Model(limit).Update("restrict_recipients", true).Error
+		}
+	} else {
+		details = fmt.Sprintf("no longer allowed <@%s> to pay %s from %s", user_id, recipient.AccountName, account.AccountName)
+		err = session.Where("limit_id = ? AND account_id = ?", limit.ID, recipient.ID).Delete(&AllowedRecipient{}).Error
+	}
+
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err = self.auditTx(session, AuditEntry{
+		EconomyID: account.EconomyID,
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "spending limit",
+		Target: account.ID.String(),
+		Details: details,
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// ClearAllowedRecipients lifts the recipient restriction of a user on an account, letting them pay any account again
+// within their other limits.
+func (self *Backend) ClearAllowedRecipients(member SessionedMember, account Account, user_id string) error {
+	if err := self.canManageMembers(member, account); err != nil {
+		return err
+	}
+
+	session := self.db.Begin()
+
+	limit, err := self.spendingLimitTx(session, account.ID, user_id)
+	if err != nil {
+		session.Rollback()
+		return err
+	} else if limit == nil || !limit.RestrictRecipients {
+		session.Rollback()
+		return BackendError{Message: "This user is not restricted to any recipients on the account."}
+	}
+
+	if err := session.Where("limit_id = ?", limit.ID).Delete(&AllowedRecipient{}).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := session.Model(limit).Update("restrict_recipients", false).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err = self.auditTx(session, AuditEntry{
+		EconomyID: account.EconomyID,
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "spending limit",
+		Target: account.ID.String(),
+		Details: fmt.Sprintf("allowed <@%s> to pay any recipient from %s", user_id, account.AccountName),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// MigrateSpendingLimits restricts the users of limits that predate RestrictRecipients to the allowed recipients they already have.
+func (self *Backend) MigrateSpendingLimits() error {
+	return self.db.Model(&SpendingLimit{}).Where("restrict_recipients = ?", false).
+		Where("EXISTS (SELECT 1 FROM allowed_recipients WHERE allowed_recipients.limit_id = spending_limits.id)").
+		Update("restrict_recipients", true).Error
+}
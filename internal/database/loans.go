@@ -0,0 +1,438 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+// LoanDefaultAfter is how many installments in a row a borrower can miss before their loan defaults.
+const LoanDefaultAfter = 3
+
+const maxLoanInstallments = 520
+
+func (self *Backend) GetLoanByID(id uint) (Loan, error) {
+	var loan Loan
+	result := self.db.Where("id = ?", id).Preload("LenderAccount.Economy").Preload("BorrowerAccount").First(&loan)
+	if err := result.Error; err != nil {
+		return Loan{}, err
+	}
+	return loan, nil
+}
+
+// GetLoans returns the open loans a member has lent or borrowed in an economy, including offers that have not been answered yet.
+func (self *Backend) GetLoans(member SessionedMember, economy Economy) ([]Loan, error) {
+	var loans []Loan
+	result := self.db.Where("economy_id = ? AND (status = ? OR status = ? OR status = ?)", economy.ID.String(), LS_Offered, LS_Active, LS_Defaulted).
+		Where("lender_id = ? OR borrower_id = ?", member.User.ID, member.User.ID).
+		Preload("LenderAccount.Economy").Preload("BorrowerAccount").Order("id").Find(&loans)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	return loans, nil
+}
+
+// loanInstallment returns the level installment that repays a principal along with rate basis points of interest per period
+// on the outstanding principal in the given number of installments, rounded up so the last one is never larger.
+func loanInstallment(principal datatypes.Money, rate uint, installments uint) (datatypes.Money, error) {
+	if rate == 0 {
+		padded, err := principal.Add(datatypes.Money(installments - 1))
+		if err != nil {
+			return 0, err
+		}
+		return padded.MulRate(1, int64(installments))
+	}
+
+	// the annuity P * r / (1 - (1 + r)^-n) with r = rate / B, where B is BasisPoints, is P * rate * (B + rate)^n / (B * ((B + rate)^n - B^n)),
+	// which is worked out exactly so that large principals do not lose precision, and rounded up once at the end
+	n := big.NewInt(int64(installments))
+	growth := new(big.Int).Exp(big.NewInt(BasisPoints + int64(rate)), n, nil)
+	base := new(big.Int).Exp(big.NewInt(BasisPoints), n, nil)
+
+	numerator := new(big.Int).Mul(big.NewInt(int64(principal)), big.NewInt(int64(rate)))
+	numerator.Mul(numerator, growth)
+	denominator := new(big.Int).Mul(big.NewInt(BasisPoints), growth.Sub(growth, base))
+
+	installment, remainder := new(big.Int).QuoRem(numerator, denominator, new(big.Int))
+	if remainder.Sign() > 0 {
+		installment.Add(installment, big.NewInt(1))
+	}
+
+	if !installment.IsInt64() {
+		return 0, datatypes.ErrMoneyOverflow
+	}
+	return datatypes.Money(installment.Int64()), nil
+}
+
+// Outstanding returns everything the borrower still owes on a loan.
+func (loan Loan) Outstanding() (datatypes.Money, error) {
+	return loan.OutstandingPrincipal.Add(loan.OutstandingInterest)
+}
+
+// NextInstallment returns what the next installment of a loan collects, which is less than the regular installment
+// once that would repay more than is owed after the period's interest.
+func (loan Loan) NextInstallment() (datatypes.Money, error) {
+	interest, err := loan.OutstandingPrincipal.MulRate(int64(loan.Rate), BasisPoints)
+	if err != nil {
+		return 0, err
+	}
+
+	owed, err := loan.Outstanding()
+	if err != nil {
+		return 0, err
+	}
+
+	if owed, err = owed.Add(interest); err != nil {
+		return 0, err
+	}
+	return min(loan.Installment, owed), nil
+}
+
+// applyPayment settles the outstanding interest of a loan with a payment first and the principal with the rest,
+// marking the loan repaid once nothing is owed anymore.
+func (loan *Loan) applyPayment(paid datatypes.Money, now time.Time) error {
+	interest := min(paid, loan.OutstandingInterest)
+
+	var err error
+	if loan.OutstandingInterest, err = loan.OutstandingInterest.Sub(interest); err != nil {
+		return err
+	}
+
+	principal, err := paid.Sub(interest)
+	if err != nil {
+		return err
+	}
+
+	if loan.OutstandingPrincipal, err = loan.OutstandingPrincipal.Sub(min(principal, loan.OutstandingPrincipal)); err != nil {
+		return err
+	}
+
+	if loan.OutstandingPrincipal.IsZero() && loan.OutstandingInterest.IsZero() {
+		settled := now.UTC()
+		loan.Status, loan.SettledAt = LS_Repaid, &settled
+	}
+	return nil
+}
+
+// saveLoanTx stores the balance and status of a loan. The previous balance makes this a compare-and-swap, so concurrent
+// repayments and collections cannot overwrite each other.
+func (self *Backend) saveLoanTx(session *gorm.DB, loan Loan, previous Loan) error {
+	result := session.Model(&Loan{}).Where("id = ? AND status = ? AND outstanding_principal = ? AND outstanding_interest = ?", loan.ID, previous.Status, previous.OutstandingPrincipal, previous.OutstandingInterest).
+		Updates(map[string]any{
+			"outstanding_principal": loan.OutstandingPrincipal,
+			"outstanding_interest": loan.OutstandingInterest,
+			"missed": loan.Missed,
+			"status": loan.Status,
+			"settled_at": loan.SettledAt,
+		})
+
+	if err := result.Error; err != nil {
+		return err
+	} else if result.RowsAffected == 0 {
+		return BackendError{Message: "The loan was modified by another transaction, please try again."}
+	}
+	return nil
+}
+
+// settleInstallmentTx accrues a period of interest on a loan and applies the installment its standing order paid, which is zero
+// if the payment failed. Missing too many installments in a row, or the order ending early, defaults the loan.
+func (self *Backend) settleInstallmentTx(session *gorm.DB, loan_id uint, paid datatypes.Money, ended bool) (*Loan, error) {
+	var loan Loan
+	if err := session.Where("id = ?", loan_id).First(&loan).Error; err != nil {
+		return nil, err
+	} else if loan.Status != LS_Active && loan.Status != LS_Defaulted {
+		return &loan, nil
+	}
+	previous := loan
+
+	interest, err := loan.OutstandingPrincipal.MulRate(int64(loan.Rate), BasisPoints)
+	if err != nil {
+		return nil, err
+	} else if loan.OutstandingInterest, err = loan.OutstandingInterest.Add(interest); err != nil {
+		return nil, err
+	}
+
+	if paid.IsPositive() {
+		loan.Missed = 0
+	} else {
+		loan.Missed++
+	}
+
+	if err := loan.applyPayment(paid, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if loan.Status == LS_Active && (ended || loan.Missed >= LoanDefaultAfter) {
+		loan.Status = LS_Defaulted
+	}
+
+	if err := self.saveLoanTx(session, loan, previous); err != nil {
+		return nil, err
+	}
+	return &loan, nil
+}
+
+// OfferLoan offers a loan from the loan's LenderAccount to its borrower, which they have to accept before the principal is paid out.
+// The member has to be able to transfer funds from the lender account.
+func (self *Backend) OfferLoan(member SessionedMember, economy Economy, loan *Loan) error {
+	if perm, err := self.HasPermission(member, P_TransferFunds, &loan.LenderAccount, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to lend from this account."}
+	}
+
+	if !loan.Principal.IsPositive() {
+		return BackendError{Message: "The principal of a loan must be positive."}
+	} else if loan.Installments == 0 || loan.Installments > maxLoanInstallments {
+		return BackendError{Message: fmt.Sprintf("A loan has to be repaid in 1 to %d installments.", maxLoanInstallments)}
+	} else if loan.PaymentInterval == PD_None || loan.PaymentInterval > PD_Monthly {
+		return BackendError{Message: "A loan has to be repaid daily, weekly or monthly."}
+	} else if loan.Rate > BasisPoints {
+		return BackendError{Message: "The interest rate cannot exceed 100% per installment."}
+	} else if loan.BorrowerID == member.User.ID {
+		return BackendError{Message: "You cannot lend to yourself."}
+	} else if loan.LenderAccount.EconomyID != economy.ID.String() {
+		return BackendError{Message: "The lender account has to be in this economy."}
+	} else if loan.LenderAccount.Deleted {
+		return BackendError{Message: "You cannot lend from a closed account."}
+	}
+
+	borrower, err := self.GetUserAccount(economy, loan.BorrowerID)
+	if err != nil {
+		return BackendError{Message: "The borrower does not have an account in this economy."}
+	} else if borrower.ID.Equals(loan.LenderAccount.ID) {
+		return BackendError{Message: "You cannot lend to the same account."}
+	}
+
+	// the principal is paid out when the borrower accepts, without anyone around to approve it
+	if policy, err := self.approvalPolicyTx(self.db, loan.LenderAccount, loan.Principal); err != nil {
+		return err
+	} else if policy != nil {
+		return BackendError{Message: "Loans from this account cannot exceed the amount above which transfers need approval."}
+	}
+
+	if loan.Installment, err = loanInstallment(loan.Principal, loan.Rate, loan.Installments); err != nil {
+		return err
+	}
+
+	loan.EconomyID = economy.ID.String()
+	loan.LenderID = member.User.ID
+	loan.LenderAccountID = loan.LenderAccount.ID
+	loan.BorrowerAccount, loan.BorrowerAccountID = borrower, borrower.ID
+	loan.Status = LS_Offered
+
+	session := self.db.Begin()
+
+	if err := session.Omit("LenderAccount", "BorrowerAccount").Create(loan).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	details := fmt.Sprintf("loan of %s to <@%s> at %s %s", economy.FormatMoney(loan.Principal), loan.BorrowerID, FormatBasisPoints(loan.Rate), PeriodName(loan.PaymentInterval))
+	if _, err := self.auditActingTx(session, member, loan.LenderAccount, CUD_Create, "loan", fmt.Sprint(loan.ID), details); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// AcceptLoan pays out the principal of an offered loan to the borrower and sets up the standing order that repays it,
+// with its first installment one period from now.
+func (self *Backend) AcceptLoan(member SessionedMember, loan *Loan) (*Transfer, error) {
+	if loan.BorrowerID != member.User.ID {
+		return nil, BackendError{Message: "Only the borrower can accept this loan."}
+	} else if loan.Status != LS_Offered {
+		return nil, BackendError{Message: "This loan is no longer on offer."}
+	}
+
+	now := time.Now().UTC()
+	session := self.db.Begin()
+
+	var lender, borrower Account
+	if err := session.Where("id = ?", loan.LenderAccountID).First(&lender).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	} else if err := session.Where("id = ?", loan.BorrowerAccountID).First(&borrower).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	transfer, err := self.postTransferTx(session, loan.LenderID, nil, &lender, &borrower, loan.Principal, TT_Personal, false)
+	if errors.Is(err, InsufficientFundsError) {
+		session.Rollback()
+		return nil, BackendError{Message: "The lender no longer has the funds for this loan."}
+	} else if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	order := RecurringTransfer{
+		EntryID: datatypes.NewUUIDv4().String(),
+		ActorID: member.User.ID,
+		FromAccountID: borrower.ID,
+		ToAccountID: lender.ID,
+		Amount: loan.Installment,
+		TransferType: TT_Personal,
+		PaymentInterval: uint(loan.PaymentInterval),
+		NextPayment: NextPeriod(now, loan.PaymentInterval).UTC(),
+		Status: RS_Active,
+		LoanID: &loan.ID,
+	}
+
+	if err := session.Omit("FromAccount", "ToAccount").Create(&order).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	result := session.Model(&Loan{}).Where("id = ? AND status = ?", loan.ID, LS_Offered).Updates(map[string]any{
+		"status": LS_Active,
+		"accepted_at": now,
+		"recurring_id": order.EntryID,
+		"outstanding_principal": loan.Principal,
+	})
+
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return nil, err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return nil, BackendError{Message: "This loan is no longer on offer."}
+	}
+
+	if err := session.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	loan.Status, loan.AcceptedAt, loan.RecurringID, loan.OutstandingPrincipal = LS_Active, &now, &order.EntryID, loan.Principal
+	return transfer, nil
+}
+
+// setLoanOfferStatus answers an offered loan, failing if it has been answered in the meantime.
+func (self *Backend) setLoanOfferStatus(loan *Loan, status uint8) error {
+	if loan.Status != LS_Offered {
+		return BackendError{Message: "This loan is no longer on offer."}
+	}
+
+	now := time.Now().UTC()
+	result := self.db.Model(&Loan{}).Where("id = ? AND status = ?", loan.ID, LS_Offered).Updates(map[string]any{"status": status, "settled_at": now})
+	if err := result.Error; err != nil {
+		return err
+	} else if result.RowsAffected == 0 {
+		return BackendError{Message: "This loan is no longer on offer."}
+	}
+
+	loan.Status, loan.SettledAt = status, &now
+	return nil
+}
+
+// DeclineLoan turns down an offered loan.
+func (self *Backend) DeclineLoan(member SessionedMember, loan *Loan) error {
+	if loan.BorrowerID != member.User.ID {
+		return BackendError{Message: "Only the borrower can decline this loan."}
+	}
+	return self.setLoanOfferStatus(loan, LS_Declined)
+}
+
+// CancelLoan withdraws a loan offer that has not been accepted yet.
+func (self *Backend) CancelLoan(member SessionedMember, loan *Loan) error {
+	if loan.LenderID != member.User.ID {
+		if perm, err := self.HasPermission(member, P_TransferFunds, &loan.LenderAccount, nil); err != nil {
+			return err
+		} else if !perm {
+			return BackendError{Message: "Only the lender can withdraw this loan."}
+		}
+	}
+	return self.setLoanOfferStatus(loan, LS_Cancelled)
+}
+
+// CollectLoan recovers what is owed on a defaulted loan from another account the borrower owns in the same economy,
+// if they agreed to collections. As much as the account holds is collected, up to everything owed, and returned.
+func (self *Backend) CollectLoan(member SessionedMember, loan *Loan, from *Account) (datatypes.Money, error) {
+	if perm, err := self.HasPermission(member, P_TransferFunds, &loan.LenderAccount, nil); err != nil {
+		return 0, err
+	} else if !perm {
+		return 0, BackendError{Message: "Only the lender can collect on this loan."}
+	}
+
+	if loan.Status != LS_Defaulted {
+		return 0, BackendError{Message: "Only defaulted loans can be collected on."}
+	} else if !loan.Collections {
+		return 0, BackendError{Message: "The borrower did not agree to collections on this loan."}
+	} else if from.OwnerID != loan.BorrowerID || from.EconomyID != loan.EconomyID {
+		return 0, BackendError{Message: "You can only collect from accounts the borrower owns in this economy."}
+	}
+
+	session := self.db.Begin()
+
+	var lender Account
+	if err := session.Where("id = ?", loan.LenderAccountID).First(&lender).Error; err != nil {
+		session.Rollback()
+		return 0, err
+	} else if err := session.Where("id = ?", from.ID).First(from).Error; err != nil {
+		session.Rollback()
+		return 0, err
+	}
+
+	owed, err := loan.Outstanding()
+	if err != nil {
+		session.Rollback()
+		return 0, err
+	}
+
+	amount := min(owed, from.Balance)
+	if !amount.IsPositive() {
+		session.Rollback()
+		return 0, BackendError{Message: fmt.Sprintf("%s has nothing to collect.", from.AccountName)}
+	}
+
+	transfer, err := self.postTransferTx(session, member.User.ID, nil, from, &lender, amount, TT_Personal, false)
+	if err != nil {
+		session.Rollback()
+		return 0, err
+	}
+
+	previous := *loan
+	if err := loan.applyPayment(amount, time.Now()); err != nil {
+		session.Rollback()
+		return 0, err
+	} else if err := self.saveLoanTx(session, *loan, previous); err != nil {
+		session.Rollback()
+		*loan = previous
+		return 0, err
+	}
+
+	// once repaid through collections, the standing order has nothing left to pay
+	if loan.Status == LS_Repaid && loan.RecurringID != nil {
+		if err := session.Model(&RecurringTransfer{}).Where("entry_id = ? AND status = ?", *loan.RecurringID, RS_Active).Update("status", RS_Completed).Error; err != nil {
+			session.Rollback()
+			*loan = previous
+			return 0, err
+		}
+	}
+
+	economy := loan.LenderAccount.Economy
+	err = self.auditTx(session, AuditEntry{
+		EconomyID: loan.EconomyID,
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "loan",
+		Target: fmt.Sprint(loan.ID),
+		Details: fmt.Sprintf("collected %s from %s in transfer #%d", economy.FormatMoney(amount), from.AccountName, transfer.TrxID),
+	})
+	if err != nil {
+		session.Rollback()
+		*loan = previous
+		return 0, err
+	}
+
+	if err := session.Commit().Error; err != nil {
+		*loan = previous
+		return 0, err
+	}
+	return amount, nil
+}
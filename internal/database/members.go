@@ -0,0 +1,300 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+// RolePermissions are the account-scoped permissions each member role grants on its account.
+// Owners hold everything except P_ManageFunds, which mints and burns money and stays with economy admins.
+var RolePermissions = map[uint8][]uint8{
+	AR_Owner: {P_ViewBalance, P_CloseAccount, P_TransferFunds, P_CreateRecurringTransfer, P_ManagePermissions, P_LoginAsAccount},
+	AR_Manager: {P_ViewBalance, P_TransferFunds, P_CreateRecurringTransfer},
+	AR_Viewer: {P_ViewBalance},
+}
+
+// canManageMembers reports whether the member may change who has access to an account,
+// which its owner and anyone with P_ManagePermissions on it may.
+func (self *Backend) canManageMembers(member SessionedMember, account Account) error {
+	if account.OwnerID == member.User.ID {
+		return nil
+	} else if perm, err := self.HasPermission(member, P_ManagePermissions, &account, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage the members of this account."}
+	}
+	return nil
+}
+
+// GetAccountMembers returns the members of an account, ordered by role.
+func (self *Backend) GetAccountMembers(member SessionedMember, account Account) ([]AccountMember, error) {
+	if _, err := self.ViewAccount(member, account); err != nil {
+		return nil, err
+	}
+
+	var members []AccountMember
+	result := self.db.Where("account_id = ?", account.ID).Order("role, created_at").Find(&members)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// setMemberTx gives a user a role on an account and replaces the permission entries of their previous role.
+func (self *Backend) setMemberTx(session *gorm.DB, account Account, user_id string, role uint8) (*AccountMember, error) {
+	var account_member AccountMember
+	err := session.Where("account_id = ? AND user_id = ?", account.ID, user_id).First(&account_member).Error
+	if errors.Is(err, RecordNotFoundError) {
+		account_member = AccountMember{AccountID: account.ID, UserID: user_id, Role: role}
+		err = session.Omit("Account").Create(&account_member).Error
+	} else if err == nil {
+		account_member.Role = role
+		err = session.Model(&account_member).Update("role", role).Error
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := session.Where("member_id = ?", account_member.ID).Delete(&UserPermission{}).Error; err != nil {
+		return nil, err
+	}
+
+	for _, permission := range RolePermissions[role] {
+		entry := UserPermission{
+			EntryID: datatypes.NewUUIDv4().String(),
+			UserID: user_id,
+			AccountID: &account.ID,
+			PermissionID: permission,
+			Value: true,
+			MemberID: &account_member.ID,
+		}
+		if err := session.Omit("Account", "Economy").Create(&entry).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return &account_member, nil
+}
+
+// stopMemberOrdersTx cancels the standing orders and removes the role incomes a user set up to pay from an account, once they
+// may no longer do so themselves, and returns how many were stopped. Orders repaying a loan keep running until the loan is settled.
+func (self *Backend) stopMemberOrdersTx(session *gorm.DB, account Account, user_id string) (int64, error) {
+	result := session.Model(&RecurringTransfer{}).Where("from_account_id = ? AND actor_id = ? AND loan_id IS NULL AND (status = ? OR status = ?)", account.ID, user_id, RS_Active, RS_Paused).
+		Update("status", RS_Cancelled)
+	if err := result.Error; err != nil {
+		return 0, err
+	}
+	stopped := result.RowsAffected
+
+	result = session.Where("from_account_id = ? AND actor_id = ?", account.ID, user_id).Delete(&RoleIncome{})
+	if err := result.Error; err != nil {
+		return 0, err
+	}
+	return stopped + result.RowsAffected, nil
+}
+
+// dropMemberTx takes a user off an account: their membership and the permissions it granted are removed, they are logged out of
+// the account if they were acting as it, and the payments they set up from it are stopped. It returns how many payments were stopped.
+func (self *Backend) dropMemberTx(session *gorm.DB, actor_id string, account Account, user_id string) (int64, error) {
+	var account_members []AccountMember
+	if err := session.Where("account_id = ? AND user_id = ?", account.ID, user_id).Find(&account_members).Error; err != nil {
+		return 0, err
+	}
+
+	for _, account_member := range account_members {
+		if err := session.Where("member_id = ?", account_member.ID).Delete(&UserPermission{}).Error; err != nil {
+			return 0, err
+		} else if err := session.Delete(&account_member).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	account_session, err := self.activeSessionTx(session, user_id, account.EconomyID)
+	if err == nil && account_session != nil && account_session.AccountID.Equals(account.ID) {
+		err = self.endSessionsTx(session, user_id, account.EconomyID, actor_id, "removed from account")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return self.stopMemberOrdersTx(session, account, user_id)
+}
+
+// stoppedDetails notes the payments stopped along with a change of membership in its audit entry
+func stoppedDetails(stopped int64) string {
+	if stopped == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", stopping %d of their standing orders and role incomes", stopped)
+}
+
+// SetAccountMember adds a user to an account with the given role, or changes the role of an existing member.
+// Ownership can only be handed over through TransferAccountOwnership.
+func (self *Backend) SetAccountMember(member SessionedMember, account Account, user_id string, role uint8) (*AccountMember, error) {
+	if err := self.canManageMembers(member, account); err != nil {
+		return nil, err
+	} else if account.Deleted {
+		return nil, BackendError{Message: "You cannot add members to a closed account."}
+	} else if role == AR_Owner {
+		return nil, BackendError{Message: "Use an ownership transfer to make someone the owner of an account."}
+	} else if _, ok := RolePermissions[role]; !ok {
+		return nil, BackendError{Message: "Unknown account role."}
+	} else if user_id == account.OwnerID {
+		return nil, BackendError{Message: "The owner of an account cannot be given another role; transfer the ownership first."}
+	}
+
+	session := self.db.Begin()
+
+	account_member, err := self.setMemberTx(session, account, user_id, role)
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	// a role that cannot set up payments from the account cannot keep the ones made under a previous role either
+	var stopped int64
+	if !slices.Contains(RolePermissions[role], P_CreateRecurringTransfer) {
+		if stopped, err = self.stopMemberOrdersTx(session, account, user_id); err != nil {
+			session.Rollback()
+			return nil, err
+		}
+	}
+
+	err = self.auditTx(session, AuditEntry{
+		EconomyID: account.EconomyID,
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "account member",
+		Target: account.ID.String(),
+		Details: fmt.Sprintf("made <@%s> %s of %s", user_id, AccountRoleName(role), account.AccountName) + stoppedDetails(stopped),
+	})
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	return account_member, session.Commit().Error
+}
+
+// RemoveAccountMember revokes a user's role on an account along with the permissions it granted, logs them out of the account
+// if they were acting as it, and stops the standing orders and role incomes they set up to pay from it.
+func (self *Backend) RemoveAccountMember(member SessionedMember, account Account, user_id string) error {
+	if err := self.canManageMembers(member, account); err != nil {
+		return err
+	} else if user_id == account.OwnerID {
+		return BackendError{Message: "The owner of an account cannot be removed; transfer the ownership first."}
+	}
+
+	session := self.db.Begin()
+
+	var account_member AccountMember
+	if err := session.Where("account_id = ? AND user_id = ?", account.ID, user_id).First(&account_member).Error; errors.Is(err, RecordNotFoundError) {
+		session.Rollback()
+		return BackendError{Message: "This user is not a member of the account."}
+	} else if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	stopped, err := self.dropMemberTx(session, member.User.ID, account, user_id)
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err = self.auditTx(session, AuditEntry{
+		EconomyID: account.EconomyID,
+		ActorID: member.User.ID,
+		Change: CUD_Delete,
+		Action: "account member",
+		Target: account.ID.String(),
+		Details: fmt.Sprintf("removed <@%s> from %s", user_id, account.AccountName) + stoppedDetails(stopped),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// TransferAccountOwnership makes another user the owner of an account. The previous owner stays on as a manager if keep_previous
+// is set, and is otherwise taken off the account like a removed member. Only the current owner or an admin with P_ManageEconomies
+// can hand over an account.
+func (self *Backend) TransferAccountOwnership(member SessionedMember, account *Account, user_id string, keep_previous bool) error {
+	if account.OwnerID != member.User.ID {
+		economy, err := self.economyTx(self.db, account.EconomyID)
+		if err != nil {
+			return err
+		}
+
+		if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+			return err
+		} else if !perm {
+			return BackendError{Message: "Only the owner of an account can transfer its ownership."}
+		}
+	}
+
+	if account.Deleted {
+		return BackendError{Message: "You cannot transfer the ownership of a closed account."}
+	} else if account.OwnerID == user_id {
+		return BackendError{Message: "This user already owns the account."}
+	}
+
+	session := self.db.Begin()
+
+	previous := account.OwnerID
+	if err := session.Model(&Account{}).Where("id = ?", account.ID).Update("owner_id", user_id).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	var stopped int64
+	if previous != "" && keep_previous {
+		if _, err := self.setMemberTx(session, *account, previous, AR_Manager); err != nil {
+			session.Rollback()
+			return err
+		}
+	} else if previous != "" {
+		var err error
+		if stopped, err = self.dropMemberTx(session, member.User.ID, *account, previous); err != nil {
+			session.Rollback()
+			return err
+		}
+	}
+
+	if _, err := self.setMemberTx(session, *account, user_id, AR_Owner); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	details := fmt.Sprintf("transferred %s to <@%s>", account.AccountName, user_id)
+	if previous != "" && !keep_previous {
+		details += fmt.Sprintf(" and removed <@%s>", previous)
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: account.EconomyID,
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "account owner",
+		Target: account.ID.String(),
+		Details: details + stoppedDetails(stopped),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := session.Commit().Error; err != nil {
+		return err
+	}
+
+	account.OwnerID = user_id
+	return nil
+}
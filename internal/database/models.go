@@ -1,6 +1,7 @@
 package database
 
 import (
+	"strings"
 	"time"
 	"github.com/ohknettel/taubot-v3/pkg/datatypes"
 )
@@ -18,6 +19,7 @@ const (
 	P_OpenSpecialAccount 
 	P_LoginAsAccount 
 	P_InstallPlugins 
+	P_IssueFines 
 )
 
 const (
@@ -25,6 +27,8 @@ const (
 	AT_Government
 	AT_Corporation
 	AT_Charity
+	AT_Reserve
+	AT_Escrow
 )
 
 const (
@@ -40,11 +44,105 @@ const (
 	TT_Purchase
 )
 
+const (
+	JT_Transfer uint8 = iota
+	JT_Tax
+	JT_Mint
+	JT_Burn
+	JT_Reversal
+	JT_Hold
+	JT_Release
+	JT_Interest
+	JT_Demurrage
+	JT_Escheatment
+)
+
+const (
+	PD_None uint8 = iota
+	PD_Daily
+	PD_Weekly
+	PD_Monthly
+)
+
+const (
+	RP_Admin uint8 = iota
+	RP_RecipientConsent
+)
+
+const (
+	RS_Active uint8 = iota
+	RS_Paused
+	RS_Completed
+	RS_Cancelled
+)
+
 const (
 	CUD_Create uint8 = iota
 	CUD_Update
 	CUD_Delete
 )
+
+const (
+	PS_Pending uint8 = iota
+	PS_Executed
+	PS_Rejected
+	PS_Expired
+	PS_Failed
+)
+
+const (
+	RQ_Pending uint8 = iota
+	RQ_Paid
+	RQ_Declined
+	RQ_Cancelled
+)
+
+const (
+	AR_Owner uint8 = iota
+	AR_Manager
+	AR_Viewer
+)
+
+const (
+	FS_Refuse uint8 = iota
+	FS_Overdraft
+	FS_Debt
+)
+
+const (
+	LS_Offered uint8 = iota
+	LS_Active
+	LS_Repaid
+	LS_Defaulted
+	LS_Declined
+	LS_Cancelled
+)
+
+const (
+	SB_Average uint8 = iota
+	SB_Minimum
+)
+
+const (
+	ES_Warned uint8 = iota
+	ES_Cleared
+	ES_Escheated
+	ES_Claimed
+)
+
+const (
+	EC_Open uint8 = iota
+	EC_Disputed
+	EC_Released
+	EC_Refunded
+)
+
+const (
+	SS_Active uint8 = iota
+	SS_Appealed
+	SS_Upheld
+	SS_Overturned
+)
 	
 var Models []any = []any{
 	Economy{},
@@ -57,6 +155,40 @@ var Models []any = []any{
 	UserPermission{},
 	Tax{},
 	RecurringTransfer{},
+	Journal{},
+	Posting{},
+	LedgerCheckpoint{},
+	TaxReceipt{},
+	WealthTaxRun{},
+	WealthTaxAssessment{},
+	TaxableIncome{},
+	VATExemption{},
+	AccountSession{},
+	AuditEntry{},
+	AccountMember{},
+	ApprovalPolicy{},
+	TransferProposal{},
+	ProposalApproval{},
+	SpendingLimit{},
+	AllowedRecipient{},
+	PaymentRequest{},
+	InvoiceItem{},
+	RoleIncome{},
+	ActivityReward{},
+	ActivityChannel{},
+	ActivityCredit{},
+	DailyReward{},
+	DailyClaim{},
+	FinePolicy{},
+	Sanction{},
+	Loan{},
+	SavingsPolicy{},
+	SavingsAccount{},
+	DemurragePolicy{},
+	EscheatmentPolicy{},
+	Escheatment{},
+	EscrowContract{},
+	EscrowLeg{},
 }
 
 type Economy struct {
@@ -66,6 +198,15 @@ type Economy struct {
 
 	CurrencyName 	string 			`gorm:"unique"`
 	CurrencyUnit 	string
+	Decimals 		uint8 			`gorm:"default:0"`
+	ReversalPolicy 	uint8 			`gorm:"default:0"`
+	WealthTaxPeriod uint8 			`gorm:"default:0"`
+	IncomeTaxPeriod uint8 			`gorm:"default:3"`
+	VATInclusive 	bool 			`gorm:"default:false"`
+	TimeZone 		string 			`gorm:"default:UTC"`
+
+	// Locked pauses every transfer and scheduled payment in the economy, such as during an exploit
+	Locked 			bool 			`gorm:"default:false"`
 
 	Guilds 			[]Guild
 	Accounts 		[]Account
@@ -102,10 +243,14 @@ type Account struct {
 	AccountLogo 	*string
 	OwnerID 		string
 
-	Balance 		uint
-	TotalBalance 	uint
+	Balance 		datatypes.Money
+	TotalBalance 	datatypes.Money
 	Deleted 		bool 			`gorm:"default:false"`
 
+	// Frozen accounts cannot send transfers, and cannot receive them either if FrozenIncoming is set
+	Frozen 			bool 			`gorm:"default:false"`
+	FrozenIncoming 	bool 			`gorm:"default:false"`
+
 	EconomyID 		string 			`gorm:"index"`
 	Economy 		Economy
 }
@@ -127,6 +272,67 @@ type Transfer struct {
 
 	ToAccountID		datatypes.UUID
 	ToAccount		Account
+
+	Amount 			datatypes.Money
+	TransferType 	uint8
+	JournalID 		uint 			`gorm:"index"`
+	Journal 		Journal
+
+	ReversalOfID 	*uint 			`gorm:"uniqueIndex"`
+	ReversalOf 		*Transfer
+}
+
+// Journal is a single business event in the ledger, such as a transfer or a tax collection.
+// Its postings always sum to zero; the balance of an account is the sum of all its postings.
+//
+// Journals are hash-chained per economy: each one stores the hash of its predecessor and of its own canonical contents,
+// so editing or deleting an entry breaks the chain from that point on.
+type Journal struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	EconomyID 		string 			`gorm:"index;uniqueIndex:idx_journal_sequence"`
+	JournalType 	uint8
+	ActorID 		string
+	Memo 			string
+	CreatedAt 		time.Time
+
+	// OnBehalfOfID is the account the actor was logged in as through an account session, if any
+	OnBehalfOfID 	*datatypes.UUID `gorm:"index"`
+
+	Sequence 		*uint64 		`gorm:"uniqueIndex:idx_journal_sequence"`
+	PrevHash 		string
+	Hash 			string
+
+	// AllowOverdraft lets the postings of this journal take accounts below zero, e.g. when forcing a reversal
+	AllowOverdraft 	bool 			`gorm:"-"`
+
+	Postings 		[]Posting 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Transfers 		[]Transfer 		`gorm:"foreignKey:JournalID"`
+}
+
+// Posting is one side of a journal; a positive amount credits the account and a negative amount debits it.
+type Posting struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	JournalID 		uint 			`gorm:"index"`
+	AccountID 		datatypes.UUID 	`gorm:"index"`
+	Amount 			datatypes.Money
+
+	// TaxEntryID marks postings that credit collected tax to the account of the given bracket
+	TaxEntryID 		*string 		`gorm:"index"`
+
+	// Held marks postings that move funds of the account into or out of a hold, which leaves its total balance unchanged
+	Held 			bool 			`gorm:"-"`
+}
+
+// LedgerCheckpoint is a signed statement of the head of an economy's journal chain at a point in time.
+// Exported checkpoints let anyone later prove that the ledger up to that entry has not been rewritten.
+type LedgerCheckpoint struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	EconomyID 		string 			`gorm:"index" json:"economy_id"`
+	Sequence 		uint64 			`json:"sequence"`
+	Hash 			string 			`json:"hash"`
+	CreatedAt 		time.Time 		`json:"created_at"`
+	PublicKey 		string 			`json:"public_key"`
+	Signature 		string 			`json:"signature"`
 }
 
 type UserPermission struct {
@@ -141,34 +347,538 @@ type UserPermission struct {
 
 	PermissionID uint8
 	Value 		 bool
+
+	// MemberID marks entries granted through an account membership, which are replaced whenever its role changes
+	MemberID 	*uint 			`gorm:"index"`
 }
 
 type Tax struct {
 	EntryID 		string 	`gorm:"primaryKey"`
+	EconomyID 		string 	`gorm:"index"`
 	TaxName 		string
 	AffectedType 	uint8
 	TaxType 		uint8
-	BracketStart 	uint
-	BracketEnd 		uint
-	Rate 			uint
+	BracketStart 	datatypes.Money
+	BracketEnd 		datatypes.Money
+
+	// Rate is in basis points, and is stored apart from the whole percents brackets used to have
+	Rate 			uint 	`gorm:"column:rate_bp"`
 
 	ToAccountID 	datatypes.UUID
 	ToAccount 		Account `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 }
 
+// RecurringTransfer is a standing order that pays a fixed amount every PaymentInterval, which is one of the PD_ periods.
+// A nil PaymentsLeft means the order pays until it is cancelled or reaches EndsAt.
 type RecurringTransfer struct {
 	EntryID 		string 			`gorm:"primaryKey"`
-	ActorID 		string
+	ActorID 		string 			`gorm:"index"`
+	CreatedAt 		time.Time
 
 	FromAccountID 	datatypes.UUID 	`gorm:"index"`
 	FromAccount 	Account 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 	ToAccountID 	datatypes.UUID 	`gorm:"index"`
 	ToAccount 		Account 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
 
-	Amount 			uint
+	Amount 			datatypes.Money
+	TransferType 	uint8
 	LastPaid 		time.Time
 	PaymentInterval uint
-	PaymentsLeft 	uint
+	PaymentsLeft 	*uint
+	NextPayment 	time.Time 		`gorm:"index"`
+	EndsAt 			*time.Time
+	Status 			uint8 			`gorm:"index;default:0"`
+
+	// OnBehalfOfID is the account the creator was logged in as, which its payments are recorded on behalf of
+	OnBehalfOfID 	*datatypes.UUID
+
+	// LoanID is the loan the order repays, if any, which it keeps paying until the loan is settled
+	LoanID 			*uint 			`gorm:"index"`
+}
+
+// TaxReceipt records the tax an account paid under a single bracket, as part of the journal that collected it.
+type TaxReceipt struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	EconomyID 		string 			`gorm:"index"`
+	JournalID 		uint 			`gorm:"index"`
+	TaxEntryID 		string 			`gorm:"index"`
+	TaxType 		uint8
+
+	AccountID 		datatypes.UUID 	`gorm:"index"`
+	ToAccountID 	datatypes.UUID 	`gorm:"index"`
+	Basis 			datatypes.Money
+	Amount 			datatypes.Money
+	CreatedAt 		time.Time 		`gorm:"index"`
+
+	WealthTaxRunID 	*uint 			`gorm:"index"`
+}
+
+// WealthTaxRun is a single collection of wealth tax in an economy, at most one per period.
+// Balances are snapshotted into assessments when the run starts, so that an interrupted run can be resumed from them.
+type WealthTaxRun struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	EconomyID 		string 			`gorm:"uniqueIndex:idx_wealth_tax_period"`
+	PeriodStart 	time.Time 		`gorm:"uniqueIndex:idx_wealth_tax_period"`
+	ActorID 		string
+	CreatedAt 		time.Time
+	CompletedAt 	*time.Time
+
+	Assessments 	[]WealthTaxAssessment `gorm:"foreignKey:RunID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+type WealthTaxAssessment struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	RunID 			uint 			`gorm:"uniqueIndex:idx_wealth_tax_assessment"`
+	AccountID 		datatypes.UUID 	`gorm:"uniqueIndex:idx_wealth_tax_assessment"`
+	Account 		Account
+	Basis 			datatypes.Money
+	Amount 			datatypes.Money
+	JournalID 		*uint
+	SettledAt 		*time.Time
+}
+
+// TaxableIncome is the gross income an account received during one income tax period of its economy.
+// Income tax brackets are applied against this running total, so that many small payments are taxed at the same
+// marginal rates as a single large one.
+type TaxableIncome struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	AccountID 		datatypes.UUID 	`gorm:"uniqueIndex:idx_taxable_income_period"`
+	PeriodStart 	time.Time 		`gorm:"uniqueIndex:idx_taxable_income_period"`
+	Total 			datatypes.Money
+}
+
+// VATExemption exempts purchases from accounts of the given type, e.g. charities, from VAT in an economy.
+type VATExemption struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	EconomyID 		string 			`gorm:"uniqueIndex:idx_vat_exemption"`
+	AccountType 	uint8 			`gorm:"uniqueIndex:idx_vat_exemption"`
+}
+
+// AccountSession lets a user act as an account they hold P_LoginAsAccount on until it expires or is ended.
+// A user has at most one active session per economy.
+type AccountSession struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	UserID 			string 			`gorm:"index"`
+	EconomyID 		string 			`gorm:"index"`
+	AccountID 		datatypes.UUID
+	Account 		Account 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	CreatedAt 		time.Time
+	ExpiresAt 		time.Time 		`gorm:"index"`
+	EndedAt 		*time.Time 		`gorm:"index"`
+}
+
+// AuditEntry records a change made in an economy by an actor, along with the account they were acting as, if any.
+type AuditEntry struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	EconomyID 		string 			`gorm:"index"`
+	ActorID 		string 			`gorm:"index"`
+	OnBehalfOfID 	*datatypes.UUID `gorm:"index"`
+
+	Change 			uint8
+	Action 			string
+	Target 			string
+	Details 		string
+	CreatedAt 		time.Time 		`gorm:"index"`
+}
+
+// AccountMember gives a user a role on a shared account. Each role is backed by account-scoped UserPermission entries,
+// see RolePermissions.
+type AccountMember struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	AccountID 		datatypes.UUID 	`gorm:"uniqueIndex:idx_account_member"`
+	Account 		Account 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	UserID 			string 			`gorm:"uniqueIndex:idx_account_member;index"`
+	Role 			uint8
+	CreatedAt 		time.Time
+}
+
+// ApprovalPolicy requires transfers from an account above a threshold to be approved by several people
+// who may transfer funds from it before they are made.
+type ApprovalPolicy struct {
+	AccountID 		datatypes.UUID 	`gorm:"primaryKey"`
+	Account 		Account 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Threshold 		datatypes.Money
+	Required 		uint
+	Expiry 			time.Duration
+
+	// ChannelID is where proposals are posted for approval, or the channel they were made in if empty
+	ChannelID 		string
+}
+
+// TransferProposal is a transfer waiting for the approvals its account's policy requires.
+// The funds it debits are held until it is executed or dropped.
+type TransferProposal struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	EconomyID 		string 			`gorm:"index"`
+	ProposerID 		string
+	OnBehalfOfID 	*datatypes.UUID
+
+	FromAccountID 	datatypes.UUID 	`gorm:"index"`
+	FromAccount 	Account
+	ToAccountID 	datatypes.UUID
+	ToAccount 		Account
+	Amount 			datatypes.Money
+	TransferType 	uint8
+	Held 			datatypes.Money
+
+	Required 		uint
+	Status 			uint8 			`gorm:"index;default:0"`
+	CreatedAt 		time.Time
+	ExpiresAt 		time.Time 		`gorm:"index"`
+	TransferID 		*uint
+	Error 			string
+
+	ChannelID 		string
+	MessageID 		string
+	Approvals 		[]ProposalApproval `gorm:"foreignKey:ProposalID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+type ProposalApproval struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	ProposalID 		uint 			`gorm:"uniqueIndex:idx_proposal_approval"`
+	UserID 			string 			`gorm:"uniqueIndex:idx_proposal_approval"`
+	CreatedAt 		time.Time
+}
+
+// SpendingLimit restricts what a user who is not the owner of an account may transfer out of it.
+// Zero amounts are not limited, and an empty list of recipients allows paying anyone.
+type SpendingLimit struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	AccountID 		datatypes.UUID 	`gorm:"uniqueIndex:idx_spending_limit"`
+	Account 		Account 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	UserID 			string 			`gorm:"uniqueIndex:idx_spending_limit"`
+
+	PerTransfer 	datatypes.Money
+	PerPeriod 		datatypes.Money
+	Period 			uint8 			`gorm:"default:0"`
+
+	// RestrictRecipients limits the user to Recipients, and stays set when the last of them is removed so they can pay no one
+	RestrictRecipients bool 		`gorm:"default:false"`
+	Recipients 		[]AllowedRecipient `gorm:"foreignKey:LimitID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+type AllowedRecipient struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	LimitID 		uint 			`gorm:"uniqueIndex:idx_allowed_recipient"`
+	AccountID 		datatypes.UUID 	`gorm:"uniqueIndex:idx_allowed_recipient"`
+	Account 		Account 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+}
+
+// PaymentRequest asks a user to pay an account. Invoices are requests with line items, which are paid as purchases.
+type PaymentRequest struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	EconomyID 		string 			`gorm:"index"`
+	GuildID 		string
+	RequesterID 	string 			`gorm:"index"`
+	PayerID 		string 			`gorm:"index"`
+
+	ToAccountID 	datatypes.UUID
+	ToAccount 		Account
+	Amount 			datatypes.Money
+	TransferType 	uint8
+	Memo 			string
+	Invoice 		bool
+	Items 			[]InvoiceItem 	`gorm:"foreignKey:RequestID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	Status 			uint8 			`gorm:"index;default:0"`
+	CreatedAt 		time.Time
+	DueAt 			*time.Time 		`gorm:"index"`
+	RemindedAt 		*time.Time
+	SettledAt 		*time.Time
+	TransferID 		*uint
+}
+
+type InvoiceItem struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	RequestID 		uint 			`gorm:"index"`
+	Description 	string
+	Quantity 		uint
+	UnitPrice 		datatypes.Money
+}
+
+// RoleIncome pays everyone holding a Discord role in a guild an amount from an account on an interval, such as a salary or basic income.
+type RoleIncome struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	EconomyID 		string 			`gorm:"index"`
+	GuildID 		string
+	RoleID 			string
+	ActorID 		string
+	CreatedAt 		time.Time
+
+	FromAccountID 	datatypes.UUID 	`gorm:"index"`
+	FromAccount 	Account 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	Amount 			datatypes.Money
+	PaymentInterval uint8
+	Taxed 			bool
+	NextPayment 	time.Time 		`gorm:"index"`
+	LastPaid 		*time.Time
+}
+
+// ActivityReward pays the members of an economy for chatting and spending time in voice in its activity channels, out of an account.
+type ActivityReward struct {
+	EconomyID 		string 			`gorm:"primaryKey"`
+	ActorID 		string
+
+	FromAccountID 	datatypes.UUID 	`gorm:"index"`
+	FromAccount 	Account 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	PerMessage 		datatypes.Money
+	PerVoiceMinute 	datatypes.Money
+	Cooldown 		time.Duration
+	DailyCap 		datatypes.Money
+}
+
+type ActivityChannel struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	EconomyID 		string 			`gorm:"uniqueIndex:idx_activity_channel"`
+	ChannelID 		string 			`gorm:"uniqueIndex:idx_activity_channel"`
+}
+
+// ActivityCredit is what a user earned through activity on a day, of which Pending has not been paid out yet.
+type ActivityCredit struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	EconomyID 		string 			`gorm:"uniqueIndex:idx_activity_credit"`
+	UserID 			string 			`gorm:"uniqueIndex:idx_activity_credit"`
+	Day 			time.Time 		`gorm:"uniqueIndex:idx_activity_credit"`
+	Earned 			datatypes.Money
+	Pending 		datatypes.Money
+	LastMessageAt 	*time.Time
+}
+
+// DailyReward lets the members of an economy claim an amount from a faucet account once a day, plus a bonus for every
+// day of their streak. The economy's admins are alerted once when the faucet's balance falls below LowBalance.
+type DailyReward struct {
+	EconomyID 		string 			`gorm:"primaryKey"`
+	ActorID 		string
+
+	FromAccountID 	datatypes.UUID 	`gorm:"index"`
+	FromAccount 	Account 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	Amount 			datatypes.Money
+	StreakBonus 	datatypes.Money
+	MaxStreak 		uint
+	LowBalance 		datatypes.Money
+	AlertChannelID 	string
+	AlertedAt 		*time.Time
+}
+
+type DailyClaim struct {
+	EconomyID 		string 			`gorm:"primaryKey"`
+	UserID 			string 			`gorm:"primaryKey"`
+	Streak 			uint
+	LastClaim 		time.Time
+}
+
+// FinePolicy designates the government account an economy's fines are paid into, and what happens when an offender cannot
+// afford one: FS_Refuse stops the fine, FS_Overdraft takes their balance below zero and FS_Debt collects what they have
+// and records the rest as debt.
+type FinePolicy struct {
+	EconomyID 		string 			`gorm:"primaryKey"`
+	ActorID 		string
+
+	AccountID 		datatypes.UUID 	`gorm:"index"`
+	Account 		Account 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Shortfall 		uint8 			`gorm:"default:0"`
+}
+
+// Sanction is a fine issued to a user, of which Collected has been paid and Debt is still owed.
+// Overturning a sanction on appeal refunds what was collected and forgives the debt.
+type Sanction struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	EconomyID 		string 			`gorm:"index"`
+	UserID 			string 			`gorm:"index"`
+	IssuerID 		string
+	Reason 			string
+	CreatedAt 		time.Time
+
+	FromAccountID 	datatypes.UUID
+	FromAccount 	Account
+	ToAccountID 	datatypes.UUID
+	ToAccount 		Account
+	Amount 			datatypes.Money
+	Collected 		datatypes.Money
+	Debt 			datatypes.Money
+	TransferID 		*uint
+
+	Status 			uint8 			`gorm:"index;default:0"`
+	Appeal 			string
+	AppealedAt 		*time.Time
+	ReviewerID 		string
+	ReviewNote 		string
+	ReviewedAt 		*time.Time
+	RefundID 		*uint
+}
+
+// Loan lends Principal from a lender account to a user, who repays it in installments through a standing order.
+// Every installment period accrues Rate basis points of the outstanding principal as interest, which payments settle first.
+// A loan defaults after too many missed installments in a row; if the borrower agreed to Collections, the lender can then
+// collect the rest from the borrower's other accounts.
+type Loan struct {
+	ID 					uint 			`gorm:"primaryKey;autoIncrement"`
+	EconomyID 			string 			`gorm:"index"`
+	GuildID 			string
+	LenderID 			string 			`gorm:"index"`
+	BorrowerID 			string 			`gorm:"index"`
+
+	LenderAccountID 	datatypes.UUID
+	LenderAccount 		Account
+	BorrowerAccountID 	datatypes.UUID
+	BorrowerAccount 	Account
+
+	Principal 			datatypes.Money
+	Rate 				uint
+	PaymentInterval 	uint8
+	Installments 		uint
+	Installment 		datatypes.Money
+	Collections 		bool
+
+	OutstandingPrincipal datatypes.Money
+	OutstandingInterest datatypes.Money
+	Missed 				uint
+
+	Status 				uint8 			`gorm:"index;default:0"`
+	RecurringID 		*string
+	CreatedAt 			time.Time
+	AcceptedAt 			*time.Time
+	SettledAt 			*time.Time
+}
+
+// SavingsPolicy sets the interest savings accounts earn in an economy, which is paid from FromAccount, the central bank.
+// Rate is in basis points per year and is earned on the average or minimum daily balance, depending on Basis.
+// Withdrawing from a savings account during its lock-up costs Penalty basis points of the amount withdrawn, paid to the central bank.
+type SavingsPolicy struct {
+	EconomyID 		string 			`gorm:"primaryKey"`
+	ActorID 		string
+
+	FromAccountID 	datatypes.UUID 	`gorm:"index"`
+	FromAccount 	Account 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	Rate 			uint
+	Basis 			uint8 			`gorm:"default:0"`
+	PayoutPeriod 	uint8 			`gorm:"default:3"`
+	Penalty 		uint
+}
+
+// SavingsAccount marks an account as earning interest. Its balance is sampled once a day: BalanceSum and MinBalance
+// cover the Days sampled since PeriodStart, and are paid out as interest and reset once the payout period is over.
+type SavingsAccount struct {
+	AccountID 		datatypes.UUID 	`gorm:"primaryKey"`
+	Account 		Account 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	EconomyID 		string 			`gorm:"index"`
+
+	LockedUntil 	*time.Time
+	PeriodStart 	time.Time
+	AccruedOn 		time.Time
+	BalanceSum 		datatypes.Money
+	MinBalance 		datatypes.Money
+	Days 			uint
+
+	Earned 			datatypes.Money
+	CreatedAt 		time.Time
+}
+
+// DemurragePolicy charges Rate basis points of the idle balance of every private account each Period, paid into ToAccount.
+// The idle balance is the lowest balance the account held throughout the period, the part of it that was never spent.
+type DemurragePolicy struct {
+	EconomyID 		string 			`gorm:"primaryKey"`
+	ActorID 		string
+
+	ToAccountID 	datatypes.UUID 	`gorm:"index"`
+	ToAccount 		Account 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	Rate 			uint
+	Period 			uint8 			`gorm:"default:3"`
+	LastCharged 	time.Time
+}
+
+// EscheatmentPolicy moves the balance of accounts without activity for DormantDays into ToAccount. Their owners are warned
+// WarningDays beforehand, and can claim the funds back for ClaimDays afterwards.
+type EscheatmentPolicy struct {
+	EconomyID 		string 			`gorm:"primaryKey"`
+	ActorID 		string
+
+	ToAccountID 	datatypes.UUID 	`gorm:"index"`
+	ToAccount 		Account 		`gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	DormantDays 	uint
+	WarningDays 	uint
+	ClaimDays 		uint
+}
+
+// Escheatment follows a dormant account from the warning to its owner, through the escheatment of its balance on DueAt,
+// to the owner claiming it back. Activity on the account before DueAt clears the warning.
+type Escheatment struct {
+	ID 				uint 			`gorm:"primaryKey;autoIncrement"`
+	EconomyID 		string 			`gorm:"index"`
+	AccountID 		datatypes.UUID 	`gorm:"index"`
+	Account 		Account
+	OwnerID 		string 			`gorm:"index"`
+
+	Status 			uint8 			`gorm:"index;default:0"`
+	LastActivity 	time.Time
+	WarnedAt 		time.Time
+	DueAt 			time.Time
+
+	Amount 			datatypes.Money
+	ToAccountID 	*datatypes.UUID
+	JournalID 		*uint
+	EscheatedAt 	*time.Time
+	ClaimBy 		*time.Time
+	ClaimedAt 		*time.Time
+}
+
+// EscrowContract holds Amount of the buyer's funds in escrow until the trade described by Terms is done. The funds are
+// released to the seller once both have confirmed, and refunded to the buyer if the contract is cancelled or expires.
+// A disputed contract waits for an arbitrator to either release or refund it.
+type EscrowContract struct {
+	ID 					uint 			`gorm:"primaryKey;autoIncrement"`
+	EconomyID 			string 			`gorm:"index"`
+	GuildID 			string
+	BuyerID 			string 			`gorm:"index"`
+	SellerID 			string 			`gorm:"index"`
+
+	BuyerAccountID 		datatypes.UUID
+	BuyerAccount 		Account
+	SellerAccountID 	datatypes.UUID
+	SellerAccount 		Account
+	OnBehalfOfID 		*datatypes.UUID
+
+	Amount 				datatypes.Money
+
+	// Held is what the buyer pays, the amount along with any exclusive VAT and early withdrawal penalty, of which the
+	// seller receives Credit and Legs receive the rest. They are worked out when the funds are held, so paying out cannot fail.
+	Held 				datatypes.Money
+	Credit 				datatypes.Money
+	Legs 				[]EscrowLeg 	`gorm:"foreignKey:ContractID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+
+	Terms 				string
+	BuyerConfirmed 		bool
+	SellerConfirmed 	bool
+
+	Status 				uint8 			`gorm:"index;default:0"`
+	CreatedAt 			time.Time
+	ExpiresAt 			time.Time 		`gorm:"index"`
+	Dispute 			string
+	ResolverID 			string
+	Resolution 			string
+	TransferID 			*uint
+	SettledAt 			*time.Time
+}
+
+// EscrowLeg is a share of the funds held for an escrow contract that goes to an account other than the seller's
+// once they are released, such as VAT or the penalty for withdrawing from a locked up savings account.
+type EscrowLeg struct {
+	ID 					uint 			`gorm:"primaryKey;autoIncrement"`
+	ContractID 			uint 			`gorm:"index"`
+	AccountID 			datatypes.UUID
+
+	// TaxEntryID is the bracket the leg was collected for, or nil for a savings penalty
+	TaxEntryID 			*string
+	TaxType 			uint8
+	Basis 				datatypes.Money
+	Amount 				datatypes.Money
 }
 
 func (Economy) TableName() string {
@@ -181,4 +891,55 @@ func (UserPermission) TableName() string {
 
 func (Tax) TableName() string {
 	return "taxes"
-}
\ No newline at end of file
+}
+
+// FormatMoney formats an amount using the economy's decimal places and currency,
+// e.g. "$12,000.50" when a currency unit is set, otherwise "12,000.50 dollars".
+func (economy Economy) FormatMoney(amount datatypes.Money) string {
+	formatted := amount.Format(economy.Decimals)
+	if economy.CurrencyUnit != "" {
+		if amount.IsNegative() {
+			return "-" + economy.CurrencyUnit + formatted[1:]
+		}
+		return economy.CurrencyUnit + formatted
+	}
+
+	if economy.CurrencyName != "" {
+		return formatted + " " + economy.CurrencyName
+	}
+	return formatted
+}
+
+// ParseMoney parses user input such as "1.5k", "12,000.50" or "$20" into an amount
+// in the economy's currency. The currency unit and name are accepted but not required.
+func (economy Economy) ParseMoney(input string) (datatypes.Money, error) {
+	input = strings.TrimSpace(input)
+	if economy.CurrencyUnit != "" {
+		// the unit either leads the amount, after its sign as in "-$20", or trails it, and is not removed from anywhere else
+		sign := ""
+		if strings.HasPrefix(input, "-") || strings.HasPrefix(input, "+") {
+			sign, input = input[:1], input[1:]
+		}
+
+		if trimmed := strings.TrimPrefix(input, economy.CurrencyUnit); trimmed != input {
+			input = trimmed
+		} else {
+			input = strings.TrimSuffix(input, economy.CurrencyUnit)
+		}
+		input = sign + input
+	}
+
+	if economy.CurrencyName != "" && len(input) > len(economy.CurrencyName) && strings.EqualFold(input[len(input)-len(economy.CurrencyName):], economy.CurrencyName) {
+		input = input[:len(input)-len(economy.CurrencyName)]
+	}
+
+	return datatypes.ParseMoney(input, economy.Decimals)
+}
+
+// Location returns the time zone of the economy, which days start in for daily claims, or UTC if it is not set.
+func (economy Economy) Location() *time.Location {
+	if location, err := time.LoadLocation(economy.TimeZone); err == nil {
+		return location
+	}
+	return time.UTC
+}
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+)
+
+func TestEconomyParseMoney(t *testing.T) {
+	economy := Economy{CurrencyName: "dollars", CurrencyUnit: "$", Decimals: 2}
+
+	tests := []struct {
+		input 	string
+		want 	datatypes.Money
+		err 	error
+	}{
+		{"20", 2000, nil},
+		{"$20", 2000, nil},
+		{"20$", 2000, nil},
+		{"-$20", -2000, nil},
+		{"+$20", 2000, nil},
+		{"$1.5k", 150000, nil},
+		{"20 dollars", 2000, nil},
+		{"20 Dollars", 2000, nil},
+		{"$12,000.50", 1200050, nil},
+		{"2$0", 0, datatypes.ErrMoneyFormat},
+		{"1$.5k", 0, datatypes.ErrMoneyFormat},
+		{"$$20", 0, datatypes.ErrMoneyFormat},
+		{"$", 0, datatypes.ErrMoneyFormat},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			got, err := economy.ParseMoney(test.input)
+			if !errors.Is(err, test.err) || got != test.want {
+				t.Errorf("ParseMoney(%q) = %d, %v; want %d, %v", test.input, got, err, test.want, test.err)
+			}
+		})
+	}
+}
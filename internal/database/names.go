@@ -0,0 +1,209 @@
+package database
+
+func PeriodName(period uint8) string {
+	switch period {
+	case PD_Daily:
+		return "daily"
+	case PD_Weekly:
+		return "weekly"
+	case PD_Monthly:
+		return "monthly"
+	default:
+		return "never"
+	}
+}
+
+func AccountTypeName(account_type uint8) string {
+	switch account_type {
+	case AT_User:
+		return "User"
+	case AT_Government:
+		return "Government"
+	case AT_Corporation:
+		return "Corporation"
+	case AT_Charity:
+		return "Charity"
+	case AT_Reserve:
+		return "Reserve"
+	case AT_Escrow:
+		return "Escrow"
+	default:
+		return "Unknown"
+	}
+}
+
+func TaxTypeName(tax_type uint8) string {
+	switch tax_type {
+	case TX_Wealth:
+		return "Wealth"
+	case TX_Income:
+		return "Income"
+	case TX_VAT:
+		return "VAT"
+	case TX_Transaction:
+		return "Transaction"
+	default:
+		return "Unknown"
+	}
+}
+
+func TransferTypeName(transfer_type uint8) string {
+	switch transfer_type {
+	case TT_Personal:
+		return "Personal"
+	case TT_Income:
+		return "Income"
+	case TT_Purchase:
+		return "Purchase"
+	default:
+		return "Unknown"
+	}
+}
+
+func RecurringStatusName(status uint8) string {
+	switch status {
+	case RS_Active:
+		return "Active"
+	case RS_Paused:
+		return "Paused"
+	case RS_Completed:
+		return "Completed"
+	case RS_Cancelled:
+		return "Cancelled"
+	default:
+		return "Unknown"
+	}
+}
+
+func AccountRoleName(role uint8) string {
+	switch role {
+	case AR_Owner:
+		return "Owner"
+	case AR_Manager:
+		return "Manager"
+	case AR_Viewer:
+		return "Viewer"
+	default:
+		return "Unknown"
+	}
+}
+
+func ProposalStatusName(status uint8) string {
+	switch status {
+	case PS_Pending:
+		return "Pending"
+	case PS_Executed:
+		return "Executed"
+	case PS_Rejected:
+		return "Rejected"
+	case PS_Expired:
+		return "Expired"
+	case PS_Failed:
+		return "Failed"
+	default:
+		return "Unknown"
+	}
+}
+
+func RequestStatusName(status uint8) string {
+	switch status {
+	case RQ_Pending:
+		return "Pending"
+	case RQ_Paid:
+		return "Paid"
+	case RQ_Declined:
+		return "Declined"
+	case RQ_Cancelled:
+		return "Cancelled"
+	default:
+		return "Unknown"
+	}
+}
+
+func ShortfallName(shortfall uint8) string {
+	switch shortfall {
+	case FS_Refuse:
+		return "Refuse the fine"
+	case FS_Overdraft:
+		return "Overdraw the account"
+	case FS_Debt:
+		return "Record a debt"
+	default:
+		return "Unknown"
+	}
+}
+
+func SanctionStatusName(status uint8) string {
+	switch status {
+	case SS_Active:
+		return "Active"
+	case SS_Appealed:
+		return "Appealed"
+	case SS_Upheld:
+		return "Upheld"
+	case SS_Overturned:
+		return "Overturned"
+	default:
+		return "Unknown"
+	}
+}
+
+func LoanStatusName(status uint8) string {
+	switch status {
+	case LS_Offered:
+		return "Offered"
+	case LS_Active:
+		return "Active"
+	case LS_Repaid:
+		return "Repaid"
+	case LS_Defaulted:
+		return "Defaulted"
+	case LS_Declined:
+		return "Declined"
+	case LS_Cancelled:
+		return "Cancelled"
+	default:
+		return "Unknown"
+	}
+}
+
+func SavingsBasisName(basis uint8) string {
+	switch basis {
+	case SB_Average:
+		return "Average balance"
+	case SB_Minimum:
+		return "Minimum balance"
+	default:
+		return "Unknown"
+	}
+}
+
+func EscheatmentStatusName(status uint8) string {
+	switch status {
+	case ES_Warned:
+		return "Warned"
+	case ES_Cleared:
+		return "Cleared"
+	case ES_Escheated:
+		return "Escheated"
+	case ES_Claimed:
+		return "Claimed"
+	default:
+		return "Unknown"
+	}
+}
+
+func EscrowStatusName(status uint8) string {
+	switch status {
+	case EC_Open:
+		return "Open"
+	case EC_Disputed:
+		return "Disputed"
+	case EC_Released:
+		return "Released"
+	case EC_Refunded:
+		return "Refunded"
+	default:
+		return "Unknown"
+	}
+}
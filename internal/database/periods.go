@@ -0,0 +1,44 @@
+package database
+
+import (
+	"time"
+)
+
+// PeriodStart returns the start of the period containing t in the given location; weeks start on Monday.
+func PeriodStart(t time.Time, period uint8, location *time.Location) time.Time {
+	t = t.In(location)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, location)
+
+	switch period {
+	case PD_Weekly:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case PD_Monthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, location)
+	default:
+		return day
+	}
+}
+
+// NextPeriod returns the start of the period following the one that starts at start.
+func NextPeriod(start time.Time, period uint8) time.Time {
+	switch period {
+	case PD_Weekly:
+		return start.AddDate(0, 0, 7)
+	case PD_Monthly:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// previousPeriod returns the start of the period preceding the one that starts at start.
+func previousPeriod(start time.Time, period uint8) time.Time {
+	switch period {
+	case PD_Weekly:
+		return start.AddDate(0, 0, -7)
+	case PD_Monthly:
+		return start.AddDate(0, -1, 0)
+	default:
+		return start.AddDate(0, 0, -1)
+	}
+}
@@ -0,0 +1,397 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+)
+
+// RecurringPayment is the outcome of a single due payment of a standing order.
+// Error holds the reason a payment failed, such as insufficient funds; the order moves on to its next payment either way.
+type RecurringPayment struct {
+	Order 		RecurringTransfer
+	Due 		time.Time
+	Transfer 	*Transfer
+	Error 		error
+
+	// Loan is the state of the loan the order repays after this payment, if any
+	Loan 		*Loan
+}
+
+// LoanOrderError is returned when changing a standing order that repays a loan, which only ends once the loan is settled.
+var LoanOrderError = BackendError{Message: "This standing order repays a loan and cannot be changed."}
+
+func (self *Backend) GetRecurringTransferByID(id string) (RecurringTransfer, error) {
+	var order RecurringTransfer
+	result := self.db.Where("entry_id = ?", id).Preload("FromAccount.Economy").Preload("ToAccount").First(&order)
+	if err := result.Error; err != nil {
+		return RecurringTransfer{}, err
+	}
+	return order, nil
+}
+
+// GetRecurringTransfers returns the active and paused standing orders paying from an account,
+// or those the member created if no account is given.
+func (self *Backend) GetRecurringTransfers(member SessionedMember, account *Account) ([]RecurringTransfer, error) {
+	stmt := self.db.Where("status = ? OR status = ?", RS_Active, RS_Paused)
+	if account == nil {
+		stmt = stmt.Where("actor_id = ?", member.User.ID)
+	} else if perm, err := self.HasPermission(member, P_CreateRecurringTransfer, account, nil); err != nil {
+		return nil, err
+	} else if !perm {
+		return nil, BackendError{Message: "You do not have the permission to manage recurring transfers of this account."}
+	} else {
+		stmt = stmt.Where("from_account_id = ?", account.ID)
+	}
+
+	var orders []RecurringTransfer
+	result := stmt.Preload("FromAccount.Economy").Preload("ToAccount").Order("next_payment").Find(&orders)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+func (self *Backend) validateRecurringTransfer(order RecurringTransfer) error {
+	if !order.Amount.IsPositive() {
+		return BackendError{Message: "The transfer amount must be positive."}
+	} else if order.PaymentInterval == uint(PD_None) || order.PaymentInterval > uint(PD_Monthly) {
+		return BackendError{Message: "A recurring transfer has to be paid daily, weekly or monthly."}
+	} else if order.FromAccountID.Equals(order.ToAccountID) {
+		return BackendError{Message: "You cannot transfer funds to the same account."}
+	} else if order.FromAccount.EconomyID != order.ToAccount.EconomyID {
+		return BackendError{Message: "You cannot transfer funds between different economies."}
+	} else if order.FromAccount.Deleted || order.ToAccount.Deleted {
+		return BackendError{Message: "You cannot transfer funds to or from a closed account."}
+	} else if order.TransferType > TT_Purchase {
+		return BackendError{Message: "Unknown transfer type."}
+	} else if order.PaymentsLeft != nil && *order.PaymentsLeft == 0 {
+		return BackendError{Message: "A recurring transfer has to make at least one payment."}
+	} else if order.EndsAt != nil && order.EndsAt.Before(order.NextPayment) {
+		return BackendError{Message: "A recurring transfer cannot end before its next payment."}
+	}
+
+	// payments are made without anyone around to approve them, so they have to stay below the approval threshold
+	if policy, err := self.approvalPolicyTx(self.db, order.FromAccount, order.Amount); err != nil {
+		return err
+	} else if policy != nil {
+		return BackendError{Message: "Standing orders from this account cannot exceed the amount above which transfers need approval."}
+	}
+	return nil
+}
+
+// CreateRecurringTransfer sets up a standing order from its FromAccount, which the member needs the permission for.
+// The first payment is made at NextPayment, or as soon as possible if it is not set.
+func (self *Backend) CreateRecurringTransfer(member SessionedMember, order *RecurringTransfer) error {
+	if perm, err := self.HasPermission(member, P_CreateRecurringTransfer, &order.FromAccount, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to create recurring transfers from this account."}
+	}
+
+	if order.NextPayment.IsZero() {
+		order.NextPayment = time.Now()
+	}
+
+	order.EntryID = datatypes.NewUUIDv4().String()
+	order.ActorID = member.User.ID
+	order.FromAccountID, order.ToAccountID = order.FromAccount.ID, order.ToAccount.ID
+	order.NextPayment = order.NextPayment.UTC()
+	order.Status = RS_Active
+
+	if err := self.validateRecurringTransfer(*order); err != nil {
+		return err
+	}
+
+	session := self.db.Begin()
+
+	details := fmt.Sprintf("standing order of %s %s to %s", order.FromAccount.Economy.FormatMoney(order.Amount), PeriodName(uint8(order.PaymentInterval)), order.ToAccount.AccountName)
+	on_behalf_of, err := self.auditActingTx(session, member, order.FromAccount, CUD_Create, "recurring transfer", order.EntryID, details)
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+	order.OnBehalfOfID = on_behalf_of
+
+	if err := session.Omit("FromAccount", "ToAccount").Create(order).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// UpdateRecurringTransfer saves changes to the amount, schedule or limits of a standing order.
+func (self *Backend) UpdateRecurringTransfer(member SessionedMember, order *RecurringTransfer) error {
+	if perm, err := self.HasPermission(member, P_CreateRecurringTransfer, &order.FromAccount, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to edit recurring transfers of this account."}
+	} else if order.LoanID != nil {
+		return LoanOrderError
+	} else if order.Status != RS_Active && order.Status != RS_Paused {
+		return BackendError{Message: "This recurring transfer has already ended."}
+	}
+
+	order.NextPayment = order.NextPayment.UTC()
+	if err := self.validateRecurringTransfer(*order); err != nil {
+		return err
+	}
+
+	session := self.db.Begin()
+
+	details := fmt.Sprintf("standing order of %s %s to %s", order.FromAccount.Economy.FormatMoney(order.Amount), PeriodName(uint8(order.PaymentInterval)), order.ToAccount.AccountName)
+	if _, err := self.auditActingTx(session, member, order.FromAccount, CUD_Update, "recurring transfer", order.EntryID, details); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := session.Omit("FromAccount", "ToAccount").Save(order).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// SetRecurringTransferStatus pauses, resumes or cancels a standing order. Payments that fell due while
+// an order was paused are skipped when it resumes.
+func (self *Backend) SetRecurringTransferStatus(member SessionedMember, order *RecurringTransfer, status uint8) error {
+	if perm, err := self.HasPermission(member, P_CreateRecurringTransfer, &order.FromAccount, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage recurring transfers of this account."}
+	} else if order.LoanID != nil {
+		return LoanOrderError
+	}
+
+	switch {
+	case order.Status != RS_Active && order.Status != RS_Paused:
+		return BackendError{Message: "This recurring transfer has already ended."}
+	case status == RS_Paused && order.Status != RS_Active:
+		return BackendError{Message: "This recurring transfer is not active."}
+	case status == RS_Active && order.Status != RS_Paused:
+		return BackendError{Message: "This recurring transfer is not paused."}
+	case status != RS_Active && status != RS_Paused && status != RS_Cancelled:
+		return BackendError{Message: "Unknown recurring transfer status."}
+	}
+
+	updates := map[string]any{"status": status}
+	if status == RS_Active {
+		now := time.Now()
+		for order.NextPayment.Before(now) {
+			order.NextPayment = NextPeriod(order.NextPayment, uint8(order.PaymentInterval)).UTC()
+		}
+		updates["next_payment"] = order.NextPayment
+
+		if order.EndsAt != nil && order.NextPayment.After(*order.EndsAt) {
+			status, updates["status"] = RS_Completed, RS_Completed
+		}
+	}
+
+	session := self.db.Begin()
+
+	result := session.Model(&RecurringTransfer{}).Where("entry_id = ? AND status = ?", order.EntryID, order.Status).Updates(updates)
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return BackendError{Message: "The recurring transfer was modified by another transaction, please try again."}
+	}
+
+	change := CUD_Update
+	if status == RS_Cancelled {
+		change = CUD_Delete
+	}
+
+	if _, err := self.auditActingTx(session, member, order.FromAccount, change, "recurring transfer", order.EntryID, "standing order " + strings.ToLower(RecurringStatusName(status))); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	order.Status = status
+	return session.Commit().Error
+}
+
+// payRecurringTransfer makes the payment of a standing order that is due at its NextPayment and moves the order on to the next one.
+// The order is claimed with a compare-and-swap on its next payment, so it returns nil if the payment was already made elsewhere.
+func (self *Backend) payRecurringTransfer(order *RecurringTransfer) (*RecurringPayment, error) {
+	payment := RecurringPayment{Due: order.NextPayment}
+	session := self.db.Begin()
+
+	var from, to Account
+	if err := session.Where("id = ?", order.FromAccountID).First(&from).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	} else if err := session.Where("id = ?", order.ToAccountID).First(&to).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	next := *order
+	next.NextPayment = NextPeriod(order.NextPayment, uint8(order.PaymentInterval)).UTC()
+
+	// installments of a loan are untaxed, and the last one only pays what is left
+	amount := order.Amount
+	if order.LoanID != nil {
+		var loan Loan
+		if err := session.Where("id = ?", *order.LoanID).First(&loan).Error; err != nil {
+			session.Rollback()
+			return nil, err
+		}
+
+		var err error
+		if amount, err = loan.NextInstallment(); err != nil {
+			session.Rollback()
+			return nil, err
+		}
+	}
+
+	var paid datatypes.Money
+	if from.Deleted || to.Deleted {
+		payment.Error = BackendError{Message: "The recurring transfer was cancelled because one of its accounts was closed."}
+		next.Status = RS_Cancelled
+	} else if transfer, err := self.postTransferTx(session, order.ActorID, order.OnBehalfOfID, &from, &to, amount, order.TransferType, order.LoanID == nil); err != nil {
+		var backend_err BackendError
+		if !errors.As(err, &backend_err) {
+			session.Rollback()
+			return nil, err
+		}
+
+		// the failed payment is skipped, but the order itself still moves on
+		session.Rollback()
+		session = self.db.Begin()
+		payment.Error = backend_err
+	} else {
+		payment.Transfer, paid = transfer, amount
+		next.LastPaid = transfer.CreatedAt
+		if next.PaymentsLeft != nil {
+			left := *next.PaymentsLeft - 1
+			next.PaymentsLeft = &left
+			if left == 0 {
+				next.Status = RS_Completed
+			}
+		}
+	}
+
+	if next.EndsAt != nil && next.NextPayment.After(*next.EndsAt) && next.Status == RS_Active {
+		next.Status = RS_Completed
+	}
+
+	if order.LoanID != nil {
+		loan, err := self.settleInstallmentTx(session, *order.LoanID, paid, next.Status == RS_Cancelled)
+		if err != nil {
+			session.Rollback()
+			return nil, err
+		} else if loan.Status == LS_Repaid {
+			next.Status = RS_Completed
+		}
+		payment.Loan = loan
+	}
+
+	result := session.Model(&RecurringTransfer{}).Where("entry_id = ? AND status = ? AND next_payment = ?", order.EntryID, RS_Active, order.NextPayment).Updates(map[string]any{
+		"next_payment": next.NextPayment,
+		"last_paid": next.LastPaid,
+		"payments_left": next.PaymentsLeft,
+		"status": next.Status,
+	})
+
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return nil, err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return nil, nil
+	}
+
+	if err := session.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	*order = next
+	payment.Order = next
+	return &payment, nil
+}
+
+// RunRecurringTransfers makes every payment of active standing orders that is due by now, including payments
+// missed while the bot was offline, and returns their outcomes so the payers can be notified.
+func (self *Backend) RunRecurringTransfers(now time.Time) ([]RecurringPayment, error) {
+	var orders []RecurringTransfer
+	err := self.db.Where("status = ? AND next_payment <= ?", RS_Active, now.UTC()).Preload("FromAccount.Economy").Preload("ToAccount").Order("next_payment").Find(&orders).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var payments []RecurringPayment
+	for _, order := range orders {
+		// payments of a locked economy wait until it is unlocked, and are then caught up on
+		if order.FromAccount.Economy.Locked {
+			continue
+		}
+
+		for order.Status == RS_Active && !order.NextPayment.After(now) {
+			payment, err := self.payRecurringTransfer(&order)
+			if err != nil {
+				return payments, err
+			} else if payment == nil {
+				break
+			}
+			payments = append(payments, *payment)
+		}
+	}
+
+	return payments, nil
+}
+
+// MigrateRecurringTransfers moves standing orders that predate scheduled payments onto the PD_ periods. Their PaymentInterval
+// counted days, so intervals of a day, a week or a month carry over while any other interval cannot be kept and cancels the order.
+// A PaymentsLeft of zero meant the order had no limit. The next payment falls due an interval after the last one, or now if that has passed.
+func (self *Backend) MigrateRecurringTransfers() error {
+	var orders []RecurringTransfer
+	if err := self.db.Where("next_payment IS NULL").Find(&orders).Error; err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	session := self.db.Begin()
+
+	for _, order := range orders {
+		period := PD_None
+		switch {
+		case order.PaymentInterval == 1:
+			period = PD_Daily
+		case order.PaymentInterval == 7:
+			period = PD_Weekly
+		case order.PaymentInterval >= 28 && order.PaymentInterval <= 31:
+			period = PD_Monthly
+		}
+
+		next := now
+		if !order.LastPaid.IsZero() && NextPeriod(order.LastPaid, period).After(now) {
+			next = NextPeriod(order.LastPaid, period).UTC()
+		}
+
+		updates := map[string]any{
+			"payment_interval": period,
+			"next_payment": next,
+			"status": RS_Active,
+		}
+		if period == PD_None {
+			updates["status"] = RS_Cancelled
+		}
+		if order.PaymentsLeft != nil && *order.PaymentsLeft == 0 {
+			updates["payments_left"] = nil
+		}
+
+		if err := session.Model(&RecurringTransfer{}).Where("entry_id = ?", order.EntryID).Updates(updates).Error; err != nil {
+			session.Rollback()
+			return err
+		}
+	}
+
+	return session.Commit().Error
+}
@@ -0,0 +1,177 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+)
+
+// MaxReportPeriods is the most periods a single report can cover.
+const MaxReportPeriods = 24
+
+// RevenueLine is the tax collected under a single bracket into a single account, with one amount per period of its report.
+type RevenueLine struct {
+	TaxEntryID 		string
+	TaxName 		string
+	TaxType 		uint8
+	ToAccountID 	datatypes.UUID
+	ToAccountName 	string
+	Amounts 		[]datatypes.Money
+}
+
+// RevenueReport is the tax collected in an economy over consecutive periods, oldest first.
+type RevenueReport struct {
+	Economy 		Economy
+	Period 			uint8
+	Starts 			[]time.Time
+	End 			time.Time
+	Lines 			[]RevenueLine
+}
+
+type revenueRow struct {
+	TaxEntryID 		string
+	TaxName 		string
+	TaxType 		uint8
+	ToAccountID 	datatypes.UUID
+	ToAccountName 	string
+	Amount 			datatypes.Money
+}
+
+// PeriodEnd returns the end of the i-th period of the report.
+func (self RevenueReport) PeriodEnd(i int) time.Time {
+	if i + 1 < len(self.Starts) {
+		return self.Starts[i + 1]
+	}
+	return self.End
+}
+
+// Totals returns the tax collected in each period of the report.
+func (self RevenueReport) Totals() []datatypes.Money {
+	totals := make([]datatypes.Money, len(self.Starts))
+	for _, line := range self.Lines {
+		for i, amount := range line.Amounts {
+			totals[i], _ = totals[i].Add(amount)
+		}
+	}
+	return totals
+}
+
+// group sums the lines that share a key, keeping the fields of the first line of each group.
+func (self RevenueReport) group(key func(RevenueLine) string) []RevenueLine {
+	var groups []RevenueLine
+	index := map[string]int{}
+	for _, line := range self.Lines {
+		i, ok := index[key(line)]
+		if !ok {
+			i = len(groups)
+			index[key(line)] = i
+			groups = append(groups, RevenueLine{TaxType: line.TaxType, ToAccountID: line.ToAccountID, ToAccountName: line.ToAccountName, Amounts: make([]datatypes.Money, len(line.Amounts))})
+		}
+
+		for j, amount := range line.Amounts {
+			groups[i].Amounts[j], _ = groups[i].Amounts[j].Add(amount)
+		}
+	}
+	return groups
+}
+
+// ByTaxType returns the lines of the report summed per tax type.
+func (self RevenueReport) ByTaxType() []RevenueLine {
+	groups := self.group(func(line RevenueLine) string { return TaxTypeName(line.TaxType) })
+	for i := range groups {
+		groups[i].TaxName = TaxTypeName(groups[i].TaxType)
+		groups[i].ToAccountID, groups[i].ToAccountName = datatypes.UUID{}, ""
+	}
+	return groups
+}
+
+// ByAccount returns the lines of the report summed per receiving account.
+func (self RevenueReport) ByAccount() []RevenueLine {
+	groups := self.group(func(line RevenueLine) string { return line.ToAccountID.String() })
+	for i := range groups {
+		groups[i].TaxName = groups[i].ToAccountName
+	}
+	return groups
+}
+
+// GetRevenueReport returns the tax collected in an economy over the given number of periods up to and including the current one.
+// Members who may manage the economy's taxes see all revenue, anyone else only the revenue of accounts whose balance they may view.
+// Refunded tax is netted out, as reversals store negated receipts.
+func (self *Backend) GetRevenueReport(member SessionedMember, economy Economy, period uint8, periods int, now time.Time) (RevenueReport, error) {
+	if period == PD_None || period > PD_Monthly {
+		return RevenueReport{}, BackendError{Message: "A report needs a daily, weekly or monthly period."}
+	} else if periods < 1 || periods > MaxReportPeriods {
+		return RevenueReport{}, BackendError{Message: fmt.Sprintf("A report can cover between 1 and %d periods.", MaxReportPeriods)}
+	}
+
+	manager, err := self.HasPermission(member, P_ManageTaxBrackets, nil, &economy)
+	if err != nil {
+		return RevenueReport{}, err
+	}
+
+	report := RevenueReport{Economy: economy, Period: period, End: now}
+	start := PeriodStart(now, period, time.UTC)
+	for range periods - 1 {
+		start = previousPeriod(start, period)
+	}
+
+	for i := 0; i < periods; i++ {
+		report.Starts = append(report.Starts, start)
+		start = NextPeriod(start, period)
+	}
+
+	index := map[string]int{}
+	visible := map[datatypes.UUID]bool{}
+	for i := range report.Starts {
+		var rows []revenueRow
+		err := self.db.Model(&TaxReceipt{}).
+			Select("tax_receipts.tax_entry_id AS tax_entry_id, taxes.tax_name AS tax_name, tax_receipts.tax_type AS tax_type, tax_receipts.to_account_id AS to_account_id, accounts.account_name AS to_account_name, SUM(tax_receipts.amount) AS amount").
+			Joins("LEFT JOIN taxes ON taxes.entry_id = tax_receipts.tax_entry_id").
+			Joins("LEFT JOIN accounts ON accounts.id = tax_receipts.to_account_id").
+			Where("tax_receipts.economy_id = ? AND tax_receipts.created_at >= ? AND tax_receipts.created_at < ?", economy.ID.String(), report.Starts[i], report.PeriodEnd(i)).
+			Group("tax_receipts.tax_entry_id, taxes.tax_name, tax_receipts.tax_type, tax_receipts.to_account_id, accounts.account_name").
+			Scan(&rows).Error
+		if err != nil {
+			return RevenueReport{}, err
+		}
+
+		for _, row := range rows {
+			if !manager {
+				perm, ok := visible[row.ToAccountID]
+				if !ok {
+					if perm, err = self.HasPermission(member, P_ViewBalance, &Account{ID: row.ToAccountID, EconomyID: economy.ID.String()}, nil); err != nil {
+						return RevenueReport{}, err
+					}
+					visible[row.ToAccountID] = perm
+				}
+
+				if !perm {
+					continue
+				}
+			}
+
+			key := row.TaxEntryID + "/" + row.ToAccountID.String()
+			j, ok := index[key]
+			if !ok {
+				j = len(report.Lines)
+				index[key] = j
+				report.Lines = append(report.Lines, RevenueLine{
+					TaxEntryID: row.TaxEntryID,
+					TaxName: row.TaxName,
+					TaxType: row.TaxType,
+					ToAccountID: row.ToAccountID,
+					ToAccountName: row.ToAccountName,
+					Amounts: make([]datatypes.Money, periods),
+				})
+			}
+			report.Lines[j].Amounts[i] = row.Amount
+		}
+	}
+
+	if !manager && len(report.Lines) == 0 && len(visible) > 0 {
+		return RevenueReport{}, BackendError{Message: "You do not have the permission to view the revenue of any tax account in this economy."}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,206 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ReminderInterval is how often the payer of an overdue request is reminded of it.
+const ReminderInterval = 24 * time.Hour
+
+const maxInvoiceItems = 25
+
+func (self *Backend) GetPaymentRequestByID(id uint) (PaymentRequest, error) {
+	var request PaymentRequest
+	result := self.db.Where("id = ?", id).Preload("ToAccount.Economy").Preload("Items").First(&request)
+	if err := result.Error; err != nil {
+		return PaymentRequest{}, err
+	}
+	return request, nil
+}
+
+// GetPaymentRequests returns the pending requests a member has to pay in an economy, or those they have sent if outgoing is set.
+func (self *Backend) GetPaymentRequests(member SessionedMember, economy Economy, outgoing bool) ([]PaymentRequest, error) {
+	stmt := self.db.Where("economy_id = ? AND status = ?", economy.ID.String(), RQ_Pending)
+	if outgoing {
+		stmt = stmt.Where("requester_id = ?", member.User.ID)
+	} else {
+		stmt = stmt.Where("payer_id = ?", member.User.ID)
+	}
+
+	var requests []PaymentRequest
+	result := stmt.Preload("ToAccount.Economy").Preload("Items").Order("id").Find(&requests)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// validatePaymentRequest checks a request before it is sent, totalling the line items of invoices into its amount.
+func (self *Backend) validatePaymentRequest(request *PaymentRequest) error {
+	if len(request.Items) > maxInvoiceItems {
+		return BackendError{Message: fmt.Sprintf("An invoice can have at most %d items.", maxInvoiceItems)}
+	}
+
+	if len(request.Items) > 0 {
+		request.Invoice, request.TransferType, request.Amount = true, TT_Purchase, 0
+	}
+
+	for _, item := range request.Items {
+		if strings.TrimSpace(item.Description) == "" {
+			return BackendError{Message: "Every item of an invoice needs a description."}
+		} else if item.Quantity == 0 || !item.UnitPrice.IsPositive() {
+			return BackendError{Message: fmt.Sprintf("The item '%s' needs a positive quantity and price.", item.Description)}
+		}
+
+		total, err := item.UnitPrice.Mul(int64(item.Quantity))
+		if err != nil {
+			return err
+		}
+
+		if request.Amount, err = request.Amount.Add(total); err != nil {
+			return err
+		}
+	}
+
+	if !request.Amount.IsPositive() {
+		return BackendError{Message: "The requested amount must be positive."}
+	} else if request.TransferType > TT_Purchase {
+		return BackendError{Message: "Unknown transfer type."}
+	} else if request.DueAt != nil && !request.DueAt.After(time.Now()) {
+		return BackendError{Message: "The due date of a request has to be in the future."}
+	}
+	return nil
+}
+
+// CreatePaymentRequest asks the payer of the request to pay its ToAccount, which the member has to own or be able to transfer funds from.
+func (self *Backend) CreatePaymentRequest(member SessionedMember, request *PaymentRequest) error {
+	if request.ToAccount.OwnerID != member.User.ID {
+		if perm, err := self.HasPermission(member, P_TransferFunds, &request.ToAccount, nil); err != nil {
+			return err
+		} else if !perm {
+			return BackendError{Message: "You do not have the permission to request payments to this account."}
+		}
+	}
+
+	if request.ToAccount.Deleted {
+		return BackendError{Message: "You cannot request payments to a closed account."}
+	} else if request.PayerID == member.User.ID {
+		return BackendError{Message: "You cannot request a payment from yourself."}
+	} else if err := self.validatePaymentRequest(request); err != nil {
+		return err
+	}
+
+	request.EconomyID = request.ToAccount.EconomyID
+	request.RequesterID = member.User.ID
+	request.ToAccountID = request.ToAccount.ID
+	request.Status = RQ_Pending
+
+	session := self.db.Begin()
+
+	if err := session.Omit("ToAccount").Create(request).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	kind := "payment request"
+	if request.Invoice {
+		kind = "invoice"
+	}
+
+	details := fmt.Sprintf("%s of %s to <@%s>", kind, request.ToAccount.Economy.FormatMoney(request.Amount), request.PayerID)
+	if _, err := self.auditActingTx(session, member, request.ToAccount, CUD_Create, kind, fmt.Sprint(request.ID), details); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// settleRequestTx closes a pending request, failing if it has been settled in the meantime.
+func (self *Backend) settleRequestTx(session *gorm.DB, request *PaymentRequest, status uint8, transfer_id *uint) error {
+	now := time.Now().UTC()
+	result := session.Model(&PaymentRequest{}).Where("id = ? AND status = ?", request.ID, RQ_Pending).Updates(map[string]any{
+		"status": status,
+		"settled_at": now,
+		"transfer_id": transfer_id,
+	})
+
+	if err := result.Error; err != nil {
+		return err
+	} else if result.RowsAffected == 0 {
+		return BackendError{Message: "This request has already been settled."}
+	}
+
+	request.Status, request.SettledAt, request.TransferID = status, &now, transfer_id
+	return nil
+}
+
+// PayPaymentRequest pays a request addressed to the member from the given account, like any other transfer.
+func (self *Backend) PayPaymentRequest(member SessionedMember, request *PaymentRequest, from *Account) (*Transfer, error) {
+	if request.PayerID != member.User.ID {
+		return nil, BackendError{Message: "This request is not addressed to you."}
+	} else if request.Status != RQ_Pending {
+		return nil, BackendError{Message: "This request has already been settled."}
+	} else if perm, err := self.HasPermission(member, P_TransferFunds, from, nil); err != nil {
+		return nil, err
+	} else if !perm {
+		return nil, BackendError{Message: "You do not have the permission to transfer funds from this account."}
+	}
+
+	session := self.db.Begin()
+
+	transfer, err := self.createTransferTx(session, member, from, &request.ToAccount, request.Amount, request.TransferType)
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	if err := self.settleRequestTx(session, request, RQ_Paid, &transfer.TrxID); err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	return transfer, session.Commit().Error
+}
+
+// DeclinePaymentRequest refuses a request addressed to the member.
+func (self *Backend) DeclinePaymentRequest(member SessionedMember, request *PaymentRequest) error {
+	if request.PayerID != member.User.ID {
+		return BackendError{Message: "This request is not addressed to you."}
+	}
+	return self.settleRequestTx(self.db, request, RQ_Declined, nil)
+}
+
+// CancelPaymentRequest withdraws a request the member has sent.
+func (self *Backend) CancelPaymentRequest(member SessionedMember, request *PaymentRequest) error {
+	if request.RequesterID != member.User.ID {
+		return BackendError{Message: "Only whoever sent this request can cancel it."}
+	}
+	return self.settleRequestTx(self.db, request, RQ_Cancelled, nil)
+}
+
+// RemindOverdueRequests returns the pending requests past their due date whose payer has not been reminded
+// within the ReminderInterval, and marks them as reminded.
+func (self *Backend) RemindOverdueRequests(now time.Time) ([]PaymentRequest, error) {
+	now = now.UTC()
+
+	var requests []PaymentRequest
+	result := self.db.Where("status = ? AND due_at < ?", RQ_Pending, now).
+		Where("reminded_at IS NULL OR reminded_at <= ?", now.Add(-ReminderInterval)).
+		Preload("ToAccount.Economy").Preload("Items").Order("id").Find(&requests)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	for i := range requests {
+		if err := self.db.Model(&requests[i]).Update("reminded_at", now).Error; err != nil {
+			return requests[:i], err
+		}
+	}
+
+	return requests, nil
+}
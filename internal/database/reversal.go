@@ -0,0 +1,158 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+func (self *Backend) GetTransferByID(id uint) (Transfer, error) {
+	var transfer Transfer
+	result := self.db.Where("trx_id = ?", id).Preload("FromAccount.Economy").Preload("ToAccount").Preload("Journal.Postings").First(&transfer)
+	if err := result.Error; err != nil {
+		return Transfer{}, err
+	}
+	return transfer, nil
+}
+
+// ReverseTransfer undoes a transfer by posting a journal that negates every posting of the original,
+// which refunds any taxes collected on it as well.
+//
+// Admins with P_ManageFunds on the recipient account can always reverse a transfer. Under the RP_RecipientConsent policy,
+// anyone who may transfer funds from the recipient account can also reverse it, which counts as the recipient's consent.
+// If the recipient or a tax account no longer holds the funds, the reversal fails unless an admin forces it, in which case those accounts are overdrawn.
+// Only reversals by the recipient are held back by frozen accounts and a locked economy.
+func (self *Backend) ReverseTransfer(member SessionedMember, transfer_id uint, reason string, force bool) (*Transfer, error) {
+	original, err := self.GetTransferByID(transfer_id)
+	if err != nil {
+		return nil, err
+	}
+
+	admin, err := self.HasPermission(member, P_ManageFunds, &original.ToAccount, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if !admin {
+		if original.FromAccount.Economy.ReversalPolicy != RP_RecipientConsent {
+			return nil, BackendError{Message: "You do not have the permission to reverse transfers in this economy."}
+		} else if perm, err := self.HasPermission(member, P_TransferFunds, &original.ToAccount, nil); err != nil {
+			return nil, err
+		} else if !perm {
+			return nil, BackendError{Message: "Only the recipient of this transfer or an admin can reverse it."}
+		} else if force {
+			return nil, BackendError{Message: "Only an admin can force a reversal."}
+		}
+	}
+
+	session := self.db.Begin()
+
+	// an admin reversal is how a frozen account or a locked economy gets corrected, while a recipient refunding
+	// a transfer pays the funds back like any other transfer
+	if !admin {
+		economy, err := self.economyTx(session, original.Journal.EconomyID)
+		if err != nil {
+			session.Rollback()
+			return nil, err
+		} else if err := self.checkTransferableTx(session, economy, &original.ToAccount, &original.FromAccount); err != nil {
+			session.Rollback()
+			return nil, err
+		}
+	}
+
+	on_behalf_of, err := self.auditActingTx(session, member, original.ToAccount, CUD_Create, "reversal", fmt.Sprint(original.TrxID), reason)
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	reversal, err := self.reverseTransferTx(session, member.User.ID, on_behalf_of, original, reason, force)
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	return reversal, session.Commit().Error
+}
+
+func (self *Backend) reverseTransferTx(session *gorm.DB, actor_id string, on_behalf_of *datatypes.UUID, original Transfer, reason string, force bool) (*Transfer, error) {
+	if original.ReversalOfID != nil {
+		return nil, BackendError{Message: "A reversal cannot be reversed itself."}
+	}
+
+	var existing Transfer
+	if err := session.Where("reversal_of_id = ?", original.TrxID).First(&existing).Error; err == nil {
+		return nil, BackendError{Message: "This transfer has already been reversed."}
+	} else if !errors.Is(err, RecordNotFoundError) {
+		return nil, err
+	}
+
+	journal := Journal{
+		EconomyID: original.Journal.EconomyID,
+		JournalType: JT_Reversal,
+		ActorID: actor_id,
+		OnBehalfOfID: on_behalf_of,
+		Memo: reason,
+		AllowOverdraft: force,
+	}
+
+	for _, posting := range original.Journal.Postings {
+		amount, err := posting.Amount.Neg()
+		if err != nil {
+			return nil, err
+		}
+		journal.Postings = append(journal.Postings, Posting{AccountID: posting.AccountID, Amount: amount})
+	}
+
+	reversal := Transfer{
+		ActorID: actor_id,
+		FromAccountID: original.ToAccountID,
+		ToAccountID: original.FromAccountID,
+		Amount: original.Amount,
+		TransferType: original.TransferType,
+		ReversalOfID: &original.TrxID,
+	}
+
+	if err := self.postJournalTx(session, &journal, &reversal); err != nil {
+		if errors.Is(err, InsufficientFundsError) {
+			return nil, BackendError{Message: "The recipient no longer holds the funds of this transfer; an admin has to force the reversal."}
+		}
+		return nil, err
+	}
+
+	if err := self.reverseReceiptsTx(session, original.Journal.ID, &journal); err != nil {
+		return nil, err
+	}
+
+	if original.TransferType == TT_Income {
+		economy, err := self.economyTx(session, original.Journal.EconomyID)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := self.deductIncomeTx(session, economy, original.ToAccountID, original.Amount, original.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	return &reversal, nil
+}
+
+func (self *Backend) SetReversalPolicy(member SessionedMember, economy *Economy, policy uint8) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage this economy."}
+	} else if policy > RP_RecipientConsent {
+		return BackendError{Message: "Unknown reversal policy."}
+	}
+
+	if err := self.db.Model(&Economy{}).Where("id = ?", economy.ID).Update("reversal_policy", policy).Error; err != nil {
+		return err
+	}
+
+	economy.ReversalPolicy = policy
+	return nil
+}
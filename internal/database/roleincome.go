@@ -0,0 +1,232 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+)
+
+// RoleIncomePayment is the outcome of a single run of a role income.
+// Error holds the reason nobody was paid, such as a shortfall of the source account; the income moves on to its next run either way.
+type RoleIncomePayment struct {
+	Income 		RoleIncome
+	Paid 		int
+	Skipped 	int
+	Total 		datatypes.Money
+	Error 		error
+}
+
+func (self *Backend) GetRoleIncomeByID(id uint) (RoleIncome, error) {
+	var income RoleIncome
+	result := self.db.Where("id = ?", id).Preload("FromAccount.Economy").First(&income)
+	if err := result.Error; err != nil {
+		return RoleIncome{}, err
+	}
+	return income, nil
+}
+
+// GetRoleIncomes returns the role incomes of an economy.
+func (self *Backend) GetRoleIncomes(economy Economy) ([]RoleIncome, error) {
+	var incomes []RoleIncome
+	result := self.db.Where("economy_id = ?", economy.ID.String()).Preload("FromAccount.Economy").Order("id").Find(&incomes)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	return incomes, nil
+}
+
+// CreateRoleIncome starts paying everyone holding the role of the income from its FromAccount. The member has to be able to manage
+// the economy as well as create recurring transfers from the account. The first payment is made at NextPayment, or as soon as possible.
+func (self *Backend) CreateRoleIncome(member SessionedMember, economy Economy, income *RoleIncome) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage the role incomes of this economy."}
+	} else if perm, err := self.HasPermission(member, P_CreateRecurringTransfer, &income.FromAccount, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to create recurring transfers from this account."}
+	}
+
+	if !income.Amount.IsPositive() {
+		return BackendError{Message: "The income amount must be positive."}
+	} else if income.PaymentInterval == PD_None || income.PaymentInterval > PD_Monthly {
+		return BackendError{Message: "A role income has to be paid daily, weekly or monthly."}
+	} else if income.FromAccount.EconomyID != economy.ID.String() {
+		return BackendError{Message: "The account to pay from has to be in this economy."}
+	} else if income.FromAccount.Deleted {
+		return BackendError{Message: "You cannot pay an income from a closed account."}
+	}
+
+	// payments are made without anyone around to approve them, so they have to stay below the approval threshold
+	if policy, err := self.approvalPolicyTx(self.db, income.FromAccount, income.Amount); err != nil {
+		return err
+	} else if policy != nil {
+		return BackendError{Message: "Incomes from this account cannot exceed the amount above which transfers need approval."}
+	}
+
+	if income.NextPayment.IsZero() {
+		income.NextPayment = time.Now()
+	}
+
+	income.EconomyID = economy.ID.String()
+	income.ActorID = member.User.ID
+	income.FromAccountID = income.FromAccount.ID
+	income.NextPayment = income.NextPayment.UTC()
+
+	session := self.db.Begin()
+
+	if err := session.Omit("FromAccount").Create(income).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Create,
+		Action: "role income",
+		Target: fmt.Sprint(income.ID),
+		Details: fmt.Sprintf("pays <@&%s> %s %s from %s", income.RoleID, economy.FormatMoney(income.Amount), PeriodName(income.PaymentInterval), income.FromAccount.AccountName),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// RemoveRoleIncome stops a role income.
+func (self *Backend) RemoveRoleIncome(member SessionedMember, economy Economy, income RoleIncome) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage the role incomes of this economy."}
+	} else if income.EconomyID != economy.ID.String() {
+		return BackendError{Message: "This role income does not belong to this economy."}
+	}
+
+	session := self.db.Begin()
+
+	if err := session.Delete(&income).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Delete,
+		Action: "role income",
+		Target: fmt.Sprint(income.ID),
+		Details: fmt.Sprintf("stopped paying <@&%s> from %s", income.RoleID, income.FromAccount.AccountName),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// DueRoleIncomes returns the role incomes of unlocked economies whose next payment is due by now, so the holders of their roles can be looked up.
+func (self *Backend) DueRoleIncomes(now time.Time) ([]RoleIncome, error) {
+	var incomes []RoleIncome
+	err := self.db.Where("next_payment <= ? AND economy_id NOT IN (?)", now.UTC(), self.lockedEconomies()).Preload("FromAccount.Economy").Order("next_payment").Find(&incomes).Error
+	return incomes, err
+}
+
+// PayRoleIncome pays a due role income to the personal accounts of the given users, who hold its role right now, and moves it on
+// to its next payment after now. Runs missed while the bot was offline are not made up, since the role may have changed hands since.
+// Either everyone is paid or nobody is, so a shortfall of the source account is reported in the payment instead of paying some at random.
+// The income is claimed with a compare-and-swap on its next payment, so it returns nil if the payment was already made elsewhere.
+func (self *Backend) PayRoleIncome(income *RoleIncome, user_ids []string, now time.Time) (*RoleIncomePayment, error) {
+	if income.NextPayment.After(now) {
+		return nil, nil
+	}
+
+	payment := RoleIncomePayment{}
+	session := self.db.Begin()
+
+	next := *income
+	for !next.NextPayment.After(now) {
+		next.NextPayment = NextPeriod(next.NextPayment, next.PaymentInterval).UTC()
+	}
+
+	result := session.Model(&RoleIncome{}).Where("id = ? AND next_payment = ?", income.ID, income.NextPayment).Update("next_payment", next.NextPayment)
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return nil, err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return nil, nil
+	}
+
+	var from Account
+	if err := session.Where("id = ?", income.FromAccountID).First(&from).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	var accounts []Account
+	if len(user_ids) > 0 {
+		err := session.Where("economy_id = ? AND owner_id IN ? AND account_type = ? AND deleted = ?", income.EconomyID, user_ids, AT_User, false).Find(&accounts).Error
+		if err != nil {
+			session.Rollback()
+			return nil, err
+		}
+	}
+	payment.Skipped = len(user_ids) - len(accounts)
+
+	session.SavePoint("payments")
+	for _, to := range accounts {
+		if to.ID.Equals(from.ID) {
+			payment.Skipped++
+			continue
+		}
+
+		_, err := self.postTransferTx(session, income.ActorID, nil, &from, &to, income.Amount, TT_Income, income.Taxed)
+		if err == nil {
+			payment.Paid++
+			continue
+		}
+
+		var backend_err BackendError
+		if !errors.As(err, &backend_err) {
+			session.Rollback()
+			return nil, err
+		}
+
+		session.RollbackTo("payments")
+		payment.Paid = 0
+		payment.Error = backend_err
+
+		if errors.Is(err, InsufficientFundsError) {
+			needed, _ := income.Amount.Mul(int64(len(accounts)))
+			economy := income.FromAccount.Economy
+			payment.Error = BackendError{Message: fmt.Sprintf("%s needs %s to pay %d members of <@&%s>, but only has %s, so nobody was paid.", from.AccountName, economy.FormatMoney(needed), len(accounts), income.RoleID, economy.FormatMoney(from.Balance))}
+		}
+		break
+	}
+
+	if payment.Paid > 0 {
+		payment.Total, _ = income.Amount.Mul(int64(payment.Paid))
+		paid_at := now.UTC()
+		next.LastPaid = &paid_at
+		if err := session.Model(&RoleIncome{}).Where("id = ?", income.ID).Update("last_paid", paid_at).Error; err != nil {
+			session.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := session.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	*income = next
+	payment.Income = next
+	return &payment, nil
+}
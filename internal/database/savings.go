@@ -0,0 +1,383 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	// savingsRateDenominator turns a rate in basis points per year into a daily fraction
+	savingsRateDenominator = BasisPoints * 365
+	maxSavingsLockup = 365
+)
+
+// FormatBasisPoints formats a rate in basis points as a percentage, e.g. "2.50%".
+func FormatBasisPoints(rate uint) string {
+	return datatypes.Money(rate).Format(2) + "%"
+}
+
+// GetSavingsPolicy returns the interest savings accounts earn in an economy, or nil if it has none.
+func (self *Backend) GetSavingsPolicy(economy Economy) (*SavingsPolicy, error) {
+	return self.savingsPolicyTx(self.db, economy.ID.String())
+}
+
+func (self *Backend) savingsPolicyTx(session *gorm.DB, economy_id string) (*SavingsPolicy, error) {
+	var policy SavingsPolicy
+	err := session.Where("economy_id = ?", economy_id).Preload("FromAccount.Economy").First(&policy).Error
+	if errors.Is(err, RecordNotFoundError) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// SetSavingsPolicy sets the interest rate of savings accounts in an economy, paid from the policy's FromAccount.
+// The member has to be able to manage the economy as well as transfer funds from the central bank account.
+func (self *Backend) SetSavingsPolicy(member SessionedMember, economy Economy, policy *SavingsPolicy) error {
+	if perm, err := self.HasPermission(member, P_ManageEconomies, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage the savings accounts of this economy."}
+	} else if perm, err := self.HasPermission(member, P_TransferFunds, &policy.FromAccount, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to transfer funds from this account."}
+	}
+
+	if policy.FromAccount.EconomyID != economy.ID.String() {
+		return BackendError{Message: "The central bank account has to be in this economy."}
+	} else if policy.FromAccount.AccountType != AT_Government {
+		return BackendError{Message: "Interest can only be paid from a government account."}
+	} else if policy.FromAccount.Deleted {
+		return BackendError{Message: "A closed account cannot pay interest."}
+	} else if policy.Rate > BasisPoints || policy.Penalty > BasisPoints {
+		return BackendError{Message: "The interest rate cannot exceed 100% a year and the penalty cannot exceed 100%."}
+	} else if policy.Basis > SB_Minimum {
+		return BackendError{Message: "Unknown interest basis."}
+	} else if policy.PayoutPeriod == PD_None || policy.PayoutPeriod > PD_Monthly {
+		return BackendError{Message: "Interest has to be paid daily, weekly or monthly."}
+	}
+
+	var savings int64
+	if err := self.db.Model(&SavingsAccount{}).Where("account_id = ?", policy.FromAccount.ID).Count(&savings).Error; err != nil {
+		return err
+	} else if savings > 0 {
+		return BackendError{Message: "A savings account cannot be the central bank."}
+	}
+
+	policy.EconomyID = economy.ID.String()
+	policy.ActorID = member.User.ID
+	policy.FromAccountID = policy.FromAccount.ID
+
+	session := self.db.Begin()
+
+	if err := session.Omit("FromAccount").Save(policy).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: economy.ID.String(),
+		ActorID: member.User.ID,
+		Change: CUD_Update,
+		Action: "savings policy",
+		Target: economy.ID.String(),
+		Details: fmt.Sprintf("pays %s a year on the %s from %s, paid %s, with a %s early withdrawal penalty",
+			FormatBasisPoints(policy.Rate), SavingsBasisName(policy.Basis), policy.FromAccount.AccountName, PeriodName(policy.PayoutPeriod), FormatBasisPoints(policy.Penalty)),
+	})
+	if err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// GetSavingsAccount returns the savings state of an account, or nil if it is not a savings account.
+func (self *Backend) GetSavingsAccount(account Account) (*SavingsAccount, error) {
+	var savings SavingsAccount
+	err := self.db.Where("account_id = ?", account.ID).Preload("Account.Economy").First(&savings).Error
+	if errors.Is(err, RecordNotFoundError) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &savings, nil
+}
+
+// OpenSavingsAccount turns an account into a savings account, which starts earning interest from the next day.
+// If lockup_days is set, withdrawing before it ends costs the economy's early withdrawal penalty.
+func (self *Backend) OpenSavingsAccount(member SessionedMember, economy Economy, account *Account, lockup_days uint) (*SavingsAccount, error) {
+	if perm, err := self.HasPermission(member, P_TransferFunds, account, nil); err != nil {
+		return nil, err
+	} else if !perm {
+		return nil, BackendError{Message: "You do not have the permission to manage the funds of this account."}
+	}
+
+	policy, err := self.GetSavingsPolicy(economy)
+	if err != nil {
+		return nil, err
+	} else if policy == nil {
+		return nil, BackendError{Message: "This economy does not offer savings accounts."}
+	}
+
+	if account.EconomyID != economy.ID.String() {
+		return nil, BackendError{Message: "The account has to be in this economy."}
+	} else if account.Deleted || account.AccountType == AT_Reserve || account.AccountType == AT_Escrow {
+		return nil, BackendError{Message: "This account cannot become a savings account."}
+	} else if account.ID.Equals(policy.FromAccountID) {
+		return nil, BackendError{Message: "The central bank cannot be a savings account."}
+	} else if lockup_days > maxSavingsLockup {
+		return nil, BackendError{Message: fmt.Sprintf("A lock-up cannot be longer than %d days.", maxSavingsLockup)}
+	}
+
+	location := economy.Location()
+	now := time.Now()
+	today := PeriodStart(now, PD_Daily, location).UTC()
+
+	// the days are stored in UTC so that they compare correctly in the database
+	savings := SavingsAccount{
+		AccountID: account.ID,
+		EconomyID: economy.ID.String(),
+		PeriodStart: PeriodStart(now, policy.PayoutPeriod, location).UTC(),
+		AccruedOn: today,
+	}
+
+	if lockup_days > 0 {
+		until := today.AddDate(0, 0, int(lockup_days)).UTC()
+		savings.LockedUntil = &until
+	}
+
+	session := self.db.Begin()
+
+	result := session.Omit("Account").Where("account_id = ?", account.ID).FirstOrCreate(&savings)
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return nil, err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return nil, BackendError{Message: "This account is already a savings account."}
+	}
+
+	details := fmt.Sprintf("opened savings account %s", account.AccountName)
+	if savings.LockedUntil != nil {
+		details += fmt.Sprintf(", locked up until %s", savings.LockedUntil.Format(time.DateOnly))
+	}
+
+	if _, err := self.auditActingTx(session, member, *account, CUD_Create, "savings account", account.ID.String(), details); err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	if err := session.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	savings.Account = *account
+	return &savings, nil
+}
+
+// CloseSavingsAccount turns a savings account back into an ordinary account once its lock-up is over.
+// Interest accrued during the current payout period is forfeited.
+func (self *Backend) CloseSavingsAccount(member SessionedMember, account *Account) error {
+	if perm, err := self.HasPermission(member, P_TransferFunds, account, nil); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage the funds of this account."}
+	}
+
+	savings, err := self.GetSavingsAccount(*account)
+	if err != nil {
+		return err
+	} else if savings == nil {
+		return BackendError{Message: "This account is not a savings account."}
+	} else if savings.Locked(time.Now()) {
+		return BackendError{Message: fmt.Sprintf("This account is locked up until <t:%d:d>.", savings.LockedUntil.Unix())}
+	}
+
+	session := self.db.Begin()
+
+	result := session.Where("account_id = ?", account.ID).Delete(&SavingsAccount{})
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return BackendError{Message: "This account is not a savings account."}
+	}
+
+	if _, err := self.auditActingTx(session, member, *account, CUD_Delete, "savings account", account.ID.String(), fmt.Sprintf("closed savings account %s", account.AccountName)); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// Locked reports whether withdrawing from the savings account at the given time costs the early withdrawal penalty.
+func (savings SavingsAccount) Locked(now time.Time) bool {
+	return savings.LockedUntil != nil && now.Before(*savings.LockedUntil)
+}
+
+// Interest returns the interest the account has accrued so far in the current payout period under a policy.
+func (savings SavingsAccount) Interest(policy SavingsPolicy) (datatypes.Money, error) {
+	if policy.Basis == SB_Minimum {
+		return savings.MinBalance.MulRate(int64(savings.Days) * int64(policy.Rate), savingsRateDenominator)
+	}
+	return savings.BalanceSum.MulRate(int64(policy.Rate), savingsRateDenominator)
+}
+
+// savingsPenaltyTx returns the posting that pays the central bank the early withdrawal penalty of a transfer from a
+// locked up savings account, along with the penalty, or nil if none is due.
+func (self *Backend) savingsPenaltyTx(session *gorm.DB, from *Account, amount datatypes.Money) (*Posting, datatypes.Money, error) {
+	var savings SavingsAccount
+	err := session.Where("account_id = ?", from.ID).First(&savings).Error
+	if errors.Is(err, RecordNotFoundError) {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	} else if !savings.Locked(time.Now()) {
+		return nil, 0, nil
+	}
+
+	policy, err := self.savingsPolicyTx(session, from.EconomyID)
+	if err != nil || policy == nil || policy.Penalty == 0 {
+		return nil, 0, err
+	}
+
+	penalty, err := amount.MulRate(int64(policy.Penalty), BasisPoints)
+	if err != nil || penalty.IsZero() {
+		return nil, 0, err
+	}
+
+	return &Posting{AccountID: policy.FromAccountID, Amount: penalty}, penalty, nil
+}
+
+// accrueSavingsTx samples the balance of a savings account for the days since it was last sampled, and pays out the
+// interest of the payout period once it is over. Interest the central bank cannot afford is carried over to the next period.
+func (self *Backend) accrueSavingsTx(session *gorm.DB, policy SavingsPolicy, location *time.Location, savings SavingsAccount, now time.Time) error {
+	today := PeriodStart(now, PD_Daily, location).UTC()
+	if !savings.AccruedOn.Before(today) {
+		return nil
+	}
+	previous := savings
+
+	var account Account
+	if err := session.Where("id = ?", savings.AccountID).First(&account).Error; err != nil {
+		return err
+	}
+
+	balance := account.Balance
+	if balance.IsNegative() {
+		balance = 0
+	}
+
+	var err error
+	if savings.BalanceSum, err = savings.BalanceSum.Add(balance); err != nil {
+		return err
+	}
+	if savings.Days == 0 || balance < savings.MinBalance {
+		savings.MinBalance = balance
+	}
+	savings.Days++
+	savings.AccruedOn = today
+
+	period := PeriodStart(now, policy.PayoutPeriod, location).UTC()
+	if period.After(savings.PeriodStart) && !account.Deleted && !account.FrozenIncoming {
+		interest, err := savings.Interest(policy)
+		if err != nil {
+			return err
+		}
+
+		var bank Account
+		if err := session.Where("id = ?", policy.FromAccountID).First(&bank).Error; err != nil {
+			return err
+		}
+
+		if !interest.IsPositive() || (!bank.Deleted && bank.Balance >= interest) {
+			if interest.IsPositive() {
+				journal := Journal{
+					EconomyID: savings.EconomyID,
+					JournalType: JT_Interest,
+					ActorID: policy.ActorID,
+					Memo: fmt.Sprintf("Interest for the period starting %s", savings.PeriodStart.Format(time.DateOnly)),
+					Postings: []Posting{
+						{AccountID: bank.ID, Amount: -interest},
+						{AccountID: account.ID, Amount: interest},
+					},
+				}
+
+				if err := self.postJournalTx(session, &journal, nil); err != nil {
+					return err
+				} else if savings.Earned, err = savings.Earned.Add(interest); err != nil {
+					return err
+				}
+			}
+
+			savings.PeriodStart, savings.BalanceSum, savings.MinBalance, savings.Days = period, 0, 0, 0
+		}
+	}
+
+	// the sample only counts once, so two processes accruing the same account cannot both pay out its interest
+	result := session.Model(&SavingsAccount{}).Where("account_id = ? AND days = ? AND earned = ?", savings.AccountID, previous.Days, previous.Earned).Updates(map[string]any{
+		"period_start": savings.PeriodStart,
+		"accrued_on": savings.AccruedOn,
+		"balance_sum": savings.BalanceSum,
+		"min_balance": savings.MinBalance,
+		"days": savings.Days,
+		"earned": savings.Earned,
+	})
+
+	if err := result.Error; err != nil {
+		return err
+	} else if result.RowsAffected == 0 {
+		return BackendError{Message: "This savings account has already accrued interest today."}
+	}
+	return nil
+}
+
+// RunSavingsInterest accrues interest on every savings account in the economies that offer them, and pays it out
+// at the end of each payout period. Each account is accrued in its own transaction.
+func (self *Backend) RunSavingsInterest(now time.Time) error {
+	var policies []SavingsPolicy
+	if err := self.db.Where("economy_id NOT IN (?)", self.lockedEconomies()).Find(&policies).Error; err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, policy := range policies {
+		economy, err := self.economyTx(self.db, policy.EconomyID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		location := economy.Location()
+		var accounts []SavingsAccount
+		if err := self.db.Where("economy_id = ? AND accrued_on < ?", policy.EconomyID, PeriodStart(now, PD_Daily, location).UTC()).Find(&accounts).Error; err != nil {
+			errs = append(errs, fmt.Errorf("economy %s: %w", economy.Name, err))
+			continue
+		}
+
+		for _, savings := range accounts {
+			session := self.db.Begin()
+
+			if err := self.accrueSavingsTx(session, policy, location, savings, now); err != nil {
+				session.Rollback()
+				errs = append(errs, fmt.Errorf("savings account %s: %w", savings.AccountID.String(), err))
+				continue
+			}
+
+			if err := session.Commit().Error; err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,210 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+// MaxAccountSessionDuration is the longest a user can be logged in as an account at once.
+const MaxAccountSessionDuration = 24 * time.Hour
+
+// DelegatedPermissions are the account permissions a user holds on the account they are logged in as.
+var DelegatedPermissions = []uint8{P_ViewBalance, P_TransferFunds, P_CreateRecurringTransfer}
+
+// activeSessionTx returns the account session of a user in an economy that has neither ended nor expired, or nil if there is none.
+func (self *Backend) activeSessionTx(session *gorm.DB, user_id string, economy_id string) (*AccountSession, error) {
+	var account_session AccountSession
+	err := session.Where("user_id = ? AND economy_id = ? AND ended_at IS NULL AND expires_at > ?", user_id, economy_id, time.Now().UTC()).
+		Preload("Account.Economy").Order("id DESC").First(&account_session).Error
+	if errors.Is(err, RecordNotFoundError) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &account_session, nil
+}
+
+// delegatedPermission reports whether the member holds a permission on an account only because they are logged in as it.
+// The session only delegates while the member may still log in as the account, so revoking P_LoginAsAccount ends it at once.
+func (self *Backend) delegatedPermission(member SessionedMember, permission uint8, account *Account) (bool, error) {
+	if account == nil || !slices.Contains(DelegatedPermissions, permission) {
+		return false, nil
+	}
+
+	account_session, err := self.activeSessionTx(self.db, member.User.ID, account.EconomyID)
+	if err != nil || account_session == nil || !account_session.AccountID.Equals(account.ID) {
+		return false, err
+	}
+	return self.grantedPermission(member, P_LoginAsAccount, account, nil)
+}
+
+// actingAs returns the ID of the account if the member is currently logged in as it, which actions on it are then recorded on behalf of.
+func (self *Backend) actingAs(session *gorm.DB, member SessionedMember, account Account) (*datatypes.UUID, error) {
+	account_session, err := self.activeSessionTx(session, member.User.ID, account.EconomyID)
+	if err != nil || account_session == nil || !account_session.AccountID.Equals(account.ID) {
+		return nil, err
+	}
+
+	id := account.ID
+	return &id, nil
+}
+
+// GetAccountSession returns the member's active account session in an economy, or nil if they are not logged in as any account.
+func (self *Backend) GetAccountSession(member SessionedMember, economy Economy) (*AccountSession, error) {
+	return self.activeSessionTx(self.db, member.User.ID, economy.ID.String())
+}
+
+// StartAccountSession logs the member in as an account for the given duration, ending any session they had in the economy.
+func (self *Backend) StartAccountSession(member SessionedMember, account Account, duration time.Duration) (*AccountSession, error) {
+	if perm, err := self.HasPermission(member, P_LoginAsAccount, &account, nil); err != nil {
+		return nil, err
+	} else if !perm {
+		return nil, BackendError{Message: "You do not have the permission to log in as this account."}
+	} else if account.Deleted {
+		return nil, BackendError{Message: "You cannot log in as a closed account."}
+	} else if duration < time.Minute || duration > MaxAccountSessionDuration {
+		return nil, BackendError{Message: fmt.Sprintf("A session has to last between a minute and %d hours.", int(MaxAccountSessionDuration.Hours()))}
+	}
+
+	session := self.db.Begin()
+
+	if err := self.endSessionsTx(session, member.User.ID, account.EconomyID, member.User.ID, "replaced"); err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	account_session := AccountSession{
+		UserID: member.User.ID,
+		EconomyID: account.EconomyID,
+		AccountID: account.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(duration),
+	}
+
+	if err := session.Omit("Account").Create(&account_session).Error; err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	err := self.auditTx(session, AuditEntry{
+		EconomyID: account.EconomyID,
+		ActorID: member.User.ID,
+		OnBehalfOfID: &account.ID,
+		Change: CUD_Create,
+		Action: "account session",
+		Target: account.ID.String(),
+		Details: fmt.Sprintf("logged in as %s until %s", account.AccountName, account_session.ExpiresAt.Format(time.RFC3339)),
+	})
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	account_session.Account = account
+	return &account_session, session.Commit().Error
+}
+
+// endSessionsTx ends every active session of a user in an economy, recording why in the audit log.
+func (self *Backend) endSessionsTx(session *gorm.DB, user_id string, economy_id string, actor_id string, reason string) error {
+	var sessions []AccountSession
+	if err := session.Where("user_id = ? AND economy_id = ? AND ended_at IS NULL", user_id, economy_id).Find(&sessions).Error; err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, account_session := range sessions {
+		ended := now
+		if account_session.ExpiresAt.Before(now) {
+			ended = account_session.ExpiresAt
+		}
+		result := session.Model(&AccountSession{}).Where("id = ? AND ended_at IS NULL", account_session.ID).Update("ended_at", ended)
+		if err := result.Error; err != nil {
+			return err
+		} else if result.RowsAffected == 0 {
+			continue
+		}
+
+		err := self.auditTx(session, AuditEntry{
+			EconomyID: economy_id,
+			ActorID: actor_id,
+			OnBehalfOfID: &account_session.AccountID,
+			Change: CUD_Delete,
+			Action: "account session",
+			Target: account_session.AccountID.String(),
+			Details: fmt.Sprintf("session of <@%s> %s", user_id, reason),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EndAccountSession logs the member out of the account they are logged in as in an economy.
+func (self *Backend) EndAccountSession(member SessionedMember, economy Economy) (*AccountSession, error) {
+	account_session, err := self.GetAccountSession(member, economy)
+	if err != nil {
+		return nil, err
+	} else if account_session == nil {
+		return nil, BackendError{Message: "You are not logged in as any account."}
+	}
+
+	session := self.db.Begin()
+
+	if err := self.endSessionsTx(session, member.User.ID, economy.ID.String(), member.User.ID, "ended"); err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	return account_session, session.Commit().Error
+}
+
+// ExpireAccountSessions ends every session that expired by now and returns them, so their users can be notified.
+func (self *Backend) ExpireAccountSessions(now time.Time) ([]AccountSession, error) {
+	var sessions []AccountSession
+	err := self.db.Where("ended_at IS NULL AND expires_at <= ?", now.UTC()).Preload("Account").Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []AccountSession
+	for _, account_session := range sessions {
+		session := self.db.Begin()
+
+		result := session.Model(&AccountSession{}).Where("id = ? AND ended_at IS NULL", account_session.ID).Update("ended_at", account_session.ExpiresAt)
+		if err := result.Error; err != nil {
+			session.Rollback()
+			return expired, err
+		} else if result.RowsAffected == 0 {
+			session.Rollback()
+			continue
+		}
+
+		err := self.auditTx(session, AuditEntry{
+			EconomyID: account_session.EconomyID,
+			OnBehalfOfID: &account_session.AccountID,
+			Change: CUD_Delete,
+			Action: "account session",
+			Target: account_session.AccountID.String(),
+			Details: fmt.Sprintf("session of <@%s> expired", account_session.UserID),
+		})
+		if err != nil {
+			session.Rollback()
+			return expired, err
+		}
+
+		if err := session.Commit().Error; err != nil {
+			return expired, err
+		}
+		expired = append(expired, account_session)
+	}
+
+	return expired, nil
+}
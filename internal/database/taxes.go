@@ -0,0 +1,429 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+// BasisPoints is a rate of 100% in basis points, which every rate in an economy is given in.
+const BasisPoints = 10000
+
+// MaxTaxRate is the highest rate of a tax bracket, in basis points.
+const MaxTaxRate = BasisPoints
+
+// TaxLeg is the part of a tax owed under a single bracket.
+type TaxLeg struct {
+	Tax 		Tax
+	Basis 		datatypes.Money
+	Amount 		datatypes.Money
+}
+
+// bracketsTx returns the brackets of an economy for a tax type and affected account type, ordered by their start.
+func (self *Backend) bracketsTx(session *gorm.DB, economy_id string, tax_type uint8, affected_type uint8) ([]Tax, error) {
+	var brackets []Tax
+	err := session.Where("economy_id = ? AND tax_type = ? AND affected_type = ?", economy_id, tax_type, affected_type).Order("bracket_start").Find(&brackets).Error
+	return brackets, err
+}
+
+// bracketCovers returns how much of the range [from, to) falls into the bracket; a bracket end of zero means it is unbounded.
+func bracketCovers(bracket Tax, from datatypes.Money, to datatypes.Money) datatypes.Money {
+	start, end := max(bracket.BracketStart, from), to
+	if !bracket.BracketEnd.IsZero() {
+		end = min(bracket.BracketEnd, to)
+	}
+
+	if end <= start {
+		return 0
+	}
+	return end - start
+}
+
+// progressiveTax applies progressive brackets to the range [from, to) of a running total, which for a one-off
+// assessment such as wealth tax is simply [0, basis). Each bracket only taxes the part of the range that falls into it.
+func progressiveTax(brackets []Tax, from datatypes.Money, to datatypes.Money) ([]TaxLeg, error) {
+	var legs []TaxLeg
+	for _, bracket := range brackets {
+		basis := bracketCovers(bracket, from, to)
+		if basis.IsZero() {
+			continue
+		}
+
+		amount, err := basis.MulRate(int64(bracket.Rate), BasisPoints)
+		if err != nil {
+			return nil, err
+		} else if amount.IsZero() {
+			continue
+		}
+
+		legs = append(legs, TaxLeg{Tax: bracket, Basis: basis, Amount: amount})
+	}
+	return legs, nil
+}
+
+func sumLegs(legs []TaxLeg) (datatypes.Money, error) {
+	var total datatypes.Money
+	for _, leg := range legs {
+		var err error
+		if total, err = total.Add(leg.Amount); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// capLegs reduces the legs so that they sum to at most limit, taking the reduction from the highest brackets first.
+func capLegs(legs []TaxLeg, limit datatypes.Money) []TaxLeg {
+	capped := make([]TaxLeg, 0, len(legs))
+	for _, leg := range legs {
+		if limit <= 0 {
+			break
+		}
+
+		leg.Amount = min(leg.Amount, limit)
+		limit -= leg.Amount
+		capped = append(capped, leg)
+	}
+	return capped
+}
+
+// taxPostings returns the postings that credit each leg to its bracket's account.
+func taxPostings(legs []TaxLeg) []Posting {
+	postings := make([]Posting, 0, len(legs))
+	for _, leg := range legs {
+		entry_id := leg.Tax.EntryID
+		postings = append(postings, Posting{AccountID: leg.Tax.ToAccountID, Amount: leg.Amount, TaxEntryID: &entry_id})
+	}
+	return postings
+}
+
+// recordReceiptsTx stores a receipt for every leg of tax the account paid in the journal.
+func (self *Backend) recordReceiptsTx(session *gorm.DB, journal *Journal, account Account, legs []TaxLeg, run_id *uint) error {
+	if len(legs) == 0 {
+		return nil
+	}
+
+	receipts := make([]TaxReceipt, 0, len(legs))
+	for _, leg := range legs {
+		receipts = append(receipts, TaxReceipt{
+			EconomyID: journal.EconomyID,
+			JournalID: journal.ID,
+			TaxEntryID: leg.Tax.EntryID,
+			TaxType: leg.Tax.TaxType,
+			AccountID: account.ID,
+			ToAccountID: leg.Tax.ToAccountID,
+			Basis: leg.Basis,
+			Amount: leg.Amount,
+			CreatedAt: journal.CreatedAt,
+			WealthTaxRunID: run_id,
+		})
+	}
+
+	return session.Create(&receipts).Error
+}
+
+// reverseReceiptsTx stores a negated copy of every receipt of the original journal under the reversing journal,
+// so that reports over receipts net out refunded tax.
+func (self *Backend) reverseReceiptsTx(session *gorm.DB, original_id uint, journal *Journal) error {
+	var receipts []TaxReceipt
+	if err := session.Where("journal_id = ?", original_id).Find(&receipts).Error; err != nil {
+		return err
+	} else if len(receipts) == 0 {
+		return nil
+	}
+
+	for i := range receipts {
+		var err error
+		if receipts[i].Basis, err = receipts[i].Basis.Neg(); err != nil {
+			return err
+		} else if receipts[i].Amount, err = receipts[i].Amount.Neg(); err != nil {
+			return err
+		}
+
+		receipts[i].ID = 0
+		receipts[i].JournalID = journal.ID
+		receipts[i].CreatedAt = journal.CreatedAt
+	}
+
+	return session.Create(&receipts).Error
+}
+
+// collectTaxTx posts a standalone JT_Tax journal that moves the legs from the account to their bracket accounts.
+func (self *Backend) collectTaxTx(session *gorm.DB, actor_id string, account Account, legs []TaxLeg, memo string, run_id *uint) (*Journal, error) {
+	total, err := sumLegs(legs)
+	if err != nil {
+		return nil, err
+	}
+
+	debit, err := total.Neg()
+	if err != nil {
+		return nil, err
+	}
+
+	journal := Journal{
+		EconomyID: account.EconomyID,
+		JournalType: JT_Tax,
+		ActorID: actor_id,
+		Memo: memo,
+		Postings: append([]Posting{{AccountID: account.ID, Amount: debit}}, taxPostings(legs)...),
+	}
+
+	if err := self.postJournalTx(session, &journal, nil); err != nil {
+		return nil, err
+	}
+
+	if err := self.recordReceiptsTx(session, &journal, account, legs, run_id); err != nil {
+		return nil, err
+	}
+
+	return &journal, nil
+}
+
+// transferTaxesTx returns what the sender of a transfer is debited, what the recipient is credited, and the legs of tax collected in between.
+// Income tax is withheld from the recipient, while VAT is either added on top of the price or carved out of it, depending on the economy.
+// Transaction tax is charged to the sender on top of any transfer, by the brackets of the sending account's type.
+func (self *Backend) transferTaxesTx(session *gorm.DB, economy Economy, from *Account, to *Account, amount datatypes.Money, transfer_type uint8) (datatypes.Money, datatypes.Money, []TaxLeg, error) {
+	var legs []TaxLeg
+	var err error
+
+	switch transfer_type {
+	case TT_Income:
+		legs, err = self.incomeTaxTx(session, economy, to, amount, time.Now())
+	case TT_Purchase:
+		legs, err = self.vatTx(session, economy, to, amount)
+	}
+
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	brackets, err := self.bracketsTx(session, economy.ID.String(), TX_Transaction, from.AccountType)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	charges, err := progressiveTax(brackets, 0, amount)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	tax, err := sumLegs(legs)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	charged, err := sumLegs(charges)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	debit, credit := amount, amount
+	if transfer_type == TT_Purchase && !economy.VATInclusive {
+		debit, err = amount.Add(tax)
+	} else {
+		credit, err = amount.Sub(tax)
+	}
+
+	if err != nil {
+		return 0, 0, nil, err
+	} else if debit, err = debit.Add(charged); err != nil {
+		return 0, 0, nil, err
+	}
+
+	debit, err = debit.Neg()
+	return debit, credit, append(legs, charges...), err
+}
+
+// recordTransferReceiptsTx issues the receipts for the legs of tax collected on a transfer. Transaction tax is receipted to the sender,
+// who paid it, and everything else to the recipient, who earned the income or made the sale.
+func (self *Backend) recordTransferReceiptsTx(session *gorm.DB, journal *Journal, from Account, to Account, legs []TaxLeg) error {
+	var paid, collected []TaxLeg
+	for _, leg := range legs {
+		if leg.Tax.TaxType == TX_Transaction {
+			paid = append(paid, leg)
+		} else {
+			collected = append(collected, leg)
+		}
+	}
+
+	if err := self.recordReceiptsTx(session, journal, from, paid, nil); err != nil {
+		return err
+	}
+	return self.recordReceiptsTx(session, journal, to, collected, nil)
+}
+
+func (self *Backend) GetTaxBrackets(economy Economy) ([]Tax, error) {
+	var brackets []Tax
+	result := self.db.Where("economy_id = ?", economy.ID.String()).Preload("ToAccount").Order("tax_type").Order("affected_type").Order("bracket_start").Find(&brackets)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	return brackets, nil
+}
+
+func (self *Backend) GetTaxBracketByID(economy Economy, id string) (Tax, error) {
+	var bracket Tax
+	result := self.db.Where("entry_id = ? AND economy_id = ?", id, economy.ID.String()).Preload("ToAccount").First(&bracket)
+	if err := result.Error; err != nil {
+		return Tax{}, err
+	}
+	return bracket, nil
+}
+
+// validateBracketTx checks a bracket's bounds, rate and target account, and that it does not overlap
+// any other bracket of the same tax type and affected account type.
+func (self *Backend) validateBracketTx(session *gorm.DB, economy Economy, bracket Tax) error {
+	if strings.TrimSpace(bracket.TaxName) == "" {
+		return BackendError{Message: "A tax bracket needs a name."}
+	} else if bracket.TaxType > TX_Transaction {
+		return BackendError{Message: "Unknown tax type."}
+	} else if bracket.AffectedType > AT_Charity {
+		return BackendError{Message: "Unknown affected account type."}
+	} else if bracket.Rate > MaxTaxRate {
+		return BackendError{Message: fmt.Sprintf("A tax rate must be between 0%% and %s.", FormatBasisPoints(MaxTaxRate))}
+	} else if bracket.BracketStart.IsNegative() || bracket.BracketEnd.IsNegative() {
+		return BackendError{Message: "A tax bracket cannot have negative bounds."}
+	} else if !bracket.BracketEnd.IsZero() && bracket.BracketEnd <= bracket.BracketStart {
+		return BackendError{Message: "A tax bracket has to end after it starts."}
+	}
+
+	var target Account
+	if err := session.Where("id = ?", bracket.ToAccountID).First(&target).Error; err != nil {
+		if errors.Is(err, RecordNotFoundError) {
+			return BackendError{Message: "The account to collect the tax into does not exist."}
+		}
+		return err
+	} else if target.EconomyID != economy.ID.String() {
+		return BackendError{Message: "The account to collect the tax into has to be in the same economy."}
+	} else if target.Deleted || target.AccountType == AT_Reserve || target.AccountType == AT_Escrow {
+		return BackendError{Message: "Tax cannot be collected into this account."}
+	}
+
+	brackets, err := self.bracketsTx(session, economy.ID.String(), bracket.TaxType, bracket.AffectedType)
+	if err != nil {
+		return err
+	}
+
+	for _, other := range brackets {
+		if other.EntryID == bracket.EntryID {
+			continue
+		}
+
+		// brackets are half-open ranges and an end of zero is unbounded
+		starts_before := other.BracketEnd.IsZero() || bracket.BracketStart < other.BracketEnd
+		ends_after := bracket.BracketEnd.IsZero() || other.BracketStart < bracket.BracketEnd
+		if starts_before && ends_after {
+			return BackendError{Message: fmt.Sprintf("This bracket overlaps the bracket '%s'.", other.TaxName)}
+		}
+	}
+
+	return nil
+}
+
+func (self *Backend) CreateTaxBracket(member SessionedMember, economy Economy, bracket *Tax) error {
+	if perm, err := self.HasPermission(member, P_ManageTaxBrackets, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage tax brackets in this economy."}
+	}
+
+	bracket.EntryID = datatypes.NewUUIDv4().String()
+	bracket.EconomyID = economy.ID.String()
+
+	session := self.db.Begin()
+
+	if err := self.validateBracketTx(session, economy, *bracket); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := session.Omit("ToAccount").Create(bracket).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+func (self *Backend) UpdateTaxBracket(member SessionedMember, economy Economy, bracket *Tax) error {
+	if perm, err := self.HasPermission(member, P_ManageTaxBrackets, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage tax brackets in this economy."}
+	} else if bracket.EconomyID != economy.ID.String() {
+		return BackendError{Message: "This tax bracket does not belong to this economy."}
+	}
+
+	session := self.db.Begin()
+
+	if err := self.validateBracketTx(session, economy, *bracket); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := session.Omit("ToAccount").Save(bracket).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+func (self *Backend) DeleteTaxBracket(member SessionedMember, economy Economy, bracket Tax) error {
+	if perm, err := self.HasPermission(member, P_ManageTaxBrackets, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage tax brackets in this economy."}
+	} else if bracket.EconomyID != economy.ID.String() {
+		return BackendError{Message: "This tax bracket does not belong to this economy."}
+	}
+
+	session := self.db.Begin()
+
+	if err := session.Where("entry_id = ?", bracket.EntryID).Delete(&Tax{}).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
+
+// SimulateTax returns the legs of tax an account of the given type would owe on an amount, without collecting anything.
+// VAT is simulated with the economy's pricing, but without regard to exemptions of the seller.
+func (self *Backend) SimulateTax(economy Economy, tax_type uint8, affected_type uint8, amount datatypes.Money) ([]TaxLeg, error) {
+	brackets, err := self.bracketsTx(self.db, economy.ID.String(), tax_type, affected_type)
+	if err != nil {
+		return nil, err
+	} else if tax_type == TX_VAT {
+		return vatLegs(brackets, amount, economy.VATInclusive)
+	}
+	return progressiveTax(brackets, 0, amount)
+}
+
+// MigrateTaxes assigns brackets that predate Tax.EconomyID to the economy of the account they collect into,
+// and moves the rates of brackets that predate basis points out of the old rate column, which held whole percents.
+func (self *Backend) MigrateTaxes() error {
+	err := self.db.Model(&Tax{}).Where("economy_id IS NULL OR economy_id = ?", "").
+		Update("economy_id", gorm.Expr("(SELECT accounts.economy_id FROM accounts WHERE accounts.id = taxes.to_account_id)")).Error
+	if err != nil || !self.db.Migrator().HasColumn(&Tax{}, "rate") {
+		return err
+	}
+
+	session := self.db.Begin()
+
+	if err := session.Model(&Tax{}).Where("1 = 1").Update("rate_bp", gorm.Expr("rate * ?", BasisPoints / 100)).Error; err != nil {
+		session.Rollback()
+		return err
+	}
+
+	if err := session.Migrator().DropColumn(&Tax{}, "rate"); err != nil {
+		session.Rollback()
+		return err
+	}
+
+	return session.Commit().Error
+}
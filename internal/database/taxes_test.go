@@ -0,0 +1,126 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+)
+
+func TestValidateBracket(t *testing.T) {
+	type bounds struct {
+		start, end 	datatypes.Money
+	}
+
+	tests := []struct {
+		name 			string
+		tax_type 		uint8
+		affected_type 	uint8
+		bracket 		bounds
+		rate 			uint
+		ok 				bool
+	}{
+		{"fills the gap", TX_Income, AT_User, bounds{10000, 20000}, 1500, true},
+		{"ends where the next starts", TX_Income, AT_User, bounds{15000, 20000}, 1500, true},
+		{"overlaps the lower bracket", TX_Income, AT_User, bounds{5000, 15000}, 1500, false},
+		{"overlaps its last unit", TX_Income, AT_User, bounds{9999, 10000}, 1500, false},
+		{"overlaps the unbounded bracket", TX_Income, AT_User, bounds{15000, 25000}, 1500, false},
+		{"unbounded overlaps the unbounded bracket", TX_Income, AT_User, bounds{10000, 0}, 1500, false},
+		{"covers both brackets", TX_Income, AT_User, bounds{0, 0}, 1500, false},
+		{"other affected type", TX_Income, AT_Corporation, bounds{0, 10000}, 1500, true},
+		{"other tax type", TX_Wealth, AT_User, bounds{0, 10000}, 1500, true},
+		{"ends before it starts", TX_Income, AT_User, bounds{15000, 12000}, 1500, false},
+		{"negative start", TX_Income, AT_User, bounds{-1, 10000}, 1500, false},
+		{"rate above the maximum", TX_Income, AT_User, bounds{10000, 20000}, MaxTaxRate + 1, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := newTestBackend(t)
+			economy := newTestEconomy(t, backend, "economy")
+			treasury := newTestAccount(t, backend, economy, "treasury", "", AT_Government)
+			grantPermission(t, backend, "admin", P_ManageTaxBrackets, nil, nil, true)
+
+			for _, existing := range []bounds{{0, 10000}, {20000, 0}} {
+				bracket := Tax{TaxName: "existing", TaxType: TX_Income, AffectedType: AT_User, BracketStart: existing.start, BracketEnd: existing.end, Rate: 1000, ToAccountID: treasury.ID}
+				if err := backend.CreateTaxBracket(testMember("admin"), economy, &bracket); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			bracket := Tax{TaxName: "new", TaxType: test.tax_type, AffectedType: test.affected_type, BracketStart: test.bracket.start, BracketEnd: test.bracket.end, Rate: test.rate, ToAccountID: treasury.ID}
+			if err := backend.CreateTaxBracket(testMember("admin"), economy, &bracket); (err == nil) != test.ok {
+				t.Errorf("CreateTaxBracket(%d, %d) = %v; want ok %t", test.bracket.start, test.bracket.end, err, test.ok)
+			}
+		})
+	}
+}
+
+func TestUpdateTaxBracketOwnRange(t *testing.T) {
+	backend := newTestBackend(t)
+	economy := newTestEconomy(t, backend, "economy")
+	treasury := newTestAccount(t, backend, economy, "treasury", "", AT_Government)
+	grantPermission(t, backend, "admin", P_ManageTaxBrackets, nil, nil, true)
+
+	bracket := Tax{TaxName: "income", TaxType: TX_Income, AffectedType: AT_User, BracketStart: 0, BracketEnd: 10000, Rate: 1000, ToAccountID: treasury.ID}
+	if err := backend.CreateTaxBracket(testMember("admin"), economy, &bracket); err != nil {
+		t.Fatal(err)
+	}
+
+	// a bracket does not overlap itself, so it can be widened over its own range
+	bracket.BracketEnd = 20000
+	if err := backend.UpdateTaxBracket(testMember("admin"), economy, &bracket); err != nil {
+		t.Errorf("UpdateTaxBracket over its own range = %v", err)
+	}
+}
+
+func TestProgressiveTax(t *testing.T) {
+	brackets := []Tax{
+		{TaxName: "low", BracketStart: 0, BracketEnd: 10000, Rate: 1000},
+		{TaxName: "middle", BracketStart: 10000, BracketEnd: 20000, Rate: 2000},
+		{TaxName: "high", BracketStart: 20000, Rate: 3000},
+	}
+
+	type leg struct {
+		bracket 		string
+		basis, amount 	datatypes.Money
+	}
+
+	tests := []struct {
+		name 		string
+		from, to 	datatypes.Money
+		want 		[]leg
+	}{
+		{"nothing", 0, 0, nil},
+		{"within the lowest bracket", 0, 5000, []leg{{"low", 5000, 500}}},
+		{"up to a boundary", 0, 10000, []leg{{"low", 10000, 1000}}},
+		{"across a boundary", 0, 15000, []leg{{"low", 10000, 1000}, {"middle", 5000, 1000}}},
+		{"into the unbounded bracket", 0, 25000, []leg{{"low", 10000, 1000}, {"middle", 10000, 2000}, {"high", 5000, 1500}}},
+		{"running total across a boundary", 15000, 25000, []leg{{"middle", 5000, 1000}, {"high", 5000, 1500}}},
+		{"running total within a bracket", 12000, 13000, []leg{{"middle", 1000, 200}}},
+		{"rounds down to nothing", 0, 9, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			legs, err := progressiveTax(brackets, test.from, test.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]leg, 0, len(legs))
+			for _, l := range legs {
+				got = append(got, leg{l.Tax.TaxName, l.Basis, l.Amount})
+			}
+
+			if len(got) != len(test.want) {
+				t.Fatalf("progressiveTax(%d, %d) = %v; want %v", test.from, test.to, got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("progressiveTax(%d, %d) = %v; want %v", test.from, test.to, got, test.want)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"time"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+	"gorm.io/gorm"
+)
+
+// VATReportLine is the VAT a seller collected under a single bracket.
+type VATReportLine struct {
+	TaxEntryID 	string
+	TaxName 	string
+	Rate 		uint
+	Sales 		int64
+	Net 		datatypes.Money
+	VAT 		datatypes.Money
+}
+
+// VATReport is the VAT a seller collected over a period.
+type VATReport struct {
+	Seller 		Account
+	From 		time.Time
+	To 			time.Time
+	Sales 		int64
+	Net 		datatypes.Money
+	VAT 		datatypes.Money
+	Lines 		[]VATReportLine
+}
+
+// vatTx returns the VAT owed on a purchase, which is a single leg at the rate of the bracket the net price falls into.
+// VAT is charged by the seller, so both the brackets and the exemptions go by the type of the selling account.
+func (self *Backend) vatTx(session *gorm.DB, economy Economy, seller *Account, amount datatypes.Money) ([]TaxLeg, error) {
+	var exemptions int64
+	if err := session.Model(&VATExemption{}).Where("economy_id = ? AND account_type = ?", economy.ID.String(), seller.AccountType).Count(&exemptions).Error; err != nil {
+		return nil, err
+	} else if exemptions > 0 {
+		return nil, nil
+	}
+
+	brackets, err := self.bracketsTx(session, economy.ID.String(), TX_VAT, seller.AccountType)
+	if err != nil {
+		return nil, err
+	}
+	return vatLegs(brackets, amount, economy.VATInclusive)
+}
+
+// vatLegs applies the rate of the bracket the net price falls into to the whole net price, unlike progressiveTax.
+// The brackets have to be ordered by their start. With VAT-inclusive pricing the amount already contains the VAT, so the
+// net price is worked out at the rate of each bracket, and the last bracket whose start it reaches is the one it falls into.
+// Between two adjacent brackets whose rate rises, a price can be past the end of the lower bracket at its own rate but
+// short of the start of the higher one at the higher rate; such prices stay in the lower bracket rather than escape VAT.
+func vatLegs(brackets []Tax, amount datatypes.Money, inclusive bool) ([]TaxLeg, error) {
+	var bracket *Tax
+	var net datatypes.Money
+	next := 0
+	for i := range brackets {
+		bracket_net := amount
+		if inclusive {
+			var err error
+			if bracket_net, err = amount.MulRate(BasisPoints, BasisPoints + int64(brackets[i].Rate)); err != nil {
+				return nil, err
+			}
+		}
+
+		if bracket_net >= brackets[i].BracketStart {
+			bracket, net, next = &brackets[i], bracket_net, i + 1
+		}
+	}
+
+	if bracket == nil {
+		return nil, nil
+	} else if !bracket.BracketEnd.IsZero() && net >= bracket.BracketEnd {
+		// past the end of its bracket, the net price only stays in it if the next bracket starts right where it ends
+		if !inclusive || next >= len(brackets) || brackets[next].BracketStart != bracket.BracketEnd {
+			return nil, nil
+		}
+	}
+
+	vat := amount - net
+	if !inclusive {
+		var err error
+		if vat, err = net.MulRate(int64(bracket.Rate), BasisPoints); err != nil {
+			return nil, err
+		}
+	}
+
+	if vat.IsZero() {
+		return nil, nil
+	}
+	return []TaxLeg{{Tax: *bracket, Basis: net, Amount: vat}}, nil
+}
+
+func (self *Backend) SetVATInclusive(member SessionedMember, economy *Economy, inclusive bool) error {
+	if perm, err := self.HasPermission(member, P_ManageTaxBrackets, nil, economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage taxes in this economy."}
+	}
+
+	if err := self.db.Model(&Economy{}).Where("id = ?", economy.ID).Update("vat_inclusive", inclusive).Error; err != nil {
+		return err
+	}
+
+	economy.VATInclusive = inclusive
+	return nil
+}
+
+func (self *Backend) GetVATExemptions(economy Economy) ([]VATExemption, error) {
+	var exemptions []VATExemption
+	result := self.db.Where("economy_id = ?", economy.ID.String()).Order("account_type").Find(&exemptions)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	return exemptions, nil
+}
+
+// SetVATExemption exempts or stops exempting sales by accounts of the given type from VAT.
+func (self *Backend) SetVATExemption(member SessionedMember, economy Economy, account_type uint8, exempt bool) error {
+	if perm, err := self.HasPermission(member, P_ManageTaxBrackets, nil, &economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage taxes in this economy."}
+	} else if account_type > AT_Charity {
+		return BackendError{Message: "Unknown account type."}
+	}
+
+	exemption := VATExemption{EconomyID: economy.ID.String(), AccountType: account_type}
+	if !exempt {
+		return self.db.Where("economy_id = ? AND account_type = ?", exemption.EconomyID, account_type).Delete(&VATExemption{}).Error
+	}
+
+	return self.db.Where(map[string]any{"economy_id": exemption.EconomyID, "account_type": account_type}).FirstOrCreate(&exemption).Error
+}
+
+// GetVATReport returns the VAT a seller collected between from and to, broken down by bracket.
+// Reversed sales are netted out, as their receipts are reversed as well.
+func (self *Backend) GetVATReport(member SessionedMember, seller Account, from time.Time, to time.Time) (VATReport, error) {
+	if perm, err := self.HasPermission(member, P_ViewBalance, &seller, nil); err != nil {
+		return VATReport{}, err
+	} else if !perm {
+		if perm, err := self.HasPermission(member, P_ManageTaxBrackets, &seller, nil); err != nil {
+			return VATReport{}, err
+		} else if !perm {
+			return VATReport{}, BackendError{Message: "You do not have the permission to view the VAT report of this account."}
+		}
+	}
+
+	report := VATReport{Seller: seller, From: from, To: to}
+	err := self.db.Model(&TaxReceipt{}).
+		Select("tax_receipts.tax_entry_id AS tax_entry_id, taxes.tax_name AS tax_name, taxes.rate_bp AS rate, SUM(CASE WHEN tax_receipts.amount < 0 THEN -1 ELSE 1 END) AS sales, SUM(tax_receipts.basis) AS net, SUM(tax_receipts.amount) AS vat").
+		Joins("LEFT JOIN taxes ON taxes.entry_id = tax_receipts.tax_entry_id").
+		Where("tax_receipts.account_id = ? AND tax_receipts.tax_type = ?", seller.ID, TX_VAT).
+		Where("tax_receipts.created_at >= ? AND tax_receipts.created_at < ?", from, to).
+		Group("tax_receipts.tax_entry_id, taxes.tax_name, taxes.rate_bp").
+		Order("taxes.rate_bp").
+		Scan(&report.Lines).Error
+	if err != nil {
+		return VATReport{}, err
+	}
+
+	for _, line := range report.Lines {
+		report.Sales += line.Sales
+		if report.Net, err = report.Net.Add(line.Net); err != nil {
+			return VATReport{}, err
+		} else if report.VAT, err = report.VAT.Add(line.VAT); err != nil {
+			return VATReport{}, err
+		}
+	}
+
+	return report, nil
+}
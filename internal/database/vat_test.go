@@ -0,0 +1,81 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/ohknettel/taubot-v3/pkg/datatypes"
+)
+
+func TestVATLegs(t *testing.T) {
+	adjacent := []Tax{
+		{TaxName: "low", BracketStart: 0, BracketEnd: 10000, Rate: 1000},
+		{TaxName: "high", BracketStart: 10000, Rate: 2000},
+	}
+	apart := []Tax{
+		{TaxName: "low", BracketStart: 0, BracketEnd: 10000, Rate: 1000},
+		{TaxName: "high", BracketStart: 20000, Rate: 2000},
+	}
+
+	tests := []struct {
+		name 		string
+		brackets 	[]Tax
+		amount 		datatypes.Money
+		inclusive 	bool
+		bracket 	string
+		basis 		datatypes.Money
+		vat 		datatypes.Money
+	}{
+		{"exclusive below the boundary", adjacent, 9999, false, "low", 9999, 999},
+		{"exclusive at the boundary", adjacent, 10000, false, "high", 10000, 2000},
+		{"exclusive between brackets", apart, 15000, false, "", 0, 0},
+		{"exclusive zero", adjacent, 0, false, "", 0, 0},
+		{"inclusive below the boundary", adjacent, 10999, true, "low", 9999, 1000},
+		{"inclusive at the boundary of the lower bracket", adjacent, 11000, true, "low", 10000, 1000},
+		{"inclusive short of the higher bracket", adjacent, 11999, true, "low", 10908, 1091},
+		{"inclusive at the boundary of the higher bracket", adjacent, 12000, true, "high", 10000, 2000},
+		{"inclusive between brackets", apart, 15000, true, "", 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			legs, err := vatLegs(test.brackets, test.amount, test.inclusive)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.bracket == "" {
+				if len(legs) != 0 {
+					t.Errorf("vatLegs(%d) = %+v; want no legs", test.amount, legs)
+				}
+				return
+			}
+
+			if len(legs) != 1 || legs[0].Tax.TaxName != test.bracket || legs[0].Basis != test.basis || legs[0].Amount != test.vat {
+				t.Errorf("vatLegs(%d) = %+v; want %s bracket, basis %d, VAT %d", test.amount, legs, test.bracket, test.basis, test.vat)
+			}
+		})
+	}
+}
+
+func TestVATBySeller(t *testing.T) {
+	backend := newTestBackend(t)
+	economy := newTestEconomy(t, backend, "economy")
+	seller := newTestAccount(t, backend, economy, "shop", "bob", AT_Corporation)
+	treasury := newTestAccount(t, backend, economy, "treasury", "", AT_Government)
+
+	bracket := Tax{EntryID: datatypes.NewUUIDv4().String(), EconomyID: economy.ID.String(), TaxName: "vat", TaxType: TX_VAT, AffectedType: AT_Corporation, Rate: 1000, ToAccountID: treasury.ID}
+	if err := backend.db.Omit("ToAccount").Create(&bracket).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if legs, err := backend.vatTx(backend.db, economy, &seller, 1000); err != nil || len(legs) != 1 || legs[0].Amount != 100 {
+		t.Errorf("vatTx by the seller's type = %+v, %v; want VAT of 100", legs, err)
+	}
+
+	if err := backend.db.Create(&VATExemption{EconomyID: economy.ID.String(), AccountType: AT_Corporation}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if legs, err := backend.vatTx(backend.db, economy, &seller, 1000); err != nil || len(legs) != 0 {
+		t.Errorf("vatTx by an exempt seller = %+v, %v; want no legs", legs, err)
+	}
+}
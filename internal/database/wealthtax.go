@@ -0,0 +1,258 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// assessWealthTaxTx computes the wealth tax of every open account in the economy from its current balance.
+// Accounts that owe nothing are left out.
+func (self *Backend) assessWealthTaxTx(session *gorm.DB, economy Economy) ([]WealthTaxAssessment, error) {
+	var accounts []Account
+	err := session.Where("economy_id = ? AND deleted = ? AND account_type <> ? AND account_type <> ? AND balance > 0", economy.ID.String(), false, AT_Reserve, AT_Escrow).Order("id").Find(&accounts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	brackets := make(map[uint8][]Tax)
+	var assessments []WealthTaxAssessment
+	for _, account := range accounts {
+		if _, ok := brackets[account.AccountType]; !ok {
+			if brackets[account.AccountType], err = self.bracketsTx(session, economy.ID.String(), TX_Wealth, account.AccountType); err != nil {
+				return nil, err
+			}
+		}
+
+		legs, err := progressiveTax(brackets[account.AccountType], 0, account.Balance)
+		if err != nil {
+			return nil, err
+		}
+
+		amount, err := sumLegs(legs)
+		if err != nil {
+			return nil, err
+		} else if amount.IsZero() {
+			continue
+		}
+
+		assessments = append(assessments, WealthTaxAssessment{AccountID: account.ID, Account: account, Basis: account.Balance, Amount: amount})
+	}
+
+	return assessments, nil
+}
+
+// PreviewWealthTax returns what a wealth tax run would collect from each account right now, without collecting anything.
+func (self *Backend) PreviewWealthTax(economy Economy) ([]WealthTaxAssessment, error) {
+	return self.assessWealthTaxTx(self.db, economy)
+}
+
+// startWealthTaxRun snapshots the balances of the economy into a new run for the period between period_start and period_end.
+// It returns nil if a run for the period already exists.
+func (self *Backend) startWealthTaxRun(economy Economy, actor_id string, period_start time.Time, period_end time.Time) (*WealthTaxRun, error) {
+	session := self.db.Begin()
+
+	// periods start at midnight in the economy's time zone, so a run started before the time zone changed
+	// is keyed by a different start, but still counts if that start falls within the period
+	var existing int64
+	err := session.Model(&WealthTaxRun{}).Where("economy_id = ? AND period_start >= ? AND period_start < ?", economy.ID.String(), period_start.UTC(), period_end.UTC()).
+		Count(&existing).Error
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	} else if existing > 0 {
+		session.Rollback()
+		return nil, nil
+	}
+
+	run := WealthTaxRun{EconomyID: economy.ID.String(), PeriodStart: period_start.UTC(), ActorID: actor_id}
+	result := session.Clauses(clause.OnConflict{DoNothing: true}).Create(&run)
+	if err := result.Error; err != nil {
+		session.Rollback()
+		return nil, err
+	} else if result.RowsAffected == 0 {
+		session.Rollback()
+		return nil, nil
+	}
+
+	assessments, err := self.assessWealthTaxTx(session, economy)
+	if err != nil {
+		session.Rollback()
+		return nil, err
+	}
+
+	for i := range assessments {
+		assessments[i].RunID = run.ID
+	}
+
+	if len(assessments) > 0 {
+		if err := session.Omit("Account").CreateInBatches(&assessments, 100).Error; err != nil {
+			session.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := session.Commit().Error; err != nil {
+		return nil, err
+	}
+
+	run.Assessments = assessments
+	return &run, nil
+}
+
+// processWealthTaxRun collects every assessment of the run that has not been collected yet, each in its own transaction,
+// so that a run which was interrupted can be resumed without taxing any account twice.
+func (self *Backend) processWealthTaxRun(run WealthTaxRun) error {
+	var pending []WealthTaxAssessment
+	if err := self.db.Where("run_id = ? AND settled_at IS NULL", run.ID).Order("id").Find(&pending).Error; err != nil {
+		return err
+	}
+
+	memo := fmt.Sprintf("Wealth tax for the period starting %s", run.PeriodStart.Format(time.DateOnly))
+	for _, assessment := range pending {
+		session := self.db.Begin()
+
+		if err := self.collectAssessmentTx(session, run, assessment, memo); err != nil {
+			session.Rollback()
+			return err
+		}
+
+		if err := session.Commit().Error; err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	return self.db.Model(&WealthTaxRun{}).Where("id = ?", run.ID).Update("completed_at", &now).Error
+}
+
+func (self *Backend) collectAssessmentTx(session *gorm.DB, run WealthTaxRun, assessment WealthTaxAssessment, memo string) error {
+	var account Account
+	if err := session.Where("id = ?", assessment.AccountID).First(&account).Error; err != nil {
+		return err
+	}
+
+	brackets, err := self.bracketsTx(session, run.EconomyID, TX_Wealth, account.AccountType)
+	if err != nil {
+		return err
+	}
+
+	legs, err := progressiveTax(brackets, 0, assessment.Basis)
+	if err != nil {
+		return err
+	}
+
+	// the balance may have dropped since the snapshot; never collect more than the account still holds
+	legs = capLegs(legs, account.Balance)
+
+	amount, err := sumLegs(legs)
+	if err != nil {
+		return err
+	}
+
+	// an assessment that could not collect anything is still settled, so that it is not retried
+	var journal_id *uint
+	if !amount.IsZero() && !account.Deleted {
+		journal, err := self.collectTaxTx(session, run.ActorID, account, legs, memo, &run.ID)
+		if err != nil {
+			return err
+		}
+		journal_id = &journal.ID
+	}
+
+	// settling only succeeds once, so two processes resuming the same run cannot both collect an assessment
+	now := time.Now()
+	result := session.Model(&WealthTaxAssessment{}).Where("id = ? AND settled_at IS NULL", assessment.ID).Updates(map[string]any{
+		"amount": amount,
+		"journal_id": journal_id,
+		"settled_at": &now,
+	})
+
+	if err := result.Error; err != nil {
+		return err
+	} else if result.RowsAffected == 0 {
+		return BackendError{Message: "This wealth tax assessment has already been settled."}
+	}
+	return nil
+}
+
+// RunWealthTax collects wealth tax in the economy for the current period right away.
+// Economies without a wealth tax period can be taxed manually at most once a day.
+func (self *Backend) RunWealthTax(member SessionedMember, economy Economy) (*WealthTaxRun, error) {
+	if perm, err := self.HasPermission(member, P_ManageTaxBrackets, nil, &economy); err != nil {
+		return nil, err
+	} else if !perm {
+		return nil, BackendError{Message: "You do not have the permission to collect taxes in this economy."}
+	} else if economy.Locked {
+		return nil, EconomyLockedError
+	}
+
+	period := economy.WealthTaxPeriod
+	if period == PD_None {
+		period = PD_Daily
+	}
+
+	start := PeriodStart(time.Now(), period, economy.Location())
+	run, err := self.startWealthTaxRun(economy, member.User.ID, start, NextPeriod(start, period))
+	if err != nil {
+		return nil, err
+	} else if run == nil {
+		return nil, BackendError{Message: "Wealth tax has already been collected for this period."}
+	}
+
+	if err := self.processWealthTaxRun(*run); err != nil {
+		return nil, err
+	}
+
+	return run, self.db.Preload("Assessments.Account").First(run, run.ID).Error
+}
+
+func (self *Backend) SetWealthTaxPeriod(member SessionedMember, economy *Economy, period uint8) error {
+	if perm, err := self.HasPermission(member, P_ManageTaxBrackets, nil, economy); err != nil {
+		return err
+	} else if !perm {
+		return BackendError{Message: "You do not have the permission to manage taxes in this economy."}
+	} else if period > PD_Monthly {
+		return BackendError{Message: "Unknown period."}
+	}
+
+	if err := self.db.Model(&Economy{}).Where("id = ?", economy.ID).Update("wealth_tax_period", period).Error; err != nil {
+		return err
+	}
+
+	economy.WealthTaxPeriod = period
+	return nil
+}
+
+// RunScheduledWealthTaxes starts the run of the current period in every economy with a wealth tax period,
+// and resumes any run that did not complete.
+func (self *Backend) RunScheduledWealthTaxes(now time.Time) error {
+	var economies []Economy
+	if err := self.db.Where("wealth_tax_period <> ? AND locked = ?", PD_None, false).Find(&economies).Error; err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, economy := range economies {
+		start := PeriodStart(now, economy.WealthTaxPeriod, economy.Location())
+		if _, err := self.startWealthTaxRun(economy, "", start, NextPeriod(start, economy.WealthTaxPeriod)); err != nil {
+			errs = append(errs, fmt.Errorf("economy %s: %w", economy.Name, err))
+		}
+	}
+
+	var runs []WealthTaxRun
+	if err := self.db.Where("completed_at IS NULL AND economy_id NOT IN (?)", self.lockedEconomies()).Find(&runs).Error; err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	for _, run := range runs {
+		if err := self.processWealthTaxRun(run); err != nil {
+			errs = append(errs, fmt.Errorf("wealth tax run %d: %w", run.ID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"log"
 	"os"
 	"slices"
+	"strings"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
 )
 
 func MigrateCommands(session *discordgo.Session, commands []Command) (map[string]Command, error) {
@@ -23,9 +26,10 @@ func MigrateCommands(session *discordgo.Session, commands []Command) (map[string
 }
 
 // Wrapper that takes a map of string->Command values and returns a handler for a discordgo.Session's InteractionCreate event; whereas the map is generated dynamically and needed for command navigation
-func SlashCommandHandlerWrapper(commands map[string]Command) func(session *discordgo.Session, event *discordgo.InteractionCreate) {
+// The backend is dereferenced per interaction, so it may be set up after the handler is registered
+func SlashCommandHandlerWrapper(commands map[string]Command, backend *database.Backend, logger *log.Logger) func(session *discordgo.Session, event *discordgo.InteractionCreate) {
 	return func (session *discordgo.Session, event *discordgo.InteractionCreate) {
-		ctx := Context{}
+		ctx := Context{Backend: *backend, Logger: logger}
 
 		switch event.Type {
 		case discordgo.InteractionApplicationCommand:
@@ -66,8 +70,26 @@ func SlashCommandHandlerWrapper(commands map[string]Command) func(session *disco
 	}
 }
 
+// ComponentHandlerWrapper returns a handler for a discordgo.Session's InteractionCreate event that routes message component interactions
+// to the component named by the prefix of their custom ID
+func ComponentHandlerWrapper(components map[string]Component, backend *database.Backend, logger *log.Logger) func(session *discordgo.Session, event *discordgo.InteractionCreate) {
+	return func (session *discordgo.Session, event *discordgo.InteractionCreate) {
+		if event.Type != discordgo.InteractionMessageComponent {
+			return
+		}
+
+		name, _, _ := strings.Cut(event.MessageComponentData().CustomID, ":")
+		if c, ok := components[name]; ok {
+			ctx := Context{Backend: *backend, Logger: logger, GetOptions: func() []*discordgo.ApplicationCommandInteractionDataOption {
+				return nil
+			}}
+			c.Callback(&ctx, session, event)
+		}
+	}
+}
+
 func ConvertOptions(options []*Option) []*discordgo.ApplicationCommandOption {
-	var converted []*discordgo.ApplicationCommandOption = make([]*discordgo.ApplicationCommandOption, len(options))
+	var converted []*discordgo.ApplicationCommandOption = make([]*discordgo.ApplicationCommandOption, 0, len(options))
 	for _, opt := range options {
 		conv := &discordgo.ApplicationCommandOption{
 			Name: opt.Name,
@@ -79,7 +101,6 @@ func ConvertOptions(options []*Option) []*discordgo.ApplicationCommandOption {
 
 		if opt.Options != nil {
 			conv.Options = ConvertOptions(opt.Options)
-			continue
 		}
 
 		switch opt.Type.(type) {
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/ohknettel/taubot-v3/internal/database"
+	"github.com/ohknettel/taubot-v3/pkg/utils"
+)
+
+// Option returns the option with the given name out of the invoked (sub)command's options, or nil if it was not provided
+func (ctx *Context) Option(name string) *discordgo.ApplicationCommandInteractionDataOption {
+	for _, opt := range ctx.GetOptions() {
+		if opt.Name == name {
+			return opt
+		}
+	}
+	return nil
+}
+
+// Member returns the invoking member along with the session, which permission checks need to resolve role positions
+func (ctx *Context) Member(s *discordgo.Session, event *discordgo.InteractionCreate) (database.SessionedMember, error) {
+	if event.Member == nil {
+		return database.SessionedMember{}, database.BackendError{Message: "This command can only be used in a server."}
+	}
+
+	member := *event.Member
+	member.GuildID = event.GuildID
+	return database.SessionedMember{Member: member, Session: s}, nil
+}
+
+// Economy returns the economy the guild of the interaction is registered to
+func (ctx *Context) Economy(event *discordgo.InteractionCreate) (database.Economy, error) {
+	economies, err := ctx.Backend.GetEconomiesIn(event.GuildID)
+	if err != nil {
+		return database.Economy{}, err
+	} else if len(economies) == 0 {
+		return database.Economy{}, database.BackendError{Message: "This server is not registered to an economy."}
+	}
+	return economies[0], nil
+}
+
+func Respond(s *discordgo.Session, event *discordgo.InteractionCreate, embed *utils.Embed, files ...*discordgo.File) error {
+	return s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{embed.Truncate().MessageEmbed},
+			Files: files,
+		},
+	})
+}
+
+func RespondEphemeral(s *discordgo.Session, event *discordgo.InteractionCreate, embed *utils.Embed) error {
+	return s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{embed.Truncate().MessageEmbed},
+			Flags: discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
+
+// RespondComponents responds with an embed and message components, such as buttons, below it
+func RespondComponents(s *discordgo.Session, event *discordgo.InteractionCreate, embed *utils.Embed, components []discordgo.MessageComponent) error {
+	return s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{embed.Truncate().MessageEmbed},
+			Components: components,
+		},
+	})
+}
+
+// UpdateMessage replaces the message a component belongs to in response to a click on it
+func UpdateMessage(s *discordgo.Session, event *discordgo.InteractionCreate, embed *utils.Embed, components []discordgo.MessageComponent) error {
+	return s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Embeds: []*discordgo.MessageEmbed{embed.Truncate().MessageEmbed},
+			Components: components,
+		},
+	})
+}
+
+// Defer acknowledges an interaction that takes longer than Discord's three seconds to answer, showing that the bot is thinking
+// until the response is edited in with EditResponse or EditError
+func Defer(s *discordgo.Session, event *discordgo.InteractionCreate) error {
+	return s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+}
+
+// DeferUpdate acknowledges a click on a component that takes longer than three seconds to handle, keeping its message
+// until it is replaced with EditResponse or EditError
+func DeferUpdate(s *discordgo.Session, event *discordgo.InteractionCreate) error {
+	return s.InteractionRespond(event.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+}
+
+// EditResponse replaces the response of a deferred interaction with an embed and message components
+func EditResponse(s *discordgo.Session, event *discordgo.InteractionCreate, embed *utils.Embed, components []discordgo.MessageComponent) error {
+	_, err := s.InteractionResponseEdit(event.Interaction, &discordgo.WebhookEdit{
+		Embeds: &[]*discordgo.MessageEmbed{embed.Truncate().MessageEmbed},
+		Components: &components,
+	})
+	return err
+}
+
+// errorEmbed shows backend errors to the user as they are, and logs anything else behind a generic message
+func errorEmbed(ctx *Context, err error) *utils.Embed {
+	message := "An unexpected error occured, please try again later."
+
+	var backend_err database.BackendError
+	if errors.As(err, &backend_err) {
+		message = backend_err.Message
+	} else if errors.Is(err, database.RecordNotFoundError) {
+		message = "The requested entry could not be found."
+	} else if ctx.Logger != nil {
+		ctx.Logger.Printf("An error occured while handling an interaction: %v", err)
+	}
+
+	return utils.NewEmbed().SetTitle("Error").SetDescription(message).SetColor(Colors.Error)
+}
+
+// RespondError shows backend errors to the user as they are, and logs anything else behind a generic message
+func RespondError(ctx *Context, s *discordgo.Session, event *discordgo.InteractionCreate, err error) {
+	if err := RespondEphemeral(s, event, errorEmbed(ctx, err)); err != nil && ctx.Logger != nil {
+		ctx.Logger.Printf("An error occured while responding to an interaction: %v", err)
+	}
+}
+
+// EditError is RespondError for interactions that have been deferred
+func EditError(ctx *Context, s *discordgo.Session, event *discordgo.InteractionCreate, err error) {
+	if err := EditResponse(s, event, errorEmbed(ctx, err), []discordgo.MessageComponent{}); err != nil && ctx.Logger != nil {
+		ctx.Logger.Printf("An error occured while responding to an interaction: %v", err)
+	}
+}
@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"log"
 	"github.com/ohknettel/taubot-v3/internal/database"
 	"github.com/bwmarrin/discordgo"
 )
 
 type Context struct {
 	Backend database.Backend
+	Logger *log.Logger
 	GetOptions func() []*discordgo.ApplicationCommandInteractionDataOption	
 }
 
@@ -27,6 +29,12 @@ type Command struct {
 	Options []*Option
 }
 
+// Component handles clicks on message components whose custom ID starts with its name, followed by a colon and any arguments
+type Component struct {
+	Name string
+	Callback EventFunc
+}
+
 type Option struct {
 	Name string
 	Description string
@@ -0,0 +1,278 @@
+package datatypes
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Money is a fixed-point monetary amount, stored as a signed count of minor units.
+// The number of decimal places is not part of the value itself; it is a property of
+// the economy the amount belongs to and has to be supplied when formatting or parsing.
+type Money int64
+
+var (
+	ErrMoneyOverflow = errors.New("monetary amount is out of range")
+	ErrMoneyPrecision = errors.New("monetary amount has too many decimal places")
+	ErrMoneyFormat = errors.New("invalid monetary amount")
+)
+
+// MaxMoneyDecimals is the largest number of decimal places a currency can use.
+const MaxMoneyDecimals uint8 = 8
+
+var moneySuffixes = map[string]int64{
+	"k": 1_000,
+	"m": 1_000_000,
+	"b": 1_000_000_000,
+	"t": 1_000_000_000_000,
+}
+
+// Add returns m + other, or ErrMoneyOverflow if the result does not fit.
+func (m Money) Add(other Money) (Money, error) {
+	result := m + other
+	if (other > 0 && result < m) || (other < 0 && result > m) {
+		return 0, ErrMoneyOverflow
+	}
+	return result, nil
+}
+
+// Sub returns m - other, or ErrMoneyOverflow if the result does not fit.
+func (m Money) Sub(other Money) (Money, error) {
+	if other == math.MinInt64 {
+		return 0, ErrMoneyOverflow
+	}
+	return m.Add(-other)
+}
+
+// Mul returns m * n, or ErrMoneyOverflow if the result does not fit.
+func (m Money) Mul(n int64) (Money, error) {
+	return m.MulRate(n, 1)
+}
+
+// MulRate returns m * numerator / denominator, truncated towards zero.
+// The intermediate product is computed in 128 bits so that it cannot overflow.
+func (m Money) MulRate(numerator int64, denominator int64) (Money, error) {
+	if denominator == 0 {
+		return 0, errors.New("division by zero")
+	}
+
+	negative := (m < 0) != (numerator < 0)
+	if denominator < 0 {
+		negative = !negative
+	}
+
+	hi, lo := bits.Mul64(absUint64(int64(m)), absUint64(numerator))
+	den := absUint64(denominator)
+	if hi >= den {
+		return 0, ErrMoneyOverflow
+	}
+
+	quotient, _ := bits.Div64(hi, lo, den)
+	if negative {
+		if quotient > 1<<63 {
+			return 0, ErrMoneyOverflow
+		}
+		return Money(-int64(quotient - 1) - 1), nil
+	}
+
+	if quotient > math.MaxInt64 {
+		return 0, ErrMoneyOverflow
+	}
+	return Money(quotient), nil
+}
+
+// Neg returns -m, or ErrMoneyOverflow for the smallest representable amount.
+func (m Money) Neg() (Money, error) {
+	if m == math.MinInt64 {
+		return 0, ErrMoneyOverflow
+	}
+	return -m, nil
+}
+
+// Abs returns the absolute value of m, or ErrMoneyOverflow for the smallest representable amount.
+func (m Money) Abs() (Money, error) {
+	if m < 0 {
+		return m.Neg()
+	}
+	return m, nil
+}
+
+// IsZero returns true if the amount is zero.
+func (m Money) IsZero() bool {
+	return m == 0
+}
+
+// IsNegative returns true if the amount is below zero.
+func (m Money) IsNegative() bool {
+	return m < 0
+}
+
+// IsPositive returns true if the amount is above zero.
+func (m Money) IsPositive() bool {
+	return m > 0
+}
+
+// Format returns the amount as a decimal string with the given number of decimal places
+// and thousands separators, e.g. 1200050 with 2 decimals becomes "12,000.50".
+func (m Money) Format(decimals uint8) string {
+	digits := strconv.FormatUint(absUint64(int64(m)), 10)
+	if len(digits) <= int(decimals) {
+		digits = strings.Repeat("0", int(decimals)-len(digits)+1) + digits
+	}
+
+	whole, fraction := digits[:len(digits)-int(decimals)], digits[len(digits)-int(decimals):]
+
+	var b strings.Builder
+	if m < 0 {
+		b.WriteByte('-')
+	}
+
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(r)
+	}
+
+	if decimals > 0 {
+		b.WriteByte('.')
+		b.WriteString(fraction)
+	}
+
+	return b.String()
+}
+
+// ParseMoney parses user input such as "12,000.50", "1.5k" or "-3" into an amount
+// with the given number of decimal places. Thousands separators, underscores and
+// spaces are ignored, and the suffixes k, m, b and t multiply the amount accordingly.
+func ParseMoney(input string, decimals uint8) (Money, error) {
+	if decimals > MaxMoneyDecimals {
+		return 0, ErrMoneyPrecision
+	}
+
+	cleaned := strings.ToLower(strings.NewReplacer(",", "", "_", "", " ", "").Replace(strings.TrimSpace(input)))
+	if cleaned == "" {
+		return 0, ErrMoneyFormat
+	}
+
+	multiplier := int64(1)
+	if last := cleaned[len(cleaned)-1:]; moneySuffixes[last] != 0 {
+		multiplier = moneySuffixes[last]
+		cleaned = cleaned[:len(cleaned)-1]
+	}
+
+	// big.Rat accepts fractions and exponents, neither of which is a sensible way of writing an amount
+	for i, r := range cleaned {
+		if (r < '0' || r > '9') && r != '.' && !(i == 0 && (r == '-' || r == '+')) {
+			return 0, ErrMoneyFormat
+		}
+	}
+
+	if strings.Count(cleaned, ".") > 1 || strings.Trim(cleaned, "+-.") == "" {
+		return 0, ErrMoneyFormat
+	}
+
+	value, ok := new(big.Rat).SetString(cleaned)
+	if !ok {
+		return 0, ErrMoneyFormat
+	}
+
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	value.Mul(value, new(big.Rat).SetInt(scale))
+	value.Mul(value, new(big.Rat).SetInt64(multiplier))
+
+	if !value.IsInt() {
+		return 0, ErrMoneyPrecision
+	}
+
+	units := value.Num()
+	if !units.IsInt64() {
+		return 0, ErrMoneyOverflow
+	}
+
+	return Money(units.Int64()), nil
+}
+
+// GormDataType gorm common data type.
+func (Money) GormDataType() string {
+	return "int"
+}
+
+// GormDBDataType gorm db data type.
+func (Money) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return "BIGINT"
+	case "sqlite":
+		return "INTEGER"
+	default:
+		return ""
+	}
+}
+
+// Scan is the scanner function for this datatype.
+func (m *Money) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = Money(v)
+	case []byte:
+		return m.Scan(string(v))
+	case string:
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to scan Money value %q: %w", v, err)
+		}
+		*m = Money(parsed)
+	default:
+		return fmt.Errorf("failed to scan Money value of type %T", value)
+	}
+	return nil
+}
+
+// Value is the valuer function for this datatype.
+func (m Money) Value() (driver.Value, error) {
+	return int64(m), nil
+}
+
+// MarshalJSON encodes the amount as its number of minor units.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(int64(m))
+}
+
+// UnmarshalJSON decodes an amount from its number of minor units, given either as a number or a string.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	var units int64
+	if err := json.Unmarshal(b, &units); err == nil {
+		*m = Money(units)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return m.Scan(s)
+}
+
+// String returns the number of minor units as a plain integer.
+func (m Money) String() string {
+	return strconv.FormatInt(int64(m), 10)
+}
+
+func absUint64(n int64) uint64 {
+	if n < 0 {
+		return uint64(^n) + 1
+	}
+	return uint64(n)
+}
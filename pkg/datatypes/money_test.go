@@ -0,0 +1,151 @@
+package datatypes
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestMoneyAdd(t *testing.T) {
+	tests := []struct {
+		name 	string
+		a, b 	Money
+		want 	Money
+		err 	error
+	}{
+		{"positive", 150, 250, 400, nil},
+		{"negative", -150, -250, -400, nil},
+		{"mixed signs", 150, -250, -100, nil},
+		{"largest", math.MaxInt64 - 1, 1, math.MaxInt64, nil},
+		{"smallest", math.MinInt64 + 1, -1, math.MinInt64, nil},
+		{"overflow", math.MaxInt64, 1, 0, ErrMoneyOverflow},
+		{"underflow", math.MinInt64, -1, 0, ErrMoneyOverflow},
+		{"opposite extremes", math.MaxInt64, math.MinInt64, -1, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.a.Add(test.b)
+			if !errors.Is(err, test.err) || got != test.want {
+				t.Errorf("%d.Add(%d) = %d, %v; want %d, %v", test.a, test.b, got, err, test.want, test.err)
+			}
+		})
+	}
+}
+
+func TestMoneySub(t *testing.T) {
+	tests := []struct {
+		name 	string
+		a, b 	Money
+		want 	Money
+		err 	error
+	}{
+		{"positive", 400, 250, 150, nil},
+		{"below zero", 150, 250, -100, nil},
+		{"negative", -150, -250, 100, nil},
+		{"overflow", math.MaxInt64, -1, 0, ErrMoneyOverflow},
+		{"underflow", math.MinInt64, 1, 0, ErrMoneyOverflow},
+		{"smallest subtrahend", 0, math.MinInt64, 0, ErrMoneyOverflow},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.a.Sub(test.b)
+			if !errors.Is(err, test.err) || got != test.want {
+				t.Errorf("%d.Sub(%d) = %d, %v; want %d, %v", test.a, test.b, got, err, test.want, test.err)
+			}
+		})
+	}
+}
+
+func TestMoneyMulRate(t *testing.T) {
+	tests := []struct {
+		name 					string
+		m 						Money
+		numerator, denominator 	int64
+		want 					Money
+		err 					error
+	}{
+		{"whole percent", 1000, 15, 100, 150, nil},
+		{"basis points", 123456, 250, 10000, 3086, nil},
+		{"truncates down", 7, 1, 2, 3, nil},
+		{"truncates negative towards zero", -7, 1, 2, -3, nil},
+		{"negative rate", 7, -1, 2, -3, nil},
+		{"negative denominator", 7, 1, -2, -3, nil},
+		{"both negative", -7, -1, 2, 3, nil},
+		{"rounds to zero", 1, 1, 3, 0, nil},
+		{"negative rounds to zero", -1, 1, 3, 0, nil},
+		{"wide intermediate", math.MaxInt64, 10000, 10000, math.MaxInt64, nil},
+		{"wide negative intermediate", math.MinInt64, 10000, 10000, math.MinInt64, nil},
+		{"overflow", math.MaxInt64, 2, 1, 0, ErrMoneyOverflow},
+		{"negative overflow", math.MinInt64, -1, 1, 0, ErrMoneyOverflow},
+		{"smallest", math.MaxInt64, -1, 1, -math.MaxInt64, nil},
+		{"zero amount", 0, math.MaxInt64, 1, 0, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.m.MulRate(test.numerator, test.denominator)
+			if !errors.Is(err, test.err) || got != test.want {
+				t.Errorf("%d.MulRate(%d, %d) = %d, %v; want %d, %v", test.m, test.numerator, test.denominator, got, err, test.want, test.err)
+			}
+		})
+	}
+
+	if _, err := Money(1).MulRate(1, 0); err == nil {
+		t.Error("MulRate by a zero denominator did not fail")
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		input 		string
+		decimals 	uint8
+		want 		Money
+		err 		error
+	}{
+		{"12,000.50", 2, 1200050, nil},
+		{"  42  ", 2, 4200, nil},
+		{"1_000", 0, 1000, nil},
+		{"+5", 2, 500, nil},
+		{"-3", 2, -300, nil},
+		{"-0.01", 2, -1, nil},
+		{".5", 2, 50, nil},
+		{"5.", 2, 500, nil},
+		{"1.5k", 2, 150000, nil},
+		{"2K", 0, 2000, nil},
+		{"2.5m", 0, 2500000, nil},
+		{"1b", 2, 100000000000, nil},
+		{"3t", 0, 3000000000000, nil},
+		{"-1.25k", 2, -125000, nil},
+		{"0.001k", 0, 1, nil},
+		{"0.0001k", 0, 0, ErrMoneyPrecision},
+		{"0.001", 2, 0, ErrMoneyPrecision},
+		{"1", MaxMoneyDecimals + 1, 0, ErrMoneyPrecision},
+		{"9223372036854775807", 0, math.MaxInt64, nil},
+		{"-9223372036854775808", 0, math.MinInt64, nil},
+		{"9223372036854775808", 0, 0, ErrMoneyOverflow},
+		{"92233720368547758.08", 2, 0, ErrMoneyOverflow},
+		{"10t", 8, 0, ErrMoneyOverflow},
+		{"", 2, 0, ErrMoneyFormat},
+		{"k", 2, 0, ErrMoneyFormat},
+		{".", 2, 0, ErrMoneyFormat},
+		{"-", 2, 0, ErrMoneyFormat},
+		{"1.2.3", 2, 0, ErrMoneyFormat},
+		{"1e5", 2, 0, ErrMoneyFormat},
+		{"1/2", 2, 0, ErrMoneyFormat},
+		{"5-", 2, 0, ErrMoneyFormat},
+		{"--5", 2, 0, ErrMoneyFormat},
+		{"$5", 2, 0, ErrMoneyFormat},
+		{"5kk", 2, 0, ErrMoneyFormat},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			got, err := ParseMoney(test.input, test.decimals)
+			if !errors.Is(err, test.err) || got != test.want {
+				t.Errorf("ParseMoney(%q, %d) = %d, %v; want %d, %v", test.input, test.decimals, got, err, test.want, test.err)
+			}
+		})
+	}
+}